@@ -0,0 +1,252 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/labstack/echo"
+)
+
+// auditActor is the user whose action is recorded in the audit log
+type auditActor struct {
+	kind string
+	id   int64
+}
+
+// String formats the actor like the targets of the audit log
+func (a auditActor) String() string {
+	return auditTarget(a.kind, a.id)
+}
+
+// AuditEntry is an action taken by a user on the resources of others or on the roles
+type AuditEntry struct {
+	ID        int64
+	ActorKind string
+	ActorID   int64
+	Action    string
+	// Target is the resource acted on, like contract:6, offer:3 or investor:2
+	Target  string
+	Detail  sql.NullString
+	Created string
+}
+
+// RemovalQuery gives the reason of removing a contract or an offer
+type RemovalQuery struct {
+	Reason string
+}
+
+// SignatureRecord is a signature stored by Sirius with its signer
+type SignatureRecord struct {
+	// Source is contract.investor, contract.supplier, contract.award, co_investor, offer, offer_revision,
+	// amendment (its proposer) or amendment_response (the other party)
+	Source      string
+	OfferID     int64 `json:",omitempty"`
+	Revision    int64 `json:",omitempty"`
+	AmendmentID int64 `json:",omitempty"`
+	SignerKind  string
+	SignerID    int64
+	Signature   string
+	Signed      sql.NullString
+}
+
+// recordAudit adds the action to the audit log within the transaction making the change
+func recordAudit(tx dbExecutor, by auditActor, action, target, detail string) error {
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto("audit_log")
+	ib.Cols("actor_kind", "actor_id", "action", "target", "detail", "created")
+	ib.Values(by.kind, by.id, action, target, sql.NullString{String: detail, Valid: detail != ""}, time.Now().Format(time.RFC3339))
+	q, args := ib.Build()
+	_, err := tx.Exec(q, args...)
+	return err
+}
+
+// AdminDeleteContract - api controller for removing an abusive contract of any investor
+func AdminDeleteContract(c echo.Context) error {
+	sc := c.(StaffContext)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	removalQuery := new(RemovalQuery)
+	if err := c.Bind(removalQuery); err != nil || removalQuery.Reason == "" {
+		return c.String(http.StatusBadRequest, "Reason is required")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	return removeContract(c, db, id, 0, auditActor{sc.UserKind, sc.UserID}, removalQuery.Reason)
+}
+
+// AdminDeleteOffer - api controller for removing an abusive offer of any supplier
+func AdminDeleteOffer(c echo.Context) error {
+	sc := c.(StaffContext)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	removalQuery := new(RemovalQuery)
+	if err := c.Bind(removalQuery); err != nil || removalQuery.Reason == "" {
+		return c.String(http.StatusBadRequest, "Reason is required")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	return removeOffer(c, db, id, 0, auditActor{sc.UserKind, sc.UserID}, removalQuery.Reason)
+}
+
+// ListAuditLog - api controller for obtaining the audit log, optionally of an actor or a target
+func ListAuditLog(c echo.Context) error {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("id", "actor_kind", "actor_id", "action", "target", "detail", "created")
+	sb.From("audit_log")
+	if action := c.QueryParam("Action"); action != "" {
+		sb.Where(sb.Equal("action", action))
+	}
+	if target := c.QueryParam("Target"); target != "" {
+		sb.Where(sb.Equal("target", target))
+	}
+	if err := paginate(c, sb); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	q, args := sb.Build()
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		e := AuditEntry{}
+		if err := rows.Scan(&e.ID, &e.ActorKind, &e.ActorID, &e.Action, &e.Target, &e.Detail, &e.Created); err != nil {
+			log.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	return c.JSON(http.StatusOK, entries)
+}
+
+// GetContractSignatures - api controller for obtaining all the signatures made on the contract,
+// its offers and their revisions
+func GetContractSignatures(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("investor_id", "supplier_id", "investor_signature", "supplier_signature", "award_signature", "awarded")
+	sb.From("contracts")
+	sb.Where(sb.Equal("id", id))
+	q, args := sb.Build()
+
+	var investorID int64
+	var supplierID sql.NullInt64
+	var investorSignature, supplierSignature, awardSignature, awarded sql.NullString
+	err = db.QueryRow(q, args...).Scan(&investorID, &supplierID, &investorSignature, &supplierSignature, &awardSignature, &awarded)
+	if err == sql.ErrNoRows {
+		return c.String(http.StatusNotFound, "Contract not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	signatures := []SignatureRecord{}
+	if investorSignature.Valid {
+		signatures = append(signatures, SignatureRecord{Source: "contract.investor", SignerKind: UserInvestor,
+			SignerID: investorID, Signature: investorSignature.String})
+	}
+	if supplierSignature.Valid {
+		signatures = append(signatures, SignatureRecord{Source: "contract.supplier", SignerKind: UserSupplier,
+			SignerID: supplierID.Int64, Signature: supplierSignature.String})
+	}
+	if awardSignature.Valid {
+		signatures = append(signatures, SignatureRecord{Source: "contract.award", SignerKind: UserInvestor,
+			SignerID: investorID, Signature: awardSignature.String, Signed: awarded})
+	}
+
+	// every source selects offer ID, revision, amendment ID, signer kind and ID, signature and signing time
+	sources := []struct {
+		source string
+		build  func(sb *sqlbuilder.SelectBuilder)
+	}{
+		{"co_investor", func(sb *sqlbuilder.SelectBuilder) {
+			sb.Select("COALESCE(offer_id, 0)", "COALESCE(revision, 0)", "0", "'investor'", "investor_id", "signature", "signed")
+			sb.From("contract_investors")
+			sb.Where(sb.Equal("contract_id", id), sb.IsNotNull("signature"))
+			sb.OrderBy("investor_id")
+		}},
+		{"offer", func(sb *sqlbuilder.SelectBuilder) {
+			sb.Select("id", "0", "0", "'supplier'", "supplier_id", "supplier_signature", "created")
+			sb.From("offers")
+			sb.Where(sb.Equal("contract_id", id))
+			sb.OrderBy("id")
+		}},
+		{"offer_revision", func(sb *sqlbuilder.SelectBuilder) {
+			sb.Select("offer_id", "revision", "0", "author_kind", "author_id", "signature", "created")
+			sb.From("offer_revisions")
+			sb.Where("offer_id IN (SELECT id FROM offers WHERE contract_id = " + sb.Var(id) + ")")
+			sb.OrderBy("offer_id", "revision")
+		}},
+		{"amendment", func(sb *sqlbuilder.SelectBuilder) {
+			sb.Select("0", "0", "id", "proposer_kind", "proposer_id", "proposer_signature", "created")
+			sb.From("contract_amendments")
+			sb.Where(sb.Equal("contract_id", id))
+			sb.OrderBy("id")
+		}},
+		{"amendment_response", func(sb *sqlbuilder.SelectBuilder) {
+			sb.Select("0", "0", "id", "responder_kind", "responder_id", "responder_signature", "resolved")
+			sb.From("contract_amendments")
+			sb.Where(sb.Equal("contract_id", id), sb.IsNotNull("responder_signature"))
+			sb.OrderBy("id")
+		}},
+	}
+	for _, source := range sources {
+		sb := sqlbuilder.NewSelectBuilder()
+		source.build(sb)
+		q, args := sb.Build()
+		rows, err := db.Query(q, args...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for rows.Next() {
+			r := SignatureRecord{Source: source.source}
+			if err := rows.Scan(&r.OfferID, &r.Revision, &r.AmendmentID, &r.SignerKind, &r.SignerID, &r.Signature, &r.Signed); err != nil {
+				log.Fatal(err)
+			}
+			signatures = append(signatures, r)
+		}
+		rows.Close()
+	}
+	return c.JSON(http.StatusOK, signatures)
+}
+
+// auditTarget formats the target of an audit entry
+func auditTarget(kind string, id int64) string {
+	return fmt.Sprintf("%s:%d", kind, id)
+}
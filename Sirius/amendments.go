@@ -0,0 +1,944 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/labstack/echo"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Statuses of the amendments
+const (
+	AmendmentPending   = "pending"
+	AmendmentAccepted  = "accepted"
+	AmendmentRejected  = "rejected"
+	AmendmentWithdrawn = "withdrawn"
+)
+
+// BodyChange is a field of ContractBody changed by a version, Old is null for a field which was
+// not set and New for a field which is cleared
+type BodyChange struct {
+	Field string
+	Old   json.RawMessage
+	New   json.RawMessage
+}
+
+// ContractVersion is an immutable version of the body of a contract. Version 1 is the body the
+// contract was created with, the later ones are the edits of the open contract, the terms of the
+// offer which concluded it and the amendments agreed by both parties of the signed contract.
+type ContractVersion struct {
+	ID         int64
+	ContractID int64
+	Version    int64
+	Body       ContractBody
+	// Changes are made to the previous version
+	Changes    []BodyChange
+	AuthorKind string
+	AuthorID   int64
+	// OfferRevisionID is the accepted revision of the offer which concluded the contract
+	OfferRevisionID sql.NullInt64
+	AmendmentID     sql.NullInt64
+	Comment         sql.NullString
+	Created         string
+}
+
+// ContractAmendment is a change of the body of a signed contract proposed by one of its parties,
+// it makes the next version of the body when the other party signs it as well
+type ContractAmendment struct {
+	ID                 int64
+	ContractID         int64
+	BaseVersion        int64
+	Body               ContractBody
+	Changes            []BodyChange
+	ProposerKind       string
+	ProposerID         int64
+	ProposerSignature  string
+	Comment            sql.NullString
+	Status             string
+	ResponderKind      sql.NullString
+	ResponderID        sql.NullInt64
+	ResponderSignature sql.NullString
+	Created            string
+	Resolved           sql.NullString
+
+	// encoded are the signed bytes of Body as stored
+	encoded []byte
+}
+
+// AmendmentQuery - the new body of a contract, it replaces the title, the description, the amount,
+// the deadline and the milestones. Signature is made over the encoded ContractBody by the party
+// proposing an amendment of a signed contract, the edits of an open contract are not signed.
+type AmendmentQuery struct {
+	Title       string
+	Description string
+	Money
+	MustBeDone *Timestamp
+	Milestones []Milestone
+	Comment    string
+	Signature  string
+}
+
+// AmendmentAcceptionQuery - Signature of the encoded Body of the amendment by the other party
+type AmendmentAcceptionQuery struct {
+	Signature string
+}
+
+var contractVersionColumns = []string{"id", "contract_id", "version", "body", "changes", "author_kind", "author_id",
+	"offer_revision_id", "amendment_id", "comment", "created"}
+
+func scanContractVersion(row rowScanner, v *ContractVersion) error {
+	var body, changes string
+	err := row.Scan(&v.ID, &v.ContractID, &v.Version, &body, &changes, &v.AuthorKind, &v.AuthorID,
+		&v.OfferRevisionID, &v.AmendmentID, &v.Comment, &v.Created)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(body), &v.Body); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(changes), &v.Changes)
+}
+
+var contractAmendmentColumns = []string{"id", "contract_id", "base_version", "body", "changes", "proposer_kind", "proposer_id",
+	"proposer_signature", "comment", "status", "responder_kind", "responder_id", "responder_signature", "created", "resolved"}
+
+func scanContractAmendment(row rowScanner, a *ContractAmendment) error {
+	var body, changes string
+	err := row.Scan(&a.ID, &a.ContractID, &a.BaseVersion, &body, &changes, &a.ProposerKind, &a.ProposerID,
+		&a.ProposerSignature, &a.Comment, &a.Status, &a.ResponderKind, &a.ResponderID, &a.ResponderSignature,
+		&a.Created, &a.Resolved)
+	if err != nil {
+		return err
+	}
+	a.encoded = []byte(body)
+	if err := json.Unmarshal(a.encoded, &a.Body); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(changes), &a.Changes)
+}
+
+// body returns the contract body requested by the query, ValidUntil set by the accepted offer is kept
+func (q *AmendmentQuery) body(current ContractBody) (ContractBody, error) {
+	body := ContractBody{
+		Title:       q.Title,
+		Description: q.Description,
+		Money:       q.Money,
+		Milestones:  q.Milestones,
+		ValidUntil:  current.ValidUntil,
+	}
+	if q.MustBeDone == nil {
+		return body, fmt.Errorf("MustBeDone is required")
+	}
+	body.MustBeDone = time.Time(*q.MustBeDone).Format(time.RFC3339)
+	if err := body.Money.validate(); err != nil {
+		return body, err
+	}
+	if err := body.validateMilestones(); err != nil {
+		return body, fmt.Errorf("Bad Milestones")
+	}
+	return body, nil
+}
+
+// diffBodies returns the fields of the encoded bodies which differ, in order of their names
+func diffBodies(old, new ContractBody) []BodyChange {
+	var before, after map[string]json.RawMessage
+	encoded, _ := json.Marshal(old)
+	json.Unmarshal(encoded, &before)
+	encoded, _ = json.Marshal(new)
+	json.Unmarshal(encoded, &after)
+
+	fields := make([]string, 0, len(after))
+	for field := range after {
+		fields = append(fields, field)
+	}
+	for field := range before {
+		if _, ok := after[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	var changes []BodyChange
+	for _, field := range fields {
+		if !bytes.Equal(before[field], after[field]) {
+			changes = append(changes, BodyChange{Field: field, Old: before[field], New: after[field]})
+		}
+	}
+	return changes
+}
+
+// changesValue encodes the changes for the changes columns
+func changesValue(changes []BodyChange) string {
+	if changes == nil {
+		changes = []BodyChange{}
+	}
+	r, _ := json.Marshal(changes)
+	return string(r)
+}
+
+// insertContractVersion stores the version of the body, the version number must be unique within the contract
+func insertContractVersion(tx dbExecutor, v *ContractVersion) error {
+	v.Created = time.Now().Format(time.RFC3339)
+	if v.Changes == nil {
+		v.Changes = []BodyChange{}
+	}
+	encoded, _ := json.Marshal(v.Body)
+
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto("contract_versions")
+	ib.Cols("contract_id", "version", "body", "changes", "author_kind", "author_id", "offer_revision_id", "amendment_id", "comment", "created")
+	ib.Values(v.ContractID, v.Version, string(encoded), changesValue(v.Changes), v.AuthorKind, v.AuthorID, v.OfferRevisionID,
+		v.AmendmentID, v.Comment, v.Created)
+	q, args := ib.Build()
+
+	res, err := tx.Exec(q, args...)
+	if err != nil {
+		return err
+	}
+	v.ID, err = res.LastInsertId()
+	return err
+}
+
+// loadContractVersion returns the version of the body of the contract
+func loadContractVersion(db dbExecutor, contractID, version int64) (*ContractVersion, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select(contractVersionColumns...)
+	sb.From("contract_versions")
+	sb.Where(sb.Equal("contract_id", contractID), sb.Equal("version", version))
+	q, args := sb.Build()
+
+	v := &ContractVersion{}
+	if err := scanContractVersion(db.QueryRow(q, args...), v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// backfillContractVersions makes the first version of the contracts made before amendments were
+// introduced, it is the body of the contract at the time of the migration
+func backfillContractVersions(db *sql.DB) error {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("id", "investor_id", "version", "created", "title", "description", "amount", "currency", "must_be_done",
+		"milestones", "valid_until")
+	sb.From("contracts")
+	sb.Where("NOT EXISTS (SELECT 1 FROM contract_versions WHERE contract_versions.contract_id = contracts.id)")
+	q, args := sb.Build()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return err
+	}
+	var versions []ContractVersion
+	for rows.Next() {
+		v := ContractVersion{AuthorKind: UserInvestor}
+		var milestones, validUntil sql.NullString
+		err := rows.Scan(&v.ContractID, &v.AuthorID, &v.Version, &v.Created, &v.Body.Title, &v.Body.Description,
+			&v.Body.Amount, &v.Body.Currency, &v.Body.MustBeDone, &milestones, &validUntil)
+		if err == nil {
+			err = v.Body.scanMilestones(milestones)
+		}
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		v.Body.ValidUntil = validUntil.String
+		versions = append(versions, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, v := range versions {
+		encoded, _ := json.Marshal(v.Body)
+		ib := sqlbuilder.NewInsertBuilder()
+		ib.InsertInto("contract_versions")
+		ib.Cols("contract_id", "version", "body", "author_kind", "author_id", "created")
+		ib.Values(v.ContractID, v.Version, string(encoded), v.AuthorKind, v.AuthorID, v.Created)
+		q, args := ib.Build()
+		if _, err := db.Exec(q, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectContract returns the contract which is neither deleted nor archived, sql.ErrNoRows if there is none
+func selectContract(db dbExecutor, id int64) (*Contract, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select(contractColumns...)
+	sb.From("contracts")
+	sb.Where(sb.Equal("id", id), sb.IsNull("deleted_at"), sb.IsNull("archived_at"))
+	q, args := sb.Build()
+
+	contract := &Contract{Investor: &Investor{}, Supplier: &Supplier{}}
+	if err := scanContract(db.QueryRow(q, args...), contract); err != nil {
+		return nil, err
+	}
+	return contract, nil
+}
+
+// updateContractBody stores the next version of the body of the contract with the columns already set
+// by ub, the template the title and the description were rendered from is dropped when they change
+func updateContractBody(tx dbExecutor, ub *sqlbuilder.UpdateBuilder, contract *Contract, body ContractBody, changes []BodyChange) error {
+	ub.Update("contracts")
+	ub.SetMore(ub.Assign("title", body.Title), ub.Assign("description", body.Description), ub.Assign("amount", body.Amount),
+		ub.Assign("currency", body.Currency), ub.Assign("must_be_done", body.MustBeDone), ub.Assign("milestones", body.milestonesValue()),
+		ub.Assign("version", contract.Version+1))
+	for _, change := range changes {
+		if change.Field == "Title" || change.Field == "Description" {
+			ub.SetMore(ub.Assign("template_id", nil), ub.Assign("template_version", nil), ub.Assign("template_params", nil))
+			break
+		}
+	}
+	ub.Where(ub.Equal("id", contract.ID), ub.Equal("version", contract.Version))
+	q, args := ub.Build()
+	res, err := tx.Exec(q, args...)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected != 1 {
+		return errContractAmended
+	}
+	return nil
+}
+
+// errContractAmended is returned when the body of the contract changed concurrently
+var errContractAmended = errors.New("Contract was amended concurrently")
+
+// EditContract - api controller for changing the body of an open contract by its creator, the body
+// becomes the next version of the contract. The offers made so far become stale until their suppliers
+// sign a new revision, the signatures of the co-investors and the pre-authorized award are discarded.
+func EditContract(c echo.Context) error {
+	ic := c.(InvestorContext)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	amendmentQuery := new(AmendmentQuery)
+	if err := c.Bind(amendmentQuery); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	contract, err := selectContract(tx, id)
+	if err == sql.ErrNoRows || err == nil && contract.Investor.ID.Int64 != ic.InvestorID.Int64 {
+		return c.String(http.StatusNotFound, "Contract not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if etag := contractETag(contract.Revision); !ifMatch(c, etag) {
+		return preconditionFailed(c, etag)
+	}
+	if contract.Stage != 0 {
+		return c.String(http.StatusConflict, "Contract is signed, it is changed by amendments agreed by both parties")
+	}
+	if contract.Sealed {
+		var bids int64
+		if err := tx.QueryRow("SELECT COUNT(*) FROM sealed_bids WHERE contract_id = ?", id).Scan(&bids); err != nil {
+			log.Fatal(err)
+		}
+		if bids > 0 {
+			return c.String(http.StatusConflict, "Sealed bids were submitted for the contract")
+		}
+	}
+	body, err := amendmentQuery.body(contract.ContractBody)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	changes := diffBodies(contract.ContractBody, body)
+	if len(changes) == 0 {
+		version, err := loadContractVersion(tx, id, contract.Version)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.Response().Header().Set(HeaderETag, contractETag(contract.Revision))
+		return c.JSON(http.StatusOK, version)
+	}
+
+	// the creator funds the rest of the new amount
+	investors, err := contractInvestors(tx, id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(investors) > 0 {
+		var coInvestors []InvestorShare
+		for _, ci := range investors {
+			if ci.InvestorID != ic.InvestorID.Int64 {
+				coInvestors = append(coInvestors, InvestorShare{ci.InvestorID, ci.Share})
+			}
+		}
+		shares, err := fundingShares(ic.InvestorID.Int64, body.Amount, coInvestors)
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		ub := sqlbuilder.NewUpdateBuilder()
+		ub.Update("contract_investors")
+		ub.Set(ub.Assign("offer_id", nil), ub.Assign("revision", nil), ub.Assign("signature", nil), ub.Assign("signed", nil))
+		ub.Where(ub.Equal("contract_id", id))
+		q, args := ub.Build()
+		if _, err := tx.Exec(q, args...); err != nil {
+			log.Fatal(err)
+		}
+		if shares != nil {
+			ub := sqlbuilder.NewUpdateBuilder()
+			ub.Update("contract_investors")
+			ub.Set(ub.Assign("share", shares[0].Share))
+			ub.Where(ub.Equal("contract_id", id), ub.Equal("investor_id", ic.InvestorID.Int64))
+			q, args := ub.Build()
+			if _, err := tx.Exec(q, args...); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	ub := sqlbuilder.NewUpdateBuilder()
+	ub.Set(ub.Assign("award_signature", nil))
+	err = updateContractBody(tx, ub, contract, body, changes)
+	if err == errContractAmended {
+		return c.String(http.StatusConflict, err.Error())
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("id")
+	sb.From("offers")
+	sb.Where(sb.Equal("contract_id", id), sb.IsNull("stale"), sb.IsNull("deleted_at"))
+	q, args := sb.Build()
+	stale, err := selectIDs(tx, q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ub = sqlbuilder.NewUpdateBuilder()
+	ub.Update("offers")
+	ub.Set(ub.Assign("stale", time.Now().UTC().Format(time.RFC3339)))
+	ub.Where(ub.Equal("contract_id", id), ub.IsNull("stale"), ub.IsNull("deleted_at"))
+	q, args = ub.Build()
+	if _, err := tx.Exec(q, args...); err != nil {
+		log.Fatal(err)
+	}
+
+	version := &ContractVersion{
+		ContractID: id,
+		Version:    contract.Version + 1,
+		Body:       body,
+		Changes:    changes,
+		AuthorKind: UserInvestor,
+		AuthorID:   ic.InvestorID.Int64,
+		Comment:    sql.NullString{String: amendmentQuery.Comment, Valid: amendmentQuery.Comment != ""},
+	}
+	if err := insertContractVersion(tx, version); err != nil {
+		log.Fatal(err)
+	}
+	err = EmitEvent(tx, Event{
+		Type:       EventContractAmended,
+		ContractID: id,
+		InvestorID: ic.InvestorID.Int64,
+		Data: struct {
+			Version     int64
+			Changes     []BodyChange
+			StaleOffers []int64 `json:",omitempty"`
+		}{version.Version, changes, stale},
+		Public: true,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	revision, err := currentContractRevision(tx, id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := tx.Commit(); isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	eventsCommitted()
+	c.Response().Header().Set(HeaderETag, contractETag(revision))
+	return c.JSON(http.StatusOK, version)
+}
+
+// ListContractVersions - api controller for obtaining the history of the body of a contract
+func ListContractVersions(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select(contractVersionColumns...)
+	sb.From("contract_versions")
+	sb.Where(sb.Equal("contract_id", id), "contract_id IN (SELECT id FROM contracts WHERE deleted_at IS NULL)")
+	if err := paginate(c, sb); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	q, args := sb.Build()
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	versions := []ContractVersion{}
+	for rows.Next() {
+		v := ContractVersion{}
+		if err := scanContractVersion(rows, &v); err != nil {
+			log.Fatal(err)
+		}
+		versions = append(versions, v)
+	}
+	if len(versions) == 0 && c.QueryParam("Offset") == "" {
+		return c.String(http.StatusNotFound, "Contract not found")
+	}
+	return c.JSON(http.StatusOK, versions)
+}
+
+// ListAmendments - api controller for obtaining the amendments proposed for a contract
+func ListAmendments(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select(contractAmendmentColumns...)
+	sb.From("contract_amendments")
+	sb.Where(sb.Equal("contract_id", id))
+	if status := c.QueryParam("Status"); status != "" {
+		sb.Where(sb.Equal("status", status))
+	}
+	if err := paginate(c, sb); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	q, args := sb.Build()
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	var exists bool
+	if err := db.QueryRow("SELECT COUNT(*) > 0 FROM contracts WHERE id = ? AND deleted_at IS NULL", id).Scan(&exists); err != nil {
+		log.Fatal(err)
+	}
+	if !exists {
+		return c.String(http.StatusNotFound, "Contract not found")
+	}
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	amendments := []ContractAmendment{}
+	for rows.Next() {
+		a := ContractAmendment{}
+		if err := scanContractAmendment(rows, &a); err != nil {
+			log.Fatal(err)
+		}
+		amendments = append(amendments, a)
+	}
+	return c.JSON(http.StatusOK, amendments)
+}
+
+// contractParty reports whether the user is the creator or the supplier of the contract, they sign
+// its amendments
+func contractParty(contract *Contract, kind string, userID int64) bool {
+	switch kind {
+	case UserInvestor:
+		return contract.Investor.ID.Int64 == userID
+	case UserSupplier:
+		return contract.Supplier.ID.Valid && contract.Supplier.ID.Int64 == userID
+	}
+	return false
+}
+
+// paidMilestoneChanged returns the paid milestone the body changes, 0 when none. A contract without
+// milestones is paid as its milestone 1, so its milestones are kept once it is paid.
+func paidMilestoneChanged(db dbExecutor, contractID int64, old, new ContractBody) (int64, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("milestone")
+	sb.From("ledger_transactions")
+	sb.Where(sb.Equal("type", LedgerRelease), sb.Equal("contract_id", contractID))
+	sb.OrderBy("milestone")
+	q, args := sb.Build()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n int64
+		if err := rows.Scan(&n); err != nil {
+			return 0, err
+		}
+		if len(old.Milestones) == 0 {
+			if len(new.Milestones) > 0 {
+				return n, nil
+			}
+			continue
+		}
+		if n > int64(len(new.Milestones)) || n > int64(len(old.Milestones)) || new.Milestones[n-1] != old.Milestones[n-1] {
+			return n, nil
+		}
+	}
+	return 0, rows.Err()
+}
+
+// ProposeAmendment - api controller for proposing a change of the body of a signed contract by its
+// creator or its supplier, the amount and the currency are funded in the escrow and the paid milestones
+// are kept. The contract has at most one pending amendment.
+func ProposeAmendment(c echo.Context) error {
+	kind, userID := currentUser(c)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	amendmentQuery := new(AmendmentQuery)
+	if err := c.Bind(amendmentQuery); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	contract, err := selectContract(db, id)
+	if err == sql.ErrNoRows || err == nil && !contractParty(contract, kind, userID) {
+		return c.String(http.StatusNotFound, "Contract not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if contract.Stage == 0 {
+		return c.String(http.StatusConflict, "Contract is not signed, its creator edits it")
+	}
+	body, err := amendmentQuery.body(contract.ContractBody)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	if body.Money != contract.ContractBody.Money {
+		return c.String(http.StatusBadRequest, "Amount and currency of a signed contract are funded in the escrow and are not amended")
+	}
+	changes := diffBodies(contract.ContractBody, body)
+	if len(changes) == 0 {
+		return c.String(http.StatusBadRequest, "Amendment changes nothing")
+	}
+	if n, err := paidMilestoneChanged(db, id, contract.ContractBody, body); err != nil {
+		log.Fatal(err)
+	} else if n != 0 {
+		return c.String(http.StatusConflict, fmt.Sprintf("Milestone %d is paid and is not amended", n))
+	}
+
+	cert, err := userCert(c.Request().Context(), kind, userID)
+	if err != nil {
+		log.Print(err)
+		return c.String(http.StatusBadGateway, "User's certificate could not be loaded")
+	}
+	encoded, _ := json.Marshal(body)
+	if !VerifySignature(amendmentQuery.Signature, cert, encoded) {
+		return c.String(http.StatusBadRequest, "Bad Signature")
+	}
+
+	tx, err := db.Begin()
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto("contract_amendments")
+	ib.Cols("contract_id", "base_version", "body", "changes", "proposer_kind", "proposer_id", "proposer_signature", "comment",
+		"status", "created")
+	ib.Values(id, contract.Version, string(encoded), changesValue(changes), kind, userID, amendmentQuery.Signature,
+		sql.NullString{String: amendmentQuery.Comment, Valid: amendmentQuery.Comment != ""}, AmendmentPending, time.Now().Format(time.RFC3339))
+	q, args := ib.Build()
+	res, err := tx.Exec(q, args...)
+	if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+		return c.String(http.StatusConflict, "Another amendment of the contract is pending")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	amendmentID, err := res.LastInsertId()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	investorID, supplierID := contract.Investor.ID.Int64, contract.Supplier.ID.Int64
+	err = EmitEvent(tx, Event{
+		Type:       EventAmendmentProposed,
+		ContractID: id,
+		InvestorID: investorID,
+		SupplierID: supplierID,
+		Data: struct {
+			Amendment    int64
+			BaseVersion  int64
+			ProposerKind string
+			Changes      []BodyChange
+			Comment      string `json:",omitempty"`
+		}{amendmentID, contract.Version, kind, changes, amendmentQuery.Comment},
+		Recipients: investorAndSupplier(investorID, supplierID),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := tx.Commit(); isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	eventsCommitted()
+	return c.JSON(http.StatusCreated, CreatedResponse{ID: amendmentID})
+}
+
+// pendingAmendment returns the contract and its pending amendment the user is a party to, it responds
+// to the request itself and returns nil when there is none
+func pendingAmendment(c echo.Context, tx dbExecutor, kind string, userID int64) (*Contract, *ContractAmendment, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, nil, c.String(http.StatusBadRequest, "Bad Request")
+	}
+	amendmentID, err := strconv.ParseInt(c.Param("amendment"), 10, 64)
+	if err != nil {
+		return nil, nil, c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	contract, err := selectContract(tx, id)
+	if err == sql.ErrNoRows || err == nil && !contractParty(contract, kind, userID) {
+		return nil, nil, c.String(http.StatusNotFound, "Contract not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select(contractAmendmentColumns...)
+	sb.From("contract_amendments")
+	sb.Where(sb.Equal("id", amendmentID), sb.Equal("contract_id", id))
+	q, args := sb.Build()
+	amendment := &ContractAmendment{}
+	err = scanContractAmendment(tx.QueryRow(q, args...), amendment)
+	if err == sql.ErrNoRows {
+		return nil, nil, c.String(http.StatusNotFound, "Amendment not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if amendment.Status != AmendmentPending {
+		return nil, nil, c.String(http.StatusConflict, "Amendment is "+amendment.Status)
+	}
+	return contract, amendment, nil
+}
+
+// resolveAmendment records the response to the pending amendment
+func resolveAmendment(tx dbExecutor, amendment *ContractAmendment, status, kind string, userID int64, signature sql.NullString) error {
+	ub := sqlbuilder.NewUpdateBuilder()
+	ub.Update("contract_amendments")
+	ub.Set(ub.Assign("status", status), ub.Assign("responder_kind", kind), ub.Assign("responder_id", userID),
+		ub.Assign("responder_signature", signature), ub.Assign("resolved", time.Now().Format(time.RFC3339)))
+	ub.Where(ub.Equal("id", amendment.ID), ub.Equal("status", AmendmentPending))
+	q, args := ub.Build()
+	res, err := tx.Exec(q, args...)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected != 1 {
+		return errContractAmended
+	}
+	return nil
+}
+
+// AcceptAmendment - api controller for signing the pending amendment by the other party of the contract,
+// the body of the amendment becomes the next version of the contract. The signatures of both parties are kept
+// with the amendment, the signatures the contract was accepted with stay unchanged.
+func AcceptAmendment(c echo.Context) error {
+	kind, userID := currentUser(c)
+	acceptionQuery := new(AmendmentAcceptionQuery)
+	if err := c.Bind(acceptionQuery); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	cert, err := userCert(c.Request().Context(), kind, userID)
+	if err != nil {
+		log.Print(err)
+		return c.String(http.StatusBadGateway, "User's certificate could not be loaded")
+	}
+
+	tx, err := db.Begin()
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	contract, amendment, err := pendingAmendment(c, tx, kind, userID)
+	if amendment == nil {
+		return err
+	}
+	if amendment.ProposerKind == kind {
+		return c.String(http.StatusConflict, "Amendment is accepted by the other party")
+	}
+	if amendment.BaseVersion != contract.Version {
+		return c.String(http.StatusConflict, "Contract was amended since the amendment was proposed")
+	}
+	if !VerifySignature(acceptionQuery.Signature, cert, amendment.encoded) {
+		return c.String(http.StatusBadRequest, "Bad Signature")
+	}
+
+	err = updateContractBody(tx, sqlbuilder.NewUpdateBuilder(), contract, amendment.Body, amendment.Changes)
+	if err == nil {
+		err = resolveAmendment(tx, amendment, AmendmentAccepted, kind, userID, sql.NullString{String: acceptionQuery.Signature, Valid: true})
+	}
+	if err == errContractAmended {
+		return c.String(http.StatusConflict, err.Error())
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	version := &ContractVersion{
+		ContractID:  contract.ID,
+		Version:     contract.Version + 1,
+		Body:        amendment.Body,
+		Changes:     amendment.Changes,
+		AuthorKind:  amendment.ProposerKind,
+		AuthorID:    amendment.ProposerID,
+		AmendmentID: sql.NullInt64{Int64: amendment.ID, Valid: true},
+		Comment:     amendment.Comment,
+	}
+	if err := insertContractVersion(tx, version); err != nil {
+		log.Fatal(err)
+	}
+	investorID, supplierID := contract.Investor.ID.Int64, contract.Supplier.ID.Int64
+	err = EmitEvent(tx, Event{
+		Type:       EventContractAmended,
+		ContractID: contract.ID,
+		InvestorID: investorID,
+		SupplierID: supplierID,
+		Data: struct {
+			Version   int64
+			Changes   []BodyChange
+			Amendment int64
+		}{version.Version, amendment.Changes, amendment.ID},
+		Recipients: investorAndSupplier(investorID, supplierID),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	revision, err := currentContractRevision(tx, contract.ID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := tx.Commit(); isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	eventsCommitted()
+	c.Response().Header().Set(HeaderETag, contractETag(revision))
+	return c.JSON(http.StatusOK, version)
+}
+
+// RejectAmendment - api controller for rejecting the pending amendment by the other party of the
+// contract or withdrawing it by its proposer
+func RejectAmendment(c echo.Context) error {
+	kind, userID := currentUser(c)
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	contract, amendment, err := pendingAmendment(c, tx, kind, userID)
+	if amendment == nil {
+		return err
+	}
+	status := AmendmentRejected
+	if amendment.ProposerKind == kind {
+		status = AmendmentWithdrawn
+	}
+	err = resolveAmendment(tx, amendment, status, kind, userID, sql.NullString{})
+	if err == errContractAmended {
+		return c.String(http.StatusConflict, "Amendment was resolved concurrently")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	investorID, supplierID := contract.Investor.ID.Int64, contract.Supplier.ID.Int64
+	err = EmitEvent(tx, Event{
+		Type:       EventAmendmentRejected,
+		ContractID: contract.ID,
+		InvestorID: investorID,
+		SupplierID: supplierID,
+		Data: struct {
+			Amendment int64
+			Status    string
+		}{amendment.ID, status},
+		Recipients: investorAndSupplier(investorID, supplierID),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := tx.Commit(); isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	eventsCommitted()
+	return c.String(http.StatusOK, "")
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// amend sends the body of the contract with the title to the path, signed by the user
+func (u *testUser) amend(method, path string, contractID int64, title string) *httptest.ResponseRecorder {
+	t := u.api.t
+	t.Helper()
+	rec := u.api.do(nil, http.MethodGet, "/contracts/"+strconv.FormatInt(contractID, 10)+"/encoded", nil)
+	var body ContractBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("encoded contract: %d %s", rec.Code, rec.Body.String())
+	}
+	body.Title = title
+	encoded, _ := json.Marshal(body)
+	return u.do(method, path, map[string]interface{}{"Title": body.Title, "Description": body.Description, "Amount": body.Amount,
+		"Currency": body.Currency, "MustBeDone": body.MustBeDone, "Signature": u.sign(encoded)})
+}
+
+// contractVersions returns the versions of the contract
+func contractVersions(t *testing.T, api *testAPI, contractID int64) []ContractVersion {
+	t.Helper()
+	rec := api.do(nil, http.MethodGet, "/contracts/"+strconv.FormatInt(contractID, 10)+"/versions", nil)
+	var versions []ContractVersion
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &versions) != nil {
+		t.Fatalf("versions: %d %s", rec.Code, rec.Body.String())
+	}
+	return versions
+}
+
+func TestEditContract(t *testing.T) {
+	api := newTestAPI(t)
+	usePaymentProvider(t, &FakePaymentProvider{})
+	investor, supplier := api.user(UserInvestor, 1), api.user(UserSupplier, 7)
+	created(t, investor.deposit(100000))
+	contractID := investor.createContract(nil)
+	offerID := supplier.createOffer(contractID)
+	path := "/contracts/" + strconv.FormatInt(contractID, 10)
+
+	expectStatus(t, "edit by another investor", api.user(UserInvestor, 2).amend(http.MethodPut, path, contractID, "Nuts"), http.StatusNotFound)
+	expectStatus(t, "edit", investor.amend(http.MethodPut, path, contractID, "Nuts"), http.StatusOK)
+	if versions := contractVersions(t, api, contractID); len(versions) != 2 || versions[1].Body.Title != "Nuts" {
+		t.Errorf("versions %+v, want the edit as version 2", versions)
+	}
+
+	// the offer made on the first version is signed again by its supplier
+	expectStatus(t, "acceptance of the stale offer", investor.accept(contractID, offerID), http.StatusConflict)
+	rec := api.do(nil, http.MethodGet, path+"/encoded", nil)
+	var terms ContractBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &terms); err != nil {
+		t.Fatal(err)
+	}
+	created(t, supplier.do(http.MethodPost, "/suppliers/offers/"+strconv.FormatInt(offerID, 10)+"/revisions", map[string]interface{}{
+		"Previous": 1, "Amount": terms.Amount, "MustBeDone": terms.MustBeDone, "Signature": supplier.sign(rec.Body.Bytes())}))
+	expectStatus(t, "acceptance of the revised offer", investor.accept(contractID, offerID), http.StatusOK)
+	expectStatus(t, "edit of the signed contract", investor.amend(http.MethodPut, path, contractID, "Screws"), http.StatusConflict)
+}
+
+func TestAmendSignedContract(t *testing.T) {
+	api := newTestAPI(t)
+	usePaymentProvider(t, &FakePaymentProvider{})
+	investor, supplier := api.user(UserInvestor, 1), api.user(UserSupplier, 7)
+	created(t, investor.deposit(100000))
+	contractID := investor.createContract(nil)
+	expectStatus(t, "acceptance", investor.accept(contractID, supplier.createOffer(contractID)), http.StatusOK)
+	amendments := "/contracts/" + strconv.FormatInt(contractID, 10) + "/amendments"
+
+	expectStatus(t, "amendment by another investor", api.user(UserInvestor, 2).amend(http.MethodPost, "/investors"+amendments, contractID, "Nuts"), http.StatusNotFound)
+	outsider := api.user(UserSupplier, 8)
+	expectStatus(t, "amendment by another supplier", outsider.amend(http.MethodPost, "/suppliers"+amendments, contractID, "Nuts"), http.StatusNotFound)
+	expectStatus(t, "amendment changing nothing", supplier.amend(http.MethodPost, "/suppliers"+amendments, contractID, "Bolts"), http.StatusBadRequest)
+	amendmentID := created(t, supplier.amend(http.MethodPost, "/suppliers"+amendments, contractID, "Nuts"))
+	expectStatus(t, "second pending amendment", investor.amend(http.MethodPost, "/investors"+amendments, contractID, "Screws"), http.StatusConflict)
+
+	rec := api.do(nil, http.MethodGet, amendments, nil)
+	var pending []ContractAmendment
+	if err := json.Unmarshal(rec.Body.Bytes(), &pending); err != nil || len(pending) != 1 || pending[0].Status != AmendmentPending {
+		t.Fatalf("amendments %s, want the pending one", rec.Body.String())
+	}
+	encoded, _ := json.Marshal(pending[0].Body)
+	signature := investor.sign(encoded)
+	accept := "/" + strconv.FormatInt(amendmentID, 10) + "/accept"
+	expectStatus(t, "acceptance by the proposer", supplier.do(http.MethodPost, "/suppliers"+amendments+accept,
+		AmendmentAcceptionQuery{supplier.sign(encoded)}), http.StatusConflict)
+	expectStatus(t, "acceptance by another supplier", outsider.do(http.MethodPost, "/suppliers"+amendments+accept,
+		AmendmentAcceptionQuery{signature}), http.StatusNotFound)
+	expectStatus(t, "acceptance", investor.do(http.MethodPost, "/investors"+amendments+accept, AmendmentAcceptionQuery{signature}), http.StatusOK)
+	versions := contractVersions(t, api, contractID)
+	if last := versions[len(versions)-1]; last.Body.Title != "Nuts" || last.AmendmentID.Int64 != amendmentID {
+		t.Errorf("last version %+v, want the amendment %d", last, amendmentID)
+	}
+
+	// the proposer withdraws an amendment, the other party rejects it
+	for _, by := range []*testUser{supplier, investor} {
+		amendmentID := created(t, supplier.amend(http.MethodPost, "/suppliers"+amendments, contractID, "Screws"))
+		reject := amendments + "/" + strconv.FormatInt(amendmentID, 10) + "/reject"
+		expectStatus(t, "rejection by another supplier", outsider.do(http.MethodPost, "/suppliers"+reject, nil), http.StatusNotFound)
+		expectStatus(t, "rejection by the "+by.kind, by.do(http.MethodPost, "/"+by.kind+"s"+reject, nil), http.StatusOK)
+		expectStatus(t, "second rejection", by.do(http.MethodPost, "/"+by.kind+"s"+reject, nil), http.StatusConflict)
+	}
+	rec = api.do(nil, http.MethodGet, amendments, nil)
+	var all []ContractAmendment
+	if err := json.Unmarshal(rec.Body.Bytes(), &all); err != nil || len(all) != 3 {
+		t.Fatalf("amendments %s, want 3", rec.Body.String())
+	}
+	statuses := map[string]bool{}
+	for _, a := range all {
+		statuses[a.Status] = true
+	}
+	if !statuses[AmendmentAccepted] || !statuses[AmendmentWithdrawn] || !statuses[AmendmentRejected] {
+		t.Errorf("statuses %v, want accepted, withdrawn and rejected", statuses)
+	}
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/labstack/echo"
+)
+
+// Award policies of contracts
+const (
+	AwardManual           = "manual"
+	AwardLowestAmount     = "lowest_amount"
+	AwardEarliestDelivery = "earliest_delivery"
+	AwardWeightedScore    = "weighted_score"
+)
+
+const awardPeriod = time.Minute
+
+// AwardWeights are the weights of the criteria of weighted_score policy, every criterion is
+// normalized among the offers so the best one scores 1 and the worst one 0
+type AwardWeights struct {
+	Amount   float64
+	Delivery float64
+}
+
+// ContractAward describes how the winning offer is selected at the bidding deadline,
+// Awarded is the time the offers were evaluated and OfferID is the winner
+type ContractAward struct {
+	Policy  string
+	Weights *AwardWeights `json:",omitempty"`
+	Awarded sql.NullString
+	OfferID sql.NullInt64
+}
+
+func (a *ContractAward) scan(policy, weights sql.NullString) error {
+	a.Policy = AwardManual
+	if policy.Valid && policy.String != "" {
+		a.Policy = policy.String
+	}
+	a.Weights = nil
+	if !weights.Valid || weights.String == "" {
+		return nil
+	}
+	a.Weights = new(AwardWeights)
+	return json.Unmarshal([]byte(weights.String), a.Weights)
+}
+
+// AwardQuery - award policy of a contract. PreauthorizedSignature is the investor's signature of
+// the encoded ContractBody, the contract is concluded automatically when the winning offer has
+// exactly these terms, otherwise the investor is notified to sign the winning offer
+type AwardQuery struct {
+	AwardPolicy            string
+	AwardWeights           *AwardWeights
+	PreauthorizedSignature string
+}
+
+func (q *AwardQuery) validate(hasDeadline bool) error {
+	switch q.AwardPolicy {
+	case "", AwardManual:
+		return nil
+	case AwardLowestAmount, AwardEarliestDelivery:
+	case AwardWeightedScore:
+		w := q.AwardWeights
+		if w == nil || w.Amount < 0 || w.Delivery < 0 || w.Amount+w.Delivery == 0 {
+			return errors.New("AwardWeights must be non-negative and not all zero")
+		}
+	default:
+		return errors.New("Unknown AwardPolicy")
+	}
+	if !hasDeadline {
+		return errors.New("AwardPolicy requires BiddingDeadline")
+	}
+	return nil
+}
+
+func (q *AwardQuery) policyValue() interface{} {
+	if q.AwardPolicy == "" {
+		return nil
+	}
+	return q.AwardPolicy
+}
+
+func (q *AwardQuery) weightsValue() interface{} {
+	if q.AwardPolicy != AwardWeightedScore {
+		return nil
+	}
+	r, _ := json.Marshal(q.AwardWeights)
+	return string(r)
+}
+
+func (q *AwardQuery) signatureValue() interface{} {
+	if q.PreauthorizedSignature == "" {
+		return nil
+	}
+	return q.PreauthorizedSignature
+}
+
+// SetAwardPolicy - api controller for changing the award policy of an open contract
+func SetAwardPolicy(c echo.Context) error {
+	ic := c.(InvestorContext)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	awardQuery := new(AwardQuery)
+	if err := c.Bind(awardQuery); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("stage", "bidding_deadline", "awarded")
+	sb.From("contracts")
+	sb.Where(sb.Equal("id", id), sb.Equal("investor_id", ic.InvestorID), sb.IsNull("deleted_at"))
+	q, args := sb.Build()
+
+	var stage int64
+	var biddingDeadline, awarded sql.NullString
+	err = db.QueryRow(q, args...).Scan(&stage, &biddingDeadline, &awarded)
+	if err == sql.ErrNoRows {
+		return c.String(http.StatusNotFound, "Contract not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if stage != 0 {
+		return c.String(http.StatusConflict, "Contract is already concluded")
+	}
+	if awarded.Valid {
+		return c.String(http.StatusConflict, "Contract is already awarded")
+	}
+	if err := awardQuery.validate(biddingDeadline.Valid); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	ub := sqlbuilder.NewUpdateBuilder()
+	ub.Update("contracts")
+	ub.Set(ub.Assign("award_policy", awardQuery.policyValue()), ub.Assign("award_weights", awardQuery.weightsValue()),
+		ub.Assign("award_signature", awardQuery.signatureValue()))
+	ub.Where(ub.Equal("id", id), ub.Equal("stage", 0), ub.IsNull("awarded"))
+	q, args = ub.Build()
+	res, err := db.Exec(q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if affected, err := res.RowsAffected(); err != nil || affected != 1 {
+		return c.String(http.StatusConflict, "Contract is already awarded")
+	}
+	return c.String(http.StatusOK, "")
+}
+
+// RunAwards periodically selects the winners of the contracts whose bidding deadline has passed
+func RunAwards() {
+	for {
+		if err := awardContracts(time.Now()); err != nil {
+			log.Print("awards: ", err)
+		}
+		time.Sleep(awardPeriod)
+	}
+}
+
+// awardContracts evaluates the offers of the open contracts with automatic award policy
+// whose bidding deadline has passed by the time
+func awardContracts(now time.Time) error {
+	db, err := openDB(context.Background())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// deadlines are stored as RFC3339 in UTC, so they are compared as strings,
+	// sealed contracts are awarded once the bids are revealed
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select(contractColumns...)
+	sb.From("contracts")
+	sb.Where(sb.Equal("stage", 0), sb.IsNull("awarded"), sb.IsNull("deleted_at"), sb.IsNotNull("award_policy"),
+		sb.NotEqual("award_policy", AwardManual), sb.IsNotNull("bidding_deadline"),
+		sb.LessEqualThan("COALESCE(reveal_deadline, bidding_deadline)", now.UTC().Format(time.RFC3339)))
+	q, args := sb.Build()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return err
+	}
+	var contracts []Contract
+	for rows.Next() {
+		contract := Contract{Investor: &Investor{}, Supplier: &Supplier{}}
+		if err := scanContract(rows, &contract); err != nil {
+			rows.Close()
+			return err
+		}
+		contracts = append(contracts, contract)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range contracts {
+		// a failed award is retried on the next run
+		if err := awardContract(db, &contracts[i], now); err != nil {
+			log.Printf("awards: contract %d: %v", contracts[i].ID, err)
+		}
+	}
+	return nil
+}
+
+// awardCandidates returns the latest revisions of the offers which may be accepted at the time,
+// in order of the offers
+func awardCandidates(db *sql.DB, contractID int64, now time.Time) ([]*OfferRevision, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("id")
+	sb.From("offers")
+	sb.Where(sb.Equal("contract_id", contractID), sb.IsNull("expired"), sb.IsNull("stale"), sb.IsNull("deleted_at"))
+	sb.OrderBy("id")
+	q, args := sb.Build()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var candidates []*OfferRevision
+	for _, id := range ids {
+		r, err := latestOfferRevision(db, id)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if r.AuthorKind != UserSupplier || r.expired(now) {
+			continue
+		}
+		if _, err := time.Parse(time.RFC3339, r.Terms.MustBeDone); err != nil {
+			continue
+		}
+		candidates = append(candidates, r)
+	}
+	return candidates, nil
+}
+
+// awardScores scores the candidates according to the policy, the higher the better
+func awardScores(award ContractAward, candidates []*OfferRevision) []float64 {
+	amount := make([]float64, len(candidates))
+	delivery := make([]float64, len(candidates))
+	for i, r := range candidates {
+		due, _ := time.Parse(time.RFC3339, r.Terms.MustBeDone)
+		amount[i] = -float64(r.Terms.Amount)
+		delivery[i] = -float64(due.Unix())
+	}
+	switch award.Policy {
+	case AwardLowestAmount:
+		return amount
+	case AwardEarliestDelivery:
+		return delivery
+	}
+
+	weights := AwardWeights{}
+	if award.Weights != nil {
+		weights = *award.Weights
+	}
+	amount, delivery = normalizeScores(amount), normalizeScores(delivery)
+	scores := make([]float64, len(candidates))
+	for i := range candidates {
+		scores[i] = weights.Amount*amount[i] + weights.Delivery*delivery[i]
+	}
+	return scores
+}
+
+// normalizeScores maps the values linearly so the best is 1 and the worst is 0
+func normalizeScores(values []float64) []float64 {
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	normalized := make([]float64, len(values))
+	for i, v := range values {
+		if max == min {
+			normalized[i] = 1
+		} else {
+			normalized[i] = (v - min) / (max - min)
+		}
+	}
+	return normalized
+}
+
+// selectWinner returns the best candidate, the earlier offer wins a tie
+func selectWinner(award ContractAward, candidates []*OfferRevision) *OfferRevision {
+	if len(candidates) == 0 {
+		return nil
+	}
+	scores := awardScores(award, candidates)
+	best := 0
+	for i := range candidates {
+		if scores[i] > scores[best] {
+			best = i
+		}
+	}
+	return candidates[best]
+}
+
+// awardContract records the winner of the contract and notifies the parties. When the investor's
+// pre-authorized signature verifies over the winning terms the offer is accepted the same way
+// as by UpdateContract, otherwise the investor is expected to accept it
+func awardContract(db *sql.DB, contract *Contract, now time.Time) error {
+	candidates, err := awardCandidates(db, contract.ID, now)
+	if err != nil {
+		return err
+	}
+	winner := selectWinner(contract.Award, candidates)
+
+	var signature sql.NullString
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("award_signature")
+	sb.From("contracts")
+	sb.Where(sb.Equal("id", contract.ID))
+	q, args := sb.Build()
+	if err := db.QueryRow(q, args...).Scan(&signature); err != nil {
+		return err
+	}
+	if winner != nil && signature.Valid {
+		cert, err := userCert(context.Background(), UserInvestor, contract.Investor.ID.Int64)
+		if err != nil {
+			// the certificate is needed to accept the offer
+			return err
+		}
+		contract.Investor.Cert = sql.NullString{String: cert, Valid: true}
+		if VerifySignature(signature.String, cert, winner.encoded) {
+			err := recordAward(db, contract, winner, now, signature.String)
+			acceptErr, ok := err.(offerNotAccepted)
+			if !ok {
+				return err
+			}
+			// the investor accepts the offer by the notification instead
+			log.Printf("awards: contract %d: offer %d is not accepted: %v", contract.ID, winner.OfferID, acceptErr.err)
+		}
+	}
+	return recordAward(db, contract, winner, now, "")
+}
+
+// offerNotAccepted is returned by recordAward when the offer is not accepted by the pre-authorized
+// signature, nothing is recorded then
+type offerNotAccepted struct {
+	err error
+}
+
+func (e offerNotAccepted) Error() string {
+	return "offer is not accepted: " + e.err.Error()
+}
+
+// recordAward records the winner, which may be nil, and emits contract.awarded. When signature is given
+// the winning offer is accepted by it in the same transaction, so the parties are notified of the award
+// as pre-authorized only when the offer is accepted. Co-investors of a co-funded contract sign the winning
+// offer by the notification.
+func recordAward(db *sql.DB, contract *Contract, winner *OfferRevision, now time.Time, signature string) error {
+	var winnerID sql.NullInt64
+	var supplierID, revision int64
+	if winner != nil {
+		winnerID = sql.NullInt64{Int64: winner.OfferID, Valid: true}
+		supplierID, revision = winner.AuthorID, winner.Revision
+	}
+	investorID := contract.Investor.ID.Int64
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ub := sqlbuilder.NewUpdateBuilder()
+	ub.Update("contracts")
+	ub.Set(ub.Assign("awarded", now.Format(time.RFC3339)), ub.Assign("award_offer_id", winnerID))
+	ub.Where(ub.Equal("id", contract.ID), ub.Equal("stage", 0), ub.IsNull("awarded"))
+	q, args := ub.Build()
+	res, err := tx.Exec(q, args...)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil || affected != 1 {
+		// concluded or awarded meanwhile
+		return err
+	}
+
+	err = EmitEvent(tx, Event{
+		Type:       EventContractAwarded,
+		ContractID: contract.ID,
+		OfferID:    winnerID.Int64,
+		InvestorID: investorID,
+		SupplierID: supplierID,
+		Data: struct {
+			Policy        string
+			Revision      int64 `json:",omitempty"`
+			Preauthorized bool
+		}{contract.Award.Policy, revision, signature != ""},
+		Recipients: investorAndSupplier(investorID, supplierID),
+	})
+	if err != nil {
+		return err
+	}
+	if signature != "" {
+		_, err := acceptOffer(tx, contract, contract.Investor, winner.OfferID, winner.Revision, signature)
+		if err != nil && err != errSignaturesPending {
+			return offerNotAccepted{err}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	eventsCommitted()
+	return nil
+}
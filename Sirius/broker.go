@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Message is a domain event prepared for publishing, RoutingKey is the event type
+type Message struct {
+	ID         string
+	RoutingKey string
+	Timestamp  time.Time
+	Body       []byte
+}
+
+// Broker publishes messages, Publish returns nil only when the broker has confirmed
+// that the message is accepted
+type Broker interface {
+	Publish(msg Message) error
+	Close() error
+}
+
+// AMQPBroker publishes messages to a RabbitMQ exchange with publisher confirms,
+// the connection is re-established on the next Publish after a failure
+type AMQPBroker struct {
+	URL            string
+	Exchange       string
+	ConfirmTimeout time.Duration
+
+	mu       sync.Mutex
+	conn     *amqp.Connection
+	channel  *amqp.Channel
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+}
+
+// NewAMQPBroker creates broker publishing to the durable topic exchange, the connection
+// is established lazily so the service starts while the broker is down
+func NewAMQPBroker(url, exchange string) *AMQPBroker {
+	return &AMQPBroker{URL: url, Exchange: exchange, ConfirmTimeout: 10 * time.Second}
+}
+
+func (b *AMQPBroker) connect() error {
+	conn, err := amqp.Dial(b.URL)
+	if err != nil {
+		return err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	err = ch.ExchangeDeclare(b.Exchange, amqp.ExchangeTopic, true, false, false, false, nil)
+	if err == nil {
+		err = ch.Confirm(false)
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	b.conn = conn
+	b.channel = ch
+	b.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+	b.returns = ch.NotifyReturn(make(chan amqp.Return, 1))
+	return nil
+}
+
+func (b *AMQPBroker) reset() {
+	if b.conn != nil {
+		b.conn.Close()
+	}
+	b.conn, b.channel, b.confirms, b.returns = nil, nil, nil, nil
+}
+
+// Publish sends persistent message and waits for the confirmation. The message is mandatory,
+// the broker returns it before the confirmation when no queue is bound for its routing key,
+// then it is not delivered and Publish fails.
+func (b *AMQPBroker) Publish(msg Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.channel == nil {
+		if err := b.connect(); err != nil {
+			return err
+		}
+	}
+	err := b.channel.Publish(b.Exchange, msg.RoutingKey, true, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    msg.ID,
+		Type:         msg.RoutingKey,
+		Timestamp:    msg.Timestamp,
+		AppId:        "sirius",
+		Headers:      amqp.Table{"version": int32(EventSchemaVersion)},
+		Body:         msg.Body,
+	})
+	if err != nil {
+		b.reset()
+		return err
+	}
+
+	select {
+	case confirm, ok := <-b.confirms:
+		if !ok {
+			b.reset()
+			return errors.New("amqp: channel closed before confirmation")
+		}
+		if !confirm.Ack {
+			return errors.New("amqp: message is not acknowledged by the broker")
+		}
+		return b.returned(msg)
+	case <-time.After(b.ConfirmTimeout):
+		// the confirmation of this message may arrive later and would be taken for the next one's
+		b.reset()
+		return errors.New("amqp: confirmation timeout")
+	}
+}
+
+// returned takes the returns received before the confirmation of msg, it fails when msg is among them
+func (b *AMQPBroker) returned(msg Message) error {
+	var err error
+	for {
+		select {
+		case ret, ok := <-b.returns:
+			if !ok {
+				b.reset()
+				return errors.New("amqp: channel closed before confirmation")
+			}
+			if ret.MessageId == msg.ID {
+				err = fmt.Errorf("amqp: message is returned by the broker: %s", ret.ReplyText)
+			}
+		default:
+			return err
+		}
+	}
+}
+
+// Close closes the connection
+func (b *AMQPBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reset()
+	return nil
+}
+
+// MemoryBroker keeps published messages in memory, it replaces AMQPBroker in tests
+type MemoryBroker struct {
+	mu       sync.Mutex
+	messages []Message
+	// Err is returned by Publish instead of accepting the message, when set
+	Err error
+}
+
+// Publish stores the message
+func (b *MemoryBroker) Publish(msg Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.Err != nil {
+		return b.Err
+	}
+	b.messages = append(b.messages, msg)
+	return nil
+}
+
+// Messages returns the published messages
+func (b *MemoryBroker) Messages() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Message(nil), b.messages...)
+}
+
+// Close does nothing
+func (b *MemoryBroker) Close() error {
+	return nil
+}
@@ -14,6 +14,7 @@ import (
 	"io/ioutil"
 	"log"
 	"math/big"
+	"net"
 	"os"
 	"strconv"
 	"time"
@@ -53,21 +54,19 @@ func GenerateECKey(fn string) (key *ecdsa.PrivateKey) {
 	return
 }
 
-func GenerateCert(pub, priv interface{}, cert_signer *x509.Certificate, cn string, ku x509.KeyUsage, filename string) {
+// GenerateCert issues the certificate of the template with the next serial, it is valid for a year
+// unless the template sets NotAfter. The certificate is self-signed when cert_signer is the template.
+func GenerateCert(pub, priv interface{}, cert_signer *x509.Certificate, template *x509.Certificate, filename string) {
 	sn, _ := ioutil.ReadFile("serial")
 	s, _ := strconv.Atoi(string(sn))
-	template := x509.Certificate{
-		SerialNumber: big.NewInt(int64(s)),
-		Subject: pkix.Name{
-			CommonName:   cn,
-			Organization: []string{"Sirius Service"},
-		},
-		KeyUsage:  ku,
-		NotBefore: time.Now(),
-		NotAfter:  time.Now().Add(time.Hour * 24 * 365),
+	template.SerialNumber = big.NewInt(int64(s))
+	template.Subject.Organization = []string{"Sirius Service"}
+	template.NotBefore = time.Now()
+	if template.NotAfter.IsZero() {
+		template.NotAfter = time.Now().Add(time.Hour * 24 * 365)
 	}
 	certDer, err := x509.CreateCertificate(
-		rand.Reader, &template, cert_signer, pub, priv,
+		rand.Reader, template, cert_signer, pub, priv,
 	)
 	if err != nil {
 		log.Fatalf("Failed to create certificate: %s\n", err)
@@ -90,6 +89,30 @@ func GenerateCert(pub, priv interface{}, cert_signer *x509.Certificate, cn strin
 	ioutil.WriteFile("serial", []byte(strconv.Itoa(s+1)), 0644)
 }
 
+// loadAuthority reads the key and the certificate of the root authority
+func loadAuthority() (*ecdsa.PrivateKey, *x509.Certificate) {
+	keyPem, err := ioutil.ReadFile("sirius.key")
+	if err != nil {
+		log.Fatal(err)
+	}
+	certPem, err := ioutil.ReadFile("sirius.crt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	keyBlock, _ := pem.Decode(keyPem)
+	certBlock, _ := pem.Decode(certPem)
+
+	priv, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return priv, cert
+}
+
 func VerifySignature(b64signature, pemcert string, data []byte) bool {
 	derSignature, err := base64.StdEncoding.DecodeString(b64signature)
 	if err != nil {
@@ -116,22 +139,70 @@ func VerifySignature(b64signature, pemcert string, data []byte) bool {
 
 func main() {
 	switch os.Args[1] {
+	case "-ca":
+		// the root authority signs the certificates of the users and verifies their TLS client certificates
+		log.Print("Generating the root authority to sirius.key and sirius.crt")
+		ECKey := GenerateECKey("sirius.key")
+		template := &x509.Certificate{
+			Subject:               pkix.Name{CommonName: "ECDSA Sirius Root Authority"},
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+			NotAfter:              time.Now().Add(time.Hour * 24 * 365 * 10),
+		}
+		GenerateCert(&ECKey.PublicKey, ECKey, template, template, "sirius.crt")
 	case "-g":
+		// -g CN [supplier|investor ID] adds the kind and the ID of the user to the subject,
+		// Sirius identifies the TLS client by them
 		if len(os.Args) < 3 {
 			log.Fatal("No CN provided!")
 		}
 		fn := os.Args[2]
+		template := &x509.Certificate{
+			Subject:     pkix.Name{CommonName: fn},
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		if len(os.Args) == 5 {
+			if os.Args[3] != "supplier" && os.Args[3] != "investor" {
+				log.Fatal("User kind must be supplier or investor")
+			}
+			if _, err := strconv.ParseInt(os.Args[4], 10, 64); err != nil {
+				log.Fatal("Invalid user ID")
+			}
+			template.Subject.OrganizationalUnit = []string{os.Args[3]}
+			template.Subject.SerialNumber = os.Args[4]
+		} else if len(os.Args) != 3 {
+			log.Fatal("Invalid params!")
+		}
 		log.Printf("Generating an ECDSA P-384 Private Key to %s.key", fn)
-		keyPem, _ := ioutil.ReadFile("sirius.key")
-		certPem, _ := ioutil.ReadFile("sirius.crt")
-		keyBlock, _ := pem.Decode(keyPem)
-		certBlock, _ := pem.Decode(certPem)
+		priv, cert := loadAuthority()
 
-		priv, _ := x509.ParseECPrivateKey(keyBlock.Bytes)
-		cert, _ := x509.ParseCertificate(certBlock.Bytes)
+		ECKey := GenerateECKey(fn + ".key")
+		GenerateCert(&ECKey.PublicKey, priv, cert, template, fn+".crt")
+	case "-server":
+		// -server NAME HOST... issues the TLS certificate of Sirius for the host names and addresses
+		if len(os.Args) < 4 {
+			log.Fatal("No hosts provided!")
+		}
+		fn := os.Args[2]
+		template := &x509.Certificate{
+			Subject:     pkix.Name{CommonName: os.Args[3]},
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		for _, host := range os.Args[3:] {
+			if ip := net.ParseIP(host); ip != nil {
+				template.IPAddresses = append(template.IPAddresses, ip)
+			} else {
+				template.DNSNames = append(template.DNSNames, host)
+			}
+		}
+		log.Printf("Generating an ECDSA P-384 Private Key to %s.key", fn)
+		priv, cert := loadAuthority()
 
 		ECKey := GenerateECKey(fn + ".key")
-		GenerateCert(&ECKey.PublicKey, priv, cert, fn, x509.KeyUsageDigitalSignature, fn+".crt")
+		GenerateCert(&ECKey.PublicKey, priv, cert, template, fn+".crt")
 	case "-s":
 		var key, data string
 		if len(os.Args) < 4 {
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// The admin methods are allowed to the users with the staff roles, they authenticate by Login
+// or by WithClientCertificate
+
+// RemoveContract removes an abusive contract of any investor, the reason is kept in the audit log
+func (c *Client) RemoveContract(ctx context.Context, contractID int64, reason string) error {
+	payload := struct{ Reason string }{reason}
+	return c.doJSON(ctx, http.MethodDelete, fmt.Sprintf("/admin/contracts/%d", contractID), nil, payload, nil)
+}
+
+// RemoveOffer removes an abusive offer of any supplier, the reason is kept in the audit log
+func (c *Client) RemoveOffer(ctx context.Context, offerID int64, reason string) error {
+	payload := struct{ Reason string }{reason}
+	return c.doJSON(ctx, http.MethodDelete, fmt.Sprintf("/admin/offers/%d", offerID), nil, payload, nil)
+}
+
+// RestoreRemovedContract restores a deleted contract of any investor, the reason is kept in the audit log
+func (c *Client) RestoreRemovedContract(ctx context.Context, contractID int64, reason string) error {
+	payload := struct{ Reason string }{reason}
+	return c.doJSON(ctx, http.MethodPost, fmt.Sprintf("/admin/contracts/%d/restore", contractID), nil, payload, nil)
+}
+
+// RestoreRemovedOffer restores a deleted offer of any supplier, the reason is kept in the audit log
+func (c *Client) RestoreRemovedOffer(ctx context.Context, offerID int64, reason string) error {
+	payload := struct{ Reason string }{reason}
+	return c.doJSON(ctx, http.MethodPost, fmt.Sprintf("/admin/offers/%d/restore", offerID), nil, payload, nil)
+}
+
+// ContractSignatures returns the signatures made on the contract, its offers and their revisions
+func (c *Client) ContractSignatures(ctx context.Context, contractID int64) ([]SignatureRecord, error) {
+	var signatures []SignatureRecord
+	err := c.doJSON(ctx, http.MethodGet, fmt.Sprintf("/admin/contracts/%d/signatures", contractID), nil, nil, &signatures)
+	return signatures, err
+}
+
+// AuditLog returns a single page of the audit log, action and target filter it when not empty
+func (c *Client) AuditLog(ctx context.Context, action, target string, p Page) ([]AuditEntry, error) {
+	q := url.Values{}
+	if action != "" {
+		q.Set("Action", action)
+	}
+	if target != "" {
+		q.Set("Target", target)
+	}
+	p.apply(q)
+
+	var entries []AuditEntry
+	err := c.doJSON(ctx, http.MethodGet, "/admin/audit", q, nil, &entries)
+	return entries, err
+}
+
+// ListRoles returns the staff roles granted to the users
+func (c *Client) ListRoles(ctx context.Context) ([]RoleGrant, error) {
+	var grants []RoleGrant
+	err := c.doJSON(ctx, http.MethodGet, "/admin/roles", nil, nil, &grants)
+	return grants, err
+}
+
+// GrantRole grants the staff role to the user of kind UserInvestor or UserSupplier
+func (c *Client) GrantRole(ctx context.Context, kind string, userID int64, role string) (*RoleGrant, error) {
+	payload := struct {
+		UserKind string
+		UserID   int64
+		Role     string
+	}{kind, userID, role}
+	var grant RoleGrant
+	if err := c.doJSON(ctx, http.MethodPost, "/admin/roles", nil, payload, &grant); err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+// RevokeRole revokes the role grant
+func (c *Client) RevokeRole(ctx context.Context, grantID int64) error {
+	return c.doJSON(ctx, http.MethodDelete, fmt.Sprintf("/admin/roles/%d", grantID), nil, nil, nil)
+}
@@ -0,0 +1,30 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// RegisterCertificate registers the client certificate the client connects with for the user of
+// kind UserInvestor or UserSupplier authenticated by the token. Certificates with the user in the
+// subject identify the user without registration.
+func (c *Client) RegisterCertificate(ctx context.Context, kind string) (*ClientCertificate, error) {
+	var cert ClientCertificate
+	if err := c.doJSON(ctx, http.MethodPost, "/"+kind+"s/certificates", nil, nil, &cert); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// ListCertificates returns the client certificates registered by the user
+func (c *Client) ListCertificates(ctx context.Context, kind string) ([]ClientCertificate, error) {
+	var certs []ClientCertificate
+	err := c.doJSON(ctx, http.MethodGet, "/"+kind+"s/certificates", nil, nil, &certs)
+	return certs, err
+}
+
+// DeleteCertificate unregisters the user's client certificate
+func (c *Client) DeleteCertificate(ctx context.Context, kind string, id int64) error {
+	return c.doJSON(ctx, http.MethodDelete, fmt.Sprintf("/%ss/certificates/%d", kind, id), nil, nil, nil)
+}
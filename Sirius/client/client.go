@@ -0,0 +1,270 @@
+// Package client is a Go client for the Sirius contracts api.
+//
+// A Client acts on behalf of a single user, investor or supplier, identified
+// by the token issued by Canopus or Vega:
+//
+//	c := client.New("http://localhost:1323", client.WithToken(token))
+//	contract, err := c.GetContract(ctx, 6)
+//
+// Contracts are signed with ECDSA P-384 over SHA-384 of the encoded
+// ContractBody, see SignContract.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client performs requests to the Sirius api
+type Client struct {
+	baseURL    string
+	token      string
+	session    string
+	httpClient *http.Client
+	userAgent  string
+
+	maxRetries int
+	backoff    time.Duration
+}
+
+// Option configures Client
+type Option func(*Client)
+
+// WithToken sets the token sent in the Authorization header
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithSession sets the session token of the challenge-response login, it is sent instead of the token
+func WithSession(session string) Option {
+	return func(c *Client) {
+		c.session = session
+	}
+}
+
+// WithHTTPClient replaces http.DefaultClient
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithClientCertificate authenticates the user by the TLS client certificate issued by the Sirius CA
+// instead of the token, the server certificate is verified against roots or the system roots when
+// roots is nil. It replaces the HTTP client.
+func WithClientCertificate(cert tls.Certificate, roots *x509.CertPool) Option {
+	return func(c *Client) {
+		c.httpClient = &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: roots},
+		}}
+	}
+}
+
+// WithUserAgent sets User-Agent header of the requests
+func WithUserAgent(ua string) Option {
+	return func(c *Client) {
+		c.userAgent = ua
+	}
+}
+
+// WithRetries sets how many times a failed idempotent request is retried,
+// the delay before the n-th retry is backoff * 2^(n-1)
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.backoff = backoff
+	}
+}
+
+// New creates a client for the api served at baseURL
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		userAgent:  "sirius-go-client/1.0",
+		maxRetries: 3,
+		backoff:    200 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// retryable reports whether a request which ended with the status may succeed if repeated
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// idempotent reports whether a request with the method may be safely repeated
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// idempotencyKeyContext is the context key of the Idempotency-Key of the request
+type idempotencyKeyContext struct{}
+
+// withIdempotencyKey returns the context of a request sent with the key, or with a random key
+// when it is empty
+func withIdempotencyKey(ctx context.Context, key string) context.Context {
+	if key == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return ctx
+		}
+		key = hex.EncodeToString(b)
+	}
+	return context.WithValue(ctx, idempotencyKeyContext{}, key)
+}
+
+// ifMatchContext is the context key of the If-Match of the request
+type ifMatchContext struct{}
+
+// IfMatch returns the context of a conditional request, the update or deletion sent with it fails
+// with ErrPreconditionFailed when the resource has changed since its ETag was obtained
+func IfMatch(ctx context.Context, etag string) context.Context {
+	return context.WithValue(ctx, ifMatchContext{}, etag)
+}
+
+// do sends the request and returns the body of a successful (2xx) response,
+// in is marshaled to json request body if not nil
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in interface{}) ([]byte, error) {
+	data, _, err := c.doResponse(ctx, method, path, query, in)
+	return data, err
+}
+
+// doResponse sends the request like do and returns the response as well, its body is read
+func (c *Client) doResponse(ctx context.Context, method, path string, query url.Values, in interface{}) ([]byte, *http.Response, error) {
+	var payload []byte
+	if in != nil {
+		var err error
+		payload, err = json.Marshal(in)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	// requests with an Idempotency-Key are safe to repeat as the api returns the first response
+	idempotencyKey, _ := ctx.Value(idempotencyKeyContext{}).(string)
+	attempts := 1
+	if idempotent(method) || idempotencyKey != "" {
+		attempts += c.maxRetries
+	}
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			delay := c.backoff << uint(attempt-1)
+			if apiErr, ok := lastErr.(*APIError); ok && apiErr.RetryAfter > delay {
+				delay = apiErr.RetryAfter
+			}
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+				return nil, nil, lastErr
+			}
+			select {
+			case <-ctx.Done():
+				return nil, nil, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		var body io.Reader
+		if payload != nil {
+			body = bytes.NewReader(payload)
+		}
+		req, err := http.NewRequest(method, u, body)
+		if err != nil {
+			return nil, nil, err
+		}
+		req = req.WithContext(ctx)
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if c.session != "" {
+			req.Header.Set("Authorization", "Session "+c.session)
+		} else if c.token != "" {
+			req.Header.Set("Authorization", "Token "+c.token)
+		}
+		req.Header.Set("User-Agent", c.userAgent)
+		if idempotencyKey != "" {
+			req.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+		if etag, ok := ctx.Value(ifMatchContext{}).(string); ok && method != http.MethodGet {
+			req.Header.Set("If-Match", etag)
+		}
+
+		res, err := c.httpClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if res.StatusCode >= 200 && res.StatusCode < 300 {
+			return data, res, nil
+		}
+		apiErr := &APIError{
+			StatusCode: res.StatusCode,
+			Method:     method,
+			Path:       path,
+			Message:    strings.TrimSpace(string(data)),
+		}
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		lastErr = apiErr
+		if !retryable(res.StatusCode) {
+			break
+		}
+	}
+	return nil, nil, lastErr
+}
+
+// doJSON sends the request and unmarshals the response to out
+func (c *Client) doJSON(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	data, err := c.do(ctx, method, path, query, in)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("sirius: decoding response of %s %s: %v", method, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer records the requests made to it and answers them with the handler
+type testServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []map[string]interface{}
+}
+
+func newTestServer(t *testing.T, handler http.HandlerFunc) *testServer {
+	s := &testServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		s.mu.Lock()
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, body)
+		s.mu.Unlock()
+		handler(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testServer) client(opts ...Option) *Client {
+	return New(s.URL, append([]Option{WithToken("secret"), WithRetries(3, time.Millisecond)}, opts...)...)
+}
+
+func TestAcceptOfferSigned(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	terms := []byte(`{"Title":"Bolts","Amount":90000,"Currency":"EUR"}`)
+	s := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "GET /contracts/3":
+			w.Write([]byte(`{"ID":3,"Revision":5}`))
+		case "GET /investors/offers/9/encoded":
+			w.Header().Set(headerRevision, "2")
+			w.Write(terms)
+		case "PATCH /contracts/3":
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	if err := s.client().AcceptOfferSigned(context.Background(), 3, 9, key); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.requests) != 3 {
+		t.Fatalf("%d requests, want 3", len(s.requests))
+	}
+	accept, body := s.requests[2], s.bodies[2]
+	if accept.Method != http.MethodPatch || accept.Header.Get("If-Match") != `"5"` {
+		t.Errorf("%s with If-Match %q, want PATCH of the contract revision 5", accept.Method, accept.Header.Get("If-Match"))
+	}
+	if body["OfferID"] != 9.0 || body["Revision"] != 2.0 {
+		t.Errorf("accepted %v, want revision 2 of offer 9", body)
+	}
+	sig, _ := base64.StdEncoding.DecodeString(body["InvestorSignature"].(string))
+	hash := sha512.Sum384(terms)
+	if !ecdsa.VerifyASN1(&key.PublicKey, hash[:], sig) {
+		t.Error("signature of the terms is not verified")
+	}
+}
+
+func TestAcceptOfferIfMatch(t *testing.T) {
+	s := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+	})
+	err := s.client().AcceptOffer(IfMatch(context.Background(), `"4"`), 3, 9, 0, "sig")
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("error %v, want ErrPreconditionFailed", err)
+	}
+	if len(s.requests) != 1 || s.requests[0].Header.Get("If-Match") != `"4"` {
+		t.Errorf("requests %v, want one with If-Match", s.requests)
+	}
+	if _, ok := s.bodies[0]["Revision"]; ok {
+		t.Errorf("latest revision is sent as %v", s.bodies[0]["Revision"])
+	}
+}
+
+func TestRetries(t *testing.T) {
+	failures := 2
+	s := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"ID":6}`))
+	})
+	c := s.client()
+
+	if _, err := c.GetContract(context.Background(), 6); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.requests) != 3 {
+		t.Errorf("GET is sent %d times, want 3", len(s.requests))
+	}
+
+	// a request without an idempotency key is not repeated
+	failures, s.requests = 1, nil
+	if err := c.AcceptOffer(context.Background(), 6, 9, 0, "sig"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("error %v, want ErrUnavailable", err)
+	}
+	if len(s.requests) != 1 {
+		t.Errorf("PATCH is sent %d times, want 1", len(s.requests))
+	}
+
+	// a contract is created once with the key of the first attempt
+	failures, s.requests = 2, nil
+	id, err := c.CreateContract(context.Background(), ContractInput{Title: "Bolts", Amount: 100, Currency: "EUR", MustBeDone: time.Now()})
+	if err != nil || id != 6 {
+		t.Fatalf("created %d %v", id, err)
+	}
+	if len(s.requests) != 3 {
+		t.Fatalf("POST is sent %d times, want 3", len(s.requests))
+	}
+	key := s.requests[0].Header.Get("Idempotency-Key")
+	for _, r := range s.requests {
+		if key == "" || r.Header.Get("Idempotency-Key") != key {
+			t.Errorf("Idempotency-Key %q, want %q on every attempt", r.Header.Get("Idempotency-Key"), key)
+		}
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	s := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[]`))
+	})
+	if _, err := s.client().ListExchangeRates(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.client(WithSession("session")).ListExchangeRates(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"Token secret", "Session session"} {
+		if auth := s.requests[i].Header.Get("Authorization"); auth != want {
+			t.Errorf("Authorization %q, want %q", auth, want)
+		}
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	s := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Contract not found", http.StatusNotFound)
+	})
+	_, err := s.client().GetContract(context.Background(), 6)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || !errors.Is(err, ErrNotFound) || errors.Is(err, ErrBadRequest) {
+		t.Fatalf("error %v, want ErrNotFound", err)
+	}
+	if apiErr.Path != "/contracts/6" || apiErr.Message != "Contract not found" {
+		t.Errorf("APIError %+v", apiErr)
+	}
+}
+
+func TestDepositPending(t *testing.T) {
+	status := http.StatusCreated
+	s := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		if status == http.StatusCreated {
+			w.Write([]byte(`{"ID":12}`))
+		} else {
+			w.Write([]byte("Deposit is pending, it is credited once the payment is settled"))
+		}
+	})
+	c := s.client()
+	if id, err := c.Deposit(context.Background(), Money{Amount: 1000, Currency: "EUR"}); err != nil || id != 12 {
+		t.Errorf("deposit %d %v, want transaction 12", id, err)
+	}
+	status = http.StatusAccepted
+	if _, err := c.Deposit(context.Background(), Money{Amount: 1000, Currency: "EUR"}); err != ErrDepositPending {
+		t.Errorf("error %v, want ErrDepositPending", err)
+	}
+}
@@ -0,0 +1,260 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+func (p Page) apply(q url.Values) {
+	if p.Limit > 0 {
+		q.Set("Limit", strconv.Itoa(p.Limit))
+	}
+	if p.Offset > 0 {
+		q.Set("Offset", strconv.Itoa(p.Offset))
+	}
+}
+
+// ListContracts returns a single page of contracts matching the filter
+func (c *Client) ListContracts(ctx context.Context, f ContractFilter, p Page) ([]Contract, error) {
+	q := url.Values{}
+	if f.SupplierID != 0 {
+		q.Set("SupplierID", strconv.FormatInt(f.SupplierID, 10))
+	}
+	if f.InvestorID != 0 {
+		q.Set("InvestorID", strconv.FormatInt(f.InvestorID, 10))
+	}
+	if f.Title != "" {
+		q.Set("Title", f.Title)
+	}
+	if f.Currency != "" {
+		q.Set("Currency", f.Currency)
+	}
+	if f.ConvertTo != "" {
+		q.Set("ConvertTo", f.ConvertTo)
+	}
+	if f.Archived {
+		q.Set("Archived", "true")
+	}
+	p.apply(q)
+
+	var contracts []Contract
+	err := c.doJSON(ctx, http.MethodGet, "/contracts", q, nil, &contracts)
+	return contracts, err
+}
+
+// GetContract returns the contract by ID
+func (c *Client) GetContract(ctx context.Context, id int64) (*Contract, error) {
+	contract := new(Contract)
+	err := c.doJSON(ctx, http.MethodGet, fmt.Sprintf("/contracts/%d", id), nil, nil, contract)
+	if err != nil {
+		return nil, err
+	}
+	return contract, nil
+}
+
+// GetContractEncoded returns the exact bytes of the contract body which are signed by
+// the supplier and the investor
+func (c *Client) GetContractEncoded(ctx context.Context, id int64) ([]byte, error) {
+	return c.do(ctx, http.MethodGet, fmt.Sprintf("/contracts/%d/encoded", id), nil, nil)
+}
+
+// ETag returns the entity tag of the contract for IfMatch
+func (c *Contract) ETag() string {
+	return strconv.Quote(strconv.FormatInt(c.Revision, 10))
+}
+
+// CreateContract creates a contract on behalf of the investor and returns its ID
+func (c *Client) CreateContract(ctx context.Context, in ContractInput) (int64, error) {
+	payload := struct {
+		Title           string
+		Description     string
+		Amount          int64
+		Currency        string
+		MustBeDone      string
+		Milestones      []Milestone            `json:",omitempty"`
+		BiddingDeadline string                 `json:",omitempty"`
+		Sealed          bool                   `json:",omitempty"`
+		RevealDeadline  string                 `json:",omitempty"`
+		CoInvestors     []InvestorShare        `json:",omitempty"`
+		TemplateID      int64                  `json:",omitempty"`
+		TemplateVersion int64                  `json:",omitempty"`
+		TemplateParams  map[string]interface{} `json:",omitempty"`
+		AwardInput
+	}{in.Title, in.Description, in.Amount, in.Currency, in.MustBeDone.Format(time.RFC3339), in.Milestones, formatTime(in.BiddingDeadline),
+		in.Sealed, formatTime(in.RevealDeadline), in.CoInvestors, in.TemplateID, in.TemplateVersion, in.TemplateParams, in.Award}
+
+	var res createdResponse
+	err := c.doJSON(withIdempotencyKey(ctx, in.IdempotencyKey), http.MethodPost, "/contracts", nil, payload, &res)
+	return res.ID, err
+}
+
+// AcceptOffer concludes the contract with the revision of the offer, investorSignature is base64
+// encoded ASN.1 ECDSA signature of the encoded terms of the revision (see GetOfferEncoded). The
+// acceptance fails when the offer was revised after revision, 0 accepts the latest revision. The
+// contract is accepted at the revision of the IfMatch ETag when the context has one.
+// A co-funded contract is concluded when the last of its investors accepts the same revision,
+// Contract.Investors shows who has signed.
+func (c *Client) AcceptOffer(ctx context.Context, contractID, offerID, revision int64, investorSignature string) error {
+	payload := struct {
+		OfferID           int64
+		Revision          int64 `json:",omitempty"`
+		InvestorSignature string
+	}{offerID, revision, investorSignature}
+	return c.doJSON(ctx, http.MethodPatch, fmt.Sprintf("/contracts/%d", contractID), nil, payload, nil)
+}
+
+// ListExchangeRates returns the exchange rates the api converts amounts with
+func (c *Client) ListExchangeRates(ctx context.Context) ([]ExchangeRate, error) {
+	var rates []ExchangeRate
+	err := c.doJSON(ctx, http.MethodGet, "/rates", nil, nil, &rates)
+	return rates, err
+}
+
+// SetAwardPolicy changes the award policy of the investor's open contract
+func (c *Client) SetAwardPolicy(ctx context.Context, contractID int64, in AwardInput) error {
+	return c.doJSON(ctx, http.MethodPut, fmt.Sprintf("/contracts/%d/award", contractID), nil, in, nil)
+}
+
+// SetCoInvestors replaces the co-investors of the investor's open contract, the creator funds the rest
+// of the amount and no co-investors make the contract funded by the creator alone
+func (c *Client) SetCoInvestors(ctx context.Context, contractID int64, coInvestors []InvestorShare) error {
+	payload := struct {
+		CoInvestors []InvestorShare
+	}{coInvestors}
+	return c.doJSON(ctx, http.MethodPut, fmt.Sprintf("/contracts/%d/investors", contractID), nil, payload, nil)
+}
+
+// DeleteContract removes the investor's open contract, it may be restored with RestoreContract for
+// the restore grace period of the server. A concluded contract is cancelled with CancelContract.
+func (c *Client) DeleteContract(ctx context.Context, id int64) error {
+	return c.doJSON(ctx, http.MethodDelete, fmt.Sprintf("/contracts/%d", id), nil, nil, nil)
+}
+
+// CancelContract requests the cancellation of the signed contract on behalf of its party of the kind
+// UserInvestor or UserSupplier. It reports true when the other party has requested it as well, then
+// the contract is archived and the money left in its escrow is refunded to its investors.
+func (c *Client) CancelContract(ctx context.Context, kind string, id int64) (bool, error) {
+	var res struct {
+		Cancelled bool
+	}
+	err := c.doJSON(ctx, http.MethodPost, fmt.Sprintf("/%ss/contracts/%d/cancellation", kind, id), nil, nil, &res)
+	return res.Cancelled, err
+}
+
+// RestoreContract undoes the deletion of the investor's contract, ErrGone is returned when the
+// grace period is over
+func (c *Client) RestoreContract(ctx context.Context, id int64) error {
+	return c.doJSON(ctx, http.MethodPost, fmt.Sprintf("/contracts/%d/restore", id), nil, nil, nil)
+}
+
+// EditContract replaces the body of the investor's open contract and returns the new version.
+// Offers made so far become stale until their suppliers sign a new revision. Send it with IfMatch
+// to fail with ErrPreconditionFailed when the contract has changed.
+func (c *Client) EditContract(ctx context.Context, id int64, in AmendmentInput) (*ContractVersion, error) {
+	var version ContractVersion
+	if err := c.doJSON(ctx, http.MethodPut, fmt.Sprintf("/contracts/%d", id), nil, amendmentPayload(in), &version); err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+// ListContractVersions returns a single page of the version history of the contract body
+func (c *Client) ListContractVersions(ctx context.Context, id int64, p Page) ([]ContractVersion, error) {
+	q := url.Values{}
+	p.apply(q)
+
+	var versions []ContractVersion
+	err := c.doJSON(ctx, http.MethodGet, fmt.Sprintf("/contracts/%d/versions", id), q, nil, &versions)
+	return versions, err
+}
+
+// ListAmendments returns a single page of the amendments proposed for the contract, status
+// filters them when not empty
+func (c *Client) ListAmendments(ctx context.Context, id int64, status string, p Page) ([]ContractAmendment, error) {
+	q := url.Values{}
+	if status != "" {
+		q.Set("Status", status)
+	}
+	p.apply(q)
+
+	var amendments []ContractAmendment
+	err := c.doJSON(ctx, http.MethodGet, fmt.Sprintf("/contracts/%d/amendments", id), q, nil, &amendments)
+	return amendments, err
+}
+
+// ProposeAmendment proposes a change of the signed contract on behalf of its party of the kind
+// UserInvestor or UserSupplier and returns the amendment ID, in.Signature is made over the encoded
+// amended body
+func (c *Client) ProposeAmendment(ctx context.Context, kind string, contractID int64, in AmendmentInput) (int64, error) {
+	var res createdResponse
+	err := c.doJSON(ctx, http.MethodPost, fmt.Sprintf("/%ss/contracts/%d/amendments", kind, contractID), nil, amendmentPayload(in), &res)
+	return res.ID, err
+}
+
+// AcceptAmendment signs the pending amendment on behalf of the other party, signature is made over
+// the encoded ContractAmendment.Body. It returns the new version of the contract.
+func (c *Client) AcceptAmendment(ctx context.Context, kind string, contractID, amendmentID int64, signature string) (*ContractVersion, error) {
+	payload := struct {
+		Signature string
+	}{signature}
+
+	var version ContractVersion
+	path := fmt.Sprintf("/%ss/contracts/%d/amendments/%d/accept", kind, contractID, amendmentID)
+	if err := c.doJSON(ctx, http.MethodPost, path, nil, payload, &version); err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+// RejectAmendment rejects the pending amendment, or withdraws it when the user proposed it
+func (c *Client) RejectAmendment(ctx context.Context, kind string, contractID, amendmentID int64) error {
+	path := fmt.Sprintf("/%ss/contracts/%d/amendments/%d/reject", kind, contractID, amendmentID)
+	return c.doJSON(ctx, http.MethodPost, path, nil, nil, nil)
+}
+
+func amendmentPayload(in AmendmentInput) interface{} {
+	return struct {
+		Title       string
+		Description string
+		Amount      int64
+		Currency    string
+		MustBeDone  string
+		Milestones  []Milestone `json:",omitempty"`
+		Comment     string      `json:",omitempty"`
+		Signature   string      `json:",omitempty"`
+	}{in.Title, in.Description, in.Amount, in.Currency, in.MustBeDone.Format(time.RFC3339), in.Milestones, in.Comment, in.Signature}
+}
+
+// Contracts returns an iterator over all the contracts matching the filter,
+// pageSize contracts are requested at once
+func (c *Client) Contracts(f ContractFilter, pageSize int) *ContractIterator {
+	it := &ContractIterator{}
+	it.pager = pager{size: pageSize, fetch: func(ctx context.Context, p Page) (int, error) {
+		contracts, err := c.ListContracts(ctx, f, p)
+		it.page = contracts
+		return len(contracts), err
+	}}
+	return it
+}
+
+// ContractIterator iterates over contracts page by page:
+//
+//	it := c.Contracts(client.ContractFilter{InvestorID: 1}, 50)
+//	for it.Next(ctx) {
+//		contract := it.Contract()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+type ContractIterator struct {
+	pager
+	page []Contract
+}
+
+// Contract returns the current contract
+func (it *ContractIterator) Contract() Contract {
+	return it.page[it.pos]
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Errors which APIError matches with errors.Is
+var (
+	ErrBadRequest         = errors.New("sirius: bad request")
+	ErrUnauthorized       = errors.New("sirius: unauthorized")
+	ErrNotFound           = errors.New("sirius: not found")
+	ErrUnavailable        = errors.New("sirius: service unavailable")
+	ErrRateLimited        = errors.New("sirius: rate limit or quota exceeded")
+	ErrPreconditionFailed = errors.New("sirius: precondition failed")
+	ErrGone               = errors.New("sirius: grace period is over")
+)
+
+// APIError is returned when the api responds with a non-2xx status
+type APIError struct {
+	StatusCode int
+	Method     string
+	Path       string
+	Message    string
+	// RetryAfter is the delay asked for by a 429 or 503 response, zero when none is given
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("sirius: %s %s: %d %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// Is makes errors.Is(err, ErrNotFound) and alike work
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrPreconditionFailed:
+		return e.StatusCode == http.StatusPreconditionFailed
+	case ErrGone:
+		return e.StatusCode == http.StatusGone
+	case ErrUnavailable:
+		return e.StatusCode == http.StatusBadGateway || e.StatusCode == http.StatusServiceUnavailable ||
+			e.StatusCode == http.StatusGatewayTimeout
+	}
+	return false
+}
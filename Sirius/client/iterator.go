@@ -0,0 +1,52 @@
+package client
+
+import "context"
+
+const defaultPageSize = 100
+
+// pager holds the iteration state shared by ContractIterator and OfferIterator,
+// fetch loads the page into the iterator and returns its length
+type pager struct {
+	size   int
+	fetch  func(ctx context.Context, p Page) (int, error)
+	offset int
+	length int
+	pos    int
+	done   bool
+	err    error
+}
+
+// Next advances to the next item, requesting the next page when the current one is exhausted,
+// it returns false when there are no more items or an error occurred
+func (p *pager) Next(ctx context.Context) bool {
+	if p.err != nil {
+		return false
+	}
+	if p.length > 0 && p.pos+1 < p.length {
+		p.pos++
+		return true
+	}
+	if p.done {
+		return false
+	}
+	if p.size <= 0 {
+		p.size = defaultPageSize
+	}
+	n, err := p.fetch(ctx, Page{Limit: p.size, Offset: p.offset})
+	if err != nil {
+		p.err = err
+		return false
+	}
+	p.offset += n
+	p.length = n
+	p.pos = 0
+	if n < p.size {
+		p.done = true
+	}
+	return n > 0
+}
+
+// Err returns the error which stopped the iteration
+func (p *pager) Err() error {
+	return p.err
+}
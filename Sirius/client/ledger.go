@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ErrDepositPending is returned by Deposit when the deposit is recorded but not credited yet, the api
+// charges and credits it once the payment provider and the database are available
+var ErrDepositPending = errors.New("sirius: deposit is pending")
+
+// Deposit charges the investor with the payment provider and credits the money to the investor's
+// account, signed contracts are funded from it. It returns the ID of the ledger transaction, or
+// ErrDepositPending when the deposit is credited later.
+func (c *Client) Deposit(ctx context.Context, m Money) (int64, error) {
+	data, res, err := c.doResponse(ctx, http.MethodPost, "/investors/deposits", nil, m)
+	if err != nil {
+		return 0, err
+	}
+	if res.StatusCode == http.StatusAccepted {
+		return 0, ErrDepositPending
+	}
+	var created createdResponse
+	if err := json.Unmarshal(data, &created); err != nil {
+		return 0, fmt.Errorf("sirius: decoding response of POST /investors/deposits: %v", err)
+	}
+	return created.ID, nil
+}
+
+// ListLedgerAccounts returns the accounts of the user of kind UserInvestor or UserSupplier
+func (c *Client) ListLedgerAccounts(ctx context.Context, kind string) ([]LedgerAccount, error) {
+	var accounts []LedgerAccount
+	err := c.doJSON(ctx, http.MethodGet, "/"+kind+"s/ledger/accounts", nil, nil, &accounts)
+	return accounts, err
+}
+
+// LedgerStatement returns a single page of the postings to the user's account
+func (c *Client) LedgerStatement(ctx context.Context, kind string, accountID int64, p Page) ([]LedgerEntry, error) {
+	q := url.Values{}
+	p.apply(q)
+
+	var entries []LedgerEntry
+	err := c.doJSON(ctx, http.MethodGet, fmt.Sprintf("/%ss/ledger/accounts/%d/statement", kind, accountID), q, nil, &entries)
+	return entries, err
+}
+
+// AcceptMilestone releases the payment of the milestone, numbered from 1, from the escrow of the
+// investor's concluded contract to the supplier and returns the ID of the ledger transaction
+func (c *Client) AcceptMilestone(ctx context.Context, contractID int64, milestone int) (int64, error) {
+	var res createdResponse
+	err := c.doJSON(ctx, http.MethodPost, fmt.Sprintf("/contracts/%d/milestones/%d/accept", contractID, milestone), nil, nil, &res)
+	return res.ID, err
+}
+
+// CheckLedger verifies the invariants of the ledger, it is allowed to auditors and admins
+func (c *Client) CheckLedger(ctx context.Context) (*LedgerCheck, error) {
+	check := new(LedgerCheck)
+	if err := c.doJSON(ctx, http.MethodGet, "/ledger/check", nil, nil, check); err != nil {
+		return nil, err
+	}
+	return check, nil
+}
@@ -0,0 +1,195 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ListOffers returns a single page of offers matching the filter
+func (c *Client) ListOffers(ctx context.Context, f OfferFilter, p Page) ([]Offer, error) {
+	q := url.Values{}
+	if f.SupplierID != 0 {
+		q.Set("SupplierID", strconv.FormatInt(f.SupplierID, 10))
+	}
+	if f.ContractID != 0 {
+		q.Set("ContractID", strconv.FormatInt(f.ContractID, 10))
+	}
+	if f.Currency != "" {
+		q.Set("Currency", f.Currency)
+	}
+	p.apply(q)
+
+	var offers []Offer
+	err := c.doJSON(ctx, http.MethodGet, "/offers", q, nil, &offers)
+	return offers, err
+}
+
+// GetOffer returns the offer by ID
+func (c *Client) GetOffer(ctx context.Context, id int64) (*Offer, error) {
+	offer := new(Offer)
+	err := c.doJSON(ctx, http.MethodGet, fmt.Sprintf("/offers/%d", id), nil, nil, offer)
+	if err != nil {
+		return nil, err
+	}
+	return offer, nil
+}
+
+// CreateOffer makes an offer on behalf of the supplier and returns its ID
+func (c *Client) CreateOffer(ctx context.Context, in OfferInput) (int64, error) {
+	payload := struct {
+		ContractID        int64
+		Comment           string
+		ValidUntil        string `json:",omitempty"`
+		SupplierSignature string
+	}{in.ContractID, in.Comment, formatTime(in.ValidUntil), in.SupplierSignature}
+
+	var res createdResponse
+	err := c.doJSON(withIdempotencyKey(ctx, in.IdempotencyKey), http.MethodPost, "/offers", nil, payload, &res)
+	return res.ID, err
+}
+
+// DeleteOffer withdraws the supplier's offer, it may be restored with RestoreOffer for the restore
+// grace period of the server
+func (c *Client) DeleteOffer(ctx context.Context, id int64) error {
+	return c.doJSON(ctx, http.MethodDelete, fmt.Sprintf("/offers/%d", id), nil, nil, nil)
+}
+
+// RestoreOffer undoes the withdrawal of the supplier's offer, ErrGone is returned when the grace
+// period is over
+func (c *Client) RestoreOffer(ctx context.Context, id int64) error {
+	return c.doJSON(ctx, http.MethodPost, fmt.Sprintf("/offers/%d/restore", id), nil, nil, nil)
+}
+
+// ListOfferRevisions returns a single page of the negotiation history of the offer to its party of the kind
+// UserInvestor or UserSupplier, ErrNotFound is returned to the others
+func (c *Client) ListOfferRevisions(ctx context.Context, kind string, offerID int64, p Page) ([]OfferRevision, error) {
+	q := url.Values{}
+	p.apply(q)
+
+	var revisions []OfferRevision
+	err := c.doJSON(ctx, http.MethodGet, fmt.Sprintf("/%ss/offers/%d/revisions", kind, offerID), q, nil, &revisions)
+	return revisions, err
+}
+
+// LatestOfferRevision returns the revision which would be accepted to the party of the offer of the kind
+func (c *Client) LatestOfferRevision(ctx context.Context, kind string, offerID int64) (*OfferRevision, error) {
+	revisions, err := c.ListOfferRevisions(ctx, kind, offerID, Page{})
+	if err != nil {
+		return nil, err
+	}
+	if len(revisions) == 0 {
+		return nil, ErrNotFound
+	}
+	return &revisions[len(revisions)-1], nil
+}
+
+// GetOfferEncoded returns the exact bytes of the terms of the latest revision to the party of the offer
+// of the kind UserInvestor or UserSupplier, the investor signs them to accept the offer
+func (c *Client) GetOfferEncoded(ctx context.Context, kind string, offerID int64) ([]byte, error) {
+	data, _, err := c.getOfferEncoded(ctx, kind, offerID)
+	return data, err
+}
+
+// headerRevision is the header of the encoded offer with the number of its revision
+const headerRevision = "X-Sirius-Revision"
+
+// getOfferEncoded returns the encoded terms of the latest revision of the offer and its number
+func (c *Client) getOfferEncoded(ctx context.Context, kind string, offerID int64) ([]byte, int64, error) {
+	data, res, err := c.doResponse(ctx, http.MethodGet, fmt.Sprintf("/%ss/offers/%d/encoded", kind, offerID), nil, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	revision, err := strconv.ParseInt(res.Header.Get(headerRevision), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("sirius: %s of offer %d: %v", headerRevision, offerID, err)
+	}
+	return data, revision, nil
+}
+
+// AmendOffer proposes new terms on behalf of the supplier and returns the revision ID
+func (c *Client) AmendOffer(ctx context.Context, offerID int64, in RevisionInput) (int64, error) {
+	return c.proposeRevision(ctx, "/suppliers", offerID, in)
+}
+
+// CounterOffer proposes new terms on behalf of the investor and returns the revision ID
+func (c *Client) CounterOffer(ctx context.Context, offerID int64, in RevisionInput) (int64, error) {
+	return c.proposeRevision(ctx, "/investors", offerID, in)
+}
+
+func (c *Client) proposeRevision(ctx context.Context, prefix string, offerID int64, in RevisionInput) (int64, error) {
+	payload := struct {
+		Previous   int64
+		Amount     int64
+		MustBeDone string
+		Milestones []Milestone `json:",omitempty"`
+		ValidUntil string      `json:",omitempty"`
+		Comment    string
+		Signature  string
+	}{in.Previous, in.Amount, in.MustBeDone.Format(time.RFC3339), in.Milestones, formatTime(in.ValidUntil), in.Comment, in.Signature}
+
+	var res createdResponse
+	err := c.doJSON(ctx, http.MethodPost, fmt.Sprintf("%s/offers/%d/revisions", prefix, offerID), nil, payload, &res)
+	return res.ID, err
+}
+
+// ListSealedBids returns a single page of the sealed bids of the contract to its investors, kind is
+// UserInvestor, or of the supplier's own bids, kind is UserSupplier
+func (c *Client) ListSealedBids(ctx context.Context, kind string, contractID int64, p Page) ([]SealedBid, error) {
+	q := url.Values{}
+	p.apply(q)
+
+	var bids []SealedBid
+	err := c.doJSON(ctx, http.MethodGet, fmt.Sprintf("/%ss/contracts/%d/bids", kind, contractID), q, nil, &bids)
+	return bids, err
+}
+
+// SubmitSealedBid commits to a hidden offer on behalf of the supplier and returns the bid ID
+func (c *Client) SubmitSealedBid(ctx context.Context, in SealedBidInput) (int64, error) {
+	var res createdResponse
+	err := c.doJSON(ctx, http.MethodPost, "/bids", nil, in, &res)
+	return res.ID, err
+}
+
+// RevealBid opens the supplier's sealed bid and returns the ID of the offer it becomes
+func (c *Client) RevealBid(ctx context.Context, bidID int64, r Reveal) (int64, error) {
+	return c.reveal(ctx, "/suppliers", bidID, r)
+}
+
+// RevealBidDecrypted opens a sealed bid on behalf of the investor who decrypted it (see OpenSealedBid)
+// and returns the ID of the offer it becomes
+func (c *Client) RevealBidDecrypted(ctx context.Context, bidID int64, r Reveal) (int64, error) {
+	return c.reveal(ctx, "/investors", bidID, r)
+}
+
+func (c *Client) reveal(ctx context.Context, prefix string, bidID int64, r Reveal) (int64, error) {
+	var res createdResponse
+	err := c.doJSON(ctx, http.MethodPost, fmt.Sprintf("%s/bids/%d/reveal", prefix, bidID), nil, r, &res)
+	return res.ID, err
+}
+
+// Offers returns an iterator over all the offers matching the filter,
+// pageSize offers are requested at once
+func (c *Client) Offers(f OfferFilter, pageSize int) *OfferIterator {
+	it := &OfferIterator{}
+	it.pager = pager{size: pageSize, fetch: func(ctx context.Context, p Page) (int, error) {
+		offers, err := c.ListOffers(ctx, f, p)
+		it.page = offers
+		return len(offers), err
+	}}
+	return it
+}
+
+// OfferIterator iterates over offers page by page, see ContractIterator
+type OfferIterator struct {
+	pager
+	page []Offer
+}
+
+// Offer returns the current offer
+func (it *OfferIterator) Offer() Offer {
+	return it.page[it.pos]
+}
@@ -0,0 +1,204 @@
+package client
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+)
+
+const (
+	saltSize = 32
+	// p384PointSize is the size of an uncompressed P-384 public key
+	p384PointSize = 97
+)
+
+// SealBid signs the terms of the bid with the supplier's key and commits to them. The returned
+// Reveal must be kept until the bidding deadline; when encrypt is set it is also encrypted to the
+// investor's certificate key, so the investor can reveal the bid.
+func (c *Client) SealBid(ctx context.Context, in BidInput, signer crypto.Signer, encrypt bool) (SealedBidInput, Reveal, error) {
+	bid := SealedBidInput{ContractID: in.ContractID}
+	contract, err := c.GetContract(ctx, in.ContractID)
+	if err != nil {
+		return bid, Reveal{}, err
+	}
+	terms, err := proposedTerms(contract.ContractBody, in.Amount, in.MustBeDone, in.Milestones, in.ValidUntil)
+	if err != nil {
+		return bid, Reveal{}, err
+	}
+	data, err := json.Marshal(terms)
+	if err != nil {
+		return bid, Reveal{}, err
+	}
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return bid, Reveal{}, err
+	}
+	r := Reveal{
+		Amount:     terms.Amount,
+		MustBeDone: terms.MustBeDone,
+		Milestones: terms.Milestones,
+		ValidUntil: terms.ValidUntil,
+		Comment:    in.Comment,
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+	}
+	r.SupplierSignature, err = Sign(signer, data)
+	if err != nil {
+		return bid, r, err
+	}
+	h := sha512.New384()
+	h.Write(salt)
+	h.Write(data)
+	bid.Commitment = base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	if encrypt {
+		pub, err := certPublicKey(contract.Investor)
+		if err != nil {
+			return bid, r, err
+		}
+		plaintext, err := json.Marshal(r)
+		if err != nil {
+			return bid, r, err
+		}
+		ciphertext, err := Encrypt(pub, plaintext)
+		if err != nil {
+			return bid, r, err
+		}
+		bid.Ciphertext = base64.StdEncoding.EncodeToString(ciphertext)
+	}
+	return bid, r, nil
+}
+
+// SubmitSealedBidSigned seals the bid and submits it, the returned Reveal must be kept by the
+// supplier to reveal the bid after the bidding deadline
+func (c *Client) SubmitSealedBidSigned(ctx context.Context, in BidInput, signer crypto.Signer, encrypt bool) (int64, Reveal, error) {
+	bid, r, err := c.SealBid(ctx, in, signer, encrypt)
+	if err != nil {
+		return 0, r, err
+	}
+	id, err := c.SubmitSealedBid(ctx, bid)
+	return id, r, err
+}
+
+// OpenSealedBid decrypts the ciphertext of the bid with the investor's key, the ciphertext is
+// listed after the bidding deadline
+func OpenSealedBid(bid SealedBid, key crypto.Signer) (Reveal, error) {
+	var r Reveal
+	if !bid.Ciphertext.Valid {
+		return r, errors.New("sirius: bid is not encrypted or bidding is not closed")
+	}
+	priv, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return r, errors.New("sirius: decryption requires an ECDSA private key")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(bid.Ciphertext.String)
+	if err != nil {
+		return r, err
+	}
+	plaintext, err := Decrypt(priv, ciphertext)
+	if err != nil {
+		return r, err
+	}
+	err = json.Unmarshal(plaintext, &r)
+	return r, err
+}
+
+// Encrypt encrypts data to the P-384 key with ECIES: an ephemeral key agrees a secret with the key,
+// AES-256-GCM key is derived from it with ANSI X9.63 KDF with SHA-384 and the ephemeral public key
+// as shared info. The result is the uncompressed ephemeral public key, the nonce and the sealed data.
+func Encrypt(pub *ecdsa.PublicKey, data []byte) ([]byte, error) {
+	recipient, err := pub.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := ecdh.P384().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+	ephemeralPub := ephemeral.PublicKey().Bytes()
+	gcm, err := eciesCipher(secret, ephemeralPub)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(append([]byte{}, ephemeralPub...), nonce...)
+	return gcm.Seal(out, nonce, data, nil), nil
+}
+
+// Decrypt opens data encrypted with Encrypt
+func Decrypt(priv *ecdsa.PrivateKey, ciphertext []byte) ([]byte, error) {
+	key, err := priv.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < p384PointSize {
+		return nil, errors.New("sirius: ciphertext is too short")
+	}
+	ephemeralPub := ciphertext[:p384PointSize]
+	ephemeral, err := ecdh.P384().NewPublicKey(ephemeralPub)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := key.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := eciesCipher(secret, ephemeralPub)
+	if err != nil {
+		return nil, err
+	}
+	rest := ciphertext[p384PointSize:]
+	if len(rest) < gcm.NonceSize() {
+		return nil, errors.New("sirius: ciphertext is too short")
+	}
+	return gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], nil)
+}
+
+func eciesCipher(secret, sharedInfo []byte) (cipher.AEAD, error) {
+	// a single X9.63 KDF block of SHA-384 covers the 32 bytes key
+	h := sha512.New384()
+	h.Write(secret)
+	h.Write([]byte{0, 0, 0, 1})
+	h.Write(sharedInfo)
+	block, err := aes.NewCipher(h.Sum(nil)[:32])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// certPublicKey returns the ECDSA key of the user's PEM encoded certificate
+func certPublicKey(u *User) (*ecdsa.PublicKey, error) {
+	if u == nil || !u.Cert.Valid {
+		return nil, errors.New("sirius: user has no certificate")
+	}
+	block, _ := pem.Decode([]byte(u.Cert.String))
+	if block == nil {
+		return nil, errors.New("sirius: no PEM block found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("sirius: certificate key is not ECDSA")
+	}
+	return pub, nil
+}
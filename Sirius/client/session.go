@@ -0,0 +1,44 @@
+package client
+
+import (
+	"context"
+	"crypto"
+	"net/http"
+)
+
+// Login logs the user of kind UserInvestor or UserSupplier in by signing the challenge issued by
+// the api with the user's signing key, the client sends the session token afterwards
+func (c *Client) Login(ctx context.Context, kind string, id int64, signer crypto.Signer) (*Session, error) {
+	var challenge LoginChallenge
+	in := struct {
+		Kind string
+		ID   int64
+	}{kind, id}
+	if err := c.doJSON(ctx, http.MethodPost, "/auth/challenges", nil, in, &challenge); err != nil {
+		return nil, err
+	}
+	signature, err := Sign(signer, []byte("Sirius login "+challenge.Nonce))
+	if err != nil {
+		return nil, err
+	}
+
+	var session Session
+	answer := struct {
+		Nonce     string
+		Signature string
+	}{challenge.Nonce, signature}
+	if err := c.doJSON(ctx, http.MethodPost, "/auth/sessions", nil, answer, &session); err != nil {
+		return nil, err
+	}
+	c.session = session.Token
+	return &session, nil
+}
+
+// Logout revokes the session token of the client
+func (c *Client) Logout(ctx context.Context) error {
+	if err := c.doJSON(ctx, http.MethodDelete, "/auth/sessions", nil, nil, nil); err != nil {
+		return err
+	}
+	c.session = ""
+	return nil
+}
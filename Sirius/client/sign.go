@@ -0,0 +1,243 @@
+package client
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"time"
+)
+
+// ParsePrivateKey parses PEM encoded EC private key, as written by the ca tool
+func ParsePrivateKey(keyPem []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPem)
+	if block == nil {
+		return nil, errors.New("sirius: no PEM block found")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// Sign signs data the way the api verifies it: SHA-384 digest signed with ECDSA, ASN.1 DER
+// signature encoded with base64. ecdsa.PrivateKey and hardware backed signers producing
+// ASN.1 signatures may be used.
+func Sign(signer crypto.Signer, data []byte) (string, error) {
+	hash := sha512.Sum384(data)
+	sig, err := signer.Sign(rand.Reader, hash[:], crypto.SHA384)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// SignContract fetches the encoded body of the contract and signs it
+func (c *Client) SignContract(ctx context.Context, contractID int64, signer crypto.Signer) (string, error) {
+	data, err := c.GetContractEncoded(ctx, contractID)
+	if err != nil {
+		return "", err
+	}
+	return Sign(signer, data)
+}
+
+// CreateSignedOffer signs the contract with in.ValidUntil with the supplier's key and makes
+// an offer for it
+func (c *Client) CreateSignedOffer(ctx context.Context, in OfferInput, signer crypto.Signer) (int64, error) {
+	data, err := c.GetContractEncoded(ctx, in.ContractID)
+	if err != nil {
+		return 0, err
+	}
+	if !in.ValidUntil.IsZero() {
+		var body ContractBody
+		if err := json.Unmarshal(data, &body); err != nil {
+			return 0, err
+		}
+		body.ValidUntil = formatTime(in.ValidUntil)
+		if data, err = json.Marshal(body); err != nil {
+			return 0, err
+		}
+	}
+	in.SupplierSignature, err = Sign(signer, data)
+	if err != nil {
+		return 0, err
+	}
+	return c.CreateOffer(ctx, in)
+}
+
+// AcceptOfferSigned signs the latest terms of the offer with the investor's key and accepts the
+// offer, it fails when the offer is revised or the contract is changed after the terms were read.
+// The contract is read first unless the context has the IfMatch ETag of the contract.
+func (c *Client) AcceptOfferSigned(ctx context.Context, contractID, offerID int64, signer crypto.Signer) error {
+	if _, ok := ctx.Value(ifMatchContext{}).(string); !ok {
+		contract, err := c.GetContract(ctx, contractID)
+		if err != nil {
+			return err
+		}
+		ctx = IfMatch(ctx, contract.ETag())
+	}
+	data, revision, err := c.getOfferEncoded(ctx, UserInvestor, offerID)
+	if err != nil {
+		return err
+	}
+	sig, err := Sign(signer, data)
+	if err != nil {
+		return err
+	}
+	return c.AcceptOffer(ctx, contractID, offerID, revision, sig)
+}
+
+// SetAwardPolicySigned pre-authorizes acceptance of the contract terms with the investor's key
+// and sets the award policy
+func (c *Client) SetAwardPolicySigned(ctx context.Context, contractID int64, in AwardInput, signer crypto.Signer) error {
+	sig, err := c.SignContract(ctx, contractID, signer)
+	if err != nil {
+		return err
+	}
+	in.PreauthorizedSignature = sig
+	return c.SetAwardPolicy(ctx, contractID, in)
+}
+
+// proposedTerms replaces the terms of the contract body the way the api does, so the encoded
+// body matches the signed one. The currency of the contract is kept.
+func proposedTerms(body ContractBody, amount int64, mustBeDone time.Time, milestones []Milestone, validUntil time.Time) (ContractBody, error) {
+	terms := ContractBody{
+		Title:       body.Title,
+		Description: body.Description,
+		Money:       Money{Amount: amount, Currency: body.Currency},
+		MustBeDone:  mustBeDone.Format(time.RFC3339),
+		ValidUntil:  formatTime(validUntil),
+	}
+	for _, m := range milestones {
+		due, err := time.Parse(time.RFC3339, m.DueDate)
+		if err != nil {
+			return terms, err
+		}
+		m.DueDate = due.UTC().Format(time.RFC3339)
+		m.Currency = body.Currency
+		terms.Milestones = append(terms.Milestones, m)
+	}
+	return terms, nil
+}
+
+// SignRevision completes the revision input of the party of the offer of the kind UserInvestor or
+// UserSupplier: the latest revision is answered unless in.Previous is set, the terms are encoded the way
+// the api does with the current version of the contract and signed
+func (c *Client) SignRevision(ctx context.Context, kind string, offerID int64, in RevisionInput, signer crypto.Signer) (RevisionInput, error) {
+	latest, err := c.LatestOfferRevision(ctx, kind, offerID)
+	if err != nil {
+		return in, err
+	}
+	if in.Previous == 0 {
+		in.Previous = latest.Revision
+	}
+	offer, err := c.GetOffer(ctx, offerID)
+	if err != nil {
+		return in, err
+	}
+	contract, err := c.GetContract(ctx, offer.ContractID)
+	if err != nil {
+		return in, err
+	}
+	terms, err := proposedTerms(contract.ContractBody, in.Amount, in.MustBeDone, in.Milestones, in.ValidUntil)
+	if err != nil {
+		return in, err
+	}
+	in.Milestones = terms.Milestones
+
+	data, err := json.Marshal(terms)
+	if err != nil {
+		return in, err
+	}
+	in.Signature, err = Sign(signer, data)
+	return in, err
+}
+
+// AmendOfferSigned signs the amended terms with the supplier's key and revises the offer
+func (c *Client) AmendOfferSigned(ctx context.Context, offerID int64, in RevisionInput, signer crypto.Signer) (int64, error) {
+	in, err := c.SignRevision(ctx, UserSupplier, offerID, in, signer)
+	if err != nil {
+		return 0, err
+	}
+	return c.AmendOffer(ctx, offerID, in)
+}
+
+// CounterOfferSigned signs the proposed terms with the investor's key and makes a counter-offer
+func (c *Client) CounterOfferSigned(ctx context.Context, offerID int64, in RevisionInput, signer crypto.Signer) (int64, error) {
+	in, err := c.SignRevision(ctx, UserInvestor, offerID, in, signer)
+	if err != nil {
+		return 0, err
+	}
+	return c.CounterOffer(ctx, offerID, in)
+}
+
+// amendedBody builds the body requested by the amendment input the way the api does, ValidUntil of
+// the contract is kept
+func amendedBody(body ContractBody, in AmendmentInput) (ContractBody, error) {
+	amended := ContractBody{
+		Title:       in.Title,
+		Description: in.Description,
+		Money:       Money{Amount: in.Amount, Currency: in.Currency},
+		MustBeDone:  in.MustBeDone.Format(time.RFC3339),
+		ValidUntil:  body.ValidUntil,
+	}
+	for _, m := range in.Milestones {
+		due, err := time.Parse(time.RFC3339, m.DueDate)
+		if err != nil {
+			return amended, err
+		}
+		m.DueDate = due.UTC().Format(time.RFC3339)
+		m.Currency = in.Currency
+		amended.Milestones = append(amended.Milestones, m)
+	}
+	return amended, nil
+}
+
+// ProposeAmendmentSigned signs the amended body of the contract with the key of its party of the kind
+// and proposes the amendment
+func (c *Client) ProposeAmendmentSigned(ctx context.Context, kind string, contractID int64, in AmendmentInput, signer crypto.Signer) (int64, error) {
+	contract, err := c.GetContract(ctx, contractID)
+	if err != nil {
+		return 0, err
+	}
+	body, err := amendedBody(contract.ContractBody, in)
+	if err != nil {
+		return 0, err
+	}
+	in.Milestones = body.Milestones
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	if in.Signature, err = Sign(signer, data); err != nil {
+		return 0, err
+	}
+	return c.ProposeAmendment(ctx, kind, contractID, in)
+}
+
+// AcceptAmendmentSigned signs the body of the pending amendment with the key of the other party
+// and accepts it
+func (c *Client) AcceptAmendmentSigned(ctx context.Context, kind string, contractID, amendmentID int64, signer crypto.Signer) (*ContractVersion, error) {
+	amendments, err := c.ListAmendments(ctx, contractID, AmendmentPending, Page{})
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range amendments {
+		if a.ID != amendmentID {
+			continue
+		}
+		data, err := json.Marshal(a.Body)
+		if err != nil {
+			return nil, err
+		}
+		sig, err := Sign(signer, data)
+		if err != nil {
+			return nil, err
+		}
+		return c.AcceptAmendment(ctx, kind, contractID, amendmentID, sig)
+	}
+	return nil, ErrNotFound
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// ListTemplates returns a single page of templates with their latest versions
+func (c *Client) ListTemplates(ctx context.Context, f TemplateFilter, p Page) ([]Template, error) {
+	q := url.Values{}
+	if f.InvestorID != 0 {
+		q.Set("InvestorID", strconv.FormatInt(f.InvestorID, 10))
+	}
+	if f.Name != "" {
+		q.Set("Name", f.Name)
+	}
+	p.apply(q)
+
+	var templates []Template
+	err := c.doJSON(ctx, http.MethodGet, "/templates", q, nil, &templates)
+	return templates, err
+}
+
+// GetTemplate returns the template by ID with its latest version
+func (c *Client) GetTemplate(ctx context.Context, id int64) (*Template, error) {
+	t := new(Template)
+	err := c.doJSON(ctx, http.MethodGet, fmt.Sprintf("/templates/%d", id), nil, nil, t)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ListTemplateVersions returns the versions of the template, they are kept after the template is deleted
+func (c *Client) ListTemplateVersions(ctx context.Context, id int64, p Page) ([]TemplateVersion, error) {
+	q := url.Values{}
+	p.apply(q)
+
+	var versions []TemplateVersion
+	err := c.doJSON(ctx, http.MethodGet, fmt.Sprintf("/templates/%d/versions", id), q, nil, &versions)
+	return versions, err
+}
+
+// GetTemplateVersion returns the version of the template a contract was rendered from
+func (c *Client) GetTemplateVersion(ctx context.Context, id, version int64) (*TemplateVersion, error) {
+	q := url.Values{"Version": {strconv.FormatInt(version, 10)}}
+	var versions []TemplateVersion
+	if err := c.doJSON(ctx, http.MethodGet, fmt.Sprintf("/templates/%d/versions", id), q, nil, &versions); err != nil {
+		return nil, err
+	}
+	return &versions[0], nil
+}
+
+// CreateTemplate creates a template on behalf of the investor and returns its ID
+func (c *Client) CreateTemplate(ctx context.Context, in TemplateInput) (int64, error) {
+	var res createdResponse
+	err := c.doJSON(ctx, http.MethodPost, "/templates", nil, in, &res)
+	return res.ID, err
+}
+
+// UpdateTemplate makes a new version of the investor's template and returns its number
+func (c *Client) UpdateTemplate(ctx context.Context, id int64, in TemplateInput) (int64, error) {
+	var res struct{ Version int64 }
+	err := c.doJSON(ctx, http.MethodPut, fmt.Sprintf("/templates/%d", id), nil, in, &res)
+	return res.Version, err
+}
+
+// DeleteTemplate removes the investor's template, contracts keep referring to its versions
+func (c *Client) DeleteTemplate(ctx context.Context, id int64) error {
+	return c.doJSON(ctx, http.MethodDelete, fmt.Sprintf("/templates/%d", id), nil, nil, nil)
+}
+
+// RenderTemplate returns the title and description the version of the template renders with the
+// parameters, version 0 is the latest one
+func (c *Client) RenderTemplate(ctx context.Context, id, version int64, params map[string]interface{}) (*ContractBody, error) {
+	payload := struct {
+		Version int64                  `json:",omitempty"`
+		Params  map[string]interface{} `json:",omitempty"`
+	}{version, params}
+	body := new(ContractBody)
+	if err := c.doJSON(ctx, http.MethodPost, fmt.Sprintf("/templates/%d/render", id), nil, payload, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
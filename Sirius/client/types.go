@@ -0,0 +1,534 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// NullString mirrors json encoding of sql.NullString used by the api
+type NullString struct {
+	String string
+	Valid  bool
+}
+
+// NullInt64 mirrors json encoding of sql.NullInt64 used by the api
+type NullInt64 struct {
+	Int64 int64
+	Valid bool
+}
+
+// User is a supplier or an investor, Cert is PEM encoded certificate issued by the Sirius CA
+type User struct {
+	ID   NullInt64
+	Name NullString
+	Cert NullString
+}
+
+// Money is an amount in minor units of an ISO 4217 currency, e.g. 1050 USD is 10.50 dollars.
+// Contracts made before currencies were introduced have no currency.
+type Money struct {
+	Amount   int64
+	Currency string `json:",omitempty"`
+}
+
+// ContractBody is the signed part of a contract, it is encoded the same way as by the api
+type ContractBody struct {
+	Title       string
+	Description string
+	Money
+	MustBeDone string
+	Milestones []Milestone `json:",omitempty"`
+	ValidUntil string      `json:",omitempty"`
+}
+
+// Milestone is a part of the work with its own payment in the currency of the contract,
+// DueDate is RFC3339 in UTC
+type Milestone struct {
+	Title string
+	Money
+	DueDate string
+}
+
+// Contract is created by an investor and concluded (Stage 1) when an offer is accepted
+type Contract struct {
+	ID       int64
+	Supplier *User
+	// Investor created the contract, Investors fund it together when it is co-funded
+	Investor  *User
+	Investors []ContractInvestor `json:",omitempty"`
+	Stage     int64
+	Created   string
+	// BiddingDeadline - offers are not accepted after it when set
+	BiddingDeadline NullString
+	// Sealed contracts accept only sealed bids, they are revealed between the bidding deadline
+	// and RevealDeadline
+	Sealed         bool
+	RevealDeadline NullString
+	Award          ContractAward
+	// Converted is the amount in the currency requested with ContractFilter.ConvertTo
+	Converted *Money `json:",omitempty"`
+	// Template the title and description were rendered from
+	Template *TemplateRef `json:",omitempty"`
+	// Revision is increased on every change of the contract, see ETag
+	Revision int64
+	// Archived is the time the concluded contract was cancelled, see ContractFilter.Archived
+	Archived NullString
+	// Version of ContractBody, see ListContractVersions
+	Version int64
+
+	ContractBody ContractBody
+
+	SupplierSignature NullString
+	InvestorSignature NullString
+}
+
+// ContractInvestor is an investor funding Share of a co-funded contract, Signature is made over
+// the encoded terms of Revision of OfferID
+type ContractInvestor struct {
+	InvestorID int64
+	Share      int64
+	OfferID    NullInt64
+	Revision   NullInt64
+	Signature  NullString
+	Signed     NullString
+}
+
+// InvestorShare is a part of the amount committed by a co-investor
+type InvestorShare struct {
+	InvestorID int64
+	Share      int64
+}
+
+// Award policies
+const (
+	AwardManual           = "manual"
+	AwardLowestAmount     = "lowest_amount"
+	AwardEarliestDelivery = "earliest_delivery"
+	AwardWeightedScore    = "weighted_score"
+)
+
+// AwardWeights are the weights of the criteria of AwardWeightedScore policy
+type AwardWeights struct {
+	Amount   float64
+	Delivery float64
+}
+
+// ContractAward describes how the winning offer is selected at the bidding deadline,
+// Awarded is the time the offers were evaluated and OfferID is the winner
+type ContractAward struct {
+	Policy  string
+	Weights *AwardWeights `json:",omitempty"`
+	Awarded NullString
+	OfferID NullInt64
+}
+
+// Offer is made by a supplier for a contract
+type Offer struct {
+	ID                int64
+	Created           string
+	ContractID        int64
+	Supplier          *User
+	SupplierSignature NullString
+	Comment           NullString
+	ValidUntil        NullString
+	// Expired is set when the offer was found expired
+	Expired NullString
+	// Stale is the time the contract was edited after the offer was made, the supplier signs
+	// a new revision of the offer over the new version with AmendOfferSigned
+	Stale NullString
+}
+
+// OfferRevision is a round of negotiation on an offer, AuthorKind is "supplier" or "investor"
+type OfferRevision struct {
+	ID         int64
+	OfferID    int64
+	Revision   int64
+	PreviousID NullInt64
+	AuthorKind string
+	AuthorID   int64
+	Terms      ContractBody
+	Signature  string
+	Comment    NullString
+	Created    string
+}
+
+// BodyChange is a field of ContractBody changed by a version, Old and New are json values,
+// null when the field is not set
+type BodyChange struct {
+	Field string
+	Old   json.RawMessage
+	New   json.RawMessage
+}
+
+// ContractVersion is a version of the body of a contract. Version 1 is the created body, the later
+// ones are edits of the open contract, the terms of the accepted offer and agreed amendments.
+type ContractVersion struct {
+	ID              int64
+	ContractID      int64
+	Version         int64
+	Body            ContractBody
+	Changes         []BodyChange
+	AuthorKind      string
+	AuthorID        int64
+	OfferRevisionID NullInt64
+	AmendmentID     NullInt64
+	Comment         NullString
+	Created         string
+}
+
+// Statuses of the amendments
+const (
+	AmendmentPending   = "pending"
+	AmendmentAccepted  = "accepted"
+	AmendmentRejected  = "rejected"
+	AmendmentWithdrawn = "withdrawn"
+)
+
+// ContractAmendment is a change of a signed contract proposed by one of its parties, it makes the
+// next version when the other party signs Body as well
+type ContractAmendment struct {
+	ID                 int64
+	ContractID         int64
+	BaseVersion        int64
+	Body               ContractBody
+	Changes            []BodyChange
+	ProposerKind       string
+	ProposerID         int64
+	ProposerSignature  string
+	Comment            NullString
+	Status             string
+	ResponderKind      NullString
+	ResponderID        NullInt64
+	ResponderSignature NullString
+	Created            string
+	Resolved           NullString
+}
+
+// SealedBid is a hidden offer for a sealed contract, SupplierID and Ciphertext are empty until
+// the bidding deadline. OfferID is set when the bid is revealed.
+type SealedBid struct {
+	ID         int64
+	ContractID int64
+	SupplierID NullInt64
+	Commitment string
+	Ciphertext NullString
+	Created    string
+	Revealed   NullString
+	OfferID    NullInt64
+}
+
+// ContractInput is the payload of CreateContract
+type ContractInput struct {
+	Title       string
+	Description string
+	// Amount is in minor units of the currency, Currency is required
+	Amount     int64
+	Currency   string
+	MustBeDone time.Time
+	Milestones []Milestone
+	// BiddingDeadline is optional, offers are refused after it
+	BiddingDeadline time.Time
+	// Sealed requires BiddingDeadline and RevealDeadline after it
+	Sealed         bool
+	RevealDeadline time.Time
+	// CoInvestors are optional, the creator funds the rest of Amount
+	CoInvestors []InvestorShare
+	// TemplateID renders Title and Description, which must be empty, from TemplateVersion of the
+	// template with TemplateParams. Version 0 is the latest one.
+	TemplateID      int64
+	TemplateVersion int64
+	TemplateParams  map[string]interface{}
+	// Award is optional, automatic policies require BiddingDeadline
+	Award AwardInput
+	// IdempotencyKey is sent in the Idempotency-Key header, a random one is used when it is empty.
+	// The request is retried with the key, and a repeated call with the same key and input returns
+	// the ID of the contract created by the first one.
+	IdempotencyKey string
+}
+
+// AwardInput is the award policy of a contract. PreauthorizedSignature is the investor's signature
+// of the encoded contract body, it concludes the contract when the winner offers exactly these terms
+type AwardInput struct {
+	AwardPolicy            string        `json:",omitempty"`
+	AwardWeights           *AwardWeights `json:",omitempty"`
+	PreauthorizedSignature string        `json:",omitempty"`
+}
+
+// OfferInput is the payload of CreateOffer, SupplierSignature is base64 encoded
+// ASN.1 ECDSA signature of the encoded contract body with ValidUntil set
+type OfferInput struct {
+	ContractID int64
+	Comment    string
+	// ValidUntil is optional, the offer may not be accepted after it
+	ValidUntil        time.Time
+	SupplierSignature string
+	// IdempotencyKey works as ContractInput.IdempotencyKey
+	IdempotencyKey string
+}
+
+// RevisionInput is the payload of AmendOffer and CounterOffer, Previous is the number of the
+// latest revision and Signature is made over the encoded contract body with the amended terms
+type RevisionInput struct {
+	Previous   int64
+	Amount     int64
+	MustBeDone time.Time
+	Milestones []Milestone
+	ValidUntil time.Time
+	Comment    string
+	Signature  string
+}
+
+// AmendmentInput is the payload of EditContract and ProposeAmendment, it replaces the body of the
+// contract. Signature is made over the encoded body by the proposer of an amendment, see
+// ProposeAmendmentSigned, edits of an open contract are not signed.
+type AmendmentInput struct {
+	Title       string
+	Description string
+	Amount      int64
+	Currency    string
+	MustBeDone  time.Time
+	Milestones  []Milestone
+	Comment     string
+	Signature   string
+}
+
+// BidInput are the terms of a sealed bid, title and description are taken from the contract
+type BidInput struct {
+	ContractID int64
+	Amount     int64
+	MustBeDone time.Time
+	Milestones []Milestone
+	// ValidUntil is optional, the revealed offer may not be accepted after it
+	ValidUntil time.Time
+	Comment    string
+}
+
+// SealedBidInput is the payload of SubmitSealedBid, Commitment is base64 encoded SHA-384 of the salt
+// followed by the signed encoded terms, Ciphertext is the optional Reveal encrypted to the investor
+type SealedBidInput struct {
+	ContractID int64
+	Commitment string
+	Ciphertext string `json:",omitempty"`
+}
+
+// Reveal opens a sealed bid after the bidding deadline, the supplier keeps it until then.
+// Salt is base64 encoded, SupplierSignature is made over the encoded terms.
+type Reveal struct {
+	Amount            int64
+	MustBeDone        string
+	Milestones        []Milestone `json:",omitempty"`
+	ValidUntil        string      `json:",omitempty"`
+	Comment           string
+	Salt              string
+	SupplierSignature string
+}
+
+// Types of template parameters, date values are RFC3339 strings
+const (
+	ParamString  = "string"
+	ParamInteger = "integer"
+	ParamNumber  = "number"
+	ParamBoolean = "boolean"
+	ParamDate    = "date"
+)
+
+// TemplateParameter is a typed value substituted into a template, Default is used when the value
+// is not given
+type TemplateParameter struct {
+	Name        string
+	Type        string
+	Description string      `json:",omitempty"`
+	Required    bool        `json:",omitempty"`
+	Default     interface{} `json:",omitempty"`
+}
+
+// TemplateVersion is an immutable revision of a template, Title and Description are text/template
+// sources with the parameters as fields
+type TemplateVersion struct {
+	TemplateID  int64
+	Version     int64
+	Title       string
+	Description string
+	Parameters  []TemplateParameter
+	Created     string
+}
+
+// Template is owned by the investor who created it, Latest is its latest version
+type Template struct {
+	ID         int64
+	InvestorID int64
+	Name       string
+	Created    string
+	Latest     TemplateVersion
+}
+
+// TemplateRef is the template version and the parameters, defaults included, a contract was rendered with
+type TemplateRef struct {
+	TemplateID int64
+	Version    int64
+	Params     map[string]interface{}
+}
+
+// TemplateInput is the payload of CreateTemplate and UpdateTemplate
+type TemplateInput struct {
+	Name        string
+	Title       string
+	Description string
+	Parameters  []TemplateParameter
+}
+
+// TemplateFilter selects templates in ListTemplates, zero fields are ignored
+type TemplateFilter struct {
+	InvestorID int64
+	Name       string
+}
+
+// ContractFilter selects contracts in ListContracts, zero fields are ignored. ConvertTo sets
+// Contract.Converted of the contracts whose currency has an exchange rate.
+type ContractFilter struct {
+	SupplierID int64
+	InvestorID int64
+	Title      string
+	Currency   string
+	ConvertTo  string
+	// Archived lists the archived contracts instead of the active ones
+	Archived bool
+}
+
+// OfferFilter selects offers in ListOffers, zero fields are ignored
+type OfferFilter struct {
+	SupplierID int64
+	ContractID int64
+	Currency   string
+}
+
+// ExchangeRate is the number of major units of Currency worth one unit of the base currency
+// the rates are stored against, Exponent is the number of digits of the minor units
+type ExchangeRate struct {
+	Currency string
+	Exponent int
+	Rate     float64
+	Updated  string
+}
+
+// Page selects a part of a listing, Limit 0 means no limit
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+// formatTime formats optional time the way the api stores it, zero time is empty
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+type createdResponse struct {
+	ID int64 `json:"id"`
+}
+
+// Kinds of users, ledger accounts of both are listed with the user's token
+const (
+	UserInvestor = "investor"
+	UserSupplier = "supplier"
+)
+
+// LedgerAccount holds money in a currency, OwnerID is the user of the account or the contract of
+// an escrow account. Balance is the credits less the debits in minor units.
+type LedgerAccount struct {
+	ID       int64
+	Kind     string
+	OwnerID  int64
+	Currency string
+	Balance  int64
+	Created  string
+}
+
+// LedgerEntry is a posting to an account, Balance is the balance of the account after it.
+// Type is deposit, fund, release or refund.
+type LedgerEntry struct {
+	ID            int64
+	TransactionID int64
+	Type          string
+	ContractID    NullInt64
+	Milestone     NullInt64
+	Reference     NullString
+	Debit         int64
+	Credit        int64
+	Balance       int64
+	Created       string
+}
+
+// LedgerCheck is the result of the ledger invariant checks, the ledger is consistent when Debits
+// equal Credits and there are no unbalanced transactions and overdrawn accounts
+type LedgerCheck struct {
+	Debits     int64
+	Credits    int64
+	Unbalanced []int64
+	Overdrawn  []int64
+}
+
+// ClientCertificate is a TLS client certificate registered by the user, the user is identified
+// by it without a token
+type ClientCertificate struct {
+	ID      int64
+	Serial  string
+	Subject string
+	Expires string
+	Created string
+}
+
+// LoginChallenge is the nonce signed to log in, see Login
+type LoginChallenge struct {
+	Nonce   string
+	Expires string
+}
+
+// Session is the result of the challenge-response login
+type Session struct {
+	Token   string
+	Kind    string
+	ID      int64
+	Expires string
+}
+
+// Staff roles granted to the users
+const (
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+	RoleAuditor   = "auditor"
+)
+
+// RoleGrant is a staff role granted to a user
+type RoleGrant struct {
+	ID       int64
+	UserKind string
+	UserID   int64
+	Role     string
+	Created  string
+}
+
+// AuditEntry is an action of the staff recorded in the audit log
+type AuditEntry struct {
+	ID        int64
+	ActorKind string
+	ActorID   int64
+	Action    string
+	Target    string
+	Detail    NullString
+	Created   string
+}
+
+// SignatureRecord is a signature made on a contract, its offers, their revisions or its amendments
+type SignatureRecord struct {
+	Source      string
+	OfferID     int64
+	Revision    int64
+	AmendmentID int64
+	SignerKind  string
+	SignerID    int64
+	Signature   string
+	Signed      NullString
+}
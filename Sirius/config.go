@@ -0,0 +1,361 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	elog "github.com/labstack/gommon/log"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config is the configuration of Sirius. The defaults are overridden by the YAML or TOML file,
+// then by the environment variables and then by the flags. Fields tagged reload are applied
+// on SIGHUP, the others are read once at startup.
+type Config struct {
+	Listen           string        `yaml:"listen" toml:"listen" env:"SIRIUS_LISTEN" flag:"listen" help:"address the api listens on"`
+	MetricsListen    string        `yaml:"metrics_listen" toml:"metrics_listen" env:"SIRIUS_METRICS_LISTEN" flag:"metrics-listen" help:"address the metrics are served on to the scrapers without authentication, like 127.0.0.1:9090, empty serves them on the api to the staff only"`
+	Database         string        `yaml:"database" toml:"database" env:"SIRIUS_DATABASE" flag:"database" help:"path of the SQLite database"`
+	SuppliersURL     string        `yaml:"suppliers_url" toml:"suppliers_url" env:"SIRIUS_SUPPLIERS_URL" flag:"suppliers-url" help:"base URL of the suppliers api" reload:"true"`
+	InvestorsURL     string        `yaml:"investors_url" toml:"investors_url" env:"SIRIUS_INVESTORS_URL" flag:"investors-url" help:"base URL of the investors api" reload:"true"`
+	UserCacheTTL     time.Duration `yaml:"user_cache_ttl" toml:"user_cache_ttl" env:"SIRIUS_USER_CACHE_TTL" flag:"user-cache-ttl" help:"how long suppliers and investors loaded from the upstream apis are cached, 0 disables the cache" reload:"true"`
+	SessionTTL       time.Duration `yaml:"session_ttl" toml:"session_ttl" env:"SIRIUS_SESSION_TTL" flag:"session-ttl" help:"lifetime of the session tokens issued by the challenge-response login" reload:"true"`
+	IdempotencyTTL   time.Duration `yaml:"idempotency_ttl" toml:"idempotency_ttl" env:"SIRIUS_IDEMPOTENCY_TTL" flag:"idempotency-ttl" help:"how long the responses to requests with an Idempotency-Key are kept for replays" reload:"true"`
+	RestoreGrace     time.Duration `yaml:"restore_grace" toml:"restore_grace" env:"SIRIUS_RESTORE_GRACE" flag:"restore-grace" help:"how long deleted contracts and offers may be restored" reload:"true"`
+	DeletedRetention time.Duration `yaml:"deleted_retention" toml:"deleted_retention" env:"SIRIUS_DELETED_RETENTION" flag:"deleted-retention" help:"how long deleted open contracts and their offers are kept before they are purged, signed contracts are kept for good" reload:"true"`
+	IPRateLimit      string        `yaml:"ip_rate_limit" toml:"ip_rate_limit" env:"SIRIUS_IP_RATE_LIMIT" flag:"ip-rate-limit" help:"requests/period allowed to a client address on each route, empty disables the limit" reload:"true"`
+	UserRateLimit    string        `yaml:"user_rate_limit" toml:"user_rate_limit" env:"SIRIUS_USER_RATE_LIMIT" flag:"user-rate-limit" help:"requests/period allowed to an authenticated user on each route, empty disables the limit" reload:"true"`
+	RouteRateLimits  string        `yaml:"route_rate_limits" toml:"route_rate_limits" env:"SIRIUS_ROUTE_RATE_LIMITS" flag:"route-rate-limits" help:"comma separated METHOD /path=requests/period overriding user_rate_limit for the routes" reload:"true"`
+	TrustedProxies   string        `yaml:"trusted_proxies" toml:"trusted_proxies" env:"SIRIUS_TRUSTED_PROXIES" flag:"trusted-proxies" help:"comma separated addresses or CIDR ranges of the reverse proxies whose X-Forwarded-For and X-Real-IP give the client address to ip_rate_limit, empty limits the connection addresses" reload:"true"`
+	MaxOpenOffers    int           `yaml:"max_open_offers" toml:"max_open_offers" env:"SIRIUS_MAX_OPEN_OFFERS" flag:"max-open-offers" help:"offers a supplier may have on the open contracts, 0 disables the quota" reload:"true"`
+	DailyContracts   int           `yaml:"daily_contracts" toml:"daily_contracts" env:"SIRIUS_DAILY_CONTRACTS" flag:"daily-contracts" help:"contracts an investor may create a day, 0 disables the quota" reload:"true"`
+	Admins           string        `yaml:"admins" toml:"admins" env:"SIRIUS_ADMINS" flag:"admins" help:"comma separated kind:ID of the users who are admins, like investor:1, they grant the other staff roles" reload:"true"`
+	AllowedOrigins   string        `yaml:"allowed_origins" toml:"allowed_origins" env:"SIRIUS_ALLOWED_ORIGINS" flag:"allowed-origins" help:"comma separated origins, like https://app.example.com, whose pages may open the event WebSocket besides the pages of the api" reload:"true"`
+	LogLevel         string        `yaml:"log_level" toml:"log_level" env:"SIRIUS_LOG_LEVEL" flag:"log-level" help:"log level: debug, info, warn, error or off" reload:"true"`
+	PaymentProvider  string        `yaml:"payment_provider" toml:"payment_provider" env:"SIRIUS_PAYMENT_PROVIDER" flag:"payment-provider" help:"provider charging the deposits, it is required: fake accepts every charge without collecting money, for development only"`
+	RatesFile        string        `yaml:"rates_file" toml:"rates_file" env:"SIRIUS_RATES_FILE" flag:"rates-file" help:"JSON file of exchange rates loaded at startup"`
+	AMQPURL          string        `yaml:"amqp_url" toml:"amqp_url" env:"SIRIUS_AMQP_URL" flag:"amqp-url" help:"URL of the AMQP broker events are published to" secret:"true"`
+	AMQPExchange     string        `yaml:"amqp_exchange" toml:"amqp_exchange" env:"SIRIUS_AMQP_EXCHANGE" flag:"amqp-exchange" help:"AMQP exchange of the events"`
+	TracesExporter   string        `yaml:"traces_exporter" toml:"traces_exporter" env:"SIRIUS_TRACES_EXPORTER" flag:"traces-exporter" help:"span exporter: otlp, stdout or file, empty disables tracing"`
+	TracesFile       string        `yaml:"traces_file" toml:"traces_file" env:"SIRIUS_TRACES_FILE" flag:"traces-file" help:"file the spans are written to by the file exporter"`
+	TLSCert          string        `yaml:"tls_cert" toml:"tls_cert" env:"SIRIUS_TLS_CERT" flag:"tls-cert" help:"PEM certificate of the api, the api is served over HTTPS when it is set"`
+	TLSKey           string        `yaml:"tls_key" toml:"tls_key" env:"SIRIUS_TLS_KEY" flag:"tls-key" help:"PEM private key of tls_cert"`
+	ClientCA         string        `yaml:"client_ca" toml:"client_ca" env:"SIRIUS_CLIENT_CA" flag:"client-ca" help:"PEM certificate of the CA issuing the client certificates, enables authentication by client certificates"`
+	ClientAuth       string        `yaml:"client_auth" toml:"client_auth" env:"SIRIUS_CLIENT_AUTH" flag:"client-auth" help:"client certificates: optional accepts tokens too, require rejects connections without a certificate"`
+
+	// limits are parsed from the rate limit fields by validate
+	limits *rateLimits
+}
+
+// defaultConfig returns the configuration used when no source sets a field
+func defaultConfig() *Config {
+	return &Config{
+		Listen:           ":1323",
+		Database:         dbName,
+		SuppliersURL:     "http://192.168.43.219:8191/api/clients",
+		InvestorsURL:     "http://192.168.43.219:8193/api/investors",
+		SessionTTL:       15 * time.Minute,
+		IdempotencyTTL:   24 * time.Hour,
+		RestoreGrace:     7 * 24 * time.Hour,
+		DeletedRetention: 30 * 24 * time.Hour,
+		IPRateLimit:      "600/1m",
+		UserRateLimit:    "300/1m",
+		RouteRateLimits:  "POST /offers=20/1m,POST /contracts=10/1m,POST /bids=20/1m",
+		MaxOpenOffers:    100,
+		DailyContracts:   50,
+		LogLevel:         "info",
+		AMQPExchange:     "sirius.events",
+		ClientAuth:       ClientAuthOptional,
+	}
+}
+
+// logLevels maps the names of the log levels to the levels of the echo logger
+var logLevels = map[string]elog.Lvl{
+	"debug": elog.DEBUG,
+	"info":  elog.INFO,
+	"warn":  elog.WARN,
+	"error": elog.ERROR,
+	"off":   elog.OFF,
+}
+
+// config holds the *Config in effect, it is replaced on reload
+var config atomic.Value
+
+func init() {
+	cfg := defaultConfig()
+	if err := cfg.validate(); err != nil {
+		panic(err)
+	}
+	config.Store(cfg)
+}
+
+// currentConfig returns the configuration in effect, it must not be modified
+func currentConfig() *Config {
+	return config.Load().(*Config)
+}
+
+// configFlag is a flag overriding a field of the configuration, it is applied only when given
+type configFlag struct {
+	value string
+	set   bool
+}
+
+func (f *configFlag) String() string {
+	return f.value
+}
+
+func (f *configFlag) Set(value string) error {
+	f.value, f.set = value, true
+	return nil
+}
+
+// ConfigFlags are the flags of the configuration fields by field name
+type ConfigFlags map[string]*configFlag
+
+// RegisterConfigFlags defines a flag for every field of the configuration in fs
+func RegisterConfigFlags(fs *flag.FlagSet) ConfigFlags {
+	flags := make(ConfigFlags)
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		f := new(configFlag)
+		fs.Var(f, field.Tag.Get("flag"), field.Tag.Get("help"))
+		flags[field.Name] = f
+	}
+	return flags
+}
+
+// LoadConfig reads the configuration from the file, the environment and the flags and validates it,
+// path may be empty. The file is YAML unless its extension is .toml.
+func LoadConfig(path string, flags ConfigFlags) (*Config, error) {
+	cfg := defaultConfig()
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(filepath.Ext(path), ".toml") {
+			md, err := toml.Decode(string(data), cfg)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			if undecoded := md.Undecoded(); len(undecoded) > 0 {
+				return nil, fmt.Errorf("%s: unknown setting %s", path, undecoded[0])
+			}
+		} else if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		if value, ok := os.LookupEnv(field.Tag.Get("env")); ok {
+			if err := setConfigField(v.Field(i), value); err != nil {
+				return nil, fmt.Errorf("%s: %v", field.Tag.Get("env"), err)
+			}
+		}
+		if f := flags[field.Name]; f != nil && f.set {
+			if err := setConfigField(v.Field(i), f.value); err != nil {
+				return nil, fmt.Errorf("-%s: %v", field.Tag.Get("flag"), err)
+			}
+		}
+	}
+	return cfg, cfg.validate()
+}
+
+// setConfigField parses the value of the string, int or time.Duration field
+func setConfigField(v reflect.Value, value string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	if v.Kind() == reflect.Int {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+		return nil
+	}
+	v.SetString(value)
+	return nil
+}
+
+// validate checks the configuration and parses its rate limits, all the problems are reported at once
+func (cfg *Config) validate() error {
+	var problems []string
+	if cfg.Listen == "" {
+		problems = append(problems, "listen address is empty")
+	}
+	if cfg.Database == "" {
+		problems = append(problems, "database path is empty")
+	}
+	for name, s := range map[string]string{"suppliers_url": cfg.SuppliersURL, "investors_url": cfg.InvestorsURL} {
+		if u, err := url.Parse(s); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, name+" must be an absolute http or https URL")
+		}
+	}
+	if cfg.UserCacheTTL < 0 {
+		problems = append(problems, "user_cache_ttl is negative")
+	}
+	if cfg.SessionTTL <= 0 {
+		problems = append(problems, "session_ttl must be positive")
+	}
+	if cfg.IdempotencyTTL <= 0 {
+		problems = append(problems, "idempotency_ttl must be positive")
+	}
+	if cfg.RestoreGrace <= 0 || cfg.DeletedRetention < cfg.RestoreGrace {
+		problems = append(problems, "restore_grace must be positive and deleted_retention at least restore_grace")
+	}
+	limits, limitProblems := parseRateLimits(cfg)
+	problems = append(problems, limitProblems...)
+	cfg.limits = limits
+	if cfg.MaxOpenOffers < 0 || cfg.DailyContracts < 0 {
+		problems = append(problems, "max_open_offers and daily_contracts must not be negative")
+	}
+	for _, admin := range strings.Split(cfg.Admins, ",") {
+		if admin = strings.TrimSpace(admin); admin == "" {
+			continue
+		}
+		parts := strings.SplitN(admin, ":", 2)
+		if len(parts) != 2 || (parts[0] != UserInvestor && parts[0] != UserSupplier) {
+			problems = append(problems, "admin "+admin+" is not investor:ID or supplier:ID")
+		} else if _, err := strconv.ParseInt(parts[1], 10, 64); err != nil {
+			problems = append(problems, "admin "+admin+" is not investor:ID or supplier:ID")
+		}
+	}
+	for _, origin := range strings.Split(cfg.AllowedOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin == "" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" {
+			problems = append(problems, "allowed origin "+origin+" is not an http or https scheme and host")
+		}
+	}
+	if cfg.PaymentProvider != "" && cfg.PaymentProvider != PaymentFake {
+		problems = append(problems, "unknown payment_provider "+cfg.PaymentProvider)
+	}
+	if _, ok := logLevels[cfg.LogLevel]; !ok {
+		problems = append(problems, "unknown log_level "+cfg.LogLevel)
+	}
+	if cfg.AMQPURL != "" {
+		if u, err := url.Parse(cfg.AMQPURL); err != nil || (u.Scheme != "amqp" && u.Scheme != "amqps") {
+			problems = append(problems, "amqp_url must be an amqp or amqps URL")
+		}
+		if cfg.AMQPExchange == "" {
+			problems = append(problems, "amqp_exchange is empty")
+		}
+	}
+	switch cfg.TracesExporter {
+	case "", TracesOTLP, TracesStdout:
+	case TracesFile:
+		if cfg.TracesFile == "" {
+			problems = append(problems, "traces_file is required by the file exporter")
+		}
+	default:
+		problems = append(problems, "unknown traces_exporter "+cfg.TracesExporter)
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		problems = append(problems, "tls_cert and tls_key must be set together")
+	}
+	if cfg.ClientCA != "" && cfg.TLSCert == "" {
+		problems = append(problems, "client_ca requires tls_cert")
+	}
+	if cfg.ClientAuth != ClientAuthOptional && cfg.ClientAuth != ClientAuthRequire {
+		problems = append(problems, "unknown client_auth "+cfg.ClientAuth)
+	}
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Redacted returns the configuration as YAML with the secrets hidden, the passwords of secret URLs
+// are hidden and the other secrets are replaced as a whole
+func (cfg *Config) Redacted() string {
+	redacted := *cfg
+	v := reflect.ValueOf(&redacted).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("secret") == "" || v.Field(i).String() == "" {
+			continue
+		}
+		if u, err := url.Parse(v.Field(i).String()); err == nil && u.User != nil {
+			v.Field(i).SetString(u.Redacted())
+		} else {
+			v.Field(i).SetString("xxxxx")
+		}
+	}
+	data, err := yaml.Marshal(&redacted)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
+
+// reloadConfig reads the configuration again and puts the fields tagged reload in effect,
+// the changes of the other fields are logged and ignored until restart
+func reloadConfig(path string, flags ConfigFlags) (*Config, error) {
+	next, err := LoadConfig(path, flags)
+	if err != nil {
+		return nil, err
+	}
+	cfg := *currentConfig()
+	v, nv := reflect.ValueOf(&cfg).Elem(), reflect.ValueOf(next).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" || reflect.DeepEqual(v.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		if t.Field(i).Tag.Get("reload") == "" {
+			log.Printf("config: %s changed, restart to apply it", t.Field(i).Tag.Get("yaml"))
+			continue
+		}
+		v.Field(i).Set(nv.Field(i))
+	}
+	// the rate limits are reloaded as a whole
+	cfg.limits = next.limits
+	config.Store(&cfg)
+	return &cfg, nil
+}
+
+// WatchConfigReload reloads the configuration on SIGHUP and calls apply with the configuration
+// which took effect, a configuration failing validation is logged and not applied
+func WatchConfigReload(path string, flags ConfigFlags, apply func(*Config)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		cfg, err := reloadConfig(path, flags)
+		if err != nil {
+			log.Print("config: reload failed: ", err)
+			continue
+		}
+		apply(cfg)
+		log.Print("config: reloaded\n", cfg.Redacted())
+	}
+}
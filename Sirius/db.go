@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/mattn/go-sqlite3"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// sqliteDriver is the driver wrapped by instrumentedDriver
+var sqliteDriver = &sqlite3.SQLiteDriver{}
+
+func init() {
+	sql.Register(dbDriver, instrumentedDriver{sqliteDriver})
+}
+
+// openDB opens the database for a request, the database calls are traced as children of the span
+// in ctx unless they are given a context of their own. Like sql.Open it does not connect yet.
+func openDB(ctx context.Context) (*sql.DB, error) {
+	return sql.OpenDB(instrumentedConnector{ctx}), nil
+}
+
+// dataSourceName adds the connection options to the database path. Transactions begin IMMEDIATE,
+// so a writer waits for the busy timeout at BEGIN instead of deadlocking with another deferred
+// transaction when both upgrade their SHARED locks, which SQLite fails without waiting.
+func dataSourceName(name string) string {
+	sep := "?"
+	if strings.Contains(name, "?") {
+		sep = "&"
+	}
+	return name + sep + "_txlock=immediate"
+}
+
+// isBusy reports whether the database stayed locked by other connections for the busy timeout
+func isBusy(err error) bool {
+	sqliteErr, ok := err.(sqlite3.Error)
+	return ok && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
+
+// busyConflict responds to a request whose transaction could not lock the database, it may be retried
+func busyConflict(c echo.Context) error {
+	return c.String(http.StatusConflict, "Database is busy, retry the request")
+}
+
+// instrumentedConnector connects to dbName with the context of the request
+type instrumentedConnector struct {
+	ctx context.Context
+}
+
+// Connect implements driver.Connector
+func (c instrumentedConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := sqliteDriver.Open(dataSourceName(dbName))
+	if err != nil {
+		return nil, err
+	}
+	return instrumentedConn{conn, c.ctx}, nil
+}
+
+// Driver implements driver.Connector
+func (instrumentedConnector) Driver() driver.Driver {
+	return instrumentedDriver{sqliteDriver}
+}
+
+// instrumentedDriver wraps the sqlite3 driver to time and trace the database calls,
+// it is registered as dbDriver
+type instrumentedDriver struct {
+	driver.Driver
+}
+
+// Open implements driver.Driver
+func (d instrumentedDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(dataSourceName(name))
+	if err != nil {
+		return nil, err
+	}
+	return instrumentedConn{conn, context.Background()}, nil
+}
+
+type instrumentedConn struct {
+	driver.Conn
+	// ctx is the parent of the calls made without a traced context
+	ctx context.Context
+}
+
+// start times the database call and traces it when there is a span to be its parent,
+// the returned func ends the call with its error
+func (c instrumentedConn) start(ctx context.Context, operation, query string) func(error) {
+	start := time.Now()
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		ctx = c.ctx
+	}
+	var span trace.Span
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		_, span = tracer.Start(ctx, "sqlite."+operation, trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemSqlite))
+		if query != "" {
+			span.SetAttributes(semconv.DBQueryText(query))
+		}
+	}
+	return func(err error) {
+		dbQueryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+		if span != nil {
+			endSpan(span, err)
+		}
+	}
+}
+
+func (c instrumentedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c instrumentedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	end := c.start(ctx, "prepare", query)
+	var stmt driver.Stmt
+	var err error
+	if pc, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = pc.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	end(err)
+	if err != nil {
+		return nil, err
+	}
+	return instrumentedStmt{stmt, c, query}, nil
+}
+
+func (c instrumentedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ec, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	end := c.start(ctx, "exec", query)
+	res, err := ec.ExecContext(ctx, query, args)
+	end(err)
+	return res, err
+}
+
+func (c instrumentedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	end := c.start(ctx, "query", query)
+	rows, err := qc.QueryContext(ctx, query, args)
+	end(err)
+	return rows, err
+}
+
+func (c instrumentedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	end := c.start(ctx, "begin", "")
+	var tx driver.Tx
+	var err error
+	if bc, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = bc.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	end(err)
+	if err != nil {
+		return nil, err
+	}
+	return instrumentedTx{tx, c, ctx}, nil
+}
+
+type instrumentedTx struct {
+	driver.Tx
+	conn instrumentedConn
+	ctx  context.Context
+}
+
+func (t instrumentedTx) Commit() error {
+	end := t.conn.start(t.ctx, "commit", "")
+	err := t.Tx.Commit()
+	end(err)
+	return err
+}
+
+func (t instrumentedTx) Rollback() error {
+	end := t.conn.start(t.ctx, "rollback", "")
+	err := t.Tx.Rollback()
+	end(err)
+	return err
+}
+
+type instrumentedStmt struct {
+	driver.Stmt
+	conn  instrumentedConn
+	query string
+}
+
+func (s instrumentedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	end := s.conn.start(context.Background(), "exec", s.query)
+	res, err := s.Stmt.Exec(args)
+	end(err)
+	return res, err
+}
+
+func (s instrumentedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	end := s.conn.start(context.Background(), "query", s.query)
+	rows, err := s.Stmt.Query(args)
+	end(err)
+	return rows, err
+}
+
+func (s instrumentedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	sc, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		return s.Exec(namedValues(args))
+	}
+	end := s.conn.start(ctx, "exec", s.query)
+	res, err := sc.ExecContext(ctx, args)
+	end(err)
+	return res, err
+}
+
+func (s instrumentedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	sc, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		return s.Query(namedValues(args))
+	}
+	end := s.conn.start(ctx, "query", s.query)
+	rows, err := sc.QueryContext(ctx, args)
+	end(err)
+	return rows, err
+}
+
+// namedValues drops the names of the positional arguments
+func namedValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, a := range args {
+		values[i] = a.Value
+	}
+	return values
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/labstack/echo"
+)
+
+// Headers of the conditional requests
+const (
+	HeaderETag    = "ETag"
+	HeaderIfMatch = "If-Match"
+)
+
+// contractETag returns the entity tag of the contract at the revision, the revision is increased
+// by the triggers of the schema on every change of the contract or its investors
+func contractETag(revision int64) string {
+	return strconv.Quote(strconv.FormatInt(revision, 10))
+}
+
+// offerETag returns the entity tag of the offer, it changes with the revisions of the offer, when
+// the offer expires and when it becomes stale by an edit of the contract
+func offerETag(revision int64, expired, stale bool) string {
+	tag := strconv.FormatInt(revision, 10)
+	if expired {
+		tag += "-expired"
+	}
+	if stale {
+		tag += "-stale"
+	}
+	return strconv.Quote(tag)
+}
+
+// ifMatch reports whether the request may change the resource with the entity tag: the request
+// has no If-Match header, or the header is * or lists the tag. Weak tags never match.
+func ifMatch(c echo.Context, etag string) bool {
+	header := c.Request().Header.Get(HeaderIfMatch)
+	if header == "" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// preconditionFailed responds with 412 Precondition Failed and the current entity tag of the resource
+func preconditionFailed(c echo.Context, etag string) error {
+	c.Response().Header().Set(HeaderETag, etag)
+	return c.String(http.StatusPreconditionFailed, "Resource was modified, its current ETag is "+etag)
+}
+
+// currentOfferETag returns the entity tag of the offer with its latest revision
+func currentOfferETag(db dbExecutor, offerID int64, expired, stale bool) (string, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("COALESCE(MAX(revision), 0)")
+	sb.From("offer_revisions")
+	sb.Where(sb.Equal("offer_id", offerID))
+	q, args := sb.Build()
+
+	var revision int64
+	err := db.QueryRow(q, args...).Scan(&revision)
+	return offerETag(revision, expired, stale), err
+}
+
+// currentContractRevision returns the revision of the contract, it is read in the transaction changing
+// the contract so the ETag sent back is the one the change made
+func currentContractRevision(db dbExecutor, contractID int64) (int64, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("revision")
+	sb.From("contracts")
+	sb.Where(sb.Equal("id", contractID))
+	q, args := sb.Build()
+
+	var revision int64
+	err := db.QueryRow(q, args...).Scan(&revision)
+	return revision, err
+}
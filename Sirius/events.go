@@ -0,0 +1,189 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+)
+
+// Domain event types
+const (
+	EventContractCreated          = "contract.created"
+	EventContractAccepted         = "contract.accepted"
+	EventContractDeleted          = "contract.deleted"
+	EventCancellationRequested    = "contract.cancellation_requested"
+	EventContractRestored         = "contract.restored"
+	EventContractAwarded          = "contract.awarded"
+	EventContractSigned           = "contract.signed"
+	EventContractInvestorsChanged = "contract.investors_changed"
+	EventContractAmended          = "contract.amended"
+	EventAmendmentProposed        = "contract.amendment_proposed"
+	EventAmendmentRejected        = "contract.amendment_rejected"
+	EventMilestoneAccepted        = "contract.milestone_accepted"
+	EventOfferCreated             = "offer.created"
+	EventOfferDeleted             = "offer.deleted"
+	EventOfferRestored            = "offer.restored"
+	EventOfferRevised             = "offer.revised"
+	EventOfferExpired             = "offer.expired"
+	EventBidSealed                = "bid.sealed"
+)
+
+// EventTypes lists all the domain event types
+var EventTypes = []string{
+	EventContractCreated,
+	EventContractAccepted,
+	EventContractDeleted,
+	EventCancellationRequested,
+	EventContractRestored,
+	EventContractAwarded,
+	EventContractSigned,
+	EventContractInvestorsChanged,
+	EventContractAmended,
+	EventAmendmentProposed,
+	EventAmendmentRejected,
+	EventMilestoneAccepted,
+	EventOfferCreated,
+	EventOfferDeleted,
+	EventOfferRestored,
+	EventOfferRevised,
+	EventOfferExpired,
+	EventBidSealed,
+}
+
+// Kinds of users
+const (
+	UserInvestor = "investor"
+	UserSupplier = "supplier"
+)
+
+// EventRecipient is a user allowed to see an event
+type EventRecipient struct {
+	Kind string `json:"kind"`
+	ID   int64  `json:"id"`
+}
+
+// EventSchemaVersion is incremented on incompatible changes of Event json encoding
+const EventSchemaVersion = 1
+
+// Event is emitted by api controllers when contracts or offers change.
+// Public events are visible for everybody, others - only for Recipients
+type Event struct {
+	Version    int         `json:"version"`
+	ID         int64       `json:"id"`
+	Type       string      `json:"type"`
+	Created    string      `json:"created"`
+	ContractID int64       `json:"contract_id,omitempty"`
+	OfferID    int64       `json:"offer_id,omitempty"`
+	InvestorID int64       `json:"investor_id,omitempty"`
+	SupplierID int64       `json:"supplier_id,omitempty"`
+	Data       interface{} `json:"data,omitempty"`
+
+	Public     bool             `json:"-"`
+	Recipients []EventRecipient `json:"-"`
+}
+
+// VisibleTo reports whether the user may see the event
+func (ev *Event) VisibleTo(kind string, id int64) bool {
+	if ev.Public {
+		return true
+	}
+	for _, r := range ev.Recipients {
+		if r.Kind == kind && r.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// investorAndSupplier returns recipients list of the event parties which are known
+func investorAndSupplier(investorID, supplierID int64) []EventRecipient {
+	var recipients []EventRecipient
+	if investorID != 0 {
+		recipients = append(recipients, EventRecipient{UserInvestor, investorID})
+	}
+	if supplierID != 0 {
+		recipients = append(recipients, EventRecipient{UserSupplier, supplierID})
+	}
+	return recipients
+}
+
+// dbExecutor is implemented by both *sql.DB and *sql.Tx
+type dbExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// EmitEvent records the event in the outbox (events table) and schedules webhook deliveries for it.
+// It must be called within the transaction which makes the change described by the event,
+// so the event is stored if and only if the change is committed, and eventsCommitted is called
+// after the commit
+func EmitEvent(tx dbExecutor, ev Event) error {
+	ev.Version = EventSchemaVersion
+	ev.Created = time.Now().Format(time.RFC3339)
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	// co-investors see the events of the contracts they fund
+	if !ev.Public && ev.ContractID != 0 {
+		ev.Recipients, err = withCoInvestors(tx, ev.ContractID, ev.Recipients)
+		if err != nil {
+			return err
+		}
+	}
+	recipients, err := json.Marshal(ev.Recipients)
+	if err != nil {
+		return err
+	}
+
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto("events")
+	ib.Cols("type", "created", "payload", "public", "recipients")
+	ib.Values(ev.Type, ev.Created, string(payload), ev.Public, string(recipients))
+	q, args := ib.Build()
+
+	res, err := tx.Exec(q, args...)
+	if err != nil {
+		return err
+	}
+	ev.ID, err = res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	return ScheduleWebhookDeliveries(tx, &ev)
+}
+
+// eventsCommitted wakes up the outbox relay, the event hub and the webhook worker. It is called
+// after the commit of a transaction which emitted events, woken up before they would not see them yet.
+func eventsCommitted() {
+	wakeOutboxRelay()
+	eventHub.Wake()
+	wakeWebhookWorker()
+}
+
+// loadEvent reads the stored event
+func loadEvent(db dbExecutor, id int64) (*Event, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("payload", "public", "recipients")
+	sb.From("events")
+	sb.Where(sb.Equal("id", id))
+	q, args := sb.Build()
+
+	var payload, recipients string
+	ev := &Event{}
+	err := db.QueryRow(q, args...).Scan(&payload, &ev.Public, &recipients)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(payload), ev); err != nil {
+		return nil, err
+	}
+	ev.ID = id
+	if err := json.Unmarshal([]byte(recipients), &ev.Recipients); err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+)
+
+const offerExpiryPeriod = time.Minute
+
+// RunOfferExpiry periodically marks the offers of open contracts whose validity has passed
+// as expired and notifies their parties
+func RunOfferExpiry() {
+	for {
+		if err := expireOffers(time.Now()); err != nil {
+			log.Print("offer expiry: ", err)
+		}
+		time.Sleep(offerExpiryPeriod)
+	}
+}
+
+// expireOffers marks the offers which are not valid at the time, every offer is expired
+// in its own transaction together with the event
+func expireOffers(now time.Time) error {
+	db, err := sql.Open(dbDriver, dbName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// valid_until is stored as RFC3339 in UTC, so it is compared as a string
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("offers.id", "offers.contract_id", "offers.supplier_id", "offers.valid_until", "contracts.investor_id")
+	sb.From("offers")
+	sb.Join("contracts", "contracts.id = offers.contract_id")
+	sb.Where(sb.IsNull("offers.expired"), sb.IsNotNull("offers.valid_until"),
+		sb.LessThan("offers.valid_until", now.UTC().Format(time.RFC3339)), sb.Equal("contracts.stage", 0),
+		sb.IsNull("offers.deleted_at"), sb.IsNull("contracts.deleted_at"))
+	q, args := sb.Build()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return err
+	}
+	type expiredOffer struct {
+		ID, ContractID, SupplierID, InvestorID int64
+		ValidUntil                             string
+	}
+	var offers []expiredOffer
+	for rows.Next() {
+		var o expiredOffer
+		if err := rows.Scan(&o.ID, &o.ContractID, &o.SupplierID, &o.ValidUntil, &o.InvestorID); err != nil {
+			rows.Close()
+			return err
+		}
+		offers = append(offers, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, o := range offers {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		// the offer may have been renewed by a new revision since it was selected
+		ub := sqlbuilder.NewUpdateBuilder()
+		ub.Update("offers")
+		ub.Set(ub.Assign("expired", now.Format(time.RFC3339)))
+		ub.Where(ub.Equal("id", o.ID), ub.IsNull("expired"), ub.Equal("valid_until", o.ValidUntil))
+		q, args := ub.Build()
+		res, err := tx.Exec(q, args...)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if affected, err := res.RowsAffected(); err != nil || affected != 1 {
+			tx.Rollback()
+			continue
+		}
+
+		err = EmitEvent(tx, Event{
+			Type:       EventOfferExpired,
+			ContractID: o.ContractID,
+			OfferID:    o.ID,
+			InvestorID: o.InvestorID,
+			SupplierID: o.SupplierID,
+			Data:       struct{ ValidUntil string }{o.ValidUntil},
+			Recipients: investorAndSupplier(o.InvestorID, o.SupplierID),
+		})
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		eventsCommitted()
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestOfferExpiry(t *testing.T) {
+	api := newTestAPI(t)
+	usePaymentProvider(t, &FakePaymentProvider{})
+	investor, supplier := api.user(UserInvestor, 1), api.user(UserSupplier, 7)
+	contractID := investor.createContract(nil)
+	created(t, investor.deposit(100000))
+
+	// offerTerms returns the encoded terms of the contract valid until the time
+	offerTerms := func(validUntil time.Time) (ContractBody, []byte) {
+		t.Helper()
+		rec := api.do(nil, http.MethodGet, "/contracts/"+strconv.FormatInt(contractID, 10)+"/encoded", nil)
+		var terms ContractBody
+		if err := json.Unmarshal(rec.Body.Bytes(), &terms); err != nil {
+			t.Fatalf("encoded contract: %d %s", rec.Code, rec.Body.String())
+		}
+		terms.ValidUntil = validUntil.UTC().Format(time.RFC3339)
+		encoded, _ := json.Marshal(terms)
+		return terms, encoded
+	}
+	past := time.Now().Add(-time.Minute)
+	_, encoded := offerTerms(past)
+	expectStatus(t, "offer valid until the past", supplier.do(http.MethodPost, "/offers", map[string]interface{}{
+		"ContractID": contractID, "SupplierSignature": supplier.sign(encoded), "ValidUntil": past.UTC().Format(time.RFC3339)}), http.StatusBadRequest)
+
+	validUntil := time.Now().Add(time.Hour)
+	_, encoded = offerTerms(validUntil)
+	offerID := created(t, supplier.do(http.MethodPost, "/offers", map[string]interface{}{
+		"ContractID": contractID, "SupplierSignature": supplier.sign(encoded), "ValidUntil": validUntil.UTC().Format(time.RFC3339)}))
+
+	if err := expireOffers(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, "events WHERE type = '"+EventOfferExpired+"'"); n != 0 {
+		t.Fatalf("%d offers expired before their validity", n)
+	}
+	// the validity passes
+	execTestDB(t, "UPDATE offers SET valid_until = ? WHERE id = ?", past.UTC().Format(time.RFC3339), offerID)
+	execTestDB(t, "UPDATE offer_revisions SET terms = replace(terms, ?, ?) WHERE offer_id = ?",
+		validUntil.UTC().Format(time.RFC3339), past.UTC().Format(time.RFC3339), offerID)
+	for i := 0; i < 2; i++ {
+		if err := expireOffers(time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countRows(t, "events WHERE type = '"+EventOfferExpired+"'"); n != 1 {
+		t.Errorf("offer expired %d times, want once", n)
+	}
+
+	expectStatus(t, "acceptance of the expired offer", investor.accept(contractID, offerID), http.StatusConflict)
+	expectStatus(t, "counter-offer to the expired offer", investor.revise(offerID, 1, 90000), http.StatusConflict)
+
+	terms, encoded := offerTerms(validUntil)
+	renewal := map[string]interface{}{"Previous": 1, "Amount": terms.Amount, "MustBeDone": terms.MustBeDone,
+		"ValidUntil": terms.ValidUntil, "Signature": supplier.sign(encoded)}
+	revisions := "/offers/" + strconv.FormatInt(offerID, 10) + "/revisions"
+	expectStatus(t, "renewal by another supplier", api.user(UserSupplier, 8).do(http.MethodPost, "/suppliers"+revisions, renewal), http.StatusNotFound)
+	created(t, supplier.do(http.MethodPost, "/suppliers"+revisions, renewal))
+	expectStatus(t, "acceptance of the renewed offer", investor.accept(contractID, offerID), http.StatusOK)
+}
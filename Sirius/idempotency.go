@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/labstack/echo"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Headers of the idempotent requests
+const (
+	HeaderIdempotencyKey     = "Idempotency-Key"
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+)
+
+// maxIdempotencyKey is the longest key accepted
+const maxIdempotencyKey = 255
+
+// idempotencyLease is how long a key stays reserved for a request without a response, a reservation
+// older than that is left by a server which stopped while serving the request and is released
+const idempotencyLease = time.Minute
+
+// responseRecorder keeps a copy of the response written by the handler
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+// Write implements http.ResponseWriter
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// requestFingerprint returns the hash of the method, the path and the body of the request,
+// a key may only be used again for the same request
+func requestFingerprint(method, path string, body []byte) string {
+	hash := sha256.New()
+	io.WriteString(hash, method+" "+path+"\n")
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// contextUser returns the user of the context made by InvestorAuthMiddleware or SupplierAuthMiddleware
+func contextUser(c echo.Context) (string, int64, bool) {
+	switch uc := c.(type) {
+	case InvestorContext:
+		return UserInvestor, uc.InvestorID.Int64, true
+	case SupplierContext:
+		return UserSupplier, uc.SupplierID.Int64, true
+	}
+	return "", 0, false
+}
+
+// IdempotencyMiddleware makes the requests with an Idempotency-Key header safe to retry, it follows
+// InvestorAuthMiddleware or SupplierAuthMiddleware as the keys are per user. The key is reserved before
+// the handler runs, so of concurrent duplicates only the first one is served and the others get 409.
+// A reservation is released after idempotencyLease if the server stopped before the response.
+// The response is kept for idempotency_ttl and returned to the later requests with the key, unless it
+// is a 429 or a server error, those release the key for the retry. A key used with another request is
+// rejected with 409.
+func IdempotencyMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := c.Request().Header.Get(HeaderIdempotencyKey)
+		if key == "" {
+			return next(c)
+		}
+		if len(key) > maxIdempotencyKey {
+			return c.String(http.StatusBadRequest, "Idempotency-Key is longer than 255 characters")
+		}
+		kind, userID, ok := contextUser(c)
+		if !ok {
+			return next(c)
+		}
+
+		body, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.String(http.StatusBadRequest, "Bad Request")
+		}
+		c.Request().Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := requestFingerprint(c.Request().Method, c.Request().URL.Path, body)
+
+		db, err := openDB(c.Request().Context())
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+
+		now := time.Now().UTC()
+		created := now.Format(time.RFC3339)
+		dlb := sqlbuilder.NewDeleteBuilder()
+		dlb.DeleteFrom("idempotency_keys")
+		dlb.Where(dlb.Or(
+			dlb.LessEqualThan("expires", created),
+			dlb.And(dlb.IsNull("status"), dlb.LessEqualThan("created", now.Add(-idempotencyLease).Format(time.RFC3339))),
+		))
+		q, args := dlb.Build()
+		if _, err := db.Exec(q, args...); isBusy(err) {
+			return busyConflict(c)
+		} else if err != nil {
+			log.Fatal(err)
+		}
+
+		ib := sqlbuilder.NewInsertBuilder()
+		ib.InsertInto("idempotency_keys")
+		ib.Cols("user_kind", "user_id", "key", "fingerprint", "created", "expires")
+		ib.Values(kind, userID, key, fingerprint, created, now.Add(currentConfig().IdempotencyTTL).Format(time.RFC3339))
+		q, args = ib.Build()
+		_, err = db.Exec(q, args...)
+		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+			return replayResponse(c, db, kind, userID, key, fingerprint)
+		} else if isBusy(err) {
+			return busyConflict(c)
+		} else if err != nil {
+			log.Fatal(err)
+		}
+
+		// the reservation is changed below only while it is ours, it may be released after the lease
+		reserved := func(cond *sqlbuilder.Cond) []string {
+			return []string{cond.Equal("user_kind", kind), cond.Equal("user_id", userID), cond.Equal("key", key),
+				cond.Equal("created", created), cond.IsNull("status")}
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Response().Writer}
+		c.Response().Writer = recorder
+		err = next(c)
+		c.Response().Writer = recorder.ResponseWriter
+
+		status := c.Response().Status
+		if err != nil || !c.Response().Committed || status == http.StatusTooManyRequests || status >= 500 {
+			dlb := sqlbuilder.NewDeleteBuilder()
+			dlb.DeleteFrom("idempotency_keys")
+			dlb.Where(reserved(&dlb.Cond)...)
+			q, args := dlb.Build()
+			if _, err := db.Exec(q, args...); err != nil {
+				log.Fatal(err)
+			}
+			return err
+		}
+
+		ub := sqlbuilder.NewUpdateBuilder()
+		ub.Update("idempotency_keys")
+		ub.Set(
+			ub.Assign("status", status),
+			ub.Assign("content_type", c.Response().Header().Get(echo.HeaderContentType)),
+			ub.Assign("body", recorder.body.Bytes()),
+		)
+		ub.Where(reserved(&ub.Cond)...)
+		q, args = ub.Build()
+		if _, err := db.Exec(q, args...); err != nil {
+			log.Fatal(err)
+		}
+		return nil
+	}
+}
+
+// replayResponse answers the request whose key is already used with the kept response
+func replayResponse(c echo.Context, db *sql.DB, kind string, userID int64, key, fingerprint string) error {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("fingerprint", "status", "content_type", "body")
+	sb.From("idempotency_keys")
+	sb.Where(sb.Equal("user_kind", kind), sb.Equal("user_id", userID), sb.Equal("key", key))
+	q, args := sb.Build()
+	var storedFingerprint string
+	var status sql.NullInt64
+	var contentType sql.NullString
+	var body []byte
+	err := db.QueryRow(q, args...).Scan(&storedFingerprint, &status, &contentType, &body)
+	if err == sql.ErrNoRows {
+		// the request holding the key failed in the meantime and released it
+		return c.String(http.StatusConflict, "Request with this Idempotency-Key is in progress, retry it")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	if storedFingerprint != fingerprint {
+		return c.String(http.StatusConflict, "Idempotency-Key was used with a different request")
+	}
+	if !status.Valid {
+		return c.String(http.StatusConflict, "Request with this Idempotency-Key is in progress, retry it")
+	}
+	c.Response().Header().Set(HeaderIdempotentReplayed, "true")
+	return c.Blob(int(status.Int64), contentType.String, body)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+const idempotentContract = `{"Title":"Bolts","Amount":1000,"Currency":"EUR","MustBeDone":"2030-01-01T00:00:00Z"}`
+
+// newIdempotentServer serves CreateContract to investor 1 behind IdempotencyMiddleware
+func newIdempotentServer() *echo.Echo {
+	e := echo.New()
+	asInvestor := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			return next(InvestorContext{c, sql.NullInt64{Int64: 1, Valid: true}})
+		}
+	}
+	e.POST("/contracts", CreateContract, asInvestor, IdempotencyMiddleware)
+	return e
+}
+
+func postIdempotent(e *echo.Echo, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/contracts", strings.NewReader(idempotentContract))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(HeaderIdempotencyKey, key)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func countRows(t *testing.T, table string) int {
+	t.Helper()
+	db, err := sql.Open(dbDriver, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestIdempotencyConcurrentDuplicates(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t, func(cfg *Config) { cfg.DailyContracts = 0 })
+	e := newIdempotentServer()
+
+	const duplicates = 10
+	responses := make([]*httptest.ResponseRecorder, duplicates)
+	var wg sync.WaitGroup
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = postIdempotent(e, "create-bolts")
+		}(i)
+	}
+	wg.Wait()
+
+	var created *httptest.ResponseRecorder
+	for _, rec := range responses {
+		switch {
+		case rec.Code == http.StatusConflict:
+		case rec.Code == http.StatusCreated && rec.Header().Get(HeaderIdempotentReplayed) == "":
+			if created != nil {
+				t.Fatal("duplicate request is served twice")
+			}
+			created = rec
+		case rec.Code != http.StatusCreated:
+			t.Fatalf("duplicate request: %d %s", rec.Code, rec.Body.String())
+		}
+	}
+	if created == nil {
+		t.Fatal("none of the duplicates is served")
+	}
+	for _, rec := range responses {
+		if rec.Code == http.StatusCreated && rec.Body.String() != created.Body.String() {
+			t.Errorf("replayed %s, want %s", rec.Body.String(), created.Body.String())
+		}
+	}
+
+	rec := postIdempotent(e, "create-bolts")
+	if rec.Code != http.StatusCreated || rec.Header().Get(HeaderIdempotentReplayed) != "true" || rec.Body.String() != created.Body.String() {
+		t.Errorf("retry: %d %s, want the replayed %s", rec.Code, rec.Body.String(), created.Body.String())
+	}
+	if n := countRows(t, "contracts"); n != 1 {
+		t.Errorf("%d contracts created by the duplicates, want 1", n)
+	}
+}
+
+func TestIdempotencyReleasesStaleReservation(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t, func(cfg *Config) { cfg.DailyContracts = 0 })
+	e := newIdempotentServer()
+
+	db, err := sql.Open(dbDriver, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	reserve := func(created time.Time) {
+		_, err := db.Exec("INSERT INTO idempotency_keys (user_kind, user_id, key, fingerprint, created, expires) VALUES (?, ?, ?, ?, ?, ?)",
+			UserInvestor, 1, "create-bolts", requestFingerprint(http.MethodPost, "/contracts", []byte(idempotentContract)),
+			created.Format(time.RFC3339), created.Add(time.Hour).Format(time.RFC3339))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reserve(time.Now().UTC())
+	if rec := postIdempotent(e, "create-bolts"); rec.Code != http.StatusConflict {
+		t.Fatalf("request reserved within the lease: %d %s, want 409", rec.Code, rec.Body.String())
+	}
+	if _, err := db.Exec("DELETE FROM idempotency_keys"); err != nil {
+		t.Fatal(err)
+	}
+
+	reserve(time.Now().UTC().Add(-2 * idempotencyLease))
+	if rec := postIdempotent(e, "create-bolts"); rec.Code != http.StatusCreated || rec.Header().Get(HeaderIdempotentReplayed) != "" {
+		t.Fatalf("request reserved by a stopped server: %d %s, want it served", rec.Code, rec.Body.String())
+	}
+	if rec := postIdempotent(e, "create-bolts"); rec.Header().Get(HeaderIdempotentReplayed) != "true" {
+		t.Errorf("retry: %d %s, want it replayed", rec.Code, rec.Body.String())
+	}
+	if n := countRows(t, "contracts"); n != 1 {
+		t.Errorf("%d contracts created, want 1", n)
+	}
+}
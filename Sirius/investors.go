@@ -0,0 +1,272 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/labstack/echo"
+)
+
+// ContractInvestor is an investor funding Share of a co-funded contract, the investor who created the
+// contract is one of them. Signature is made over the encoded terms of Revision of OfferID, the contract
+// is concluded when every investor signed the same revision.
+type ContractInvestor struct {
+	InvestorID int64
+	Share      int64
+	OfferID    sql.NullInt64
+	Revision   sql.NullInt64
+	Signature  sql.NullString
+	Signed     sql.NullString
+}
+
+// InvestorShare is a part of the amount committed by a co-investor
+type InvestorShare struct {
+	InvestorID int64
+	Share      int64
+}
+
+// CoFundingQuery - co-investors of a contract, the creator funds the rest of the amount.
+// An empty list makes the contract funded by the creator alone.
+type CoFundingQuery struct {
+	CoInvestors []InvestorShare
+}
+
+// Errors of acceptOffer for co-funded contracts
+var (
+	errUnderfunded       = errors.New("Shares of the investors do not cover the amount")
+	errSignaturesPending = errors.New("Waiting for signatures of the co-investors")
+)
+
+var contractInvestorColumns = []string{"investor_id", "share", "offer_id", "revision", "signature", "signed"}
+
+// fundingShares returns the shares of all the investors of the contract, the creator's share is the rest
+// of the amount. It returns nil when there are no co-investors.
+func fundingShares(creatorID, amount int64, coInvestors []InvestorShare) ([]InvestorShare, error) {
+	if len(coInvestors) == 0 {
+		return nil, nil
+	}
+	rest := amount
+	seen := map[int64]bool{creatorID: true}
+	for _, s := range coInvestors {
+		if s.InvestorID <= 0 || s.Share <= 0 {
+			return nil, errors.New("co-investor must have ID and positive share")
+		}
+		if seen[s.InvestorID] {
+			return nil, errors.New("co-investor is listed twice")
+		}
+		seen[s.InvestorID] = true
+		rest -= s.Share
+	}
+	if rest < 0 {
+		return nil, errors.New("shares exceed the amount")
+	}
+	return append([]InvestorShare{{creatorID, rest}}, coInvestors...), nil
+}
+
+// insertContractInvestors stores the shares of the contract investors
+func insertContractInvestors(tx dbExecutor, contractID int64, shares []InvestorShare) error {
+	for _, s := range shares {
+		ib := sqlbuilder.NewInsertBuilder()
+		ib.InsertInto("contract_investors")
+		ib.Cols("contract_id", "investor_id", "share")
+		ib.Values(contractID, s.InvestorID, s.Share)
+		q, args := ib.Build()
+		if _, err := tx.Exec(q, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// contractInvestors returns the investors of a co-funded contract, nil for a contract funded by its creator
+func contractInvestors(db dbExecutor, contractID int64) ([]ContractInvestor, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select(contractInvestorColumns...)
+	sb.From("contract_investors")
+	sb.Where(sb.Equal("contract_id", contractID))
+	sb.OrderBy("investor_id")
+	q, args := sb.Build()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var investors []ContractInvestor
+	for rows.Next() {
+		ci := ContractInvestor{}
+		if err := rows.Scan(&ci.InvestorID, &ci.Share, &ci.OfferID, &ci.Revision, &ci.Signature, &ci.Signed); err != nil {
+			return nil, err
+		}
+		investors = append(investors, ci)
+	}
+	return investors, rows.Err()
+}
+
+// ownedByInvestor is the condition selecting the contracts created or co-funded by the investor,
+// prefix qualifies the columns of contracts
+func ownedByInvestor(cond *sqlbuilder.Cond, prefix string, investorID interface{}) string {
+	return cond.Or(cond.Equal(prefix+"investor_id", investorID),
+		prefix+"id IN (SELECT contract_id FROM contract_investors WHERE investor_id = "+cond.Var(investorID)+")")
+}
+
+// isContractInvestor reports whether the investor created or co-funds the contract
+func isContractInvestor(db dbExecutor, contractID, creatorID, investorID int64) (bool, error) {
+	if creatorID == investorID {
+		return true, nil
+	}
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("COUNT(*)")
+	sb.From("contract_investors")
+	sb.Where(sb.Equal("contract_id", contractID), sb.Equal("investor_id", investorID))
+	q, args := sb.Build()
+	var n int64
+	err := db.QueryRow(q, args...).Scan(&n)
+	return n > 0, err
+}
+
+// withCoInvestors adds the investors of the contract to the recipients of its event
+func withCoInvestors(tx dbExecutor, contractID int64, recipients []EventRecipient) ([]EventRecipient, error) {
+	investors, err := contractInvestors(tx, contractID)
+	if err != nil {
+		return nil, err
+	}
+	for _, ci := range investors {
+		known := false
+		for _, r := range recipients {
+			if r.Kind == UserInvestor && r.ID == ci.InvestorID {
+				known = true
+				break
+			}
+		}
+		if !known {
+			recipients = append(recipients, EventRecipient{UserInvestor, ci.InvestorID})
+		}
+	}
+	return recipients, nil
+}
+
+// signContract records the investor's signature of the revision and returns the number of the investors
+// of the co-funded contract who have signed it
+func signContract(tx dbExecutor, contractID, investorID int64, r *OfferRevision, signature string) (int, error) {
+	ub := sqlbuilder.NewUpdateBuilder()
+	ub.Update("contract_investors")
+	ub.Set(ub.Assign("offer_id", r.OfferID), ub.Assign("revision", r.Revision), ub.Assign("signature", signature),
+		ub.Assign("signed", time.Now().Format(time.RFC3339)))
+	ub.Where(ub.Equal("contract_id", contractID), ub.Equal("investor_id", investorID))
+	q, args := ub.Build()
+	res, err := tx.Exec(q, args...)
+	if err != nil {
+		return 0, err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return 0, err
+	} else if affected != 1 {
+		return 0, errContractConcluded
+	}
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("COUNT(*)")
+	sb.From("contract_investors")
+	sb.Where(sb.Equal("contract_id", contractID), sb.Equal("offer_id", r.OfferID), sb.Equal("revision", r.Revision))
+	q, args = sb.Build()
+	var signed int
+	err = tx.QueryRow(q, args...).Scan(&signed)
+	return signed, err
+}
+
+// SetContractInvestors - api controller for changing the co-investors of an open contract, it is
+// allowed to the creator of the contract only and discards the signatures made so far
+func SetContractInvestors(c echo.Context) error {
+	ic := c.(InvestorContext)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	fundingQuery := new(CoFundingQuery)
+	if err := c.Bind(fundingQuery); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("stage", "amount")
+	sb.From("contracts")
+	sb.Where(sb.Equal("id", id), sb.Equal("investor_id", ic.InvestorID), sb.IsNull("deleted_at"), sb.IsNull("archived_at"))
+	q, args := sb.Build()
+
+	var stage, amount int64
+	err = db.QueryRow(q, args...).Scan(&stage, &amount)
+	if err == sql.ErrNoRows {
+		return c.String(http.StatusNotFound, "Contract not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if stage != 0 {
+		return c.String(http.StatusConflict, "Contract is already concluded")
+	}
+	shares, err := fundingShares(ic.InvestorID.Int64, amount, fundingQuery.CoInvestors)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	tx, err := db.Begin()
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	// the investors who are removed are notified as well
+	previous, err := contractInvestors(tx, id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	recipients := investorAndSupplier(ic.InvestorID.Int64, 0)
+	for _, ci := range previous {
+		if ci.InvestorID != ic.InvestorID.Int64 {
+			recipients = append(recipients, EventRecipient{UserInvestor, ci.InvestorID})
+		}
+	}
+
+	del := sqlbuilder.NewDeleteBuilder()
+	del.DeleteFrom("contract_investors")
+	del.Where(del.Equal("contract_id", id))
+	q, args = del.Build()
+	if _, err := tx.Exec(q, args...); err != nil {
+		log.Fatal(err)
+	}
+	if err := insertContractInvestors(tx, id, shares); err != nil {
+		log.Fatal(err)
+	}
+
+	err = EmitEvent(tx, Event{
+		Type:       EventContractInvestorsChanged,
+		ContractID: id,
+		InvestorID: ic.InvestorID.Int64,
+		Data:       struct{ Investors []InvestorShare }{shares},
+		Recipients: recipients,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := tx.Commit(); isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	eventsCommitted()
+	return c.String(http.StatusOK, "")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestCoFundedContract(t *testing.T) {
+	api := newTestAPI(t)
+	usePaymentProvider(t, &FakePaymentProvider{})
+	creator, coInvestor, outsider := api.user(UserInvestor, 1), api.user(UserInvestor, 2), api.user(UserInvestor, 3)
+	supplier := api.user(UserSupplier, 7)
+	created(t, creator.deposit(60000))
+	created(t, coInvestor.deposit(40000))
+	contractID := creator.createContract(nil)
+	path := "/contracts/" + strconv.FormatInt(contractID, 10)
+
+	coFunding := CoFundingQuery{CoInvestors: []InvestorShare{{InvestorID: 2, Share: 40000}}}
+	for _, bad := range [][]InvestorShare{
+		{{InvestorID: 2, Share: 100001}},
+		{{InvestorID: 2, Share: 1}, {InvestorID: 2, Share: 1}},
+		{{InvestorID: 1, Share: 1}},
+		{{InvestorID: 2, Share: 0}},
+	} {
+		expectStatus(t, fmt.Sprintf("co-investors %v", bad), creator.do(http.MethodPut, path+"/investors", CoFundingQuery{bad}), http.StatusBadRequest)
+	}
+	expectStatus(t, "co-investors set by another investor", outsider.do(http.MethodPut, path+"/investors", coFunding), http.StatusNotFound)
+	expectStatus(t, "co-investors", creator.do(http.MethodPut, path+"/investors", coFunding), http.StatusOK)
+	expectStatus(t, "co-investors set by a co-investor", coInvestor.do(http.MethodPut, path+"/investors", coFunding), http.StatusNotFound)
+
+	rec := api.do(nil, http.MethodGet, path, nil)
+	var contract Contract
+	if err := json.Unmarshal(rec.Body.Bytes(), &contract); err != nil {
+		t.Fatalf("contract: %d %s", rec.Code, rec.Body.String())
+	}
+	if len(contract.Investors) != 2 || contract.Investors[0].Share != 60000 || contract.Investors[1].Share != 40000 {
+		t.Errorf("investors %+v, want the creator's 60000 and the co-investor's 40000", contract.Investors)
+	}
+
+	// the contract is concluded once every investor signed the offer
+	offerID := supplier.createOffer(contractID)
+	expectStatus(t, "encoded offer for another investor", outsider.do(http.MethodGet, "/investors/offers/"+strconv.FormatInt(offerID, 10)+"/encoded", nil), http.StatusNotFound)
+	expectStatus(t, "acceptance by another investor", outsider.do(http.MethodPatch, path, OfferAcceptionQuery{OfferID: offerID, InvestorSignature: "c2ln"}), http.StatusNotFound)
+	expectStatus(t, "acceptance by the creator", creator.accept(contractID, offerID), http.StatusAccepted)
+	expectStatus(t, "acceptance by the co-investor", coInvestor.accept(contractID, offerID), http.StatusOK)
+	if escrow := ledgerBalance(t, AccountEscrow, contractID, "EUR"); escrow != 100000 {
+		t.Errorf("escrow %d, want 100000", escrow)
+	}
+	expectStatus(t, "co-investors of the concluded contract", creator.do(http.MethodPut, path+"/investors", CoFundingQuery{}), http.StatusConflict)
+	checkLedgerBalanced(t)
+}
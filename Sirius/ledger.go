@@ -0,0 +1,761 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/labstack/echo"
+)
+
+// Kinds of ledger accounts besides the accounts of investors and suppliers. Every contract has
+// an escrow account, the provider account is debited by the deposits collected by the payment provider.
+const (
+	AccountEscrow   = "escrow"
+	AccountProvider = "provider"
+)
+
+// Types of ledger transactions
+const (
+	LedgerDeposit = "deposit"
+	LedgerFund    = "fund"
+	LedgerRelease = "release"
+	LedgerRefund  = "refund"
+)
+
+// Errors of the ledger
+var (
+	errInsufficientFunds = errors.New("Insufficient funds")
+	errMilestoneAccepted = errors.New("Milestone is already accepted")
+	errDepositPending    = errors.New("Deposit is pending, it is credited once the payment is settled")
+)
+
+// LedgerAccount holds money in a currency, OwnerID is the user of the account or the contract of
+// the escrow account. Balance is the credits less the debits of the account.
+type LedgerAccount struct {
+	ID       int64
+	Kind     string
+	OwnerID  int64
+	Currency string
+	Balance  int64
+	Created  string
+}
+
+// LedgerEntry is a posting to an account with its transaction, Balance is the balance of the
+// account after the posting
+type LedgerEntry struct {
+	ID            int64
+	TransactionID int64
+	Type          string
+	ContractID    sql.NullInt64
+	Milestone     sql.NullInt64
+	Reference     sql.NullString
+	Debit         int64
+	Credit        int64
+	Balance       int64
+	Created       string
+}
+
+// LedgerCheck is the result of the invariant checks, the ledger is consistent when Debits equal
+// Credits and the lists are empty
+type LedgerCheck struct {
+	Debits  int64
+	Credits int64
+	// Unbalanced are the transactions whose debits differ from their credits
+	Unbalanced []int64
+	// Overdrawn are the accounts of users and contracts with negative balance
+	Overdrawn []int64
+}
+
+// DepositQuery - money charged by the payment provider and credited to the investor's account
+type DepositQuery struct {
+	Money
+}
+
+// posting is a debit or a credit of an account in a transaction
+type posting struct {
+	AccountID int64
+	Debit     int64
+	Credit    int64
+}
+
+// ledgerAccount returns the account, it is opened on the first use
+func ledgerAccount(tx dbExecutor, kind string, ownerID int64, currency string) (int64, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("id")
+	sb.From("ledger_accounts")
+	sb.Where(sb.Equal("kind", kind), sb.Equal("owner_id", ownerID), sb.Equal("currency", currency))
+	q, args := sb.Build()
+	var id int64
+	err := tx.QueryRow(q, args...).Scan(&id)
+	if err != sql.ErrNoRows {
+		return id, err
+	}
+
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto("ledger_accounts")
+	ib.Cols("kind", "owner_id", "currency", "created")
+	ib.Values(kind, ownerID, currency, time.Now().Format(time.RFC3339))
+	q, args = ib.Build()
+	res, err := tx.Exec(q, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func accountBalance(tx dbExecutor, accountID int64) (int64, error) {
+	var balance int64
+	err := tx.QueryRow("SELECT COALESCE(SUM(credit - debit), 0) FROM ledger_postings WHERE account_id = ?", accountID).Scan(&balance)
+	return balance, err
+}
+
+// postTransaction records the postings of a transaction, their debits must equal their credits.
+// Postings are never changed afterwards, a mistake is corrected by another transaction.
+func postTransaction(tx dbExecutor, typ string, contractID, milestone int64, reference string, postings []posting) (int64, error) {
+	var debits, credits int64
+	for _, p := range postings {
+		if p.Debit < 0 || p.Credit < 0 || (p.Debit == 0) == (p.Credit == 0) {
+			return 0, errors.New("ledger: posting must be either debit or credit")
+		}
+		debits += p.Debit
+		credits += p.Credit
+	}
+	if debits != credits {
+		return 0, errors.New("ledger: debits do not equal credits")
+	}
+
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto("ledger_transactions")
+	ib.Cols("type", "contract_id", "milestone", "reference", "created")
+	ib.Values(typ, sql.NullInt64{Int64: contractID, Valid: contractID != 0}, sql.NullInt64{Int64: milestone, Valid: milestone != 0},
+		sql.NullString{String: reference, Valid: reference != ""}, time.Now().Format(time.RFC3339))
+	q, args := ib.Build()
+	res, err := tx.Exec(q, args...)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range postings {
+		ib := sqlbuilder.NewInsertBuilder()
+		ib.InsertInto("ledger_postings")
+		ib.Cols("transaction_id", "account_id", "debit", "credit")
+		ib.Values(id, p.AccountID, p.Debit, p.Credit)
+		q, args := ib.Build()
+		if _, err := tx.Exec(q, args...); err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+// splitByShares divides the amount between the investors in proportion to their shares,
+// the rounding remainder goes to the first one
+func splitByShares(amount int64, shares []InvestorShare) []InvestorShare {
+	var total int64
+	for _, s := range shares {
+		total += s.Share
+	}
+	parts := make([]InvestorShare, len(shares))
+	rest := amount
+	for i, s := range shares {
+		part := new(big.Int).Mul(big.NewInt(amount), big.NewInt(s.Share))
+		parts[i] = InvestorShare{s.InvestorID, part.Quo(part, big.NewInt(total)).Int64()}
+		rest -= parts[i].Share
+	}
+	if len(parts) > 0 {
+		parts[0].Share += rest
+	}
+	return parts
+}
+
+// fundEscrow moves the amount of the concluded contract from the accounts of its investors to its
+// escrow account, co-investors fund it in proportion to their shares. Contracts made before
+// currencies were introduced are not escrowed.
+func fundEscrow(tx dbExecutor, contractID int64, amount Money, funders []InvestorShare) error {
+	if amount.Currency == "" {
+		return nil
+	}
+	escrow, err := ledgerAccount(tx, AccountEscrow, contractID, amount.Currency)
+	if err != nil {
+		return err
+	}
+	postings := []posting{{AccountID: escrow, Credit: amount.Amount}}
+	for _, f := range splitByShares(amount.Amount, funders) {
+		if f.Share == 0 {
+			continue
+		}
+		account, err := ledgerAccount(tx, UserInvestor, f.InvestorID, amount.Currency)
+		if err != nil {
+			return err
+		}
+		balance, err := accountBalance(tx, account)
+		if err != nil {
+			return err
+		}
+		if balance < f.Share {
+			return errInsufficientFunds
+		}
+		postings = append(postings, posting{AccountID: account, Debit: f.Share})
+	}
+	_, err = postTransaction(tx, LedgerFund, contractID, 0, "", postings)
+	return err
+}
+
+// releaseMilestone moves the payment of the accepted milestone from the escrow to the supplier
+func releaseMilestone(tx dbExecutor, contractID, milestone, supplierID int64, amount Money) (int64, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("COUNT(*)")
+	sb.From("ledger_transactions")
+	sb.Where(sb.Equal("type", LedgerRelease), sb.Equal("contract_id", contractID), sb.Equal("milestone", milestone))
+	q, args := sb.Build()
+	var released int64
+	if err := tx.QueryRow(q, args...).Scan(&released); err != nil {
+		return 0, err
+	}
+	if released > 0 {
+		return 0, errMilestoneAccepted
+	}
+
+	escrow, err := ledgerAccount(tx, AccountEscrow, contractID, amount.Currency)
+	if err != nil {
+		return 0, err
+	}
+	balance, err := accountBalance(tx, escrow)
+	if err != nil {
+		return 0, err
+	}
+	if balance < amount.Amount {
+		return 0, errInsufficientFunds
+	}
+	account, err := ledgerAccount(tx, UserSupplier, supplierID, amount.Currency)
+	if err != nil {
+		return 0, err
+	}
+	return postTransaction(tx, LedgerRelease, contractID, milestone, "", []posting{
+		{AccountID: escrow, Debit: amount.Amount},
+		{AccountID: account, Credit: amount.Amount},
+	})
+}
+
+// refundEscrow returns the money left in the escrow of the cancelled contract to its investors,
+// in proportion to what they have funded
+func refundEscrow(tx dbExecutor, contractID int64) error {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("id", "currency")
+	sb.From("ledger_accounts")
+	sb.Where(sb.Equal("kind", AccountEscrow), sb.Equal("owner_id", contractID))
+	q, args := sb.Build()
+	rows, err := tx.Query(q, args...)
+	if err != nil {
+		return err
+	}
+	var escrows []LedgerAccount
+	for rows.Next() {
+		a := LedgerAccount{}
+		if err := rows.Scan(&a.ID, &a.Currency); err != nil {
+			rows.Close()
+			return err
+		}
+		escrows = append(escrows, a)
+	}
+	rows.Close()
+
+	for _, escrow := range escrows {
+		balance, err := accountBalance(tx, escrow.ID)
+		if err != nil {
+			return err
+		}
+		if balance <= 0 {
+			continue
+		}
+		funders, err := escrowFunders(tx, contractID, escrow.Currency)
+		if err != nil {
+			return err
+		}
+		postings := []posting{{AccountID: escrow.ID, Debit: balance}}
+		for _, f := range splitByShares(balance, funders) {
+			if f.Share == 0 {
+				continue
+			}
+			account, err := ledgerAccount(tx, UserInvestor, f.InvestorID, escrow.Currency)
+			if err != nil {
+				return err
+			}
+			postings = append(postings, posting{AccountID: account, Credit: f.Share})
+		}
+		if _, err := postTransaction(tx, LedgerRefund, contractID, 0, "", postings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// escrowFunders returns how much each investor has funded the escrow of the contract
+func escrowFunders(tx dbExecutor, contractID int64, currency string) ([]InvestorShare, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("ledger_accounts.owner_id", "SUM(ledger_postings.debit)")
+	sb.From("ledger_postings")
+	sb.Join("ledger_accounts", "ledger_accounts.id = ledger_postings.account_id")
+	sb.Join("ledger_transactions", "ledger_transactions.id = ledger_postings.transaction_id")
+	sb.Where(sb.Equal("ledger_transactions.type", LedgerFund), sb.Equal("ledger_transactions.contract_id", contractID),
+		sb.Equal("ledger_accounts.kind", UserInvestor), sb.Equal("ledger_accounts.currency", currency))
+	sb.GroupBy("ledger_accounts.owner_id")
+	sb.OrderBy("ledger_accounts.owner_id")
+	q, args := sb.Build()
+	rows, err := tx.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var funders []InvestorShare
+	for rows.Next() {
+		f := InvestorShare{}
+		if err := rows.Scan(&f.InvestorID, &f.Share); err != nil {
+			return nil, err
+		}
+		funders = append(funders, f)
+	}
+	return funders, rows.Err()
+}
+
+// checkLedger verifies that every transaction is balanced and no account but the provider's is overdrawn
+func checkLedger(db dbExecutor) (*LedgerCheck, error) {
+	check := &LedgerCheck{Unbalanced: []int64{}, Overdrawn: []int64{}}
+	err := db.QueryRow("SELECT COALESCE(SUM(debit), 0), COALESCE(SUM(credit), 0) FROM ledger_postings").Scan(&check.Debits, &check.Credits)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range []struct {
+		query string
+		ids   *[]int64
+	}{
+		{"SELECT transaction_id FROM ledger_postings GROUP BY transaction_id HAVING SUM(debit) <> SUM(credit)", &check.Unbalanced},
+		{"SELECT account_id FROM ledger_postings JOIN ledger_accounts ON ledger_accounts.id = account_id " +
+			"WHERE kind <> '" + AccountProvider + "' GROUP BY account_id HAVING SUM(credit - debit) < 0", &check.Overdrawn},
+	} {
+		rows, err := db.Query(c.query)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			*c.ids = append(*c.ids, id)
+		}
+		rows.Close()
+	}
+	return check, nil
+}
+
+// Statuses of the deposits
+const (
+	DepositPending  = "pending"
+	DepositCredited = "credited"
+	DepositDeclined = "declined"
+)
+
+// depositSettleDelay is how long a pending deposit is left to the request which made it,
+// after that RunDepositSettlement charges it again with the same key and credits it
+const depositSettleDelay = time.Minute
+
+// deposit is the charge of the investor's payment method credited to the investor's account
+type deposit struct {
+	ID         int64
+	InvestorID int64
+	Money
+}
+
+// chargeKey is the idempotency key of the charge, retries of the charge collect the money once
+func (d *deposit) chargeKey() string {
+	return "sirius-deposit-" + strconv.FormatInt(d.ID, 10)
+}
+
+// charge asks the payment provider to collect the deposit, a declined deposit is marked so
+func (d *deposit) charge(db *sql.DB) (string, error) {
+	reference, err := paymentProvider.Charge(d.chargeKey(), UserInvestor, d.InvestorID, d.Money)
+	if err != errPaymentDeclined {
+		return reference, err
+	}
+	ub := sqlbuilder.NewUpdateBuilder()
+	ub.Update("deposits")
+	ub.Set(ub.Assign("status", DepositDeclined))
+	ub.Where(ub.Equal("id", d.ID), ub.Equal("status", DepositPending))
+	q, args := ub.Build()
+	if _, err := db.Exec(q, args...); err != nil {
+		return "", err
+	}
+	return "", errPaymentDeclined
+}
+
+// credit posts the charged deposit to the investor's account and returns the ledger transaction,
+// a deposit is credited once
+func (d *deposit) credit(db *sql.DB, reference string) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	ub := sqlbuilder.NewUpdateBuilder()
+	ub.Update("deposits")
+	ub.Set(ub.Assign("status", DepositCredited), ub.Assign("reference", reference))
+	ub.Where(ub.Equal("id", d.ID), ub.Equal("status", DepositPending))
+	q, args := ub.Build()
+	res, err := tx.Exec(q, args...)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if affected == 0 {
+		// settled by RunDepositSettlement meanwhile
+		sb := sqlbuilder.NewSelectBuilder()
+		sb.Select("transaction_id")
+		sb.From("deposits")
+		sb.Where(sb.Equal("id", d.ID))
+		q, args := sb.Build()
+		var id sql.NullInt64
+		err := tx.QueryRow(q, args...).Scan(&id)
+		return id.Int64, err
+	}
+
+	provider, err := ledgerAccount(tx, AccountProvider, 0, d.Currency)
+	if err != nil {
+		return 0, err
+	}
+	account, err := ledgerAccount(tx, UserInvestor, d.InvestorID, d.Currency)
+	if err != nil {
+		return 0, err
+	}
+	id, err := postTransaction(tx, LedgerDeposit, 0, 0, reference, []posting{
+		{AccountID: provider, Debit: d.Amount},
+		{AccountID: account, Credit: d.Amount},
+	})
+	if err != nil {
+		return 0, err
+	}
+	ub = sqlbuilder.NewUpdateBuilder()
+	ub.Update("deposits")
+	ub.Set(ub.Assign("transaction_id", id))
+	ub.Where(ub.Equal("id", d.ID))
+	q, args = ub.Build()
+	if _, err := tx.Exec(q, args...); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// Deposit - api controller for charging the investor with the payment provider, the money is
+// credited to the investor's account in the currency. The deposit is recorded before the charge,
+// a deposit whose credit is interrupted is settled by RunDepositSettlement.
+func Deposit(c echo.Context) error {
+	ic := c.(InvestorContext)
+	depositQuery := new(DepositQuery)
+	if err := c.Bind(depositQuery); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	if err := depositQuery.Money.validate(); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	d := &deposit{InvestorID: ic.InvestorID.Int64, Money: depositQuery.Money}
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto("deposits")
+	ib.Cols("investor_id", "amount", "currency", "status", "created")
+	ib.Values(d.InvestorID, d.Amount, d.Currency, DepositPending, time.Now().UTC().Format(time.RFC3339))
+	q, args := ib.Build()
+	res, err := db.Exec(q, args...)
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if d.ID, err = res.LastInsertId(); err != nil {
+		log.Fatal(err)
+	}
+
+	// the deposit is left pending for RunDepositSettlement when the provider or the database fails
+	reference, err := d.charge(db)
+	if err == errPaymentDeclined {
+		return c.String(http.StatusPaymentRequired, err.Error())
+	} else if err != nil {
+		log.Print("deposit: ", err)
+		return c.String(http.StatusAccepted, errDepositPending.Error())
+	}
+	id, err := d.credit(db, reference)
+	if isBusy(err) {
+		return c.String(http.StatusAccepted, errDepositPending.Error())
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	return c.JSON(http.StatusCreated, CreatedResponse{ID: id})
+}
+
+// RunDepositSettlement periodically charges again the deposits left pending by interrupted requests
+// and credits them, the provider collects the money of a deposit once
+func RunDepositSettlement() {
+	for {
+		if err := settleDeposits(time.Now().Add(-depositSettleDelay)); err != nil {
+			log.Print("deposit settlement: ", err)
+		}
+		time.Sleep(depositSettleDelay)
+	}
+}
+
+// settleDeposits settles the deposits pending since before the time
+func settleDeposits(before time.Time) error {
+	db, err := openDB(context.Background())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("id", "investor_id", "amount", "currency")
+	sb.From("deposits")
+	sb.Where(sb.Equal("status", DepositPending), sb.LessThan("created", before.UTC().Format(time.RFC3339)))
+	sb.OrderBy("id")
+	q, args := sb.Build()
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return err
+	}
+	var deposits []*deposit
+	for rows.Next() {
+		d := &deposit{}
+		if err := rows.Scan(&d.ID, &d.InvestorID, &d.Amount, &d.Currency); err != nil {
+			rows.Close()
+			return err
+		}
+		deposits = append(deposits, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, d := range deposits {
+		reference, err := d.charge(db)
+		if err == errPaymentDeclined {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if _, err := d.credit(db, reference); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListLedgerAccounts - api controller for getting the balances of the user's accounts
+func ListLedgerAccounts(c echo.Context) error {
+	kind, userID := currentUser(c)
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("id", "kind", "owner_id", "currency", "created",
+		"(SELECT COALESCE(SUM(credit - debit), 0) FROM ledger_postings WHERE account_id = ledger_accounts.id)")
+	sb.From("ledger_accounts")
+	sb.Where(sb.Equal("kind", kind), sb.Equal("owner_id", userID))
+	sb.OrderBy("currency")
+	q, args := sb.Build()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	accounts := []LedgerAccount{}
+	for rows.Next() {
+		a := LedgerAccount{}
+		if err := rows.Scan(&a.ID, &a.Kind, &a.OwnerID, &a.Currency, &a.Created, &a.Balance); err != nil {
+			log.Fatal(err)
+		}
+		accounts = append(accounts, a)
+	}
+	return c.JSON(http.StatusOK, accounts)
+}
+
+// GetLedgerStatement - api controller for getting the postings to the user's account
+func GetLedgerStatement(c echo.Context) error {
+	kind, userID := currentUser(c)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("COUNT(*)")
+	sb.From("ledger_accounts")
+	sb.Where(sb.Equal("id", id), sb.Equal("kind", kind), sb.Equal("owner_id", userID))
+	q, args := sb.Build()
+	var owned int64
+	if err := db.QueryRow(q, args...).Scan(&owned); err != nil {
+		log.Fatal(err)
+	}
+	if owned == 0 {
+		return c.String(http.StatusNotFound, "Account not found")
+	}
+
+	sb = sqlbuilder.NewSelectBuilder()
+	sb.Select("id", "transaction_id", "type", "contract_id", "milestone", "reference", "debit", "credit", "balance", "created")
+	sb.From("ledger_statement")
+	sb.Where(sb.Equal("account_id", id))
+	if err := paginate(c, sb); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	q, args = sb.Build()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	entries := []LedgerEntry{}
+	for rows.Next() {
+		e := LedgerEntry{}
+		err := rows.Scan(&e.ID, &e.TransactionID, &e.Type, &e.ContractID, &e.Milestone, &e.Reference,
+			&e.Debit, &e.Credit, &e.Balance, &e.Created)
+		if err != nil {
+			log.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	return c.JSON(http.StatusOK, entries)
+}
+
+// AcceptMilestone - api controller for accepting a milestone of the concluded contract by its creator,
+// the payment of the milestone is released from the escrow to the supplier. Milestones are numbered
+// from 1, a contract without milestones is paid as its milestone 1.
+func AcceptMilestone(c echo.Context) error {
+	ic := c.(InvestorContext)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	n, err := strconv.ParseInt(c.Param("n"), 10, 64)
+	if err != nil || n < 1 {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select(contractColumns...)
+	sb.From("contracts")
+	sb.Where(sb.Equal("id", id), sb.Equal("investor_id", ic.InvestorID.Int64), sb.IsNull("archived_at"))
+	q, args := sb.Build()
+
+	contract := Contract{Investor: &Investor{}, Supplier: &Supplier{}}
+	err = scanContract(db.QueryRow(q, args...), &contract)
+	if err == sql.ErrNoRows {
+		return c.String(http.StatusNotFound, "Contract not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if contract.Stage == 0 {
+		return c.String(http.StatusConflict, "Contract is not concluded")
+	}
+	if contract.ContractBody.Currency == "" {
+		return c.String(http.StatusConflict, "Contract is not escrowed")
+	}
+	payment := contract.ContractBody.Money
+	milestones := contract.ContractBody.Milestones
+	if len(milestones) > 0 && n <= int64(len(milestones)) {
+		payment = milestones[n-1].Money
+	} else if len(milestones) > 0 || n != 1 {
+		return c.String(http.StatusNotFound, "Milestone not found")
+	}
+
+	tx, err := db.Begin()
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	supplierID := contract.Supplier.ID.Int64
+	transactionID, err := releaseMilestone(tx, id, n, supplierID, payment)
+	if err == errMilestoneAccepted || err == errInsufficientFunds {
+		return c.String(http.StatusConflict, err.Error())
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	err = EmitEvent(tx, Event{
+		Type:       EventMilestoneAccepted,
+		ContractID: id,
+		InvestorID: ic.InvestorID.Int64,
+		SupplierID: supplierID,
+		Data: struct {
+			Milestone int64
+			Released  Money
+		}{n, payment},
+		Recipients: investorAndSupplier(ic.InvestorID.Int64, supplierID),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := tx.Commit(); isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	eventsCommitted()
+	return c.JSON(http.StatusCreated, CreatedResponse{ID: transactionID})
+}
+
+// CheckLedger - api controller for verifying the invariants of the ledger
+func CheckLedger(c echo.Context) error {
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	check, err := checkLedger(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return c.JSON(http.StatusOK, check)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// usePaymentProvider backs the deposits of the test with the provider
+func usePaymentProvider(t *testing.T, provider PaymentProvider) {
+	old := paymentProvider
+	paymentProvider = provider
+	t.Cleanup(func() { paymentProvider = old })
+}
+
+// ledgerBalance returns the balance of the account, 0 when it is not opened
+func ledgerBalance(t *testing.T, kind string, ownerID int64, currency string) int64 {
+	t.Helper()
+	db, err := sql.Open(dbDriver, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var balance int64
+	err = db.QueryRow(`SELECT COALESCE(SUM(credit - debit), 0) FROM ledger_postings
+		JOIN ledger_accounts ON ledger_accounts.id = account_id WHERE kind = ? AND owner_id = ? AND currency = ?`,
+		kind, ownerID, currency).Scan(&balance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return balance
+}
+
+// checkLedgerBalanced checks that the postings of every currency sum to zero and checkLedger finds nothing
+func checkLedgerBalanced(t *testing.T) {
+	t.Helper()
+	db, err := sql.Open(dbDriver, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query(`SELECT currency, SUM(credit - debit) FROM ledger_postings
+		JOIN ledger_accounts ON ledger_accounts.id = account_id GROUP BY currency`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var currency string
+		var sum int64
+		if err := rows.Scan(&currency, &sum); err != nil {
+			t.Fatal(err)
+		}
+		if sum != 0 {
+			t.Errorf("postings in %s sum to %d", currency, sum)
+		}
+	}
+	check, err := checkLedger(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Debits != check.Credits || len(check.Unbalanced) != 0 || len(check.Overdrawn) != 0 {
+		t.Errorf("ledger check %+v", check)
+	}
+}
+
+func (u *testUser) deposit(amount int64) *httptest.ResponseRecorder {
+	u.api.t.Helper()
+	return u.do(http.MethodPost, "/investors/deposits", DepositQuery{Money{Amount: amount, Currency: "EUR"}})
+}
+
+// accept signs the latest revision of the offer and accepts it for the contract
+func (u *testUser) accept(contractID, offerID int64) *httptest.ResponseRecorder {
+	t := u.api.t
+	t.Helper()
+	rec := u.do(http.MethodGet, "/investors/offers/"+strconv.FormatInt(offerID, 10)+"/encoded", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("encoded offer: %d %s", rec.Code, rec.Body.String())
+	}
+	return u.do(http.MethodPatch, "/contracts/"+strconv.FormatInt(contractID, 10), OfferAcceptionQuery{
+		OfferID: offerID, InvestorSignature: u.sign(rec.Body.Bytes())})
+}
+
+func TestSplitByShares(t *testing.T) {
+	for _, test := range []struct {
+		amount int64
+		shares []int64
+		want   []int64
+	}{
+		{100, []int64{1, 1, 1}, []int64{34, 33, 33}},
+		{1000, []int64{300, 700}, []int64{300, 700}},
+		{999, []int64{300, 700}, []int64{300, 699}},
+		{1, []int64{1, 1}, []int64{1, 0}},
+		{0, []int64{5, 5}, []int64{0, 0}},
+		{math.MaxInt64, []int64{math.MaxInt64 / 2, math.MaxInt64 / 2}, []int64{math.MaxInt64/2 + 1, math.MaxInt64 / 2}},
+	} {
+		var shares []InvestorShare
+		for i, s := range test.shares {
+			shares = append(shares, InvestorShare{InvestorID: int64(i + 1), Share: s})
+		}
+		parts := splitByShares(test.amount, shares)
+		var sum int64
+		for i, p := range parts {
+			if p.InvestorID != int64(i+1) || p.Share != test.want[i] {
+				t.Errorf("%d split by %v: %v, want %v", test.amount, test.shares, parts, test.want)
+				break
+			}
+			sum += p.Share
+		}
+		if sum != test.amount {
+			t.Errorf("%d split by %v sums to %d", test.amount, test.shares, sum)
+		}
+	}
+}
+
+func TestDeposit(t *testing.T) {
+	api := newTestAPI(t)
+	provider := &FakePaymentProvider{Limit: 500000}
+	usePaymentProvider(t, provider)
+	investor := api.user(UserInvestor, 1)
+
+	created(t, investor.deposit(200000))
+	expectStatus(t, "deposit over the limit", investor.deposit(600000), http.StatusPaymentRequired)
+	expectStatus(t, "deposit of an unknown currency", investor.do(http.MethodPost, "/investors/deposits",
+		DepositQuery{Money{Amount: 100, Currency: "XXX"}}), http.StatusBadRequest)
+	if balance := ledgerBalance(t, UserInvestor, 1, "EUR"); balance != 200000 {
+		t.Errorf("balance %d, want 200000", balance)
+	}
+
+	// the request taking the payment was interrupted before the credit
+	old := time.Now().Add(-2 * depositSettleDelay).UTC().Format(time.RFC3339)
+	execTestDB(t, "INSERT INTO deposits (investor_id, amount, currency, status, created) VALUES (1, 30000, 'EUR', ?, ?)", DepositPending, old)
+	db, err := sql.Open(dbDriver, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var id int64
+	if err := db.QueryRow("SELECT MAX(id) FROM deposits").Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	pending := &deposit{ID: id, InvestorID: 1, Money: Money{Amount: 30000, Currency: "EUR"}}
+	reference, err := provider.Charge(pending.chargeKey(), UserInvestor, 1, pending.Money)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := settleDeposits(time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if balance := ledgerBalance(t, UserInvestor, 1, "EUR"); balance != 230000 {
+		t.Errorf("balance after the settlement %d, want 230000", balance)
+	}
+	var status, settledReference string
+	if err := db.QueryRow("SELECT status, reference FROM deposits WHERE id = ?", id).Scan(&status, &settledReference); err != nil {
+		t.Fatal(err)
+	}
+	if status != DepositCredited || settledReference != reference {
+		t.Errorf("settled deposit %s %s, want credited with the reference of the first charge %s", status, settledReference, reference)
+	}
+	if len(provider.charges) != 2 {
+		t.Errorf("%d charges collected, want 2", len(provider.charges))
+	}
+	checkLedgerBalanced(t)
+}
+
+func TestEscrowFlows(t *testing.T) {
+	api := newTestAPI(t)
+	usePaymentProvider(t, &FakePaymentProvider{})
+	creator, coInvestor, supplier := api.user(UserInvestor, 1), api.user(UserInvestor, 2), api.user(UserSupplier, 7)
+	created(t, creator.deposit(200000))
+	created(t, coInvestor.deposit(100000))
+
+	// the contract paid in two milestones is funded by the creator alone, the milestones are released
+	due := time.Now().Add(10 * 24 * time.Hour).UTC().Format(time.RFC3339)
+	paid := creator.createContract(func(q map[string]interface{}) {
+		q["Milestones"] = []Milestone{{Title: "Half", Money: Money{Amount: 40000}, DueDate: due}, {Title: "Rest", Money: Money{Amount: 60000}, DueDate: due}}
+	})
+	expectStatus(t, "acceptance", creator.accept(paid, supplier.createOffer(paid)), http.StatusOK)
+	if escrow := ledgerBalance(t, AccountEscrow, paid, "EUR"); escrow != 100000 {
+		t.Errorf("escrow %d, want 100000", escrow)
+	}
+	milestone := "/contracts/" + strconv.FormatInt(paid, 10) + "/milestones/"
+	created(t, creator.do(http.MethodPost, milestone+"1/accept", nil))
+	expectStatus(t, "milestone accepted twice", creator.do(http.MethodPost, milestone+"1/accept", nil), http.StatusConflict)
+	expectStatus(t, "milestone accepted by the supplier", supplier.do(http.MethodPost, milestone+"2/accept", nil), http.StatusUnauthorized)
+	created(t, creator.do(http.MethodPost, milestone+"2/accept", nil))
+	if got := ledgerBalance(t, UserSupplier, 7, "EUR"); got != 100000 {
+		t.Errorf("supplier got %d, want 100000", got)
+	}
+	if escrow := ledgerBalance(t, AccountEscrow, paid, "EUR"); escrow != 0 {
+		t.Errorf("escrow of the paid contract %d", escrow)
+	}
+
+	// the co-funded contract is funded by its investors in proportion and refunded so when cancelled
+	cofunded := creator.createContract(func(q map[string]interface{}) {
+		q["Amount"] = 90000
+		q["CoInvestors"] = []InvestorShare{{InvestorID: 2, Share: 30000}}
+	})
+	offerID := supplier.createOffer(cofunded)
+	expectStatus(t, "acceptance by the creator", creator.accept(cofunded, offerID), http.StatusAccepted)
+	expectStatus(t, "acceptance by the co-investor", coInvestor.accept(cofunded, offerID), http.StatusOK)
+	if creatorBalance, coBalance := ledgerBalance(t, UserInvestor, 1, "EUR"), ledgerBalance(t, UserInvestor, 2, "EUR"); creatorBalance != 40000 || coBalance != 70000 {
+		t.Errorf("funded: creator has %d, co-investor %d, want 40000 and 70000", creatorBalance, coBalance)
+	}
+	cancellation := "/contracts/" + strconv.FormatInt(cofunded, 10) + "/cancellation"
+	expectStatus(t, "cancellation by the supplier", supplier.do(http.MethodPost, "/suppliers"+cancellation, nil), http.StatusAccepted)
+	rec := creator.do(http.MethodPost, "/investors"+cancellation, nil)
+	var res CancellationResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil || !res.Cancelled {
+		t.Fatalf("cancellation: %d %s", rec.Code, rec.Body.String())
+	}
+	if creatorBalance, coBalance := ledgerBalance(t, UserInvestor, 1, "EUR"), ledgerBalance(t, UserInvestor, 2, "EUR"); creatorBalance != 100000 || coBalance != 100000 {
+		t.Errorf("refunded: creator has %d, co-investor %d, want 100000 each", creatorBalance, coBalance)
+	}
+	if escrow := ledgerBalance(t, AccountEscrow, cofunded, "EUR"); escrow != 0 {
+		t.Errorf("escrow of the cancelled contract %d", escrow)
+	}
+	checkLedgerBalanced(t)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/labstack/echo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Results of the signature verifications
+const (
+	SignatureValid     = "valid"
+	SignatureInvalid   = "invalid"
+	SignatureMalformed = "malformed"
+)
+
+// Upstream services and their operations
+const (
+	UpstreamSuppliers = "suppliers"
+	UpstreamInvestors = "investors"
+	UpstreamLoad      = "load"
+	UpstreamAuthorize = "authorize"
+)
+
+var (
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sirius_http_request_duration_seconds",
+		Help:    "Duration of the HTTP requests by route and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "code"})
+	dbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sirius_db_query_duration_seconds",
+		Help:    "Duration of the database calls by operation: exec, query, prepare, begin, commit or rollback.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+	}, []string{"operation"})
+	upstreamRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sirius_upstream_request_duration_seconds",
+		Help:    "Duration of the requests to the suppliers and investors services by operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"target", "operation"})
+	upstreamErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sirius_upstream_errors_total",
+		Help: "Failed requests to the suppliers and investors services, rejected tokens are not counted.",
+	}, []string{"target", "operation"})
+	signatureVerifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sirius_signature_verifications_total",
+		Help: "Signature verifications by result: valid, invalid or malformed.",
+	}, []string{"result"})
+	rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sirius_rate_limited_total",
+		Help: "Requests rejected with 429 by scope: ip and user rate limits or quota.",
+	}, []string{"scope"})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequestDuration, dbQueryDuration, upstreamRequestDuration, upstreamErrors,
+		signatureVerifications, rateLimited, businessCollector{})
+}
+
+// MetricsMiddleware observes the duration of the requests by their route
+func MetricsMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+
+		route, code := requestRoute(c, err)
+		httpRequestDuration.WithLabelValues(c.Request().Method, route, strconv.Itoa(code)).
+			Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// requestRoute returns the route of the handled request and the status code of the response,
+// requests which match no route are reported as unmatched rather than by their paths
+func requestRoute(c echo.Context, err error) (string, int) {
+	code := c.Response().Status
+	if he, ok := err.(*echo.HTTPError); ok {
+		code = he.Code
+	} else if err != nil {
+		code = http.StatusInternalServerError
+	}
+	route := c.Path()
+	if err == echo.ErrNotFound || err == echo.ErrMethodNotAllowed || route == "" {
+		route = "unmatched"
+	}
+	return route, code
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format
+var MetricsHandler = echo.WrapHandler(promhttp.Handler())
+
+// ServeMetrics serves the metrics on the address of metrics_listen, it is meant to be reachable by
+// the scrapers only, so the requests are not authenticated
+func ServeMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
+
+// observeUpstream records the duration of the request to the upstream service started at start,
+// failed is true when the service could not be reached or answered with an error
+func observeUpstream(target, operation string, start time.Time, failed bool) {
+	upstreamRequestDuration.WithLabelValues(target, operation).Observe(time.Since(start).Seconds())
+	if failed {
+		upstreamErrors.WithLabelValues(target, operation).Inc()
+	}
+}
+
+// contractOffersBuckets are the upper bounds of the offers per contract histogram
+var contractOffersBuckets = []float64{0, 1, 2, 3, 5, 10, 20}
+
+var (
+	openContractsDesc = prometheus.NewDesc("sirius_contracts_open",
+		"Contracts which are not concluded yet.", nil, nil)
+	contractOffersDesc = prometheus.NewDesc("sirius_contract_offers",
+		"Number of offers made on each of the contracts.", nil, nil)
+	acceptanceRatioDesc = prometheus.NewDesc("sirius_contract_acceptance_ratio",
+		"Share of the contracts with offers on which an offer is accepted.", nil, nil)
+)
+
+// businessCollector reads the business metrics from the database on every scrape
+type businessCollector struct{}
+
+// Describe implements prometheus.Collector
+func (businessCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- openContractsDesc
+	ch <- contractOffersDesc
+	ch <- acceptanceRatioDesc
+}
+
+// Collect implements prometheus.Collector, the deleted and archived contracts and the deleted offers are
+// left out as in the listings
+func (businessCollector) Collect(ch chan<- prometheus.Metric) {
+	db, err := openDB(context.Background())
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer db.Close()
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("COUNT(*)")
+	sb.From("contracts")
+	sb.Where(sb.Equal("stage", 0), sb.IsNull("deleted_at"), sb.IsNull("archived_at"))
+	q, args := sb.Build()
+	var open int64
+	if err := db.QueryRow(q, args...).Scan(&open); err != nil {
+		ch <- prometheus.NewInvalidMetric(openContractsDesc, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(openContractsDesc, prometheus.GaugeValue, float64(open))
+	}
+
+	if m, err := contractOffersHistogram(db); err != nil {
+		ch <- prometheus.NewInvalidMetric(contractOffersDesc, err)
+	} else {
+		ch <- m
+	}
+
+	sb = sqlbuilder.NewSelectBuilder()
+	sb.Select("COUNT(*)", "COALESCE(SUM(stage != 0), 0)")
+	sb.From("contracts")
+	sb.Where(sb.IsNull("deleted_at"), sb.IsNull("archived_at"),
+		"id IN (SELECT contract_id FROM offers WHERE deleted_at IS NULL)")
+	q, args = sb.Build()
+	var withOffers, accepted int64
+	if err := db.QueryRow(q, args...).Scan(&withOffers, &accepted); err != nil {
+		ch <- prometheus.NewInvalidMetric(acceptanceRatioDesc, err)
+	} else if withOffers > 0 {
+		ch <- prometheus.MustNewConstMetric(acceptanceRatioDesc, prometheus.GaugeValue, float64(accepted)/float64(withOffers))
+	}
+}
+
+// contractOffersHistogram returns the histogram of the numbers of offers made on the contracts
+func contractOffersHistogram(db dbExecutor) (prometheus.Metric, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("(SELECT COUNT(*) FROM offers WHERE offers.contract_id = contracts.id AND offers.deleted_at IS NULL)")
+	sb.From("contracts")
+	sb.Where(sb.IsNull("deleted_at"), sb.IsNull("archived_at"))
+	q, args := sb.Build()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var count uint64
+	var sum float64
+	buckets := make(map[float64]uint64, len(contractOffersBuckets))
+	for rows.Next() {
+		var offers float64
+		if err := rows.Scan(&offers); err != nil {
+			return nil, err
+		}
+		count++
+		sum += offers
+		for _, b := range contractOffersBuckets {
+			if offers <= b {
+				buckets[b]++
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return prometheus.NewConstHistogram(contractOffersDesc, count, sum, buckets)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// collectBusiness returns the business metrics by their descriptions
+func collectBusiness(t *testing.T) map[*prometheus.Desc]*dto.Metric {
+	t.Helper()
+	ch := make(chan prometheus.Metric, 3)
+	businessCollector{}.Collect(ch)
+	close(ch)
+	metrics := make(map[*prometheus.Desc]*dto.Metric)
+	for m := range ch {
+		var out dto.Metric
+		if err := m.Write(&out); err != nil {
+			t.Fatal(err)
+		}
+		metrics[m.Desc()] = &out
+	}
+	return metrics
+}
+
+func TestBusinessMetricsSkipDeleted(t *testing.T) {
+	api := newTestAPI(t)
+	investor, supplier := api.user(UserInvestor, 1), api.user(UserSupplier, 7)
+	open := investor.createContract(nil)
+	supplier.createOffer(open)
+	deleted := investor.createContract(nil)
+	supplier.createOffer(deleted)
+	archived := investor.createContract(nil)
+	supplier.createOffer(archived)
+	withDeletedOffer := investor.createContract(nil)
+	supplier.createOffer(withDeletedOffer)
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	execTestDB(t, "UPDATE contracts SET deleted_at = ? WHERE id = ?", now, deleted)
+	execTestDB(t, "UPDATE contracts SET stage = 1, archived_at = ? WHERE id = ?", now, archived)
+	execTestDB(t, "UPDATE offers SET deleted_at = ? WHERE contract_id = ?", now, withDeletedOffer)
+
+	metrics := collectBusiness(t)
+	if got := metrics[openContractsDesc].GetGauge().GetValue(); got != 2 {
+		t.Errorf("open contracts %v, want 2", got)
+	}
+	if h := metrics[contractOffersDesc].GetHistogram(); h.GetSampleCount() != 2 || h.GetSampleSum() != 1 {
+		t.Errorf("offers histogram of %d contracts with %v offers, want 2 with 1", h.GetSampleCount(), h.GetSampleSum())
+	}
+	if got := metrics[acceptanceRatioDesc].GetGauge().GetValue(); got != 0 {
+		t.Errorf("acceptance ratio %v, want 0", got)
+	}
+}
+
+func TestMetricsAreForStaff(t *testing.T) {
+	api := newTestAPI(t)
+	auditor := api.user(UserInvestor, 1)
+	execTestDB(t, "INSERT INTO user_roles (user_kind, user_id, role, created) VALUES (?, 1, ?, ?)",
+		UserInvestor, RoleAuditor, time.Now().UTC().Format(time.RFC3339))
+
+	expectStatus(t, "anonymous scrape", api.do(nil, http.MethodGet, "/metrics", nil), http.StatusUnauthorized)
+	expectStatus(t, "scrape by an investor", api.user(UserInvestor, 2).do(http.MethodGet, "/metrics", nil), http.StatusForbidden)
+	expectStatus(t, "scrape by an auditor", auditor.do(http.MethodGet, "/metrics", nil), http.StatusOK)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/labstack/echo"
+)
+
+// Money is an amount in minor units of an ISO 4217 currency, e.g. 1050 USD is 10.50 dollars.
+// Currency is omitted from the encoding when empty, so the contracts made before currencies
+// were introduced keep their encoding and signatures.
+type Money struct {
+	Amount   int64
+	Currency string `json:",omitempty"`
+}
+
+// currencyExponents are the numbers of digits after the decimal point of the supported currencies
+var currencyExponents = map[string]int{
+	"AED": 2, "ARS": 2, "AUD": 2, "BGN": 2, "BHD": 3, "BRL": 2, "CAD": 2, "CHF": 2, "CLP": 0, "CNY": 2,
+	"COP": 2, "CZK": 2, "DKK": 2, "EGP": 2, "EUR": 2, "GBP": 2, "HKD": 2, "HUF": 2, "IDR": 2, "ILS": 2,
+	"INR": 2, "ISK": 0, "JOD": 3, "JPY": 0, "KRW": 0, "KWD": 3, "KZT": 2, "MXN": 2, "MYR": 2, "NOK": 2,
+	"NZD": 2, "OMR": 3, "PHP": 2, "PLN": 2, "RON": 2, "RUB": 2, "SAR": 2, "SEK": 2, "SGD": 2, "THB": 2,
+	"TND": 3, "TRY": 2, "TWD": 2, "UAH": 2, "USD": 2, "VND": 0, "ZAR": 2,
+}
+
+// errUnknownCurrency is returned for currencies missing in currencyExponents
+var errUnknownCurrency = errors.New("Unknown currency")
+
+// validate checks that the currency is supported and the amount is positive
+func (m Money) validate() error {
+	if _, ok := currencyExponents[m.Currency]; !ok {
+		return errUnknownCurrency
+	}
+	if m.Amount <= 0 {
+		return errors.New("Amount must be positive")
+	}
+	return nil
+}
+
+// ExchangeRate is the number of major units of Currency worth one unit of the base currency the
+// rates are stored against, the base itself has rate 1. Rate is for display, conversions use the
+// exact decimal rate.
+type ExchangeRate struct {
+	Currency string
+	Exponent int
+	Rate     float64
+	Updated  string
+
+	rate *big.Rat
+}
+
+// parseRate parses the decimal rate, it is false when the rate is not a positive number
+func parseRate(s string) (*big.Rat, bool) {
+	rate, ok := new(big.Rat).SetString(s)
+	return rate, ok && rate.Sign() > 0
+}
+
+// exchangeRates returns the stored rates by currency
+func exchangeRates(db dbExecutor) (map[string]ExchangeRate, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("currency", "rate", "updated")
+	sb.From("exchange_rates")
+	q, args := sb.Build()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := make(map[string]ExchangeRate)
+	for rows.Next() {
+		r := ExchangeRate{}
+		var rate string
+		if err := rows.Scan(&r.Currency, &rate, &r.Updated); err != nil {
+			return nil, err
+		}
+		var ok bool
+		if r.rate, ok = parseRate(rate); !ok {
+			return nil, errors.New("bad exchange rate of " + r.Currency + ": " + rate)
+		}
+		r.Rate, _ = r.rate.Float64()
+		r.Exponent = currencyExponents[r.Currency]
+		rates[r.Currency] = r
+	}
+	return rates, rows.Err()
+}
+
+// convert returns the amount in the currency, computed exactly and rounded to its minor units half
+// away from zero. ok is false when a rate of either currency is not stored or the converted amount
+// does not fit in int64.
+func convert(rates map[string]ExchangeRate, m Money, currency string) (Money, bool) {
+	if m.Currency == currency {
+		return m, true
+	}
+	from, ok := rates[m.Currency]
+	to, ok2 := rates[currency]
+	if !ok || !ok2 {
+		return Money{}, false
+	}
+	// amount / 10^from.Exponent / from.rate * to.rate * 10^to.Exponent
+	minor := new(big.Rat).SetInt64(m.Amount)
+	minor.Mul(minor, to.rate)
+	minor.Mul(minor, new(big.Rat).SetInt(pow10(to.Exponent)))
+	minor.Quo(minor, from.rate)
+	minor.Quo(minor, new(big.Rat).SetInt(pow10(from.Exponent)))
+
+	amount, rem := new(big.Int).QuoRem(minor.Num(), minor.Denom(), new(big.Int))
+	if rem.Abs(rem).Lsh(rem, 1).Cmp(minor.Denom()) >= 0 {
+		amount.Add(amount, big.NewInt(int64(minor.Sign())))
+	}
+	if !amount.IsInt64() {
+		return Money{}, false
+	}
+	return Money{Amount: amount.Int64(), Currency: currency}, true
+}
+
+// pow10 returns 10^n
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// LoadExchangeRates replaces the stored rates with the JSON object of currencies and rates in the file,
+// e.g. {"EUR": 1, "USD": 1.08}
+func LoadExchangeRates(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// the rates are kept as written, so they are converted without binary rounding
+	var rates map[string]json.Number
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&rates); err != nil {
+		return err
+	}
+
+	db, err := openDB(context.Background())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	dlb := sqlbuilder.NewDeleteBuilder()
+	dlb.DeleteFrom("exchange_rates")
+	q, args := dlb.Build()
+	if _, err := tx.Exec(q, args...); err != nil {
+		return err
+	}
+	updated := time.Now().UTC().Format(time.RFC3339)
+	for currency, rate := range rates {
+		_, known := currencyExponents[currency]
+		if _, ok := parseRate(rate.String()); !known || !ok {
+			return errors.New("bad exchange rate of " + currency)
+		}
+		ib := sqlbuilder.NewInsertBuilder()
+		ib.InsertInto("exchange_rates")
+		ib.Cols("currency", "rate", "updated")
+		ib.Values(currency, rate.String(), updated)
+		q, args := ib.Build()
+		if _, err := tx.Exec(q, args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListExchangeRates - api controller for getting the stored exchange rates
+func ListExchangeRates(c echo.Context) error {
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	rates, err := exchangeRates(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	list := []ExchangeRate{}
+	for _, r := range rates {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Currency < list[j].Currency })
+	return c.JSON(http.StatusOK, list)
+}
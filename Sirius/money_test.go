@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func testRates(t *testing.T, decimal map[string]string) map[string]ExchangeRate {
+	t.Helper()
+	rates := make(map[string]ExchangeRate)
+	for currency, s := range decimal {
+		rate, ok := parseRate(s)
+		if !ok {
+			t.Fatalf("bad rate %s", s)
+		}
+		rates[currency] = ExchangeRate{Currency: currency, Exponent: currencyExponents[currency], rate: rate}
+	}
+	return rates
+}
+
+func TestConvert(t *testing.T) {
+	rates := testRates(t, map[string]string{"EUR": "1", "USD": "1.08", "JPY": "161.5", "KWD": "0.332", "CHF": "1.005"})
+	for _, test := range []struct {
+		m        Money
+		currency string
+		want     int64
+		ok       bool
+	}{
+		{Money{10000, "EUR"}, "EUR", 10000, true},
+		{Money{10000, "EUR"}, "USD", 10800, true},
+		{Money{10800, "USD"}, "EUR", 10000, true},
+		{Money{1, "USD"}, "EUR", 1, true},
+		{Money{100, "EUR"}, "JPY", 162, true},
+		{Money{5, "EUR"}, "JPY", 8, true},
+		{Money{10000, "EUR"}, "KWD", 33200, true},
+		{Money{162, "JPY"}, "EUR", 100, true},
+		// 1.005 * 100 is 100.49999999999999 in float64
+		{Money{100, "EUR"}, "CHF", 101, true},
+		{Money{math.MaxInt64, "EUR"}, "JPY", 0, false},
+		{Money{10000, "EUR"}, "SEK", 0, false},
+	} {
+		got, ok := convert(rates, test.m, test.currency)
+		if ok != test.ok || ok && (got.Amount != test.want || got.Currency != test.currency) {
+			t.Errorf("%v to %s: %v %v, want %d %v", test.m, test.currency, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestLoadExchangeRates(t *testing.T) {
+	useTestDB(t)
+	path := filepath.Join(t.TempDir(), "rates.json")
+	write := func(data string) {
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"EUR": 1, "USD": 1.08, "CHF": 1.005}`)
+	if err := LoadExchangeRates(path); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{`{"EUR": 1, "USD": 0}`, `{"EUR": 1, "USD": -1.08}`, `{"EUR": 1, "XXX": 2}`} {
+		write(bad)
+		if err := LoadExchangeRates(path); err == nil {
+			t.Errorf("rates %s are loaded", bad)
+		}
+	}
+
+	db, err := openDB(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rates, err := exchangeRates(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) != 3 || rates["USD"].Rate != 1.08 || rates["CHF"].rate.RatString() != "201/200" {
+		t.Errorf("stored rates %v", rates)
+	}
+	if got, ok := convert(rates, Money{100, "EUR"}, "CHF"); !ok || got.Amount != 101 {
+		t.Errorf("1.00 EUR converted to %v %v, want 1.01 CHF", got, ok)
+	}
+}
@@ -0,0 +1,1091 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// apiParam describes a path or query parameter of an api operation
+type apiParam struct {
+	Name        string
+	In          string
+	Type        string
+	Description string
+}
+
+// apiResponse describes one of the possible responses of an api operation,
+// Body == nil means plain text response
+type apiResponse struct {
+	Description string
+	Body        interface{}
+}
+
+// apiOperation is a documentation entry for a single echo route
+type apiOperation struct {
+	Summary   string
+	Auth      string
+	Params    []apiParam
+	Request   interface{}
+	Responses map[int]apiResponse
+}
+
+const (
+	authInvestor = "InvestorToken"
+	authSupplier = "SupplierToken"
+	authSession  = "SessionToken"
+)
+
+var pathID = apiParam{Name: "id", In: "path", Type: "integer", Description: "Resource identifier"}
+
+var ifMatchParam = apiParam{Name: "If-Match", In: "header", Type: "string",
+	Description: "ETag of the resource returned by its GET, the request fails with 412 when the resource has changed since"}
+
+var respPreconditionFailed = apiResponse{Description: "If-Match does not match the current ETag, which is returned in the ETag header"}
+
+var idempotencyKey = apiParam{Name: "Idempotency-Key", In: "header", Type: "string",
+	Description: "Unique key of the request, the retries with the key get the response of the first request with Idempotent-Replayed: true"}
+
+var pageParams = []apiParam{
+	{Name: "Limit", In: "query", Type: "integer", Description: "Maximum number of returned items, items are ordered by id"},
+	{Name: "Offset", In: "query", Type: "integer", Description: "Number of items to skip"},
+}
+
+var (
+	respBadRequest   = apiResponse{Description: "Malformed request"}
+	respForbidden    = apiResponse{Description: "The roles of the user do not allow the action"}
+	respUnauthorized = apiResponse{Description: "Authorization token is missing or invalid"}
+	respOK           = apiResponse{Description: "Success, empty body"}
+)
+
+// apiDocs - documentation for every route registered in main, keyed by "METHOD path"
+var apiDocs = map[string]apiOperation{
+	"GET /contracts": {
+		Summary: "List contracts",
+		Params: append([]apiParam{
+			{Name: "SupplierID", In: "query", Type: "integer", Description: "Only contracts concluded with the supplier"},
+			{Name: "InvestorID", In: "query", Type: "integer", Description: "Only contracts created or co-funded by the investor"},
+			{Name: "Title", In: "query", Type: "string", Description: "SQL LIKE pattern matched against the title"},
+			{Name: "Currency", In: "query", Type: "string", Description: "Only contracts in the ISO 4217 currency"},
+			{Name: "ConvertTo", In: "query", Type: "string", Description: "Currency of Converted amounts, contracts without a stored exchange rate are not converted"},
+			{Name: "Archived", In: "query", Type: "boolean", Description: "List the archived contracts instead of the active ones"},
+		}, pageParams...),
+		Responses: map[int]apiResponse{
+			http.StatusOK:         {Description: "List of contracts", Body: []Contract{}},
+			http.StatusBadRequest: respBadRequest,
+		},
+	},
+	"GET /contracts/:id": {
+		Summary: "Retrieve contract",
+		Params:  []apiParam{pathID},
+		Responses: map[int]apiResponse{
+			http.StatusOK:         {Description: "Contract, the ETag header is its revision", Body: Contract{}},
+			http.StatusBadRequest: respBadRequest,
+			http.StatusNotFound:   {Description: "Contract not found"},
+		},
+	},
+	"GET /contracts/:id/encoded": {
+		Summary: "Retrieve encoded ContractBody, these exact bytes are signed by the supplier and the investor",
+		Params:  []apiParam{pathID},
+		Responses: map[int]apiResponse{
+			http.StatusOK:         {Description: "Encoded contract body", Body: ContractBody{}},
+			http.StatusBadRequest: respBadRequest,
+			http.StatusNotFound:   {Description: "Contract not found"},
+		},
+	},
+	"POST /contracts": {
+		Summary: "Create contract",
+		Auth:    authInvestor,
+		Params:  []apiParam{idempotencyKey},
+		Request: ContractQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusCreated:         {Description: "Contract created", Body: CreatedResponse{}},
+			http.StatusBadRequest:      {Description: "Malformed request, bad milestones, award policy, currency, shares of co-investors, deadlines of a sealed contract, template parameters or Idempotency-Key"},
+			http.StatusUnauthorized:    respUnauthorized,
+			http.StatusConflict:        {Description: "Request with the Idempotency-Key is in progress or the key was used with a different request"},
+			http.StatusTooManyRequests: {Description: "Rate limit or daily quota of contracts exceeded"},
+		},
+	},
+	"PATCH /contracts/:id": {
+		Summary: "Accept the latest revision of an offer, the investor signs its encoded terms (see /offers/{id}/encoded). Every investor of a co-funded contract signs the same revision",
+		Auth:    authInvestor,
+		Params:  []apiParam{pathID, ifMatchParam},
+		Request: OfferAcceptionQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                 {Description: "Contract concluded, the ETag header is its new revision"},
+			http.StatusAccepted:           {Description: "Signature recorded, waiting for signatures of the co-investors"},
+			http.StatusPreconditionFailed: respPreconditionFailed,
+			http.StatusBadRequest:         {Description: "Malformed request or signature not verified"},
+			http.StatusUnauthorized:       respUnauthorized,
+			http.StatusNotFound:           {Description: "Contract or offer not found"},
+			http.StatusConflict:           {Description: "Offer was revised, expired, made on an earlier version of the contract, the latest revision is a counter-offer of the investor, sealed bids are being revealed, shares do not cover the amount or the investors lack funds for the escrow"},
+			http.StatusBadGateway:         {Description: "Investor's certificate could not be loaded"},
+		},
+	},
+	"PUT /contracts/:id": {
+		Summary: "Edit the open contract, its body becomes the next version. Offers made so far become stale until their suppliers sign a new revision, signatures of the co-investors and the pre-authorized award are discarded and the creator funds the changed amount",
+		Auth:    authInvestor,
+		Params:  []apiParam{pathID, ifMatchParam},
+		Request: AmendmentQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                 {Description: "New version with its changes, the current one when nothing changed. The ETag header is the new revision of the contract", Body: ContractVersion{}},
+			http.StatusBadRequest:         {Description: "Malformed request, bad milestones, currency or shares of co-investors exceed the amount"},
+			http.StatusUnauthorized:       respUnauthorized,
+			http.StatusNotFound:           {Description: "Contract not found"},
+			http.StatusConflict:           {Description: "Contract is signed, sealed bids were submitted or it was amended concurrently"},
+			http.StatusPreconditionFailed: respPreconditionFailed,
+		},
+	},
+	"GET /contracts/:id/versions": {
+		Summary: "Version history of the contract body with the changes made by each version",
+		Params:  append([]apiParam{pathID}, pageParams...),
+		Responses: map[int]apiResponse{
+			http.StatusOK:         {Description: "Versions", Body: []ContractVersion{}},
+			http.StatusBadRequest: respBadRequest,
+			http.StatusNotFound:   {Description: "Contract not found"},
+		},
+	},
+	"GET /contracts/:id/amendments": {
+		Summary: "Amendments proposed for the signed contract",
+		Params: append([]apiParam{pathID,
+			{Name: "Status", In: "query", Type: "string", Description: "pending, accepted, rejected or withdrawn"},
+		}, pageParams...),
+		Responses: map[int]apiResponse{
+			http.StatusOK:         {Description: "Amendments", Body: []ContractAmendment{}},
+			http.StatusBadRequest: respBadRequest,
+			http.StatusNotFound:   {Description: "Contract not found"},
+		},
+	},
+	"DELETE /contracts/:id": {
+		Summary: "Delete contract, an open contract may be restored for restore_grace and is purged after deleted_retention. A concluded contract is cancelled by agreement with the supplier, see /investors/contracts/{id}/cancellation",
+		Auth:    authInvestor,
+		Params:  []apiParam{pathID, ifMatchParam},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                 respOK,
+			http.StatusUnauthorized:       respUnauthorized,
+			http.StatusForbidden:          {Description: "The roles of the investor do not allow deleting contracts"},
+			http.StatusNotFound:           {Description: "Contract not found"},
+			http.StatusPreconditionFailed: respPreconditionFailed,
+			http.StatusConflict:           {Description: "Contract is signed, it is cancelled by agreement with the supplier"},
+		},
+	},
+	"PUT /contracts/:id/award": {
+		Summary: "Set the award policy, the winner is selected among the valid offers at the bidding deadline",
+		Auth:    authInvestor,
+		Params:  []apiParam{pathID},
+		Request: AwardQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:           respOK,
+			http.StatusBadRequest:   {Description: "Malformed request, unknown policy, bad weights or no bidding deadline"},
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusNotFound:     {Description: "Contract not found"},
+			http.StatusConflict:     {Description: "Contract is already concluded or awarded"},
+		},
+	},
+	"PUT /contracts/:id/investors": {
+		Summary: "Set the co-investors of an open contract and their shares, the creator funds the rest of the amount. Signatures made so far are discarded",
+		Auth:    authInvestor,
+		Params:  []apiParam{pathID},
+		Request: CoFundingQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:           respOK,
+			http.StatusBadRequest:   {Description: "Malformed request or shares exceed the amount"},
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusNotFound:     {Description: "Contract not found"},
+			http.StatusConflict:     {Description: "Contract is already concluded"},
+		},
+	},
+	"GET /offers": {
+		Summary: "List offers",
+		Params: append([]apiParam{
+			{Name: "SupplierID", In: "query", Type: "integer", Description: "Only offers made by the supplier"},
+			{Name: "ContractID", In: "query", Type: "integer", Description: "Only offers made for the contract"},
+			{Name: "Currency", In: "query", Type: "string", Description: "Only offers for the contracts in the ISO 4217 currency"},
+		}, pageParams...),
+		Responses: map[int]apiResponse{
+			http.StatusOK:         {Description: "List of offers", Body: []Offer{}},
+			http.StatusBadRequest: respBadRequest,
+		},
+	},
+	"GET /offers/:id": {
+		Summary: "Retrieve offer",
+		Params:  []apiParam{pathID},
+		Responses: map[int]apiResponse{
+			http.StatusOK:         {Description: "Offer, the ETag header changes with its revisions and expiry", Body: Offer{}},
+			http.StatusBadRequest: respBadRequest,
+			http.StatusNotFound:   {Description: "Offer not found"},
+		},
+	},
+	"POST /offers": {
+		Summary: "Create offer, the supplier signs the encoded ContractBody with ValidUntil set",
+		Auth:    authSupplier,
+		Params:  []apiParam{idempotencyKey},
+		Request: OfferQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusCreated:         {Description: "Offer created", Body: CreatedResponse{}},
+			http.StatusBadRequest:      {Description: "Malformed request, ValidUntil in the past, bad signature or Idempotency-Key"},
+			http.StatusUnauthorized:    respUnauthorized,
+			http.StatusNotFound:        {Description: "Contract not found"},
+			http.StatusConflict:        {Description: "Contract is concluded, sealed or its bidding deadline has passed, request with the Idempotency-Key is in progress or the key was used with a different request"},
+			http.StatusTooManyRequests: {Description: "Rate limit or quota of open offers exceeded"},
+			http.StatusBadGateway:      {Description: "Supplier's certificate could not be loaded"},
+		},
+	},
+	"DELETE /offers/:id": {
+		Summary: "Delete offer, it may be restored for restore_grace and is purged with its revisions after deleted_retention",
+		Auth:    authSupplier,
+		Params:  []apiParam{pathID, ifMatchParam},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                 respOK,
+			http.StatusUnauthorized:       respUnauthorized,
+			http.StatusForbidden:          {Description: "The roles of the supplier do not allow deleting offers"},
+			http.StatusNotFound:           {Description: "Offer not found"},
+			http.StatusPreconditionFailed: respPreconditionFailed,
+		},
+	},
+	"POST /bids": {
+		Summary: "Submit a sealed bid: SHA-384 commitment of the salt and the signed encoded terms, optionally the reveal encrypted to the investor",
+		Auth:    authSupplier,
+		Request: SealedBidQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusCreated:      {Description: "Bid submitted", Body: CreatedResponse{}},
+			http.StatusBadRequest:   {Description: "Malformed request, commitment or ciphertext"},
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusNotFound:     {Description: "Contract not found"},
+			http.StatusConflict:     {Description: "Contract is concluded, not sealed, its bidding deadline has passed or the supplier has already bid"},
+		},
+	},
+	"GET /rates": {
+		Summary: "List exchange rates used to convert amounts, rates are loaded from SIRIUS_RATES_FILE",
+		Responses: map[int]apiResponse{
+			http.StatusOK: {Description: "Exchange rates against the common base currency", Body: []ExchangeRate{}},
+		},
+	},
+	"POST /auth/challenges": {
+		Summary: "Issue the nonce of the challenge-response login, it is answered within 2 minutes",
+		Request: ChallengeQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusCreated:    {Description: "Challenge, the user signs \"Sirius login \" followed by the nonce", Body: LoginChallenge{}},
+			http.StatusBadRequest: respBadRequest,
+		},
+	},
+	"POST /auth/sessions": {
+		Summary: "Log in with the challenge signed by the user's key, the challenge is used up",
+		Request: SessionQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusCreated:      {Description: "Session token sent as \"Session <token>\"", Body: Session{}},
+			http.StatusBadRequest:   respBadRequest,
+			http.StatusUnauthorized: {Description: "Challenge not found or expired, user not found or invalid signature"},
+		},
+	},
+	"DELETE /auth/sessions": {
+		Summary: "Log out, the session token is revoked",
+		Auth:    authSession,
+		Responses: map[int]apiResponse{
+			http.StatusOK:           respOK,
+			http.StatusUnauthorized: respUnauthorized,
+		},
+	},
+	"POST /investors/deposits": {
+		Summary: "Deposit money charged by the payment provider to the investor's account, contracts are funded from it",
+		Auth:    authInvestor,
+		Request: DepositQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusCreated:         {Description: "Ledger transaction of the deposit", Body: CreatedResponse{}},
+			http.StatusAccepted:        {Description: "Deposit is pending, it is charged and credited once the provider and the database are available"},
+			http.StatusBadRequest:      {Description: "Malformed request, unknown currency or not positive amount"},
+			http.StatusUnauthorized:    respUnauthorized,
+			http.StatusPaymentRequired: {Description: "Payment declined by the provider"},
+		},
+	},
+	"POST /contracts/:id/milestones/:n/accept": {
+		Summary: "Accept a milestone of the concluded contract, its payment is released from the escrow to the supplier. A contract without milestones is paid as milestone 1",
+		Auth:    authInvestor,
+		Params:  []apiParam{pathID, {Name: "n", In: "path", Type: "integer", Description: "Number of the milestone, from 1"}},
+		Responses: map[int]apiResponse{
+			http.StatusCreated:      {Description: "Ledger transaction of the release", Body: CreatedResponse{}},
+			http.StatusBadRequest:   respBadRequest,
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusNotFound:     {Description: "Contract or milestone not found"},
+			http.StatusConflict:     {Description: "Contract is not concluded or not escrowed, or the milestone is already accepted"},
+		},
+	},
+	"GET /ledger/check": {
+		Summary: "Verify that the debits of the ledger equal its credits and no account is overdrawn, for auditors and admins",
+		Auth:    authSession,
+		Responses: map[int]apiResponse{
+			http.StatusOK:           {Description: "Result of the checks", Body: LedgerCheck{}},
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusForbidden:    respForbidden,
+		},
+	},
+	"DELETE /admin/contracts/:id": {
+		Summary: "Remove an abusive contract of any investor, for moderators and admins. A concluded contract is archived and the money left in its escrow is refunded to its investors",
+		Auth:    authSession,
+		Params:  []apiParam{pathID, ifMatchParam},
+		Request: RemovalQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                 respOK,
+			http.StatusBadRequest:         {Description: "Malformed request or no reason"},
+			http.StatusUnauthorized:       respUnauthorized,
+			http.StatusForbidden:          respForbidden,
+			http.StatusNotFound:           {Description: "Contract not found"},
+			http.StatusPreconditionFailed: respPreconditionFailed,
+		},
+	},
+	"DELETE /admin/offers/:id": {
+		Summary: "Remove an abusive offer of any supplier, for moderators and admins",
+		Auth:    authSession,
+		Params:  []apiParam{pathID, ifMatchParam},
+		Request: RemovalQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                 respOK,
+			http.StatusBadRequest:         {Description: "Malformed request or no reason"},
+			http.StatusUnauthorized:       respUnauthorized,
+			http.StatusForbidden:          respForbidden,
+			http.StatusNotFound:           {Description: "Offer not found"},
+			http.StatusPreconditionFailed: respPreconditionFailed,
+		},
+	},
+	"POST /contracts/:id/restore": {
+		Summary: "Restore a contract deleted by the investor within restore_grace",
+		Auth:    authInvestor,
+		Params:  []apiParam{pathID},
+		Responses: map[int]apiResponse{
+			http.StatusOK:           respOK,
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusForbidden:    {Description: "The roles of the investor do not allow restoring contracts or the contract was removed by the staff"},
+			http.StatusNotFound:     {Description: "Deleted contract not found"},
+			http.StatusConflict:     {Description: "Contract is archived"},
+			http.StatusGone:         {Description: "Grace period of the deletion is over"},
+		},
+	},
+	"POST /offers/:id/restore": {
+		Summary: "Restore an offer deleted by the supplier within restore_grace",
+		Auth:    authSupplier,
+		Params:  []apiParam{pathID},
+		Responses: map[int]apiResponse{
+			http.StatusOK:           respOK,
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusForbidden:    {Description: "The roles of the supplier do not allow restoring offers or the offer was removed by the staff"},
+			http.StatusNotFound:     {Description: "Deleted offer not found"},
+			http.StatusConflict:     {Description: "Contract of the offer is deleted"},
+			http.StatusGone:         {Description: "Grace period of the deletion is over"},
+		},
+	},
+	"POST /admin/contracts/:id/restore": {
+		Summary: "Restore a deleted contract of any investor within restore_grace, for moderators and admins",
+		Auth:    authSession,
+		Params:  []apiParam{pathID},
+		Request: RemovalQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:           respOK,
+			http.StatusBadRequest:   respBadRequest,
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusForbidden:    respForbidden,
+			http.StatusNotFound:     {Description: "Deleted contract not found"},
+			http.StatusConflict:     {Description: "Contract is archived"},
+			http.StatusGone:         {Description: "Grace period of the deletion is over"},
+		},
+	},
+	"POST /admin/offers/:id/restore": {
+		Summary: "Restore a deleted offer of any supplier within restore_grace, for moderators and admins",
+		Auth:    authSession,
+		Params:  []apiParam{pathID},
+		Request: RemovalQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:           respOK,
+			http.StatusBadRequest:   respBadRequest,
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusForbidden:    respForbidden,
+			http.StatusNotFound:     {Description: "Deleted offer not found"},
+			http.StatusConflict:     {Description: "Contract of the offer is deleted"},
+			http.StatusGone:         {Description: "Grace period of the deletion is over"},
+		},
+	},
+	"GET /admin/contracts/:id/signatures": {
+		Summary: "Signatures of the contract, its co-investors, offers and offer revisions, for auditors and admins",
+		Auth:    authSession,
+		Params:  []apiParam{pathID},
+		Responses: map[int]apiResponse{
+			http.StatusOK:           {Description: "Signatures", Body: []SignatureRecord{}},
+			http.StatusBadRequest:   respBadRequest,
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusForbidden:    respForbidden,
+			http.StatusNotFound:     {Description: "Contract not found"},
+		},
+	},
+	"GET /admin/audit": {
+		Summary: "Audit log of the removals by the staff and of the role changes, for auditors, moderators and admins",
+		Auth:    authSession,
+		Params: append([]apiParam{
+			{Name: "Action", In: "query", Type: "string", Description: "contract.delete, offer.delete or roles.manage"},
+			{Name: "Target", In: "query", Type: "string", Description: "Resource like contract:6 or investor:2"},
+		}, pageParams...),
+		Responses: map[int]apiResponse{
+			http.StatusOK:           {Description: "Audit entries", Body: []AuditEntry{}},
+			http.StatusBadRequest:   respBadRequest,
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusForbidden:    respForbidden,
+		},
+	},
+	"GET /admin/roles": {
+		Summary: "List the staff roles granted to the users, for admins",
+		Auth:    authSession,
+		Params:  pageParams,
+		Responses: map[int]apiResponse{
+			http.StatusOK:           {Description: "Role grants", Body: []RoleGrant{}},
+			http.StatusBadRequest:   respBadRequest,
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusForbidden:    respForbidden,
+		},
+	},
+	"POST /admin/roles": {
+		Summary: "Grant the moderator, auditor or admin role to an investor or a supplier, for admins",
+		Auth:    authSession,
+		Request: RoleQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusCreated:      {Description: "Role granted", Body: RoleGrant{}},
+			http.StatusBadRequest:   {Description: "Malformed request, unknown user kind or role"},
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusForbidden:    respForbidden,
+			http.StatusConflict:     {Description: "Role is already granted"},
+		},
+	},
+	"DELETE /admin/roles/:id": {
+		Summary: "Revoke a staff role, for admins",
+		Auth:    authSession,
+		Params:  []apiParam{pathID},
+		Responses: map[int]apiResponse{
+			http.StatusOK:           respOK,
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusForbidden:    respForbidden,
+			http.StatusNotFound:     {Description: "Role grant not found"},
+		},
+	},
+	"GET /templates": {
+		Summary: "List contract templates with their latest versions",
+		Params: append([]apiParam{
+			{Name: "InvestorID", In: "query", Type: "integer", Description: "Only templates of the investor"},
+			{Name: "Name", In: "query", Type: "string", Description: "SQL LIKE pattern matched against the name"},
+		}, pageParams...),
+		Responses: map[int]apiResponse{
+			http.StatusOK:         {Description: "Templates", Body: []Template{}},
+			http.StatusBadRequest: respBadRequest,
+		},
+	},
+	"GET /templates/:id": {
+		Summary: "Retrieve template with its latest version",
+		Params:  []apiParam{pathID},
+		Responses: map[int]apiResponse{
+			http.StatusOK:         {Description: "Template", Body: Template{}},
+			http.StatusBadRequest: respBadRequest,
+			http.StatusNotFound:   {Description: "Template not found"},
+		},
+	},
+	"GET /templates/:id/versions": {
+		Summary: "List versions of the template, they remain available after the template is deleted",
+		Params: append([]apiParam{pathID,
+			{Name: "Version", In: "query", Type: "integer", Description: "Only the version with the number"},
+		}, pageParams...),
+		Responses: map[int]apiResponse{
+			http.StatusOK:         {Description: "Template versions", Body: []TemplateVersion{}},
+			http.StatusBadRequest: respBadRequest,
+			http.StatusNotFound:   {Description: "Template not found"},
+		},
+	},
+	"POST /templates/:id/render": {
+		Summary: "Render title and description of the template version with the parameters",
+		Params:  []apiParam{pathID},
+		Request: RenderQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:         {Description: "Rendered title and description", Body: ContractBody{}},
+			http.StatusBadRequest: {Description: "Malformed request or parameters"},
+			http.StatusNotFound:   {Description: "Template not found"},
+		},
+	},
+	"POST /templates": {
+		Summary: "Create template, Title and Description are text/template sources with the parameters as fields, range and template calls are not allowed",
+		Auth:    authInvestor,
+		Request: TemplateQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusCreated:      {Description: "Template created", Body: CreatedResponse{}},
+			http.StatusBadRequest:   {Description: "Malformed request, parameters or template sources"},
+			http.StatusUnauthorized: respUnauthorized,
+		},
+	},
+	"PUT /templates/:id": {
+		Summary: "Make a new version of the template, contracts keep referring to the versions they were rendered from",
+		Auth:    authInvestor,
+		Params:  []apiParam{pathID},
+		Request: TemplateQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:           {Description: "Number of the new version", Body: struct{ Version int64 }{}},
+			http.StatusBadRequest:   {Description: "Malformed request, parameters or template sources"},
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusNotFound:     {Description: "Template not found"},
+		},
+	},
+	"DELETE /templates/:id": {
+		Summary: "Delete template, its versions are kept",
+		Auth:    authInvestor,
+		Params:  []apiParam{pathID},
+		Responses: map[int]apiResponse{
+			http.StatusOK:           respOK,
+			http.StatusBadRequest:   respBadRequest,
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusNotFound:     {Description: "Template not found"},
+		},
+	},
+	"GET /metrics": {
+		Summary: "Prometheus metrics of requests, database and upstream calls, signature verifications and contracts, for admins and auditors. Scrapers read them on metrics_listen",
+		Auth:    authSession,
+		Responses: map[int]apiResponse{
+			http.StatusOK:           {Description: "Metrics in the Prometheus text format"},
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusForbidden:    respForbidden,
+		},
+	},
+	"GET /openapi.json": {
+		Summary: "OpenAPI 3 description of this api",
+		Responses: map[int]apiResponse{
+			http.StatusOK: {Description: "OpenAPI document", Body: map[string]interface{}{}},
+		},
+	},
+	"GET /docs": {
+		Summary: "Interactive api documentation",
+		Responses: map[int]apiResponse{
+			http.StatusOK: {Description: "HTML page"},
+		},
+	},
+}
+
+var streamParams = []apiParam{
+	{Name: "types", In: "query", Type: "string", Description: "Comma separated event types, all types by default"},
+	{Name: "token", In: "query", Type: "string", Description: "Session token or upstream token for clients which cannot set headers"},
+	{Name: "Last-Event-ID", In: "header", Type: "integer", Description: "Resume after the event with this ID"},
+	{Name: "last_event_id", In: "query", Type: "integer", Description: "Same as Last-Event-ID header"},
+}
+
+var amendmentParam = apiParam{Name: "amendment", In: "path", Type: "integer", Description: "Amendment identifier"}
+
+// userRouteDocs documents the routes which are registered both for investors and suppliers,
+// paths are relative to the prefix
+var userRouteDocs = map[string]apiOperation{
+	"GET /ledger/accounts": {
+		Summary: "List ledger accounts of the user with their balances, an account is opened per currency",
+		Responses: map[int]apiResponse{
+			http.StatusOK:           {Description: "Accounts", Body: []LedgerAccount{}},
+			http.StatusUnauthorized: respUnauthorized,
+		},
+	},
+	"GET /ledger/accounts/:id/statement": {
+		Summary: "Postings to the user's account with the balance after each of them",
+		Params:  append([]apiParam{pathID}, pageParams...),
+		Responses: map[int]apiResponse{
+			http.StatusOK:           {Description: "Statement", Body: []LedgerEntry{}},
+			http.StatusBadRequest:   respBadRequest,
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusNotFound:     {Description: "Account not found or not the user's"},
+		},
+	},
+	"POST /certificates": {
+		Summary: "Register the TLS client certificate of the connection, the user is authenticated by it afterwards",
+		Responses: map[int]apiResponse{
+			http.StatusCreated:      {Description: "Registered certificate", Body: ClientCertificate{}},
+			http.StatusBadRequest:   {Description: "No verified client certificate"},
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusConflict:     {Description: "Certificate is already registered or identifies its user by the subject"},
+		},
+	},
+	"GET /certificates": {
+		Summary: "List client certificates registered by the user",
+		Responses: map[int]apiResponse{
+			http.StatusOK:           {Description: "Certificates", Body: []ClientCertificate{}},
+			http.StatusUnauthorized: respUnauthorized,
+		},
+	},
+	"DELETE /certificates/:id": {
+		Summary: "Unregister client certificate",
+		Params:  []apiParam{pathID},
+		Responses: map[int]apiResponse{
+			http.StatusOK:           respOK,
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusNotFound:     {Description: "Certificate not found"},
+		},
+	},
+	"POST /webhooks": {
+		Summary: "Subscribe to events, deliveries are signed with HMAC-SHA256 using the returned secret",
+		Request: WebhookQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusCreated:      {Description: "Webhook with the secret", Body: Webhook{}},
+			http.StatusBadRequest:   {Description: "Malformed request, invalid URL, URL of a loopback, link-local or private address or unknown event type"},
+			http.StatusUnauthorized: respUnauthorized,
+		},
+	},
+	"GET /webhooks": {
+		Summary: "List webhooks of the user",
+		Responses: map[int]apiResponse{
+			http.StatusOK:           {Description: "Webhooks, secrets are not included", Body: []Webhook{}},
+			http.StatusUnauthorized: respUnauthorized,
+		},
+	},
+	"DELETE /webhooks/:id": {
+		Summary: "Delete webhook",
+		Params:  []apiParam{pathID},
+		Responses: map[int]apiResponse{
+			http.StatusOK:           respOK,
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusNotFound:     {Description: "Webhook not found"},
+		},
+	},
+	"GET /webhooks/:id/deliveries": {
+		Summary: "Delivery log of the webhook",
+		Params: append([]apiParam{pathID,
+			{Name: "Status", In: "query", Type: "string", Description: "pending, delivered or failed"},
+		}, pageParams...),
+		Responses: map[int]apiResponse{
+			http.StatusOK:           {Description: "Deliveries", Body: []WebhookDelivery{}},
+			http.StatusBadRequest:   respBadRequest,
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusNotFound:     {Description: "Webhook not found"},
+		},
+	},
+	"GET /events": {
+		Summary: "Stream of events visible to the user as Server-Sent Events, heartbeat comments are sent every 15 seconds",
+		Params:  streamParams,
+		Responses: map[int]apiResponse{
+			http.StatusOK:           {Description: "text/event-stream, event id is the stored event ID, data is Event json"},
+			http.StatusBadRequest:   {Description: "Bad Last-Event-ID"},
+			http.StatusUnauthorized: respUnauthorized,
+		},
+	},
+	"GET /events/ws": {
+		Summary: "Stream of events visible to the user over WebSocket, each text message is Event json",
+		Params:  streamParams,
+		Responses: map[int]apiResponse{
+			http.StatusSwitchingProtocols: {Description: "WebSocket connection"},
+			http.StatusBadRequest:         {Description: "Bad last_event_id"},
+			http.StatusUnauthorized:       respUnauthorized,
+			http.StatusForbidden:          {Description: "Origin of the page is not the api or one of allowed_origins"},
+		},
+	},
+	"GET /offers/:id/revisions": {
+		Summary: "Negotiation history of the offer, for the supplier of the offer and the investors of its contract",
+		Params:  append([]apiParam{pathID}, pageParams...),
+		Responses: map[int]apiResponse{
+			http.StatusOK:           {Description: "Revisions", Body: []OfferRevision{}},
+			http.StatusBadRequest:   respBadRequest,
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusNotFound:     {Description: "Offer not found or the user is not its party"},
+		},
+	},
+	"GET /offers/:id/encoded": {
+		Summary: "Retrieve encoded terms of the latest revision, the investor signs these bytes to accept the offer. X-Sirius-Revision header is the revision number. For the supplier of the offer and the investors of its contract",
+		Params:  []apiParam{pathID},
+		Responses: map[int]apiResponse{
+			http.StatusOK:           {Description: "Encoded terms", Body: ContractBody{}},
+			http.StatusBadRequest:   respBadRequest,
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusNotFound:     {Description: "Offer not found or the user is not its party"},
+		},
+	},
+	"POST /offers/:id/revisions": {
+		Summary: "Amend the offer (supplier) or make a counter-offer (investor), the author signs the encoded ContractBody with the amended terms",
+		Params:  []apiParam{pathID},
+		Request: RevisionQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusCreated:      {Description: "Revision created", Body: CreatedResponse{}},
+			http.StatusBadRequest:   {Description: "Malformed request, bad milestones or bad signature"},
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusNotFound:     {Description: "Offer not found"},
+			http.StatusConflict:     {Description: "Offer was revised meanwhile, expired or made on an earlier version of the contract (only the supplier may renew it), the contract is concluded or its bidding is closed (supplier)"},
+			http.StatusBadGateway:   {Description: "User's certificate could not be loaded"},
+		},
+	},
+	"POST /contracts/:id/amendments": {
+		Summary: "Propose an amendment of the signed contract (creator or supplier), the proposer signs the encoded ContractBody with the amended terms. The amount and the paid milestones are kept",
+		Params:  []apiParam{pathID},
+		Request: AmendmentQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusCreated:      {Description: "Amendment proposed", Body: CreatedResponse{}},
+			http.StatusBadRequest:   {Description: "Malformed request, bad milestones, amount changed, nothing changed or bad signature"},
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusNotFound:     {Description: "Contract not found or the user is not its party"},
+			http.StatusConflict:     {Description: "Contract is not signed, a paid milestone is changed or another amendment is pending"},
+			http.StatusBadGateway:   {Description: "User's certificate could not be loaded"},
+		},
+	},
+	"POST /contracts/:id/cancellation": {
+		Summary: "Request the cancellation of the signed contract (creator or supplier), when both parties have requested it the contract is archived and the money left in its escrow is refunded to its investors",
+		Params:  []apiParam{pathID},
+		Responses: map[int]apiResponse{
+			http.StatusOK:           {Description: "Contract cancelled and archived", Body: CancellationResponse{}},
+			http.StatusAccepted:     {Description: "Cancellation requested, waiting for the other party", Body: CancellationResponse{}},
+			http.StatusBadRequest:   respBadRequest,
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusNotFound:     {Description: "Contract not found or the user is not its party"},
+			http.StatusConflict:     {Description: "Contract is not signed"},
+		},
+	},
+	"POST /contracts/:id/amendments/:amendment/accept": {
+		Summary: "Accept the pending amendment by the other party, who signs its encoded Body. The amendment becomes the next version of the contract, the signatures the contract was accepted with are kept",
+		Params:  []apiParam{pathID, amendmentParam},
+		Request: AmendmentAcceptionQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:           {Description: "New version, the ETag header is the new revision of the contract", Body: ContractVersion{}},
+			http.StatusBadRequest:   {Description: "Malformed request or bad signature"},
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusNotFound:     {Description: "Contract or amendment not found"},
+			http.StatusConflict:     {Description: "Amendment is not pending, was proposed by the user or the contract was amended since"},
+			http.StatusBadGateway:   {Description: "User's certificate could not be loaded"},
+		},
+	},
+	"POST /contracts/:id/amendments/:amendment/reject": {
+		Summary: "Reject the pending amendment by the other party or withdraw it by its proposer",
+		Params:  []apiParam{pathID, amendmentParam},
+		Responses: map[int]apiResponse{
+			http.StatusOK:           respOK,
+			http.StatusBadRequest:   respBadRequest,
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusNotFound:     {Description: "Contract or amendment not found"},
+			http.StatusConflict:     {Description: "Amendment is not pending"},
+		},
+	},
+	"GET /contracts/:id/bids": {
+		Summary: "List sealed bids of the contract, suppliers and ciphertexts are hidden until the bidding deadline. The investors of the contract see all the bids, a supplier sees only its own",
+		Params:  append([]apiParam{pathID}, pageParams...),
+		Responses: map[int]apiResponse{
+			http.StatusOK:           {Description: "Sealed bids", Body: []SealedBid{}},
+			http.StatusBadRequest:   respBadRequest,
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusNotFound:     {Description: "Sealed contract not found or the investor is not its party"},
+		},
+	},
+	"POST /bids/:id/reveal": {
+		Summary: "Reveal a sealed bid after the bidding deadline (supplier, or investor who decrypted it), the bid becomes an offer",
+		Params:  []apiParam{pathID},
+		Request: RevealQuery{},
+		Responses: map[int]apiResponse{
+			http.StatusCreated:      {Description: "Offer created", Body: CreatedResponse{}},
+			http.StatusBadRequest:   {Description: "Malformed request, reveal does not match the commitment or bad signature"},
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusNotFound:     {Description: "Bid not found"},
+			http.StatusConflict:     {Description: "Bid is already revealed, the contract is concluded, bidding is not closed or reveal phase is over"},
+			http.StatusBadGateway:   {Description: "Supplier's certificate could not be loaded"},
+		},
+	},
+	"POST /webhooks/:id/deliveries/:delivery/replay": {
+		Summary: "Send the event of the delivery once more",
+		Params: []apiParam{pathID,
+			{Name: "delivery", In: "path", Type: "integer", Description: "Delivery identifier"},
+		},
+		Responses: map[int]apiResponse{
+			http.StatusCreated:      {Description: "New delivery", Body: CreatedResponse{}},
+			http.StatusBadRequest:   respBadRequest,
+			http.StatusUnauthorized: respUnauthorized,
+			http.StatusNotFound:     {Description: "Webhook or delivery not found"},
+		},
+	},
+}
+
+func init() {
+	for route, doc := range userRouteDocs {
+		parts := strings.SplitN(route, " ", 2)
+		investorDoc, supplierDoc := doc, doc
+		investorDoc.Auth, supplierDoc.Auth = authInvestor, authSupplier
+		apiDocs[parts[0]+" /investors"+parts[1]] = investorDoc
+		apiDocs[parts[0]+" /suppliers"+parts[1]] = supplierDoc
+	}
+}
+
+// openAPISchemas builds json schemas of go types, the way encoding/json marshals them
+type openAPISchemas map[string]interface{}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	timestampType = reflect.TypeOf(Timestamp{})
+	rawType       = reflect.TypeOf(json.RawMessage{})
+)
+
+func (s openAPISchemas) schemaOf(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType || t == timestampType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawType:
+		return map[string]interface{}{} // any json value
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return map[string]interface{}{"type": "string", "format": "byte"}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.schemaOf(t.Elem())}
+	case t.Kind() == reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schemaOf(t.Elem())}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		name := t.Name()
+		if _, ok := s[name]; !ok {
+			s[name] = nil // placeholder to break recursion
+			s[name] = s.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	case t.Kind() == reflect.Struct:
+		return s.structSchema(t)
+	}
+	return map[string]interface{}{}
+}
+
+func (s openAPISchemas) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	s.collectFields(t, properties)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+// collectFields follows encoding/json rules: unexported fields are skipped,
+// embedded structs are flattened, json tags rename fields
+func (s openAPISchemas) collectFields(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		if f.Anonymous && opts[0] == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				s.collectFields(ft, properties)
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if opts[0] != "" {
+			name = opts[0]
+		}
+		schema := s.schemaOf(f.Type)
+		for _, o := range opts[1:] {
+			if o == "string" {
+				schema = map[string]interface{}{"type": "string"}
+			}
+		}
+		properties[name] = schema
+	}
+}
+
+func (s openAPISchemas) bodyOf(v interface{}) map[string]interface{} {
+	if v == nil {
+		return map[string]interface{}{"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}}
+	}
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": s.schemaOf(reflect.TypeOf(v))}}
+}
+
+// BuildOpenAPI generates OpenAPI 3 document for the registered echo routes,
+// routes without an entry in apiDocs are still listed
+func BuildOpenAPI(routes []*echo.Route) map[string]interface{} {
+	schemas := make(openAPISchemas)
+	paths := make(map[string]map[string]interface{})
+
+	for _, r := range routes {
+		doc, ok := apiDocs[r.Method+" "+r.Path]
+		if !ok {
+			doc = apiOperation{Summary: r.Name}
+		}
+
+		segments := strings.Split(r.Path, "/")
+		for i, seg := range segments {
+			if strings.HasPrefix(seg, ":") {
+				segments[i] = "{" + seg[1:] + "}"
+			}
+		}
+		path := strings.Join(segments, "/")
+
+		op := map[string]interface{}{
+			"summary":     doc.Summary,
+			"operationId": operationID(r.Name) + userRouteSuffix(r.Method, r.Path),
+		}
+
+		var params []interface{}
+		for _, p := range doc.Params {
+			params = append(params, map[string]interface{}{
+				"name":        p.Name,
+				"in":          p.In,
+				"required":    p.In == "path",
+				"description": p.Description,
+				"schema":      map[string]interface{}{"type": p.Type},
+			})
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+
+		if doc.Request != nil {
+			body := schemas.bodyOf(doc.Request)
+			body["application/x-www-form-urlencoded"] = body["application/json"]
+			op["requestBody"] = map[string]interface{}{"required": true, "content": body}
+		}
+
+		responses := make(map[string]interface{})
+		for code, resp := range doc.Responses {
+			responses[strconv.Itoa(code)] = map[string]interface{}{
+				"description": resp.Description,
+				"content":     schemas.bodyOf(resp.Body),
+			}
+		}
+		if len(responses) == 0 {
+			responses["default"] = map[string]interface{}{"description": "Undocumented response"}
+		}
+		// every route is rate limited, the RateLimit headers are sent with the responses
+		if _, ok := responses[strconv.Itoa(http.StatusTooManyRequests)]; !ok {
+			responses[strconv.Itoa(http.StatusTooManyRequests)] = map[string]interface{}{
+				"description": "Rate limit exceeded, retry after Retry-After seconds",
+				"content":     schemas.bodyOf(nil),
+			}
+		}
+		op["responses"] = responses
+
+		if doc.Auth == authSession {
+			op["security"] = []interface{}{map[string]interface{}{doc.Auth: []string{}}}
+		} else if doc.Auth != "" {
+			// the session of the challenge-response login is accepted in place of the token
+			op["security"] = []interface{}{map[string]interface{}{doc.Auth: []string{}}, map[string]interface{}{authSession: []string{}}}
+		}
+
+		if paths[path] == nil {
+			paths[path] = make(map[string]interface{})
+		}
+		paths[path][strings.ToLower(r.Method)] = op
+	}
+
+	tokenScheme := func(service string) map[string]interface{} {
+		return map[string]interface{}{
+			"type":        "apiKey",
+			"in":          "header",
+			"name":        echo.HeaderAuthorization,
+			"description": "\"Token <token>\", token is issued by " + service,
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Sirius Contracts Service",
+			"version": "1.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				authInvestor: tokenScheme("Canopus (investors service)"),
+				authSupplier: tokenScheme("Vega (clients service)"),
+				authSession: map[string]interface{}{
+					"type":        "apiKey",
+					"in":          "header",
+					"name":        echo.HeaderAuthorization,
+					"description": "\"Session <token>\", token is issued by POST /auth/sessions",
+				},
+			},
+		},
+	}
+}
+
+// userRouteSuffix tells apart the operations of the routes registered both for investors and suppliers
+// with the same handler, operation ids must be unique
+func userRouteSuffix(method, path string) string {
+	for _, prefix := range []string{"/investors", "/suppliers"} {
+		if _, ok := userRouteDocs[method+" "+strings.TrimPrefix(path, prefix)]; ok && strings.HasPrefix(path, prefix+"/") {
+			return "As" + strings.Title(strings.TrimSuffix(prefix[1:], "s"))
+		}
+	}
+	return ""
+}
+
+// operationID turns echo route name (handler function name like "main.ListContracts"
+// or "main.OpenAPIHandler.func1") into operation id
+func operationID(name string) string {
+	parts := strings.Split(name, ".")
+	for i := len(parts) - 1; i >= 0; i-- {
+		if !strings.HasPrefix(parts[i], "func") {
+			return parts[i]
+		}
+	}
+	return name
+}
+
+// CheckAPIDocs logs the routes which are missing in apiDocs and the docs without a route
+func CheckAPIDocs(routes []*echo.Route) []string {
+	registered := make(map[string]bool)
+	var problems []string
+	for _, r := range routes {
+		key := r.Method + " " + r.Path
+		registered[key] = true
+		if _, ok := apiDocs[key]; !ok {
+			problems = append(problems, "undocumented route "+key)
+		}
+	}
+	for key := range apiDocs {
+		if !registered[key] {
+			problems = append(problems, "documented route is not registered "+key)
+		}
+	}
+	sort.Strings(problems)
+	for _, p := range problems {
+		log.Print("openapi: ", p)
+	}
+	return problems
+}
+
+// OpenAPIHandler - api controller serving the generated OpenAPI document
+func OpenAPIHandler(e *echo.Echo) echo.HandlerFunc {
+	var once sync.Once
+	var spec map[string]interface{}
+	return func(c echo.Context) error {
+		once.Do(func() {
+			spec = BuildOpenAPI(e.Routes())
+		})
+		return c.JSON(http.StatusOK, spec)
+	}
+}
+
+const docsPage = `<!DOCTYPE html>
+<html>
+<head>
+	<title>Sirius Contracts Service</title>
+	<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+	<div id="swagger-ui"></div>
+	<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+	<script>
+		SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});
+	</script>
+</body>
+</html>
+`
+
+// DocsHandler - api controller serving interactive documentation for /openapi.json
+func DocsHandler(c echo.Context) error {
+	return c.HTML(http.StatusOK, docsPage)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+func newTestServer() *echo.Echo {
+	e := echo.New()
+	registerRoutes(e)
+	return e
+}
+
+func TestCheckAPIDocs(t *testing.T) {
+	e := newTestServer()
+	if problems := CheckAPIDocs(e.Routes()); len(problems) != 0 {
+		t.Errorf("api docs drifted from the routes:\n%s", strings.Join(problems, "\n"))
+	}
+}
+
+// openAPIDocument is the part of the OpenAPI 3 document checked by the tests
+type openAPIDocument struct {
+	OpenAPI string
+	Info    struct {
+		Title   string
+		Version string
+	}
+	Paths      map[string]map[string]openAPIOperation
+	Components struct {
+		Schemas         map[string]json.RawMessage
+		SecuritySchemes map[string]json.RawMessage
+	}
+}
+
+type openAPIOperation struct {
+	OperationID string
+	Parameters  []struct {
+		Name     string
+		In       string
+		Required bool
+	}
+	Responses map[string]struct {
+		Description string
+		Content     map[string]struct {
+			Schema map[string]interface{}
+		}
+	}
+	Security []map[string][]string
+}
+
+func fetchOpenAPI(t *testing.T) *openAPIDocument {
+	e := newTestServer()
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json: %d %s", rec.Code, rec.Body.String())
+	}
+	doc := &openAPIDocument{}
+	if err := json.Unmarshal(rec.Body.Bytes(), doc); err != nil {
+		t.Fatalf("GET /openapi.json: %v", err)
+	}
+	return doc
+}
+
+var pathParam = regexp.MustCompile(`\{([^}]+)\}`)
+
+func TestOpenAPIDocument(t *testing.T) {
+	doc := fetchOpenAPI(t)
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("openapi = %q, want 3.x", doc.OpenAPI)
+	}
+	if doc.Info.Title == "" || doc.Info.Version == "" {
+		t.Errorf("info = %+v, title and version are required", doc.Info)
+	}
+	if len(doc.Paths) == 0 {
+		t.Fatal("no paths")
+	}
+
+	operationIDs := make(map[string]string)
+	for path, operations := range doc.Paths {
+		if strings.Contains(path, ":") {
+			t.Errorf("%s: echo path parameter is not converted", path)
+		}
+		for method, op := range operations {
+			route := strings.ToUpper(method) + " " + path
+			if op.OperationID == "" {
+				t.Errorf("%s: no operationId", route)
+			} else if other, ok := operationIDs[op.OperationID]; ok {
+				t.Errorf("%s: operationId %s is used by %s as well", route, op.OperationID, other)
+			}
+			operationIDs[op.OperationID] = route
+
+			declared := make(map[string]bool)
+			for _, p := range op.Parameters {
+				if p.In == "path" {
+					declared[p.Name] = true
+					if !p.Required {
+						t.Errorf("%s: path parameter %s is not required", route, p.Name)
+					}
+				}
+			}
+			for _, m := range pathParam.FindAllStringSubmatch(path, -1) {
+				if !declared[m[1]] {
+					t.Errorf("%s: path parameter %s is not declared", route, m[1])
+				}
+			}
+
+			if len(op.Responses) == 0 {
+				t.Errorf("%s: no responses", route)
+			}
+			for code, resp := range op.Responses {
+				if resp.Description == "" {
+					t.Errorf("%s: response %s has no description", route, code)
+				}
+				for _, content := range resp.Content {
+					checkRefs(t, doc, route, content.Schema)
+				}
+			}
+			for _, requirement := range op.Security {
+				for scheme := range requirement {
+					if _, ok := doc.Components.SecuritySchemes[scheme]; !ok {
+						t.Errorf("%s: unknown security scheme %s", route, scheme)
+					}
+				}
+			}
+		}
+	}
+}
+
+// checkRefs reports the references of the schema to undefined component schemas
+func checkRefs(t *testing.T, doc *openAPIDocument, route string, schema interface{}) {
+	switch s := schema.(type) {
+	case map[string]interface{}:
+		if ref, ok := s["$ref"].(string); ok {
+			name := strings.TrimPrefix(ref, "#/components/schemas/")
+			if _, ok := doc.Components.Schemas[name]; !ok {
+				t.Errorf("%s: unresolved $ref %s", route, ref)
+			}
+		}
+		for _, v := range s {
+			checkRefs(t, doc, route, v)
+		}
+	case []interface{}:
+		for _, v := range s {
+			checkRefs(t, doc, route, v)
+		}
+	}
+}
+
+func TestOpenAPICreateContractResponse(t *testing.T) {
+	doc := fetchOpenAPI(t)
+	op, ok := doc.Paths["/contracts"]["post"]
+	if !ok {
+		t.Fatal("POST /contracts is not documented")
+	}
+	created := op.Responses["201"].Content["application/json"].Schema
+	ref, _ := created["$ref"].(string)
+	name := strings.TrimPrefix(ref, "#/components/schemas/")
+
+	var schema struct {
+		Type       string
+		Properties map[string]struct {
+			Type string
+		}
+	}
+	if err := json.Unmarshal(doc.Components.Schemas[name], &schema); err != nil {
+		t.Fatalf("schema %q of the 201 response: %v", ref, err)
+	}
+	if schema.Type != "object" || schema.Properties["id"].Type != "integer" {
+		t.Errorf("201 response schema = %+v, want object with integer id", schema)
+	}
+}
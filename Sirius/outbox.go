@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+)
+
+const (
+	outboxPollPeriod = time.Second
+	outboxBatchSize  = 100
+	outboxMaxBackoff = time.Minute
+)
+
+// outboxWake wakes up the relay when new events are stored
+var outboxWake = make(chan struct{}, 1)
+
+func wakeOutboxRelay() {
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// RunOutboxRelay publishes the stored events to the broker in order of their IDs.
+// An event is marked published only after the broker confirmed it, so events emitted
+// while the broker is down are published once it is back. Delivery is at least once,
+// consumers deduplicate by message ID.
+func RunOutboxRelay(broker Broker) {
+	backoff := outboxPollPeriod
+	for {
+		select {
+		case <-time.After(backoff):
+		case <-outboxWake:
+		}
+		n, err := relayOutbox(broker)
+		if err != nil {
+			log.Print("outbox: ", err)
+			if backoff *= 2; backoff > outboxMaxBackoff {
+				backoff = outboxMaxBackoff
+			}
+			continue
+		}
+		backoff = outboxPollPeriod
+		if n == outboxBatchSize {
+			wakeOutboxRelay()
+		}
+	}
+}
+
+// relayOutbox publishes a batch of unpublished events and returns how many were published
+func relayOutbox(broker Broker) (int, error) {
+	db, err := sql.Open(dbDriver, dbName)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("id")
+	sb.From("events")
+	sb.Where(sb.IsNull("published"))
+	sb.OrderBy("id")
+	sb.Limit(outboxBatchSize)
+	q, args := sb.Build()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var messages []Message
+	for _, id := range ids {
+		ev, err := loadEvent(db, id)
+		if err != nil {
+			return 0, err
+		}
+		body, err := json.Marshal(ev)
+		if err != nil {
+			return 0, err
+		}
+		ts, _ := time.Parse(time.RFC3339, ev.Created)
+		messages = append(messages, Message{
+			ID:         strconv.FormatInt(id, 10),
+			RoutingKey: ev.Type,
+			Timestamp:  ts,
+			Body:       body,
+		})
+	}
+
+	for i, msg := range messages {
+		if err := broker.Publish(msg); err != nil {
+			return i, err
+		}
+		ub := sqlbuilder.NewUpdateBuilder()
+		ub.Update("events")
+		ub.Set(ub.Assign("published", time.Now().Format(time.RFC3339)))
+		ub.Where(ub.Equal("id", ids[i]))
+		q, args := ub.Build()
+		if _, err := db.Exec(q, args...); err != nil {
+			return i, err
+		}
+	}
+	return len(messages), nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// confirmingBroker checks that an event is not marked published before Publish returns,
+// and fails the publishing of the event in failOn once
+type confirmingBroker struct {
+	MemoryBroker
+	t      *testing.T
+	failOn string
+}
+
+func (b *confirmingBroker) Publish(msg Message) error {
+	if published := publishedEvents(b.t)[msg.ID]; published {
+		b.t.Errorf("event %s is marked published before the broker confirmed it", msg.ID)
+	}
+	if msg.ID == b.failOn {
+		b.failOn = ""
+		return errors.New("broker is down")
+	}
+	return b.MemoryBroker.Publish(msg)
+}
+
+// publishedEvents returns the published flags of the stored events by message ID
+func publishedEvents(t *testing.T) map[string]bool {
+	t.Helper()
+	db, err := sql.Open(dbDriver, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT id, published IS NOT NULL FROM events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	published := make(map[string]bool)
+	for rows.Next() {
+		var id int64
+		var ok bool
+		if err := rows.Scan(&id, &ok); err != nil {
+			t.Fatal(err)
+		}
+		published[strconv.FormatInt(id, 10)] = ok
+	}
+	return published
+}
+
+func checkPublished(t *testing.T, want map[string]bool) {
+	t.Helper()
+	published := publishedEvents(t)
+	for id, ok := range want {
+		if published[id] != ok {
+			t.Errorf("event %s published = %v, want %v", id, published[id], ok)
+		}
+	}
+}
+
+func TestOutboxRelayRecovers(t *testing.T) {
+	useTestDB(t)
+	for i := 0; i < 3; i++ {
+		emitTestEvent(t)
+	}
+	broker := &confirmingBroker{t: t}
+
+	broker.Err = errors.New("broker is down")
+	if n, err := relayOutbox(broker); err == nil || n != 0 {
+		t.Fatalf("relay to the broker which is down: %d, %v", n, err)
+	}
+	checkPublished(t, map[string]bool{"1": false, "2": false, "3": false})
+
+	broker.Err = nil
+	broker.failOn = "2"
+	if n, err := relayOutbox(broker); err == nil || n != 1 {
+		t.Fatalf("relay failing on the second event: %d, %v", n, err)
+	}
+	checkPublished(t, map[string]bool{"1": true, "2": false, "3": false})
+
+	if n, err := relayOutbox(broker); err != nil || n != 2 {
+		t.Fatalf("relay after recovery: %d, %v", n, err)
+	}
+	checkPublished(t, map[string]bool{"1": true, "2": true, "3": true})
+	if n, err := relayOutbox(broker); err != nil || n != 0 {
+		t.Fatalf("relay of published events: %d, %v", n, err)
+	}
+
+	messages := broker.Messages()
+	if len(messages) != 3 {
+		t.Fatalf("%d messages published, want 3", len(messages))
+	}
+	for i, msg := range messages {
+		if msg.ID != strconv.Itoa(i+1) || msg.RoutingKey != EventContractCreated {
+			t.Errorf("message %d: %s %s, want events in order, each once", i, msg.ID, msg.RoutingKey)
+		}
+	}
+}
+
+func TestCreateContractIsRelayed(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t, func(cfg *Config) { cfg.DailyContracts = 0 })
+	select {
+	case <-outboxWake:
+	default:
+	}
+
+	if rec := postIdempotent(newIdempotentServer(), ""); rec.Code != http.StatusCreated {
+		t.Fatalf("create contract: %d %s", rec.Code, rec.Body.String())
+	}
+	select {
+	case <-outboxWake:
+	default:
+		t.Fatal("relay is not woken up after the contract is created")
+	}
+
+	broker := &MemoryBroker{}
+	if n, err := relayOutbox(broker); err != nil || n != 1 {
+		t.Fatalf("relay: %d, %v", n, err)
+	}
+	messages := broker.Messages()
+	if len(messages) != 1 || messages[0].RoutingKey != EventContractCreated {
+		t.Fatalf("published %v, want the contract.created event", messages)
+	}
+	checkPublished(t, map[string]bool{messages[0].ID: true})
+}
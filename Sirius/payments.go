@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Payment providers of payment_provider
+const (
+	PaymentFake = "fake"
+)
+
+// errPaymentDeclined is returned by Charge when the provider refuses the payment
+var errPaymentDeclined = errors.New("Payment declined")
+
+// PaymentProvider collects the deposits of the users from their payment methods
+type PaymentProvider interface {
+	// Charge collects the amount from the user and returns the reference of the payment, it returns
+	// errPaymentDeclined when the payment is declined. The amount is collected once for the key,
+	// a charge retried with the key returns the reference of the first one.
+	Charge(key string, kind string, userID int64, amount Money) (string, error)
+}
+
+// FakePaymentProvider accepts every charge without collecting money, it is used for development
+// when payment_provider is fake. Limit declines larger charges when it is not 0.
+type FakePaymentProvider struct {
+	Limit int64
+
+	mu      sync.Mutex
+	charges map[string]string
+}
+
+// Charge implements PaymentProvider
+func (p *FakePaymentProvider) Charge(key string, kind string, userID int64, amount Money) (string, error) {
+	if p.Limit != 0 && amount.Amount > p.Limit {
+		return "", errPaymentDeclined
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if reference, ok := p.charges[key]; ok {
+		return reference, nil
+	}
+	if p.charges == nil {
+		p.charges = make(map[string]string)
+	}
+	reference := fmt.Sprintf("fake-%d-%d", time.Now().Unix(), len(p.charges)+1)
+	p.charges[key] = reference
+	return reference, nil
+}
+
+// NewPaymentProvider returns the provider of payment_provider, the service does not start without one
+func NewPaymentProvider(name string) (PaymentProvider, error) {
+	switch name {
+	case PaymentFake:
+		return &FakePaymentProvider{}, nil
+	case "":
+		return nil, errors.New("payment_provider is not configured")
+	}
+	return nil, errors.New("unknown payment_provider " + name)
+}
+
+// paymentProvider backs the deposits, it is set at startup
+var paymentProvider PaymentProvider
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// Scopes of the rejected requests
+const (
+	RateLimitIP    = "ip"
+	RateLimitUser  = "user"
+	RateLimitQuota = "quota"
+)
+
+// rateLimit allows requests in bursts of up to requests, refilled evenly over period
+type rateLimit struct {
+	requests int
+	period   time.Duration
+}
+
+// parseRateLimit parses a limit formatted as requests/period like 10/1m, empty means no limit
+func parseRateLimit(s string) (*rateLimit, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("rate limit " + s + " is not requests/period")
+	}
+	requests, err := strconv.Atoi(parts[0])
+	if err != nil || requests <= 0 {
+		return nil, errors.New("rate limit " + s + " must allow a positive number of requests")
+	}
+	period, err := time.ParseDuration(parts[1])
+	if err != nil || period <= 0 {
+		return nil, errors.New("rate limit " + s + " must have a positive period")
+	}
+	return &rateLimit{requests, period}, nil
+}
+
+// parseRouteRateLimits parses the limits of the routes formatted as METHOD /path=requests/period
+// separated by commas, the paths are the ones of the registered routes like POST /contracts/:id/award
+func parseRouteRateLimits(s string) (map[string]*rateLimit, error) {
+	limits := make(map[string]*rateLimit)
+	if strings.TrimSpace(s) == "" {
+		return limits, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(parts) != 2 || len(strings.Fields(parts[0])) != 2 {
+			return nil, errors.New("route rate limit " + item + " is not METHOD /path=requests/period")
+		}
+		limit, err := parseRateLimit(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		limits[strings.Join(strings.Fields(parts[0]), " ")] = limit
+	}
+	return limits, nil
+}
+
+// parseTrustedProxies parses comma separated addresses and CIDR ranges
+func parseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		if ip := net.ParseIP(item); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, errors.New("trusted proxy " + item + " is not an address or a CIDR range")
+		}
+		proxies = append(proxies, ipNet)
+	}
+	return proxies, nil
+}
+
+// rateLimits are the rate limits of a configuration, they are parsed once by Config.validate
+type rateLimits struct {
+	ip             *rateLimit
+	user           *rateLimit
+	routes         map[string]*rateLimit
+	trustedProxies []*net.IPNet
+}
+
+// parseRateLimits parses the rate limits of the configuration, all the problems are reported at once
+func parseRateLimits(cfg *Config) (*rateLimits, []string) {
+	limits := &rateLimits{}
+	var problems []string
+	var err error
+	if limits.ip, err = parseRateLimit(cfg.IPRateLimit); err != nil {
+		problems = append(problems, "ip_rate_limit: "+err.Error())
+	}
+	if limits.user, err = parseRateLimit(cfg.UserRateLimit); err != nil {
+		problems = append(problems, "user_rate_limit: "+err.Error())
+	}
+	if limits.routes, err = parseRouteRateLimits(cfg.RouteRateLimits); err != nil {
+		problems = append(problems, "route_rate_limits: "+err.Error())
+	}
+	if limits.trustedProxies, err = parseTrustedProxies(cfg.TrustedProxies); err != nil {
+		problems = append(problems, "trusted_proxies: "+err.Error())
+	}
+	return limits, problems
+}
+
+// trusted reports whether the address is one of the trusted proxies
+func (l *rateLimits) trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	for _, proxy := range l.trustedProxies {
+		if ip != nil && proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddress returns the address the request came from. The forwarding headers are believed only
+// when the connection comes from a trusted proxy: the addresses of X-Forwarded-For are taken from
+// the right and the first one which is not a trusted proxy is the client's, as the proxy appends
+// the address it got the request from to the ones sent by the client.
+func (l *rateLimits) clientAddress(r *http.Request) string {
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+	if !l.trusted(addr) {
+		return addr
+	}
+	if forwarded := r.Header.Values(echo.HeaderXForwardedFor); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			addr = hop
+			if !l.trusted(hop) {
+				break
+			}
+		}
+		return addr
+	}
+	if realIP := strings.TrimSpace(r.Header.Get(echo.HeaderXRealIP)); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return addr
+}
+
+// tokenBucket holds the requests left to the key at updated
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter keeps a token bucket per key, the buckets which would be full are dropped from time to time
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+// limiter limits the requests of the addresses and of the users by their routes
+var limiter = &rateLimiter{buckets: make(map[string]*tokenBucket)}
+
+// rateLimitSweep is how often the idle buckets are dropped
+const rateLimitSweep = 10 * time.Minute
+
+// take takes a token from the bucket of the key and reports whether there was one, with the tokens
+// remaining, the time until the bucket is full again and the time until the next token
+func (l *rateLimiter) take(key string, limit *rateLimit, now time.Time) (ok bool, remaining int, reset, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) > rateLimitSweep {
+		for k, b := range l.buckets {
+			if now.Sub(b.updated) > rateLimitSweep {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+
+	capacity := float64(limit.requests)
+	// the refill of a token is kept as a float, a duration would be 0 when requests exceeds the
+	// nanoseconds of the period
+	perToken := float64(limit.period) / float64(limit.requests)
+	b, found := l.buckets[key]
+	if !found {
+		b = &tokenBucket{tokens: capacity, updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+float64(now.Sub(b.updated))/perToken)
+	b.updated = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		ok = true
+	} else {
+		retryAfter = time.Duration(math.Ceil((1 - b.tokens) * perToken))
+	}
+	reset = time.Duration(math.Ceil((capacity - b.tokens) * perToken))
+	return ok, int(b.tokens), reset, retryAfter
+}
+
+// limitRequest takes a token of the key and sets the RateLimit headers of the response,
+// it responds with 429 Too Many Requests and returns false when the limit is exceeded
+func limitRequest(c echo.Context, scope, key string, limit *rateLimit) (bool, error) {
+	ok, remaining, reset, retryAfter := limiter.take(key, limit, time.Now())
+	h := c.Response().Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(limit.requests))
+	h.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(seconds(reset)))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.requests, seconds(limit.period)))
+	if ok {
+		return true, nil
+	}
+	rateLimited.WithLabelValues(scope).Inc()
+	h.Set("Retry-After", strconv.Itoa(seconds(retryAfter)))
+	return false, c.String(http.StatusTooManyRequests, "Rate limit exceeded")
+}
+
+// seconds rounds the duration up to whole seconds
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// RateLimitMiddleware limits the requests of each client address to each route by ip_rate_limit,
+// it runs before the authentication so the upstream services are not called for the rejected requests.
+// The client address is the address of the connection unless it comes from one of trusted_proxies.
+func RateLimitMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		limits := currentConfig().limits
+		if limits.ip == nil {
+			return next(c)
+		}
+		key := RateLimitIP + " " + limits.clientAddress(c.Request()) + " " + c.Request().Method + " " + c.Path()
+		if ok, err := limitRequest(c, RateLimitIP, key, limits.ip); !ok {
+			return err
+		}
+		return next(c)
+	}
+}
+
+// limitUser limits the requests of the authenticated user to the route by the limit of the route
+// in route_rate_limits or by user_rate_limit, it is called by the authentication middlewares
+func limitUser(c echo.Context, kind string, id int64) (bool, error) {
+	limits := currentConfig().limits
+	route := c.Request().Method + " " + c.Path()
+	limit, ok := limits.routes[route]
+	if !ok {
+		limit = limits.user
+	}
+	if limit == nil {
+		return true, nil
+	}
+	return limitRequest(c, RateLimitUser, fmt.Sprintf("%s %s %d %s", RateLimitUser, kind, id, route), limit)
+}
+
+// quotaExceeded responds with 429 Too Many Requests to the request exceeding the quota, retryAfter
+// is zero when waiting does not help
+func quotaExceeded(c echo.Context, message string, retryAfter time.Duration) error {
+	rateLimited.WithLabelValues(RateLimitQuota).Inc()
+	if retryAfter > 0 {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(seconds(retryAfter)))
+	}
+	return c.String(http.StatusTooManyRequests, message)
+}
+
+// openOffers returns the number of the supplier's offers which did not expire on the contracts
+// which are not concluded
+func openOffers(db *sql.DB, supplierID int64) (int64, error) {
+	var n int64
+	err := db.QueryRow(`SELECT COUNT(*) FROM offers JOIN contracts ON contracts.id = offers.contract_id
+		WHERE offers.supplier_id = ? AND offers.expired IS NULL AND offers.deleted_at IS NULL AND contracts.stage = 0
+		AND contracts.deleted_at IS NULL`, supplierID).Scan(&n)
+	return n, err
+}
+
+// contractsCreatedToday returns the number of the contracts the investor created since the local
+// midnight and the time until the next one
+func contractsCreatedToday(db *sql.DB, investorID int64, now time.Time) (int64, time.Duration, error) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	var n int64
+	err := db.QueryRow("SELECT COUNT(*) FROM contracts WHERE investor_id = ? AND created >= ?",
+		investorID, midnight.Format(time.RFC3339)).Scan(&n)
+	return n, midnight.AddDate(0, 0, 1).Sub(now), err
+}
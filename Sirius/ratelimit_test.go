@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// useTestConfig puts the default configuration changed by set in effect for the test
+func useTestConfig(t *testing.T, set func(*Config)) {
+	t.Helper()
+	cfg := defaultConfig()
+	set(cfg)
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	old := currentConfig()
+	config.Store(cfg)
+	t.Cleanup(func() { config.Store(old) })
+}
+
+func TestClientAddress(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.TrustedProxies = "10.0.0.1, 192.168.0.0/16, ::1"
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		remoteAddr, forwardedFor, realIP, want string
+	}{
+		{"203.0.113.5:4000", "", "", "203.0.113.5"},
+		{"203.0.113.5:4000", "198.51.100.7", "198.51.100.8", "203.0.113.5"},
+		{"10.0.0.2:4000", "198.51.100.7", "", "10.0.0.2"},
+		{"10.0.0.1:4000", "198.51.100.7", "", "198.51.100.7"},
+		{"10.0.0.1:4000", "1.2.3.4, 198.51.100.7, 192.168.1.1", "", "198.51.100.7"},
+		{"10.0.0.1:4000", "192.168.1.1", "", "192.168.1.1"},
+		{"10.0.0.1:4000", "garbage, 198.51.100.7", "", "198.51.100.7"},
+		{"10.0.0.1:4000", "", "198.51.100.8", "198.51.100.8"},
+		{"[::1]:4000", "2001:db8::1", "", "2001:db8::1"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/contracts", nil)
+		req.RemoteAddr = test.remoteAddr
+		if test.forwardedFor != "" {
+			req.Header.Set(echo.HeaderXForwardedFor, test.forwardedFor)
+		}
+		if test.realIP != "" {
+			req.Header.Set(echo.HeaderXRealIP, test.realIP)
+		}
+		if got := cfg.limits.clientAddress(req); got != test.want {
+			t.Errorf("%s forwarded for %q, real ip %q: %s, want %s", test.remoteAddr, test.forwardedFor, test.realIP, got, test.want)
+		}
+	}
+}
+
+// limitedRequests sends the requests from remoteAddr with the X-Forwarded-For values through
+// RateLimitMiddleware and returns how many were rejected
+func limitedRequests(remoteAddr string, forwardedFor ...string) int {
+	e := echo.New()
+	e.GET("/contracts", func(c echo.Context) error { return c.String(http.StatusOK, "") }, RateLimitMiddleware)
+	rejected := 0
+	for _, addr := range forwardedFor {
+		req := httptest.NewRequest(http.MethodGet, "/contracts", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set(echo.HeaderXForwardedFor, addr)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code == http.StatusTooManyRequests {
+			rejected++
+		}
+	}
+	return rejected
+}
+
+func TestRateLimitIgnoresUntrustedForwardedFor(t *testing.T) {
+	limiter = &rateLimiter{buckets: make(map[string]*tokenBucket)}
+	useTestConfig(t, func(cfg *Config) { cfg.IPRateLimit = "2/1h" })
+	if n := limitedRequests("203.0.113.5:4000", "1.1.1.1", "2.2.2.2", "3.3.3.3", "4.4.4.4"); n != 2 {
+		t.Errorf("%d of 4 requests with spoofed X-Forwarded-For rejected, want 2", n)
+	}
+}
+
+func TestRateLimitTrustedProxy(t *testing.T) {
+	limiter = &rateLimiter{buckets: make(map[string]*tokenBucket)}
+	useTestConfig(t, func(cfg *Config) {
+		cfg.IPRateLimit = "2/1h"
+		cfg.TrustedProxies = "10.0.0.0/8"
+	})
+	if n := limitedRequests("10.0.0.1:4000", "1.1.1.1", "2.2.2.2", "3.3.3.3"); n != 0 {
+		t.Errorf("%d requests of different clients behind the proxy rejected", n)
+	}
+	if n := limitedRequests("10.0.0.1:4000", "5.5.5.5", "5.5.5.5", "5.5.5.5"); n != 1 {
+		t.Errorf("%d of 3 requests of a client behind the proxy rejected, want 1", n)
+	}
+}
+
+func TestValidateRateLimits(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.IPRateLimit = "ten/1m"
+	cfg.UserRateLimit = "10/0s"
+	cfg.RouteRateLimits = "POST /offers"
+	cfg.TrustedProxies = "10.0.0.0/33"
+	err := cfg.validate()
+	if err == nil {
+		t.Fatal("invalid rate limits are accepted")
+	}
+	for _, field := range []string{"ip_rate_limit", "user_rate_limit", "route_rate_limits", "trusted_proxies"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("%s is not reported: %v", field, err)
+		}
+	}
+}
+
+func TestRateLimitOfMoreRequestsThanNanoseconds(t *testing.T) {
+	l := &rateLimiter{buckets: make(map[string]*tokenBucket)}
+	limit, err := parseRateLimit("10/5ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		if ok, _, _, _ := l.take("key", limit, now); !ok {
+			t.Fatalf("request %d is rejected", i+1)
+		}
+	}
+	ok, remaining, reset, retryAfter := l.take("key", limit, now)
+	if ok || remaining != 0 || reset <= 0 || retryAfter <= 0 {
+		t.Errorf("request over the limit: ok %v, remaining %d, reset %v, retry after %v", ok, remaining, reset, retryAfter)
+	}
+	if ok, _, _, _ := l.take("key", limit, now.Add(time.Nanosecond)); !ok {
+		t.Error("tokens are not refilled")
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/labstack/echo"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Roles of the users, every user has the role of its kind and may be granted the staff roles
+const (
+	RoleInvestor  = UserInvestor
+	RoleSupplier  = UserSupplier
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+	RoleAuditor   = "auditor"
+)
+
+// StaffRoles lists the roles which are granted
+var StaffRoles = []string{RoleModerator, RoleAdmin, RoleAuditor}
+
+// Actions checked by the policy
+const (
+	ActionContractDelete  = "contract.delete"
+	ActionContractRestore = "contract.restore"
+	ActionOfferDelete     = "offer.delete"
+	ActionOfferRestore    = "offer.restore"
+	ActionSignaturesRead  = "signatures.read"
+	ActionAuditRead       = "audit.read"
+	ActionLedgerCheck     = "ledger.check"
+	ActionRolesManage     = "roles.manage"
+	ActionMetricsRead     = "metrics.read"
+)
+
+// Scopes of the permissions: own allows the action on the resources of the user, any on all of them
+const (
+	ScopeOwn = "own"
+	ScopeAny = "any"
+)
+
+// rolePermissions is the policy, the scope of each action allowed to the role
+var rolePermissions = map[string]map[string]string{
+	RoleInvestor: {
+		ActionContractDelete:  ScopeOwn,
+		ActionContractRestore: ScopeOwn,
+	},
+	RoleSupplier: {
+		ActionOfferDelete:  ScopeOwn,
+		ActionOfferRestore: ScopeOwn,
+	},
+	RoleModerator: {
+		ActionContractDelete:  ScopeAny,
+		ActionContractRestore: ScopeAny,
+		ActionOfferDelete:     ScopeAny,
+		ActionOfferRestore:    ScopeAny,
+		ActionAuditRead:       ScopeAny,
+	},
+	RoleAuditor: {
+		ActionSignaturesRead: ScopeAny,
+		ActionAuditRead:      ScopeAny,
+		ActionLedgerCheck:    ScopeAny,
+		ActionMetricsRead:    ScopeAny,
+	},
+	RoleAdmin: {
+		ActionContractDelete:  ScopeAny,
+		ActionContractRestore: ScopeAny,
+		ActionOfferDelete:     ScopeAny,
+		ActionOfferRestore:    ScopeAny,
+		ActionSignaturesRead:  ScopeAny,
+		ActionAuditRead:       ScopeAny,
+		ActionLedgerCheck:     ScopeAny,
+		ActionRolesManage:     ScopeAny,
+		ActionMetricsRead:     ScopeAny,
+	},
+}
+
+// allowed reports whether one of the roles allows the action in the scope, ScopeAny includes ScopeOwn
+func allowed(roles []string, action, scope string) bool {
+	for _, role := range roles {
+		switch rolePermissions[role][action] {
+		case ScopeAny:
+			return true
+		case ScopeOwn:
+			if scope == ScopeOwn {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// configAdmins reports whether the user is an admin by the admins setting
+func configAdmins(kind string, id int64) bool {
+	for _, admin := range strings.Split(currentConfig().Admins, ",") {
+		if strings.TrimSpace(admin) == fmt.Sprintf("%s:%d", kind, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// userRoles returns the role of the kind of the user with the roles granted to the user
+func userRoles(db dbExecutor, kind string, id int64) ([]string, error) {
+	roles := []string{kind}
+	if configAdmins(kind, id) {
+		roles = append(roles, RoleAdmin)
+	}
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("role")
+	sb.From("user_roles")
+	sb.Where(sb.Equal("user_kind", kind), sb.Equal("user_id", id))
+	q, args := sb.Build()
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// requestRoles returns the roles of the user of the request like userRoles, the staff roles count only
+// when the user is authenticated by the session or the client certificate as in StaffAuthMiddleware,
+// the users of the tokens of the suppliers and investors services have the role of their kind
+func requestRoles(c echo.Context, db dbExecutor, kind string, id int64) ([]string, error) {
+	if userKind, userID, ok := requestUser(c); !ok || userKind != kind || userID != id {
+		return []string{kind}, nil
+	}
+	return userRoles(db, kind, id)
+}
+
+// StaffContext is the context of the requests authenticated by StaffAuthMiddleware
+type StaffContext struct {
+	echo.Context
+	UserKind string
+	UserID   int64
+	Roles    []string
+}
+
+// requestUser returns the user authenticated by the session or by the client certificate of the request
+func requestUser(c echo.Context) (string, int64, bool) {
+	if user, ok := c.Get(sessionUserKey).(*sessionUser); ok {
+		return user.kind, user.id, true
+	}
+	return certificateOwner(c)
+}
+
+// StaffAuthMiddleware lets the users whose roles allow the action on any resource in, the staff
+// authenticate by the session of the challenge-response login or by the client certificate, the tokens
+// of the suppliers and investors services are not accepted
+func StaffAuthMiddleware(action string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			kind, id, ok := requestUser(c)
+			if !ok {
+				return echo.ErrUnauthorized
+			}
+			if ok, err := limitUser(c, kind, id); !ok {
+				return err
+			}
+
+			db, err := openDB(c.Request().Context())
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer db.Close()
+
+			roles, err := userRoles(db, kind, id)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if !allowed(roles, action, ScopeAny) {
+				return c.String(http.StatusForbidden, "Forbidden")
+			}
+			return next(StaffContext{c, kind, id, roles})
+		}
+	}
+}
+
+// RoleGrant is a staff role granted to a user
+type RoleGrant struct {
+	ID       int64
+	UserKind string
+	UserID   int64
+	Role     string
+	Created  string
+}
+
+// RoleQuery grants the role to the user
+type RoleQuery struct {
+	UserKind string
+	UserID   int64
+	Role     string
+}
+
+// ListRoles - api controller for obtaining the staff roles granted to the users,
+// the admins by the admins setting are not listed
+func ListRoles(c echo.Context) error {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("id", "user_kind", "user_id", "role", "created")
+	sb.From("user_roles")
+	if err := paginate(c, sb); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	q, args := sb.Build()
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	grants := []RoleGrant{}
+	for rows.Next() {
+		g := RoleGrant{}
+		if err := rows.Scan(&g.ID, &g.UserKind, &g.UserID, &g.Role, &g.Created); err != nil {
+			log.Fatal(err)
+		}
+		grants = append(grants, g)
+	}
+	return c.JSON(http.StatusOK, grants)
+}
+
+// GrantRole - api controller for granting a staff role to a user
+func GrantRole(c echo.Context) error {
+	sc := c.(StaffContext)
+	roleQuery := new(RoleQuery)
+	if err := c.Bind(roleQuery); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	if roleQuery.UserKind != UserInvestor && roleQuery.UserKind != UserSupplier {
+		return c.String(http.StatusBadRequest, "UserKind must be investor or supplier")
+	}
+	validRole := false
+	for _, role := range StaffRoles {
+		validRole = validRole || role == roleQuery.Role
+	}
+	if !validRole {
+		return c.String(http.StatusBadRequest, "Role must be one of "+strings.Join(StaffRoles, ", "))
+	}
+
+	grant := RoleGrant{
+		UserKind: roleQuery.UserKind,
+		UserID:   roleQuery.UserID,
+		Role:     roleQuery.Role,
+		Created:  time.Now().Format(time.RFC3339),
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto("user_roles")
+	ib.Cols("user_kind", "user_id", "role", "created")
+	ib.Values(grant.UserKind, grant.UserID, grant.Role, grant.Created)
+	q, args := ib.Build()
+	res, err := tx.Exec(q, args...)
+	if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+		return c.String(http.StatusConflict, "Role is already granted")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	grant.ID, err = res.LastInsertId()
+	if err != nil {
+		log.Fatal(err)
+	}
+	target := auditTarget(grant.UserKind, grant.UserID)
+	if err := recordAudit(tx, auditActor{sc.UserKind, sc.UserID}, ActionRolesManage, target, "grant "+grant.Role); err != nil {
+		log.Fatal(err)
+	}
+	if err := tx.Commit(); isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	return c.JSON(http.StatusCreated, grant)
+}
+
+// RevokeRole - api controller for revoking a staff role
+func RevokeRole(c echo.Context) error {
+	sc := c.(StaffContext)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("user_kind", "user_id", "role")
+	sb.From("user_roles")
+	sb.Where(sb.Equal("id", id))
+	q, args := sb.Build()
+	var grant RoleGrant
+	err = tx.QueryRow(q, args...).Scan(&grant.UserKind, &grant.UserID, &grant.Role)
+	if err == sql.ErrNoRows {
+		return c.String(http.StatusNotFound, "Role grant not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	dlb := sqlbuilder.NewDeleteBuilder()
+	dlb.DeleteFrom("user_roles")
+	dlb.Where(dlb.Equal("id", id))
+	q, args = dlb.Build()
+	if _, err := tx.Exec(q, args...); err != nil {
+		log.Fatal(err)
+	}
+	target := auditTarget(grant.UserKind, grant.UserID)
+	if err := recordAudit(tx, auditActor{sc.UserKind, sc.UserID}, ActionRolesManage, target, "revoke "+grant.Role); err != nil {
+		log.Fatal(err)
+	}
+	if err := tx.Commit(); isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	return c.String(http.StatusOK, "")
+}
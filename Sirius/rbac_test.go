@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// grantTestRole grants the staff role to the user
+func grantTestRole(t *testing.T, u *testUser, role string) {
+	t.Helper()
+	execTestDB(t, "INSERT INTO user_roles (user_kind, user_id, role, created) VALUES (?, ?, ?, ?)",
+		u.kind, u.id, role, time.Now().UTC().Format(time.RFC3339))
+}
+
+func TestGrantAndRevokeRole(t *testing.T) {
+	api := newTestAPI(t)
+	useTestConfig(t, func(cfg *Config) {
+		cfg.DailyContracts = 0
+		cfg.Admins = "investor:1"
+	})
+	admin, moderator := api.user(UserInvestor, 1), api.user(UserSupplier, 7)
+
+	grant := RoleQuery{UserKind: UserSupplier, UserID: 7, Role: RoleModerator}
+	expectStatus(t, "grant by a user who is not an admin", moderator.do(http.MethodPost, "/admin/roles", grant), http.StatusForbidden)
+	expectStatus(t, "grant of an unknown role", admin.do(http.MethodPost, "/admin/roles", RoleQuery{UserKind: UserSupplier, UserID: 7, Role: RoleAdmin + "s"}), http.StatusBadRequest)
+	rec := admin.do(http.MethodPost, "/admin/roles", grant)
+	var granted RoleGrant
+	if rec.Code != http.StatusCreated || json.Unmarshal(rec.Body.Bytes(), &granted) != nil {
+		t.Fatalf("grant: %d %s", rec.Code, rec.Body.String())
+	}
+	expectStatus(t, "grant of a granted role", admin.do(http.MethodPost, "/admin/roles", grant), http.StatusConflict)
+
+	rec = admin.do(http.MethodGet, "/admin/roles", nil)
+	var grants []RoleGrant
+	if err := json.Unmarshal(rec.Body.Bytes(), &grants); err != nil || len(grants) != 1 || grants[0].ID != granted.ID {
+		t.Errorf("roles %s, want the grant %d", rec.Body.String(), granted.ID)
+	}
+	expectStatus(t, "moderator reads the roles", moderator.do(http.MethodGet, "/admin/roles", nil), http.StatusForbidden)
+
+	revoke := "/admin/roles/" + strconv.FormatInt(granted.ID, 10)
+	expectStatus(t, "revocation", admin.do(http.MethodDelete, revoke, nil), http.StatusOK)
+	expectStatus(t, "revocation of a revoked role", admin.do(http.MethodDelete, revoke, nil), http.StatusNotFound)
+	if n := countRows(t, "user_roles"); n != 0 {
+		t.Errorf("%d roles left after the revocation", n)
+	}
+	if n := countRows(t, "audit_log"); n != 2 {
+		t.Errorf("%d audit entries, want the grant and the revocation", n)
+	}
+}
+
+func TestRoleScopes(t *testing.T) {
+	api := newTestAPI(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the token of the investors service is the ID of its investor
+		fmt.Fprint(w, strings.TrimPrefix(r.URL.Path, "/auth/"))
+	}))
+	defer upstream.Close()
+	useTestConfig(t, func(cfg *Config) {
+		cfg.DailyContracts = 0
+		cfg.InvestorsURL = upstream.URL
+	})
+	owner, moderator, auditor := api.user(UserInvestor, 1), api.user(UserInvestor, 2), api.user(UserInvestor, 3)
+	grantTestRole(t, moderator, RoleModerator)
+	grantTestRole(t, auditor, RoleAuditor)
+	first, second := owner.createContract(nil), owner.createContract(nil)
+
+	byToken := func(id int64, method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(echo.HeaderAuthorization, "Token "+strconv.FormatInt(id, 10))
+		rec := httptest.NewRecorder()
+		api.e.ServeHTTP(rec, req)
+		return rec
+	}
+	contract := func(id int64) string { return "/contracts/" + strconv.FormatInt(id, 10) }
+
+	// the staff roles are not used with the tokens of the investors service, as on the admin routes
+	expectStatus(t, "deletion by a moderator's token", byToken(2, http.MethodDelete, contract(first)), http.StatusNotFound)
+	expectStatus(t, "admin route with a moderator's token", byToken(2, http.MethodDelete, "/admin"+contract(first)), http.StatusUnauthorized)
+	expectStatus(t, "deletion by an auditor", auditor.do(http.MethodDelete, contract(first), nil), http.StatusNotFound)
+	expectStatus(t, "deletion by a moderator's session", moderator.do(http.MethodDelete, contract(first), nil), http.StatusOK)
+	expectStatus(t, "deletion by the owner's token", byToken(1, http.MethodDelete, contract(second)), http.StatusOK)
+
+	expectStatus(t, "signatures read by a moderator", moderator.do(http.MethodGet, "/admin"+contract(first)+"/signatures", nil), http.StatusForbidden)
+	expectStatus(t, "signatures read by an auditor", auditor.do(http.MethodGet, "/admin"+contract(first)+"/signatures", nil), http.StatusOK)
+}
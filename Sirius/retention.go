@@ -0,0 +1,491 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/labstack/echo"
+)
+
+const retentionPeriod = time.Hour
+
+// restorable reports whether the deletion at deletedAt may still be undone
+func restorable(deletedAt string, now time.Time) bool {
+	t, err := time.Parse(time.RFC3339, deletedAt)
+	return err == nil && now.Before(t.Add(currentConfig().RestoreGrace))
+}
+
+// CancellationResponse - Cancelled is set when both parties have agreed to the cancellation,
+// RequestedBy is the kind of the party which requested it first
+type CancellationResponse struct {
+	Cancelled   bool
+	RequestedBy string
+}
+
+// CancelContract - api controller for cancelling the signed contract by agreement of its creator and
+// its supplier. The first of them requests the cancellation, when the other one requests it as well
+// the contract is archived and the money left in its escrow is refunded to its investors.
+func CancelContract(c echo.Context) error {
+	kind, userID := currentUser(c)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	contract, err := selectContract(tx, id)
+	if err == sql.ErrNoRows || err == nil && !contractParty(contract, kind, userID) {
+		return c.String(http.StatusNotFound, "Contract not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if contract.Stage == 0 {
+		return c.String(http.StatusConflict, "Contract is not signed, its investor deletes it")
+	}
+	var requestedBy sql.NullString
+	if err := tx.QueryRow("SELECT cancel_requested_by FROM contracts WHERE id = ?", id).Scan(&requestedBy); err != nil {
+		log.Fatal(err)
+	}
+
+	investorID, supplierID := contract.Investor.ID.Int64, contract.Supplier.ID.Int64
+	now := time.Now().UTC()
+	if requestedBy.String == kind {
+		return c.JSON(http.StatusAccepted, CancellationResponse{RequestedBy: kind})
+	}
+	if !requestedBy.Valid {
+		ub := sqlbuilder.NewUpdateBuilder()
+		ub.Update("contracts")
+		ub.Set(ub.Assign("cancel_requested_by", kind), ub.Assign("cancel_requested_at", now.Format(time.RFC3339)))
+		ub.Where(ub.Equal("id", id))
+		q, args := ub.Build()
+		if _, err := tx.Exec(q, args...); err != nil {
+			log.Fatal(err)
+		}
+		err = EmitEvent(tx, Event{
+			Type:       EventCancellationRequested,
+			ContractID: id,
+			InvestorID: investorID,
+			SupplierID: supplierID,
+			Data: struct {
+				RequestedBy string
+			}{kind},
+			Recipients: investorAndSupplier(investorID, supplierID),
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := tx.Commit(); isBusy(err) {
+			return busyConflict(c)
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		eventsCommitted()
+		return c.JSON(http.StatusAccepted, CancellationResponse{RequestedBy: kind})
+	}
+
+	recipients, err := contractRecipients(tx, id, investorID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if archived, err := archiveContract(tx, id, auditActor{kind, userID}, now); err != nil {
+		log.Fatal(err)
+	} else if !archived {
+		return c.String(http.StatusNotFound, "Contract not found")
+	}
+	err = EmitEvent(tx, Event{
+		Type:       EventContractDeleted,
+		ContractID: id,
+		InvestorID: investorID,
+		SupplierID: supplierID,
+		Data: struct {
+			Archived bool
+		}{true},
+		Recipients: recipients,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := tx.Commit(); isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	eventsCommitted()
+	return c.JSON(http.StatusOK, CancellationResponse{Cancelled: true, RequestedBy: requestedBy.String})
+}
+
+// RestoreContract - api controller for undoing the deletion of the investor's contract
+func RestoreContract(c echo.Context) error {
+	ic := c.(InvestorContext)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	roles, err := requestRoles(c, db, UserInvestor, ic.InvestorID.Int64)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ownerID := ic.InvestorID.Int64
+	if allowed(roles, ActionContractRestore, ScopeAny) {
+		ownerID = 0
+	} else if !allowed(roles, ActionContractRestore, ScopeOwn) {
+		return c.String(http.StatusForbidden, "Forbidden")
+	}
+	return restoreContract(c, db, id, ownerID, auditActor{UserInvestor, ic.InvestorID.Int64}, "")
+}
+
+// AdminRestoreContract - api controller for undoing the removal of a contract of any investor
+func AdminRestoreContract(c echo.Context) error {
+	sc := c.(StaffContext)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	// the reason is optional, unlike the removal the restore may have no body
+	removalQuery := new(RemovalQuery)
+	if c.Request().ContentLength != 0 {
+		if err := c.Bind(removalQuery); err != nil {
+			return c.String(http.StatusBadRequest, "Bad Request")
+		}
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	return restoreContract(c, db, id, 0, auditActor{sc.UserKind, sc.UserID}, removalQuery.Reason)
+}
+
+// restoreContract undoes the deletion of the contract of the investor ownerID or of any investor when
+// ownerID is 0. The investor may not restore the contract removed by the staff, archived contracts
+// are not restored as their escrow was refunded.
+func restoreContract(c echo.Context, dB *sql.DB, id, ownerID int64, by auditActor, reason string) error {
+	tx, err := dB.Begin()
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("investor_id", "deleted_at", "deleted_by", "archived_at")
+	sb.From("contracts")
+	sb.Where(sb.Equal("id", id), sb.Or(sb.IsNotNull("deleted_at"), sb.IsNotNull("archived_at")))
+	if ownerID != 0 {
+		sb.Where(sb.Equal("investor_id", ownerID))
+	}
+	q, args := sb.Build()
+	var investorID int64
+	var deletedAt, deletedBy, archivedAt sql.NullString
+	err = tx.QueryRow(q, args...).Scan(&investorID, &deletedAt, &deletedBy, &archivedAt)
+	if err == sql.ErrNoRows {
+		return c.String(http.StatusNotFound, "Deleted contract not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if archivedAt.Valid {
+		return c.String(http.StatusConflict, "Contract is archived, concluded contracts are not restored")
+	}
+	if ownerID != 0 && deletedBy.String != by.String() {
+		return c.String(http.StatusForbidden, "Contract was removed by the staff")
+	}
+	if !restorable(deletedAt.String, time.Now()) {
+		return c.String(http.StatusGone, "Grace period of the deletion is over")
+	}
+
+	ub := sqlbuilder.NewUpdateBuilder()
+	ub.Update("contracts")
+	ub.Set(ub.Assign("deleted_at", nil), ub.Assign("deleted_by", nil))
+	ub.Where(ub.Equal("id", id), ub.Equal("deleted_at", deletedAt.String))
+	q, args = ub.Build()
+	if _, err := tx.Exec(q, args...); err != nil {
+		log.Fatal(err)
+	}
+
+	recipients, err := contractRecipients(tx, id, investorID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = EmitEvent(tx, Event{
+		Type:       EventContractRestored,
+		ContractID: id,
+		InvestorID: investorID,
+		Recipients: recipients,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if by != (auditActor{UserInvestor, investorID}) {
+		if err := recordAudit(tx, by, ActionContractRestore, auditTarget("contract", id), reason); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	eventsCommitted()
+	return c.String(http.StatusOK, "")
+}
+
+// RestoreOffer - api controller for undoing the deletion of the supplier's offer
+func RestoreOffer(c echo.Context) error {
+	sc := c.(SupplierContext)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	roles, err := requestRoles(c, db, UserSupplier, sc.SupplierID.Int64)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ownerID := sc.SupplierID.Int64
+	if allowed(roles, ActionOfferRestore, ScopeAny) {
+		ownerID = 0
+	} else if !allowed(roles, ActionOfferRestore, ScopeOwn) {
+		return c.String(http.StatusForbidden, "Forbidden")
+	}
+	return restoreOffer(c, db, id, ownerID, auditActor{UserSupplier, sc.SupplierID.Int64}, "")
+}
+
+// AdminRestoreOffer - api controller for undoing the removal of an offer of any supplier
+func AdminRestoreOffer(c echo.Context) error {
+	sc := c.(StaffContext)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	// the reason is optional, unlike the removal the restore may have no body
+	removalQuery := new(RemovalQuery)
+	if c.Request().ContentLength != 0 {
+		if err := c.Bind(removalQuery); err != nil {
+			return c.String(http.StatusBadRequest, "Bad Request")
+		}
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	return restoreOffer(c, db, id, 0, auditActor{sc.UserKind, sc.UserID}, removalQuery.Reason)
+}
+
+// restoreOffer undoes the deletion of the offer of the supplier ownerID or of any supplier when
+// ownerID is 0, the contract of the offer must not be deleted. The supplier may not restore the
+// offer removed by the staff.
+func restoreOffer(c echo.Context, dB *sql.DB, id, ownerID int64, by auditActor, reason string) error {
+	tx, err := dB.Begin()
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("offers.contract_id", "contracts.investor_id", "offers.supplier_id", "offers.deleted_at", "offers.deleted_by",
+		"contracts.deleted_at")
+	sb.From("offers")
+	sb.Join("contracts", "contracts.id = offers.contract_id")
+	sb.Where(sb.Equal("offers.id", id), sb.IsNotNull("offers.deleted_at"))
+	if ownerID != 0 {
+		sb.Where(sb.Equal("offers.supplier_id", ownerID))
+	}
+	q, args := sb.Build()
+	var contractID, investorID, supplierID int64
+	var deletedAt, deletedBy, contractDeletedAt sql.NullString
+	err = tx.QueryRow(q, args...).Scan(&contractID, &investorID, &supplierID, &deletedAt, &deletedBy, &contractDeletedAt)
+	if err == sql.ErrNoRows {
+		return c.String(http.StatusNotFound, "Deleted offer not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if contractDeletedAt.Valid {
+		return c.String(http.StatusConflict, "Contract of the offer is deleted")
+	}
+	if ownerID != 0 && deletedBy.String != by.String() {
+		return c.String(http.StatusForbidden, "Offer was removed by the staff")
+	}
+	if !restorable(deletedAt.String, time.Now()) {
+		return c.String(http.StatusGone, "Grace period of the deletion is over")
+	}
+
+	ub := sqlbuilder.NewUpdateBuilder()
+	ub.Update("offers")
+	ub.Set(ub.Assign("deleted_at", nil), ub.Assign("deleted_by", nil))
+	ub.Where(ub.Equal("id", id), ub.Equal("deleted_at", deletedAt.String))
+	q, args = ub.Build()
+	if _, err := tx.Exec(q, args...); err != nil {
+		log.Fatal(err)
+	}
+
+	err = EmitEvent(tx, Event{
+		Type:       EventOfferRestored,
+		ContractID: contractID,
+		OfferID:    id,
+		InvestorID: investorID,
+		SupplierID: supplierID,
+		Recipients: investorAndSupplier(investorID, supplierID),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if by != (auditActor{UserSupplier, supplierID}) {
+		if err := recordAudit(tx, by, ActionOfferRestore, auditTarget("offer", id), reason); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	eventsCommitted()
+	return c.String(http.StatusOK, "")
+}
+
+// RunRetention periodically purges the contracts and offers deleted longer than deleted_retention ago
+func RunRetention() {
+	for {
+		if err := purgeDeleted(time.Now()); err != nil {
+			log.Print("retention: ", err)
+		}
+		time.Sleep(retentionPeriod)
+	}
+}
+
+// purgeDeleted removes the open contracts deleted before now minus deleted_retention with their offers,
+// bids, shares and versions, and the deleted offers of the open contracts with their revisions, except the
+// awarded one. Signed contracts and the offers of concluded contracts are never purged. Every contract and
+// offer is purged in its own transaction.
+func purgeDeleted(now time.Time) error {
+	db, err := sql.Open(dbDriver, dbName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// deleted_at is stored as RFC3339 in UTC, so it is compared as a string
+	cutoff := now.Add(-currentConfig().DeletedRetention).UTC().Format(time.RFC3339)
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("id")
+	sb.From("contracts")
+	sb.Where(sb.LessEqualThan("deleted_at", cutoff), sb.Equal("stage", 0), sb.IsNull("archived_at"))
+	q, args := sb.Build()
+	contracts, err := selectIDs(db, q, args...)
+	if err != nil {
+		return err
+	}
+	for _, id := range contracts {
+		err := purge(db, []string{
+			"DELETE FROM offer_revisions WHERE offer_id IN (SELECT id FROM offers WHERE contract_id = ?)",
+			"DELETE FROM offers WHERE contract_id = ?",
+			"DELETE FROM sealed_bids WHERE contract_id = ?",
+			"DELETE FROM contract_investors WHERE contract_id = ?",
+			"DELETE FROM contract_versions WHERE contract_id = ?",
+			"DELETE FROM contracts WHERE id = ? AND stage = 0",
+		}, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	sb = sqlbuilder.NewSelectBuilder()
+	sb.Select("offers.id")
+	sb.From("offers")
+	sb.Join("contracts", "contracts.id = offers.contract_id")
+	// the offer awarded the contract is kept while the contract is, so the award keeps its winner
+	sb.Where(sb.LessEqualThan("offers.deleted_at", cutoff), sb.Equal("contracts.stage", 0),
+		"contracts.award_offer_id IS NOT offers.id")
+	q, args = sb.Build()
+	offers, err := selectIDs(db, q, args...)
+	if err != nil {
+		return err
+	}
+	for _, id := range offers {
+		// revealed bids keep their revealed time, the signatures of the co-investors are discarded
+		err := purge(db, []string{
+			"UPDATE sealed_bids SET offer_id = NULL WHERE offer_id = ?",
+			"UPDATE contract_investors SET offer_id = NULL, revision = NULL, signature = NULL, signed = NULL WHERE offer_id = ?",
+			"DELETE FROM offer_revisions WHERE offer_id = ?",
+			"DELETE FROM offers WHERE id = ?",
+		}, id)
+		if err != nil {
+			return err
+		}
+	}
+	if len(contracts)+len(offers) > 0 {
+		log.Printf("retention: purged %d contracts and %d offers", len(contracts), len(offers))
+	}
+	return nil
+}
+
+// selectIDs returns the IDs selected by the query
+func selectIDs(db dbExecutor, q string, args ...interface{}) ([]int64, error) {
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// purge runs the statements with the ID in one transaction
+func purge(db *sql.DB, stmts []string, id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPurgeDeletedOffers(t *testing.T) {
+	api := newTestAPI(t)
+	investor := api.user(UserInvestor, 1)
+	supplier, winner := api.user(UserSupplier, 7), api.user(UserSupplier, 8)
+	contractID := investor.createContract(nil)
+	revealedID, awardedID := supplier.createOffer(contractID), winner.createOffer(contractID)
+
+	deleted := time.Now().Add(-2 * currentConfig().DeletedRetention).UTC().Format(time.RFC3339)
+	execTestDB(t, "UPDATE offers SET deleted_at = ? WHERE contract_id = ?", deleted, contractID)
+	execTestDB(t, "UPDATE contracts SET sealed = 1, bidding_deadline = ?, reveal_deadline = ?, awarded = ?, award_offer_id = ? WHERE id = ?",
+		deleted, time.Now().Add(time.Hour).UTC().Format(time.RFC3339), deleted, awardedID, contractID)
+	execTestDB(t, "INSERT INTO sealed_bids (contract_id, supplier_id, commitment, created, revealed, offer_id) VALUES (?, 7, 'c', ?, ?, ?)",
+		contractID, deleted, deleted, revealedID)
+	execTestDB(t, "INSERT INTO contract_investors (contract_id, investor_id, share, offer_id, revision, signature, signed) VALUES (?, 2, 1000, ?, 1, 's', ?)",
+		contractID, revealedID, deleted)
+
+	if err := purgeDeleted(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open(dbDriver, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var offers int
+	if err := db.QueryRow("SELECT COUNT(*) FROM offers WHERE id = ?", revealedID).Scan(&offers); err != nil || offers != 0 {
+		t.Errorf("deleted offer is left: %d %v", offers, err)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM offers WHERE id = ?", awardedID).Scan(&offers); err != nil || offers != 1 {
+		t.Errorf("awarded offer is purged: %d %v", offers, err)
+	}
+	var awardOfferID sql.NullInt64
+	if err := db.QueryRow("SELECT award_offer_id FROM contracts WHERE id = ?", contractID).Scan(&awardOfferID); err != nil || awardOfferID.Int64 != awardedID {
+		t.Errorf("award_offer_id %v %v, want %d", awardOfferID, err, awardedID)
+	}
+	var signature sql.NullString
+	if err := db.QueryRow("SELECT signature FROM contract_investors WHERE investor_id = 2").Scan(&signature); err != nil || signature.Valid {
+		t.Errorf("signature of the purged offer %v %v", signature, err)
+	}
+
+	var bidID int64
+	var revealed sql.NullString
+	var offerID sql.NullInt64
+	if err := db.QueryRow("SELECT id, revealed, offer_id FROM sealed_bids").Scan(&bidID, &revealed, &offerID); err != nil {
+		t.Fatal(err)
+	}
+	if !revealed.Valid || offerID.Valid {
+		t.Errorf("bid of the purged offer: revealed %v, offer %v", revealed, offerID)
+	}
+	mustBeDone := time.Now().Add(30 * 24 * time.Hour).UTC().Format(time.RFC3339)
+	expectStatus(t, "reveal of the bid of the purged offer", supplier.do(http.MethodPost, "/suppliers/bids/"+strconv.FormatInt(bidID, 10)+"/reveal",
+		map[string]interface{}{"Amount": 100000, "MustBeDone": mustBeDone, "Salt": base64.StdEncoding.EncodeToString(make([]byte, minSaltSize))}), http.StatusConflict)
+}
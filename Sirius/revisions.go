@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/labstack/echo"
+	"github.com/mattn/go-sqlite3"
+)
+
+// OfferRevision is an immutable round of negotiation on an offer. Revision 1 is made together
+// with the offer and has the terms of the contract, later revisions are amendments of the supplier
+// and counter-offers of the investor. Terms are signed by the author of the revision.
+type OfferRevision struct {
+	ID         int64
+	OfferID    int64
+	Revision   int64
+	PreviousID sql.NullInt64
+	AuthorKind string
+	AuthorID   int64
+	Terms      ContractBody
+	Signature  string
+	Comment    sql.NullString
+	Created    string
+
+	// encoded are the signed bytes of Terms as stored
+	encoded []byte
+}
+
+// RevisionQuery - amended terms of an offer, title, description and currency are taken from the contract.
+// Signature is made over the encoded ContractBody with the amended terms.
+type RevisionQuery struct {
+	// Previous is the number of the revision which is answered, it must be the latest one
+	Previous   int64
+	Amount     int64
+	MustBeDone *Timestamp
+	Milestones []Milestone
+	ValidUntil *Timestamp
+	Comment    string
+	Signature  string
+}
+
+var offerRevisionColumns = []string{"id", "offer_id", "revision", "previous_id", "author_kind", "author_id",
+	"terms", "signature", "comment", "created"}
+
+func scanOfferRevision(row rowScanner, r *OfferRevision) error {
+	var terms string
+	err := row.Scan(&r.ID, &r.OfferID, &r.Revision, &r.PreviousID, &r.AuthorKind, &r.AuthorID,
+		&terms, &r.Signature, &r.Comment, &r.Created)
+	if err != nil {
+		return err
+	}
+	r.encoded = []byte(terms)
+	return json.Unmarshal(r.encoded, &r.Terms)
+}
+
+// expired reports whether the terms may not be accepted at the time
+func (r *OfferRevision) expired(now time.Time) bool {
+	if r.Terms.ValidUntil == "" {
+		return false
+	}
+	validUntil, err := time.Parse(time.RFC3339, r.Terms.ValidUntil)
+	return err == nil && now.After(validUntil)
+}
+
+// latestOfferRevision returns the last revision of the offer, sql.ErrNoRows if there is none
+func latestOfferRevision(db dbExecutor, offerID int64) (*OfferRevision, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select(offerRevisionColumns...)
+	sb.From("offer_revisions")
+	sb.Where(sb.Equal("offer_id", offerID))
+	sb.OrderBy("revision").Desc()
+	sb.Limit(1)
+	q, args := sb.Build()
+
+	r := &OfferRevision{}
+	if err := scanOfferRevision(db.QueryRow(q, args...), r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// insertOfferRevision stores the revision with the encoded terms and renews validity of the offer,
+// the revision number must be unique within the offer. The terms are taken from the current contract,
+// so the offer is no longer stale.
+func insertOfferRevision(tx dbExecutor, r *OfferRevision, encoded []byte) error {
+	r.Created = time.Now().Format(time.RFC3339)
+	r.encoded = encoded
+
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto("offer_revisions")
+	ib.Cols("offer_id", "revision", "previous_id", "author_kind", "author_id", "terms", "signature", "comment", "created")
+	ib.Values(r.OfferID, r.Revision, r.PreviousID, r.AuthorKind, r.AuthorID, string(encoded), r.Signature, r.Comment, r.Created)
+	q, args := ib.Build()
+
+	res, err := tx.Exec(q, args...)
+	if err != nil {
+		return err
+	}
+	r.ID, err = res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	// the offer is valid as long as its latest revision
+	ub := sqlbuilder.NewUpdateBuilder()
+	ub.Update("offers")
+	ub.Set(ub.Assign("valid_until", sql.NullString{String: r.Terms.ValidUntil, Valid: r.Terms.ValidUntil != ""}),
+		ub.Assign("expired", nil), ub.Assign("stale", nil))
+	ub.Where(ub.Equal("id", r.OfferID))
+	q, args = ub.Build()
+	_, err = tx.Exec(q, args...)
+	return err
+}
+
+// backfillOfferRevisions makes revision 1 for the offers made before negotiation was introduced,
+// the supplier signed the contract body as is
+func backfillOfferRevisions(db *sql.DB) error {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("offers.id", "offers.supplier_id", "offers.supplier_signature", "offers.comment", "offers.created",
+		"contracts.title", "contracts.description", "contracts.amount", "contracts.currency", "contracts.must_be_done",
+		"contracts.milestones")
+	sb.From("offers")
+	sb.Join("contracts", "contracts.id = offers.contract_id")
+	sb.Where("NOT EXISTS (SELECT 1 FROM offer_revisions WHERE offer_revisions.offer_id = offers.id)")
+	q, args := sb.Build()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return err
+	}
+	var revisions []OfferRevision
+	for rows.Next() {
+		r := OfferRevision{Revision: 1, AuthorKind: UserSupplier}
+		var signature, milestones sql.NullString
+		err := rows.Scan(&r.OfferID, &r.AuthorID, &signature, &r.Comment, &r.Created,
+			&r.Terms.Title, &r.Terms.Description, &r.Terms.Amount, &r.Terms.Currency, &r.Terms.MustBeDone, &milestones)
+		if err == nil {
+			err = r.Terms.scanMilestones(milestones)
+		}
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		r.Signature = signature.String
+		revisions = append(revisions, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, r := range revisions {
+		encoded, _ := json.Marshal(r.Terms)
+		ib := sqlbuilder.NewInsertBuilder()
+		ib.InsertInto("offer_revisions")
+		ib.Cols("offer_id", "revision", "author_kind", "author_id", "terms", "signature", "comment", "created")
+		ib.Values(r.OfferID, r.Revision, r.AuthorKind, r.AuthorID, string(encoded), r.Signature, r.Comment, r.Created)
+		q, args := ib.Build()
+		if _, err := db.Exec(q, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// userCert loads certificate of the user from the external api
+func userCert(ctx context.Context, kind string, id int64) (string, error) {
+	cache := make(map[int64]UserAbstract)
+	user := UserAbstract{ID: sql.NullInt64{Int64: id, Valid: true}}
+	if kind == UserInvestor {
+		investor := Investor{UserAbstract: user}
+		err := investor.Load(ctx, cache)
+		return investor.Cert.String, err
+	}
+	supplier := Supplier{UserAbstract: user}
+	err := supplier.Load(ctx, cache)
+	return supplier.Cert.String, err
+}
+
+// offerParty reports whether the user is the supplier of the offer or an investor of its contract,
+// only they see the negotiation of the offer
+func offerParty(db dbExecutor, offerID int64, kind string, userID int64) (bool, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("offers.contract_id", "offers.supplier_id", "contracts.investor_id")
+	sb.From("offers")
+	sb.Join("contracts", "contracts.id = offers.contract_id")
+	sb.Where(sb.Equal("offers.id", offerID))
+	q, args := sb.Build()
+
+	var contractID, supplierID, investorID int64
+	err := db.QueryRow(q, args...).Scan(&contractID, &supplierID, &investorID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	switch kind {
+	case UserSupplier:
+		return supplierID == userID, nil
+	case UserInvestor:
+		return isContractInvestor(db, contractID, investorID, userID)
+	}
+	return false, nil
+}
+
+// ListOfferRevisions - api controller for obtaining the negotiation history of an offer
+func ListOfferRevisions(c echo.Context) error {
+	kind, userID := currentUser(c)
+	offerID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select(offerRevisionColumns...)
+	sb.From("offer_revisions")
+	sb.Where(sb.Equal("offer_id", offerID))
+	if err := paginate(c, sb); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	q, args := sb.Build()
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	party, err := offerParty(db, offerID, kind, userID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !party {
+		return c.String(http.StatusNotFound, "Offer not found")
+	}
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	revisions := []OfferRevision{}
+	for rows.Next() {
+		r := OfferRevision{}
+		if err := scanOfferRevision(rows, &r); err != nil {
+			log.Fatal(err)
+		}
+		revisions = append(revisions, r)
+	}
+	if len(revisions) == 0 {
+		return c.String(http.StatusNotFound, "Offer not found")
+	}
+	return c.JSON(http.StatusOK, revisions)
+}
+
+// GetOfferEncoded - api controller for retrieving the exact bytes of the latest terms of the offer,
+// the investor signs them to accept the offer
+func GetOfferEncoded(c echo.Context) error {
+	kind, userID := currentUser(c)
+	offerID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	party, err := offerParty(db, offerID, kind, userID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !party {
+		return c.String(http.StatusNotFound, "Offer not found")
+	}
+
+	r, err := latestOfferRevision(db, offerID)
+	if err == sql.ErrNoRows {
+		return c.String(http.StatusNotFound, "Offer not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	c.Response().Header().Set("X-Sirius-Revision", strconv.FormatInt(r.Revision, 10))
+	return c.Blob(http.StatusOK, echo.MIMEApplicationJSON, r.encoded)
+}
+
+// ProposeOfferRevision - api controller for amending an offer by the supplier or making
+// a counter-offer by the investor, the supplier may not amend the offer after the bidding deadline
+func ProposeOfferRevision(c echo.Context) error {
+	kind, userID := currentUser(c)
+	offerID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	revisionQuery := new(RevisionQuery)
+	if err := c.Bind(revisionQuery); err != nil || revisionQuery.MustBeDone == nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("offers.contract_id", "offers.supplier_id", "offers.expired", "offers.stale", "contracts.investor_id", "contracts.stage",
+		"contracts.bidding_deadline", "contracts.title", "contracts.description", "contracts.currency")
+	sb.From("offers")
+	sb.Join("contracts", "contracts.id = offers.contract_id")
+	sb.Where(sb.Equal("offers.id", offerID), sb.IsNull("offers.deleted_at"), sb.IsNull("contracts.deleted_at"))
+	q, args := sb.Build()
+
+	var contractID, supplierID, investorID, stage int64
+	var expired, stale, biddingDeadline sql.NullString
+	terms := ContractBody{
+		Money:      Money{Amount: revisionQuery.Amount},
+		MustBeDone: time.Time(*revisionQuery.MustBeDone).Format(time.RFC3339),
+		Milestones: revisionQuery.Milestones,
+	}
+	err = db.QueryRow(q, args...).Scan(&contractID, &supplierID, &expired, &stale, &investorID, &stage, &biddingDeadline, &terms.Title, &terms.Description, &terms.Currency)
+	if err == sql.ErrNoRows {
+		return c.String(http.StatusNotFound, "Offer not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	// co-investors act on the contract as well as its creator
+	owner := kind == UserSupplier && supplierID == userID
+	if kind == UserInvestor {
+		if owner, err = isContractInvestor(db, contractID, investorID, userID); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if !owner {
+		return c.String(http.StatusNotFound, "Offer not found")
+	}
+	if stage != 0 {
+		return c.String(http.StatusConflict, "Contract is already concluded")
+	}
+	// the supplier may not change the terms once the bidding is closed, as no new offer may be made
+	// then, revealed sealed bids included
+	if deadline, err := time.Parse(time.RFC3339, biddingDeadline.String); err == nil && time.Now().After(deadline) && kind == UserSupplier {
+		return c.String(http.StatusConflict, "Bidding is closed")
+	}
+	// only the supplier may renew an expired offer
+	if expired.Valid && kind != UserSupplier {
+		return c.String(http.StatusConflict, "Offer expired")
+	}
+	// and re-sign the offer made on an earlier version of the contract
+	if stale.Valid && kind != UserSupplier {
+		return c.String(http.StatusConflict, errOfferStale.Error())
+	}
+	if err := terms.validateMilestones(); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Milestones")
+	}
+	terms.ValidUntil, err = validUntilValue(revisionQuery.ValidUntil)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	previous, err := latestOfferRevision(db, offerID)
+	if err == sql.ErrNoRows {
+		return c.String(http.StatusNotFound, "Offer not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if previous.Revision != revisionQuery.Previous {
+		return c.String(http.StatusConflict, "Offer was revised, the latest revision is "+strconv.FormatInt(previous.Revision, 10))
+	}
+
+	cert, err := userCert(c.Request().Context(), kind, userID)
+	if err != nil {
+		log.Print(err)
+		return c.String(http.StatusBadGateway, "User's certificate could not be loaded")
+	}
+	encoded, _ := json.Marshal(terms)
+	if !VerifySignature(revisionQuery.Signature, cert, encoded) {
+		return c.String(http.StatusBadRequest, "Bad Signature")
+	}
+
+	revision := OfferRevision{
+		OfferID:    offerID,
+		Revision:   previous.Revision + 1,
+		PreviousID: sql.NullInt64{Int64: previous.ID, Valid: true},
+		AuthorKind: kind,
+		AuthorID:   userID,
+		Terms:      terms,
+		Signature:  revisionQuery.Signature,
+		Comment:    sql.NullString{String: revisionQuery.Comment, Valid: revisionQuery.Comment != ""},
+	}
+
+	tx, err := db.Begin()
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	err = insertOfferRevision(tx, &revision, encoded)
+	if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+		// another revision has been made concurrently
+		return c.String(http.StatusConflict, "Offer was revised")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	err = EmitEvent(tx, Event{
+		Type:       EventOfferRevised,
+		ContractID: contractID,
+		OfferID:    offerID,
+		InvestorID: investorID,
+		SupplierID: supplierID,
+		Data: struct {
+			Revision   int64
+			AuthorKind string
+			Terms      ContractBody
+			Comment    string
+		}{revision.Revision, kind, terms, revisionQuery.Comment},
+		Recipients: investorAndSupplier(investorID, supplierID),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := tx.Commit(); isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	eventsCommitted()
+
+	return c.JSON(http.StatusCreated, CreatedResponse{ID: revision.ID})
+}
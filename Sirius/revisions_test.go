@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// testAPI serves the routes of the api over a test database, its users are authenticated by sessions
+type testAPI struct {
+	t *testing.T
+	e *echo.Echo
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	useTestDB(t)
+	useTestConfig(t, func(cfg *Config) { cfg.DailyContracts = 0 })
+	limiter = &rateLimiter{buckets: make(map[string]*tokenBucket)}
+	return &testAPI{t: t, e: newTestServer()}
+}
+
+// do sends the request as the user, anonymously when u is nil, in is sent as json
+func (api *testAPI) do(u *testUser, method, path string, in interface{}) *httptest.ResponseRecorder {
+	api.t.Helper()
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			api.t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &body)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if u != nil {
+		req.Header.Set(echo.HeaderAuthorization, "Session "+u.session)
+	}
+	rec := httptest.NewRecorder()
+	api.e.ServeHTTP(rec, req)
+	return rec
+}
+
+// testUser is an investor or a supplier with a signing key, its certificate is in the user cache
+type testUser struct {
+	api     *testAPI
+	kind    string
+	id      int64
+	key     *ecdsa.PrivateKey
+	session string
+}
+
+func (api *testAPI) user(kind string, id int64) *testUser {
+	t := api.t
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(id),
+		Subject:      pkix.Name{CommonName: kind + " " + strconv.FormatInt(id, 10)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	cacheKey := userCacheKey{kind, id}
+	upstreamUsers.mu.Lock()
+	upstreamUsers.entries[cacheKey] = userCacheEntry{UserAbstract{
+		ID:   sql.NullInt64{Int64: id, Valid: true},
+		Cert: sql.NullString{String: string(cert), Valid: true},
+	}, time.Now().Add(time.Hour)}
+	upstreamUsers.mu.Unlock()
+	t.Cleanup(func() {
+		upstreamUsers.mu.Lock()
+		delete(upstreamUsers.entries, cacheKey)
+		upstreamUsers.mu.Unlock()
+	})
+	return &testUser{api: api, kind: kind, id: id, key: key, session: createTestSession(t, kind, id)}
+}
+
+// sign signs the data the way VerifySignature checks it
+func (u *testUser) sign(data []byte) string {
+	hash := sha512.Sum384(data)
+	sig, err := ecdsa.SignASN1(rand.Reader, u.key, hash[:])
+	if err != nil {
+		u.api.t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+func (u *testUser) do(method, path string, in interface{}) *httptest.ResponseRecorder {
+	u.api.t.Helper()
+	return u.api.do(u, method, path, in)
+}
+
+// created checks that the request created a resource and returns its ID
+func created(t *testing.T, rec *httptest.ResponseRecorder) int64 {
+	t.Helper()
+	var res CreatedResponse
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("%d %s, want 201", rec.Code, rec.Body.String())
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	return res.ID
+}
+
+// expectStatus checks the status of the response
+func expectStatus(t *testing.T, what string, rec *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if rec.Code != status {
+		t.Errorf("%s: %d %s, want %d", what, rec.Code, rec.Body.String(), status)
+	}
+}
+
+// createContract creates an open contract of 1000.00 EUR, set changes the query before
+func (u *testUser) createContract(set func(q map[string]interface{})) int64 {
+	u.api.t.Helper()
+	q := map[string]interface{}{"Title": "Bolts", "Description": "Supply of bolts", "Amount": 100000, "Currency": "EUR",
+		"MustBeDone": time.Now().Add(30 * 24 * time.Hour).UTC().Format(time.RFC3339)}
+	if set != nil {
+		set(q)
+	}
+	return created(u.api.t, u.do(http.MethodPost, "/contracts", q))
+}
+
+// createOffer signs the encoded contract and makes an offer for it
+func (u *testUser) createOffer(contractID int64) int64 {
+	t := u.api.t
+	t.Helper()
+	rec := u.api.do(nil, http.MethodGet, "/contracts/"+strconv.FormatInt(contractID, 10)+"/encoded", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("encoded contract: %d %s", rec.Code, rec.Body.String())
+	}
+	return created(t, u.do(http.MethodPost, "/offers", OfferQuery{ContractID: contractID, SupplierSignature: u.sign(rec.Body.Bytes())}))
+}
+
+// revise proposes a revision of the offer answering the previous one with the amount
+func (u *testUser) revise(offerID, previous, amount int64) *httptest.ResponseRecorder {
+	t := u.api.t
+	t.Helper()
+	rec := u.do(http.MethodGet, "/"+u.kind+"s/offers/"+strconv.FormatInt(offerID, 10)+"/encoded", nil)
+	if rec.Code != http.StatusOK {
+		return rec
+	}
+	var terms ContractBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &terms); err != nil {
+		t.Fatal(err)
+	}
+	terms.Amount = amount
+	encoded, _ := json.Marshal(terms)
+	return u.do(http.MethodPost, "/"+u.kind+"s/offers/"+strconv.FormatInt(offerID, 10)+"/revisions", map[string]interface{}{
+		"Previous": previous, "Amount": amount, "MustBeDone": terms.MustBeDone, "Signature": u.sign(encoded)})
+}
+
+// execTestDB runs the statement on the test database
+func execTestDB(t *testing.T, q string, args ...interface{}) {
+	t.Helper()
+	db, err := sql.Open(dbDriver, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(q, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProposeOfferRevision(t *testing.T) {
+	api := newTestAPI(t)
+	investor, supplier := api.user(UserInvestor, 1), api.user(UserSupplier, 7)
+	contractID := investor.createContract(func(q map[string]interface{}) {
+		q["BiddingDeadline"] = time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	})
+	offerID := supplier.createOffer(contractID)
+
+	created(t, supplier.revise(offerID, 1, 90000))
+	created(t, investor.revise(offerID, 2, 85000))
+	expectStatus(t, "revision answering an earlier one", supplier.revise(offerID, 2, 88000), http.StatusConflict)
+
+	outsider := api.user(UserSupplier, 8)
+	expectStatus(t, "revision by another supplier", outsider.revise(offerID, 3, 80000), http.StatusNotFound)
+	expectStatus(t, "revision of another investor", api.user(UserInvestor, 2).revise(offerID, 3, 80000), http.StatusNotFound)
+
+	execTestDB(t, "UPDATE contracts SET bidding_deadline = ? WHERE id = ?", time.Now().Add(-time.Minute).UTC().Format(time.RFC3339), contractID)
+	expectStatus(t, "supplier revision after the bidding deadline", supplier.revise(offerID, 3, 80000), http.StatusConflict)
+	created(t, investor.revise(offerID, 3, 80000))
+}
+
+func TestOfferRevisionsArePartyOnly(t *testing.T) {
+	api := newTestAPI(t)
+	investor, supplier := api.user(UserInvestor, 1), api.user(UserSupplier, 7)
+	offerID := supplier.createOffer(investor.createContract(nil))
+	path := "/offers/" + strconv.FormatInt(offerID, 10)
+
+	for _, u := range []*testUser{investor, supplier} {
+		rec := u.do(http.MethodGet, "/"+u.kind+"s"+path+"/revisions", nil)
+		expectStatus(t, u.kind+" revisions", rec, http.StatusOK)
+		var revisions []OfferRevision
+		if err := json.Unmarshal(rec.Body.Bytes(), &revisions); err != nil || len(revisions) != 1 {
+			t.Errorf("%s revisions: %s", u.kind, rec.Body.String())
+		}
+		expectStatus(t, u.kind+" encoded offer", u.do(http.MethodGet, "/"+u.kind+"s"+path+"/encoded", nil), http.StatusOK)
+	}
+	for _, u := range []*testUser{api.user(UserInvestor, 2), api.user(UserSupplier, 8)} {
+		expectStatus(t, "revisions for another "+u.kind, u.do(http.MethodGet, "/"+u.kind+"s"+path+"/revisions", nil), http.StatusNotFound)
+		expectStatus(t, "encoded offer for another "+u.kind, u.do(http.MethodGet, "/"+u.kind+"s"+path+"/encoded", nil), http.StatusNotFound)
+	}
+	expectStatus(t, "anonymous revisions", api.do(nil, http.MethodGet, "/investors"+path+"/revisions", nil), http.StatusUnauthorized)
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"database/sql"
+)
+
+// schema lists statements creating the tables which are not present in the original contracts.sqlite3,
+// every statement must be safe to run against an already migrated database
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS events (
+		id	INTEGER PRIMARY KEY AUTOINCREMENT,
+		type	TEXT NOT NULL,
+		created	TEXT NOT NULL,
+		payload	TEXT NOT NULL,
+		public	INTEGER NOT NULL DEFAULT 0,
+		recipients	TEXT NOT NULL DEFAULT '[]',
+		published	TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhooks (
+		id	INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_kind	TEXT NOT NULL,
+		user_id	INTEGER NOT NULL,
+		url	TEXT NOT NULL,
+		event_types	TEXT NOT NULL,
+		secret	TEXT NOT NULL,
+		created	TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id	INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id	INTEGER NOT NULL,
+		event_id	INTEGER NOT NULL,
+		replay_of	INTEGER,
+		status	TEXT NOT NULL,
+		attempts	INTEGER NOT NULL DEFAULT 0,
+		next_attempt	TEXT NOT NULL,
+		response_code	INTEGER,
+		error	TEXT,
+		created	TEXT NOT NULL,
+		delivered	TEXT,
+		FOREIGN KEY(webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS offer_revisions (
+		id	INTEGER PRIMARY KEY AUTOINCREMENT,
+		offer_id	INTEGER NOT NULL,
+		revision	INTEGER NOT NULL,
+		previous_id	INTEGER,
+		author_kind	TEXT NOT NULL,
+		author_id	INTEGER NOT NULL,
+		terms	TEXT NOT NULL,
+		signature	TEXT NOT NULL,
+		comment	TEXT,
+		created	TEXT NOT NULL,
+		UNIQUE(offer_id, revision),
+		FOREIGN KEY(offer_id) REFERENCES offers(id) ON DELETE CASCADE,
+		FOREIGN KEY(previous_id) REFERENCES offer_revisions(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS sealed_bids (
+		id	INTEGER PRIMARY KEY AUTOINCREMENT,
+		contract_id	INTEGER NOT NULL,
+		supplier_id	INTEGER NOT NULL,
+		commitment	TEXT NOT NULL,
+		ciphertext	TEXT,
+		created	TEXT NOT NULL,
+		revealed	TEXT,
+		offer_id	INTEGER,
+		UNIQUE(contract_id, supplier_id),
+		FOREIGN KEY(contract_id) REFERENCES contracts(id) ON DELETE CASCADE,
+		FOREIGN KEY(offer_id) REFERENCES offers(id) ON DELETE SET NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS contract_investors (
+		contract_id	INTEGER NOT NULL,
+		investor_id	INTEGER NOT NULL,
+		share	INTEGER NOT NULL,
+		offer_id	INTEGER,
+		revision	INTEGER,
+		signature	TEXT,
+		signed	TEXT,
+		PRIMARY KEY(contract_id, investor_id),
+		FOREIGN KEY(contract_id) REFERENCES contracts(id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS exchange_rates (
+		currency	TEXT PRIMARY KEY,
+		rate	REAL NOT NULL,
+		updated	TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS ledger_accounts (
+		id	INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind	TEXT NOT NULL,
+		owner_id	INTEGER NOT NULL,
+		currency	TEXT NOT NULL,
+		created	TEXT NOT NULL,
+		UNIQUE(kind, owner_id, currency)
+	)`,
+	`CREATE TABLE IF NOT EXISTS ledger_transactions (
+		id	INTEGER PRIMARY KEY AUTOINCREMENT,
+		type	TEXT NOT NULL,
+		contract_id	INTEGER,
+		milestone	INTEGER,
+		reference	TEXT,
+		created	TEXT NOT NULL
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS ledger_transactions_release ON ledger_transactions(contract_id, milestone)
+		WHERE type = 'release'`,
+	`CREATE TABLE IF NOT EXISTS ledger_postings (
+		id	INTEGER PRIMARY KEY AUTOINCREMENT,
+		transaction_id	INTEGER NOT NULL,
+		account_id	INTEGER NOT NULL,
+		debit	INTEGER NOT NULL DEFAULT 0,
+		credit	INTEGER NOT NULL DEFAULT 0,
+		CHECK(debit >= 0 AND credit >= 0),
+		FOREIGN KEY(transaction_id) REFERENCES ledger_transactions(id),
+		FOREIGN KEY(account_id) REFERENCES ledger_accounts(id)
+	)`,
+	// the ledger is append-only
+	`CREATE TRIGGER IF NOT EXISTS ledger_postings_immutable BEFORE UPDATE ON ledger_postings
+		BEGIN SELECT RAISE(ABORT, 'ledger postings are immutable'); END`,
+	`CREATE TRIGGER IF NOT EXISTS ledger_postings_undeletable BEFORE DELETE ON ledger_postings
+		BEGIN SELECT RAISE(ABORT, 'ledger postings are immutable'); END`,
+	`CREATE TRIGGER IF NOT EXISTS ledger_transactions_immutable BEFORE UPDATE ON ledger_transactions
+		BEGIN SELECT RAISE(ABORT, 'ledger transactions are immutable'); END`,
+	`CREATE TRIGGER IF NOT EXISTS ledger_transactions_undeletable BEFORE DELETE ON ledger_transactions
+		BEGIN SELECT RAISE(ABORT, 'ledger transactions are immutable'); END`,
+	`CREATE VIEW IF NOT EXISTS ledger_statement AS
+		SELECT ledger_postings.id AS id, account_id, transaction_id, type, contract_id, milestone, reference, debit, credit,
+			SUM(credit - debit) OVER (PARTITION BY account_id ORDER BY ledger_postings.id) AS balance, created
+		FROM ledger_postings JOIN ledger_transactions ON ledger_transactions.id = transaction_id`,
+	// a deposit is recorded before the payment provider is asked to charge it, so it is credited
+	// once the charge is taken even if the request is interrupted
+	`CREATE TABLE IF NOT EXISTS deposits (
+		id	INTEGER PRIMARY KEY AUTOINCREMENT,
+		investor_id	INTEGER NOT NULL,
+		amount	INTEGER NOT NULL,
+		currency	TEXT NOT NULL,
+		status	TEXT NOT NULL,
+		reference	TEXT,
+		transaction_id	INTEGER,
+		created	TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS templates (
+		id	INTEGER PRIMARY KEY AUTOINCREMENT,
+		investor_id	INTEGER NOT NULL,
+		name	TEXT NOT NULL,
+		created	TEXT NOT NULL,
+		deleted	TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS template_versions (
+		id	INTEGER PRIMARY KEY AUTOINCREMENT,
+		template_id	INTEGER NOT NULL,
+		version	INTEGER NOT NULL,
+		title	TEXT NOT NULL,
+		description	TEXT NOT NULL,
+		parameters	TEXT NOT NULL,
+		created	TEXT NOT NULL,
+		UNIQUE(template_id, version),
+		FOREIGN KEY(template_id) REFERENCES templates(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS client_certificates (
+		id	INTEGER PRIMARY KEY AUTOINCREMENT,
+		serial	TEXT NOT NULL UNIQUE,
+		user_kind	TEXT NOT NULL,
+		user_id	INTEGER NOT NULL,
+		subject	TEXT NOT NULL,
+		expires	TEXT NOT NULL,
+		created	TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_roles (
+		id	INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_kind	TEXT NOT NULL,
+		user_id	INTEGER NOT NULL,
+		role	TEXT NOT NULL,
+		created	TEXT NOT NULL,
+		UNIQUE(user_kind, user_id, role)
+	)`,
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		id	INTEGER PRIMARY KEY AUTOINCREMENT,
+		actor_kind	TEXT NOT NULL,
+		actor_id	INTEGER NOT NULL,
+		action	TEXT NOT NULL,
+		target	TEXT NOT NULL,
+		detail	TEXT,
+		created	TEXT NOT NULL
+	)`,
+	`CREATE TRIGGER IF NOT EXISTS audit_log_immutable BEFORE UPDATE ON audit_log
+		BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END`,
+	`CREATE TRIGGER IF NOT EXISTS audit_log_undeletable BEFORE DELETE ON audit_log
+		BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END`,
+	`CREATE TABLE IF NOT EXISTS login_challenges (
+		nonce	TEXT PRIMARY KEY,
+		user_kind	TEXT NOT NULL,
+		user_id	INTEGER NOT NULL,
+		expires	TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS sessions (
+		token_hash	TEXT PRIMARY KEY,
+		user_kind	TEXT NOT NULL,
+		user_id	INTEGER NOT NULL,
+		created	TEXT NOT NULL,
+		expires	TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS idempotency_keys (
+		user_kind	TEXT NOT NULL,
+		user_id	INTEGER NOT NULL,
+		key	TEXT NOT NULL,
+		fingerprint	TEXT NOT NULL,
+		status	INTEGER,
+		content_type	TEXT,
+		body	BLOB,
+		created	TEXT NOT NULL,
+		expires	TEXT NOT NULL,
+		PRIMARY KEY (user_kind, user_id, key)
+	)`,
+	`CREATE TABLE IF NOT EXISTS contract_versions (
+		id	INTEGER PRIMARY KEY AUTOINCREMENT,
+		contract_id	INTEGER NOT NULL,
+		version	INTEGER NOT NULL,
+		body	TEXT NOT NULL,
+		changes	TEXT NOT NULL DEFAULT '[]',
+		author_kind	TEXT NOT NULL,
+		author_id	INTEGER NOT NULL,
+		offer_revision_id	INTEGER,
+		amendment_id	INTEGER,
+		comment	TEXT,
+		created	TEXT NOT NULL,
+		UNIQUE(contract_id, version),
+		FOREIGN KEY(contract_id) REFERENCES contracts(id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS contract_amendments (
+		id	INTEGER PRIMARY KEY AUTOINCREMENT,
+		contract_id	INTEGER NOT NULL,
+		base_version	INTEGER NOT NULL,
+		body	TEXT NOT NULL,
+		changes	TEXT NOT NULL,
+		proposer_kind	TEXT NOT NULL,
+		proposer_id	INTEGER NOT NULL,
+		proposer_signature	TEXT NOT NULL,
+		comment	TEXT,
+		status	TEXT NOT NULL,
+		responder_kind	TEXT,
+		responder_id	INTEGER,
+		responder_signature	TEXT,
+		created	TEXT NOT NULL,
+		resolved	TEXT,
+		FOREIGN KEY(contract_id) REFERENCES contracts(id)
+	)`,
+	// a signed contract has at most one amendment waiting for the other party
+	`CREATE UNIQUE INDEX IF NOT EXISTS contract_amendments_pending ON contract_amendments(contract_id)
+		WHERE status = 'pending'`,
+}
+
+// schemaColumns lists columns added to the existing tables, they are created when missing
+var schemaColumns = []struct {
+	Table      string
+	Column     string
+	Definition string
+}{
+	{"events", "published", "TEXT"},
+	{"contracts", "milestones", "TEXT"},
+	{"contracts", "valid_until", "TEXT"},
+	{"contracts", "bidding_deadline", "TEXT"},
+	{"offers", "valid_until", "TEXT"},
+	{"offers", "expired", "TEXT"},
+	{"contracts", "award_policy", "TEXT"},
+	{"contracts", "award_weights", "TEXT"},
+	{"contracts", "award_signature", "TEXT"},
+	{"contracts", "awarded", "TEXT"},
+	{"contracts", "award_offer_id", "INTEGER"},
+	{"contracts", "sealed", "INTEGER NOT NULL DEFAULT 0"},
+	{"contracts", "reveal_deadline", "TEXT"},
+	{"contracts", "currency", "TEXT NOT NULL DEFAULT ''"},
+	{"contracts", "template_id", "INTEGER"},
+	{"contracts", "template_version", "INTEGER"},
+	{"contracts", "template_params", "TEXT"},
+	{"contracts", "revision", "INTEGER NOT NULL DEFAULT 1"},
+	{"contracts", "deleted_at", "TEXT"},
+	{"contracts", "deleted_by", "TEXT"},
+	{"contracts", "archived_at", "TEXT"},
+	{"offers", "deleted_at", "TEXT"},
+	{"offers", "deleted_by", "TEXT"},
+	{"contracts", "version", "INTEGER NOT NULL DEFAULT 1"},
+	{"offers", "stale", "TEXT"},
+	{"contracts", "cancel_requested_by", "TEXT"},
+	{"contracts", "cancel_requested_at", "TEXT"},
+}
+
+// schemaTriggers are created after schemaColumns as they use the added columns
+var schemaTriggers = []string{
+	// the revision of a contract, its ETag, changes with the contract and with its investors
+	`CREATE TRIGGER IF NOT EXISTS contracts_revision AFTER UPDATE ON contracts WHEN NEW.revision = OLD.revision
+		BEGIN UPDATE contracts SET revision = OLD.revision + 1 WHERE id = NEW.id; END`,
+	`CREATE TRIGGER IF NOT EXISTS contract_investors_inserted AFTER INSERT ON contract_investors
+		BEGIN UPDATE contracts SET revision = revision + 1 WHERE id = NEW.contract_id; END`,
+	`CREATE TRIGGER IF NOT EXISTS contract_investors_updated AFTER UPDATE ON contract_investors
+		BEGIN UPDATE contracts SET revision = revision + 1 WHERE id = NEW.contract_id; END`,
+	`CREATE TRIGGER IF NOT EXISTS contract_investors_deleted AFTER DELETE ON contract_investors
+		BEGIN UPDATE contracts SET revision = revision + 1 WHERE id = OLD.contract_id; END`,
+	// signed contracts are archived instead, their signatures are the evidence of the agreement
+	`CREATE TRIGGER IF NOT EXISTS contracts_signed_undeletable BEFORE DELETE ON contracts WHEN OLD.stage >= 1
+		BEGIN SELECT RAISE(ABORT, 'signed contracts are never deleted'); END`,
+}
+
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int64
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// Migrate creates missing tables and columns and makes the first revisions of the offers
+// made before negotiation was introduced and the first versions of the contracts made before
+// amendments were introduced
+func Migrate() error {
+	db, err := sql.Open(dbDriver, dbName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	for _, c := range schemaColumns {
+		ok, err := hasColumn(db, c.Table, c.Column)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if _, err := db.Exec("ALTER TABLE " + c.Table + " ADD COLUMN " + c.Column + " " + c.Definition); err != nil {
+				return err
+			}
+		}
+	}
+	for _, stmt := range schemaTriggers {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if err := backfillOfferRevisions(db); err != nil {
+		return err
+	}
+	return backfillContractVersions(db)
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/labstack/echo"
+	"github.com/mattn/go-sqlite3"
+)
+
+// SealedBid is a hidden offer for a sealed contract. Commitment is SHA-384 of the salt followed by
+// the encoded terms signed by the supplier. Ciphertext is optional, it is the RevealQuery encrypted
+// to the investor's certificate key, so the investor can reveal the bid when the supplier does not.
+// SupplierID and Ciphertext are hidden until the bidding deadline.
+type SealedBid struct {
+	ID         int64
+	ContractID int64
+	SupplierID sql.NullInt64
+	Commitment string
+	Ciphertext sql.NullString
+	Created    string
+	// Revealed is the time the bid was revealed as the offer OfferID, OfferID is null once the offer
+	// is purged
+	Revealed sql.NullString
+	OfferID  sql.NullInt64
+}
+
+// SealedBidQuery - Commitment and Ciphertext are base64 encoded. Ciphertext is ECIES over P-384:
+// ephemeral public key in uncompressed form, AES-256-GCM nonce and sealed RevealQuery json, the key is
+// derived with ANSI X9.63 KDF with SHA-384 from the shared secret and the ephemeral public key
+type SealedBidQuery struct {
+	ContractID int64
+	Commitment string
+	Ciphertext string
+}
+
+// RevealQuery - terms of a sealed bid, title, description and currency are taken from the contract.
+// SupplierSignature is made over the encoded ContractBody with these terms.
+type RevealQuery struct {
+	Amount            int64
+	MustBeDone        *Timestamp
+	Milestones        []Milestone
+	ValidUntil        *Timestamp
+	Comment           string
+	Salt              string
+	SupplierSignature string
+}
+
+const (
+	minSaltSize = 16
+	// minCiphertextSize is the size of the ephemeral key, the nonce and the tag
+	minCiphertextSize = 97 + 12 + 16
+)
+
+var sealedBidColumns = []string{"id", "contract_id", "supplier_id", "commitment", "ciphertext", "created", "revealed", "offer_id"}
+
+// bidCommitment returns the commitment of the encoded terms with the salt
+func bidCommitment(salt, encoded []byte) []byte {
+	h := sha512.New384()
+	h.Write(salt)
+	h.Write(encoded)
+	return h.Sum(nil)
+}
+
+// ListSealedBids - api controller for obtaining the sealed bids of a contract by its investors,
+// a supplier obtains only its own bids
+func ListSealedBids(c echo.Context) error {
+	kind, userID := currentUser(c)
+	contractID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("investor_id", "bidding_deadline")
+	sb.From("contracts")
+	sb.Where(sb.Equal("id", contractID), sb.Equal("sealed", true), sb.IsNull("deleted_at"))
+	q, args := sb.Build()
+
+	var investorID int64
+	var biddingDeadline string
+	err = db.QueryRow(q, args...).Scan(&investorID, &biddingDeadline)
+	if err == sql.ErrNoRows {
+		return c.String(http.StatusNotFound, "Contract not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if kind == UserInvestor {
+		party, err := isContractInvestor(db, contractID, investorID, userID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !party {
+			return c.String(http.StatusNotFound, "Contract not found")
+		}
+	}
+	deadline, err := time.Parse(time.RFC3339, biddingDeadline)
+	if err != nil {
+		log.Fatal(err)
+	}
+	closed := !time.Now().Before(deadline)
+
+	sb = sqlbuilder.NewSelectBuilder()
+	sb.Select(sealedBidColumns...)
+	sb.From("sealed_bids")
+	sb.Where(sb.Equal("contract_id", contractID))
+	if kind == UserSupplier {
+		sb.Where(sb.Equal("supplier_id", userID))
+	}
+	if err := paginate(c, sb); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	q, args = sb.Build()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	bids := []SealedBid{}
+	for rows.Next() {
+		b := SealedBid{}
+		err := rows.Scan(&b.ID, &b.ContractID, &b.SupplierID, &b.Commitment, &b.Ciphertext, &b.Created, &b.Revealed, &b.OfferID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// nobody, including the investor, learns who bids or decrypts a bid during bidding
+		if !closed {
+			b.SupplierID = sql.NullInt64{}
+			b.Ciphertext = sql.NullString{}
+		}
+		bids = append(bids, b)
+	}
+	return c.JSON(http.StatusOK, bids)
+}
+
+// SubmitSealedBid - api controller for committing to a hidden offer for a sealed contract
+func SubmitSealedBid(c echo.Context) error {
+	sc := c.(SupplierContext)
+
+	bidQuery := new(SealedBidQuery)
+	if err := c.Bind(bidQuery); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	commitment, err := base64.StdEncoding.DecodeString(bidQuery.Commitment)
+	if err != nil || len(commitment) != sha512.Size384 {
+		return c.String(http.StatusBadRequest, "Bad Commitment")
+	}
+	var ciphertext sql.NullString
+	if bidQuery.Ciphertext != "" {
+		b, err := base64.StdEncoding.DecodeString(bidQuery.Ciphertext)
+		if err != nil || len(b) <= minCiphertextSize {
+			return c.String(http.StatusBadRequest, "Bad Ciphertext")
+		}
+		ciphertext.String, ciphertext.Valid = bidQuery.Ciphertext, true
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("investor_id", "stage", "sealed", "bidding_deadline")
+	sb.From("contracts")
+	sb.Where(sb.Equal("id", bidQuery.ContractID), sb.IsNull("deleted_at"))
+	q, args := sb.Build()
+
+	var investorID, stage int64
+	var sealed bool
+	var biddingDeadline sql.NullString
+	err = db.QueryRow(q, args...).Scan(&investorID, &stage, &sealed, &biddingDeadline)
+	if err == sql.ErrNoRows {
+		return c.String(http.StatusNotFound, "Contract not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if stage != 0 {
+		return c.String(http.StatusConflict, "Contract is already concluded")
+	}
+	if !sealed {
+		return c.String(http.StatusConflict, "Contract is not sealed")
+	}
+	if deadline, err := time.Parse(time.RFC3339, biddingDeadline.String); err == nil && time.Now().After(deadline) {
+		return c.String(http.StatusConflict, "Bidding is closed")
+	}
+
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto("sealed_bids")
+	ib.Cols("contract_id", "supplier_id", "commitment", "ciphertext", "created")
+	ib.Values(bidQuery.ContractID, sc.SupplierID, bidQuery.Commitment, ciphertext, time.Now().Format(time.RFC3339))
+	q, args = ib.Build()
+
+	tx, err := db.Begin()
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(q, args...)
+	if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+		return c.String(http.StatusConflict, "Bid is already submitted")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the supplier gets the event but is not named in it, the investor only learns that a bid was made
+	err = EmitEvent(tx, Event{
+		Type:       EventBidSealed,
+		ContractID: bidQuery.ContractID,
+		InvestorID: investorID,
+		Data:       struct{ BidID int64 }{id},
+		Recipients: investorAndSupplier(investorID, sc.SupplierID.Int64),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := tx.Commit(); isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	eventsCommitted()
+
+	return c.JSON(http.StatusCreated, CreatedResponse{ID: id})
+}
+
+// RevealSealedBid - api controller for opening a sealed bid after the bidding deadline, by the
+// supplier or by the investor who decrypted it. The bid becomes an offer.
+func RevealSealedBid(c echo.Context) error {
+	kind, userID := currentUser(c)
+	bidID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	revealQuery := new(RevealQuery)
+	if err := c.Bind(revealQuery); err != nil || revealQuery.MustBeDone == nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	salt, err := base64.StdEncoding.DecodeString(revealQuery.Salt)
+	if err != nil || len(salt) < minSaltSize {
+		return c.String(http.StatusBadRequest, "Bad Salt")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("sealed_bids.contract_id", "sealed_bids.supplier_id", "sealed_bids.commitment", "sealed_bids.revealed",
+		"contracts.investor_id", "contracts.stage", "contracts.bidding_deadline", "contracts.reveal_deadline",
+		"contracts.title", "contracts.description", "contracts.currency")
+	sb.From("sealed_bids")
+	sb.Join("contracts", "contracts.id = sealed_bids.contract_id")
+	sb.Where(sb.Equal("sealed_bids.id", bidID), sb.IsNull("contracts.deleted_at"))
+	q, args := sb.Build()
+
+	var contractID, supplierID, investorID, stage int64
+	var commitment, biddingDeadline, revealDeadline string
+	var revealed sql.NullString
+	terms := ContractBody{
+		Money:      Money{Amount: revealQuery.Amount},
+		MustBeDone: time.Time(*revealQuery.MustBeDone).Format(time.RFC3339),
+		Milestones: revealQuery.Milestones,
+	}
+	err = db.QueryRow(q, args...).Scan(&contractID, &supplierID, &commitment, &revealed, &investorID, &stage,
+		&biddingDeadline, &revealDeadline, &terms.Title, &terms.Description, &terms.Currency)
+	if err == sql.ErrNoRows {
+		return c.String(http.StatusNotFound, "Bid not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	// co-investors act on the contract as well as its creator
+	owner := kind == UserSupplier && supplierID == userID
+	if kind == UserInvestor {
+		if owner, err = isContractInvestor(db, contractID, investorID, userID); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if !owner {
+		return c.String(http.StatusNotFound, "Bid not found")
+	}
+	// revealed stays set when the offer of the bid is purged, so a bid is revealed once
+	if revealed.Valid {
+		return c.String(http.StatusConflict, "Bid is already revealed")
+	}
+	if stage != 0 {
+		return c.String(http.StatusConflict, "Contract is already concluded")
+	}
+	// deadlines are stored as RFC3339 in UTC, so they are compared as strings
+	now := time.Now().UTC().Format(time.RFC3339)
+	if now < biddingDeadline {
+		return c.String(http.StatusConflict, "Bidding is not closed")
+	}
+	if now > revealDeadline {
+		return c.String(http.StatusConflict, "Reveal phase is over")
+	}
+	if err := terms.validateMilestones(); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Milestones")
+	}
+	terms.ValidUntil, err = validUntilValue(revealQuery.ValidUntil)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	encoded, _ := json.Marshal(terms)
+	committed, _ := base64.StdEncoding.DecodeString(commitment)
+	if !bytes.Equal(bidCommitment(salt, encoded), committed) {
+		return c.String(http.StatusBadRequest, "Reveal does not match the commitment")
+	}
+	cert, err := userCert(c.Request().Context(), UserSupplier, supplierID)
+	if err != nil {
+		log.Print(err)
+		return c.String(http.StatusBadGateway, "Supplier's certificate could not be loaded")
+	}
+	if !VerifySignature(revealQuery.SupplierSignature, cert, encoded) {
+		return c.String(http.StatusBadRequest, "Bad Signature")
+	}
+
+	created := time.Now().Format(time.RFC3339)
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto("offers")
+	ib.Cols("contract_id", "supplier_id", "supplier_signature", "comment", "created", "valid_until")
+	ib.Values(contractID, supplierID, revealQuery.SupplierSignature, revealQuery.Comment, created,
+		sql.NullString{String: terms.ValidUntil, Valid: terms.ValidUntil != ""})
+	q, args = ib.Build()
+
+	tx, err := db.Begin()
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the revealed terms open negotiation
+	err = insertOfferRevision(tx, &OfferRevision{
+		OfferID:    id,
+		Revision:   1,
+		AuthorKind: UserSupplier,
+		AuthorID:   supplierID,
+		Terms:      terms,
+		Signature:  revealQuery.SupplierSignature,
+		Comment:    sql.NullString{String: revealQuery.Comment, Valid: true},
+	}, encoded)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ub := sqlbuilder.NewUpdateBuilder()
+	ub.Update("sealed_bids")
+	ub.Set(ub.Assign("revealed", created), ub.Assign("offer_id", id))
+	ub.Where(ub.Equal("id", bidID), ub.IsNull("revealed"))
+	q, args = ub.Build()
+	res, err = tx.Exec(q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if affected, err := res.RowsAffected(); err != nil || affected != 1 {
+		// revealed concurrently
+		return c.String(http.StatusConflict, "Bid is already revealed")
+	}
+
+	err = EmitEvent(tx, Event{
+		Type:       EventOfferCreated,
+		ContractID: contractID,
+		OfferID:    id,
+		InvestorID: investorID,
+		SupplierID: supplierID,
+		Data: struct {
+			Comment    string
+			ValidUntil string `json:",omitempty"`
+			BidID      int64
+		}{revealQuery.Comment, terms.ValidUntil, bidID},
+		Recipients: investorAndSupplier(investorID, supplierID),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := tx.Commit(); isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	eventsCommitted()
+
+	return c.JSON(http.StatusCreated, CreatedResponse{ID: id})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSealedBidsArePartyOnly(t *testing.T) {
+	api := newTestAPI(t)
+	investor, supplier := api.user(UserInvestor, 1), api.user(UserSupplier, 7)
+	contractID := investor.createContract(func(q map[string]interface{}) {
+		q["Sealed"] = true
+		q["BiddingDeadline"] = time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		q["RevealDeadline"] = time.Now().Add(2 * time.Hour).UTC().Format(time.RFC3339)
+	})
+	bids := "/contracts/" + strconv.FormatInt(contractID, 10) + "/bids"
+
+	mustBeDone := time.Now().Add(30 * 24 * time.Hour).UTC().Format(time.RFC3339)
+	terms := ContractBody{Title: "Bolts", Description: "Supply of bolts", Money: Money{Amount: 90000, Currency: "EUR"}, MustBeDone: mustBeDone}
+	encoded, _ := json.Marshal(terms)
+	salt := make([]byte, minSaltSize)
+	bidID := created(t, supplier.do(http.MethodPost, "/bids", SealedBidQuery{
+		ContractID: contractID, Commitment: base64.StdEncoding.EncodeToString(bidCommitment(salt, encoded))}))
+
+	list := func(u *testUser) []SealedBid {
+		t.Helper()
+		rec := u.do(http.MethodGet, "/"+u.kind+"s"+bids, nil)
+		var list []SealedBid
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &list) != nil {
+			t.Fatalf("%s bids: %d %s", u.kind, rec.Code, rec.Body.String())
+		}
+		return list
+	}
+	if got := list(investor); len(got) != 1 || got[0].SupplierID.Valid {
+		t.Errorf("investor's bids during bidding %+v, want one without its supplier", got)
+	}
+	if got := list(supplier); len(got) != 1 || got[0].ID != bidID {
+		t.Errorf("supplier's bids %+v, want %d", got, bidID)
+	}
+	outsider, otherInvestor := api.user(UserSupplier, 8), api.user(UserInvestor, 2)
+	if got := list(outsider); len(got) != 0 {
+		t.Errorf("bids of another supplier %+v", got)
+	}
+	expectStatus(t, "bids for another investor", otherInvestor.do(http.MethodGet, "/investors"+bids, nil), http.StatusNotFound)
+
+	execTestDB(t, "UPDATE contracts SET bidding_deadline = ? WHERE id = ?", time.Now().Add(-time.Minute).UTC().Format(time.RFC3339), contractID)
+	reveal := "/bids/" + strconv.FormatInt(bidID, 10) + "/reveal"
+	q := map[string]interface{}{"Amount": 90000, "MustBeDone": mustBeDone, "Salt": base64.StdEncoding.EncodeToString(salt),
+		"SupplierSignature": supplier.sign(encoded)}
+	expectStatus(t, "reveal by another supplier", outsider.do(http.MethodPost, "/suppliers"+reveal, q), http.StatusNotFound)
+	expectStatus(t, "reveal by another investor", otherInvestor.do(http.MethodPost, "/investors"+reveal, q), http.StatusNotFound)
+	offerID := created(t, supplier.do(http.MethodPost, "/suppliers"+reveal, q))
+	expectStatus(t, "second reveal", investor.do(http.MethodPost, "/investors"+reveal, q), http.StatusConflict)
+
+	if got := list(investor); len(got) != 1 || got[0].SupplierID.Int64 != supplier.id || got[0].OfferID.Int64 != offerID {
+		t.Errorf("investor's bids after the reveal %+v, want the offer %d of supplier %d", got, offerID, supplier.id)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/sha512"
 	"crypto/x509"
@@ -10,17 +11,19 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
 	"math/big"
 	"net/http"
+	"os"
 	"strconv"
 	"time"
 
 	"github.com/huandu/go-sqlbuilder"
 	"github.com/labstack/echo"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 type UserAbstract struct {
@@ -36,7 +39,7 @@ type userExternal struct {
 }
 
 type loadable interface {
-	Load(cache map[int64]UserAbstract) error
+	Load(ctx context.Context, cache map[int64]UserAbstract) error
 }
 
 type Supplier struct {
@@ -52,26 +55,34 @@ type Investor struct {
 const GatewayURL = "http://20.1.101.207:15672"
 
 // Load Supplier from the external api
-func (s *Supplier) Load(cache map[int64]UserAbstract) (err error) {
+func (s *Supplier) Load(ctx context.Context, cache map[int64]UserAbstract) (err error) {
 	//suppliersAPI := GatewayURL + "/clients/%d"
-	suppliersAPI := "http://192.168.43.219:8191/api/clients/%d"
+	suppliersAPI := currentConfig().SuppliersURL + "/%d"
 	if !s.ID.Valid {
 		return nil
 	}
 	v, ok := cache[s.ID.Int64]
+	if !ok {
+		v, ok = upstreamUsers.get(UserSupplier, s.ID.Int64)
+	}
 	if ok {
 		s.UserAbstract = v
 
 	} else {
-		res, err := http.Get(fmt.Sprintf(suppliersAPI, s.ID.Int64))
+		start := time.Now()
+		res, span, err := upstreamGet(ctx, UpstreamSuppliers, UpstreamLoad, fmt.Sprintf(suppliersAPI, s.ID.Int64))
 
 		if err != nil {
+			observeUpstream(UpstreamSuppliers, UpstreamLoad, start, true)
+			endSpan(span, err)
 			log.Print(err)
 			return err
 		}
 		defer res.Body.Close()
 		u := userExternal{}
 		err = json.NewDecoder(res.Body).Decode(&u)
+		observeUpstream(UpstreamSuppliers, UpstreamLoad, start, err != nil)
+		endSpan(span, err)
 		//fmt.Print(u)
 		if err != nil {
 			log.Print(err)
@@ -83,31 +94,40 @@ func (s *Supplier) Load(cache map[int64]UserAbstract) (err error) {
 		s.Cert.String = u.Cert
 
 		cache[s.ID.Int64] = s.UserAbstract
+		upstreamUsers.put(UserSupplier, s.ID.Int64, s.UserAbstract)
 	}
 	return nil
 }
 
 // Load Investor from the external api
-func (s *Investor) Load(cache map[int64]UserAbstract) error {
+func (s *Investor) Load(ctx context.Context, cache map[int64]UserAbstract) error {
 	//investorsAPI := GatewayURL + "/investors/%d"
-	investorsAPI := "http://192.168.43.219:8193/api/investors/%d"
+	investorsAPI := currentConfig().InvestorsURL + "/%d"
 	if !s.ID.Valid {
 		return nil
 	}
 	v, ok := cache[s.ID.Int64]
+	if !ok {
+		v, ok = upstreamUsers.get(UserInvestor, s.ID.Int64)
+	}
 	if ok {
 		s.UserAbstract = v
 
 	} else {
-		res, err := http.Get(fmt.Sprintf(investorsAPI, s.ID.Int64))
+		start := time.Now()
+		res, span, err := upstreamGet(ctx, UpstreamInvestors, UpstreamLoad, fmt.Sprintf(investorsAPI, s.ID.Int64))
 
 		if err != nil {
+			observeUpstream(UpstreamInvestors, UpstreamLoad, start, true)
+			endSpan(span, err)
 			log.Print(err)
 			return err
 		}
 		defer res.Body.Close()
 		u := userExternal{}
 		err = json.NewDecoder(res.Body).Decode(&u)
+		observeUpstream(UpstreamInvestors, UpstreamLoad, start, err != nil)
+		endSpan(span, err)
 		//fmt.Print(u)
 		if err != nil {
 			log.Print(err)
@@ -119,6 +139,7 @@ func (s *Investor) Load(cache map[int64]UserAbstract) error {
 		s.Cert.String = u.Cert
 
 		cache[s.ID.Int64] = s.UserAbstract
+		upstreamUsers.put(UserInvestor, s.ID.Int64, s.UserAbstract)
 	}
 	return nil
 }
@@ -126,16 +147,51 @@ func (s *Investor) Load(cache map[int64]UserAbstract) error {
 type ContractBody struct {
 	Title       string
 	Description string
-	Amount      int64
-	MustBeDone  string
+	// Money is the amount with its currency, the currency of the contract is the currency of
+	// its offers and milestones
+	Money
+	MustBeDone string
+	// Milestones are omitted from the encoding when empty, so signatures made before
+	// milestones were introduced remain valid
+	Milestones []Milestone `json:",omitempty"`
+	// ValidUntil is set by the supplier, the offer with these terms may not be accepted later
+	ValidUntil string `json:",omitempty"`
+}
+
+// Milestone is a part of the work with its own payment and deadline
+type Milestone struct {
+	Title string
+	Money
+	DueDate string
 }
 
 type Contract struct {
 	ID       int64
 	Supplier *Supplier
-	Investor *Investor
-	Stage    int64
-	Created  string
+	// Investor created the contract, Investors fund it together when it is co-funded
+	Investor  *Investor
+	Investors []ContractInvestor `json:",omitempty"`
+	Stage     int64
+	Created   string
+	// BiddingDeadline - offers are not accepted after it when set
+	BiddingDeadline sql.NullString
+	// Sealed contracts accept only sealed bids, they are revealed between the bidding deadline
+	// and RevealDeadline
+	Sealed         bool
+	RevealDeadline sql.NullString
+	Award          ContractAward
+	// Converted is the amount in the currency requested with ConvertTo of ListContracts
+	Converted *Money `json:",omitempty"`
+	// Template the title and description were rendered from
+	Template *TemplateRef `json:",omitempty"`
+	// Revision is increased on every change of the contract, it is the ETag of the contract
+	Revision int64
+	// Version of ContractBody, it is increased by the edits of the open contract, its conclusion
+	// and the amendments of the signed contract, see /contracts/{id}/versions
+	Version int64
+	// Archived is the time the concluded contract was cancelled, archived contracts are listed
+	// only with Archived=true
+	Archived sql.NullString
 
 	ContractBody ContractBody
 
@@ -148,6 +204,89 @@ func (c *Contract) GetEncoded() []byte {
 	return r
 }
 
+// contractColumns are the columns of contracts in the order scanContract expects them
+var contractColumns = []string{"id", "supplier_id", "investor_id", "stage", "created", "bidding_deadline",
+	"sealed", "reveal_deadline", "award_policy", "award_weights", "awarded", "award_offer_id", "title", "description", "amount", "currency",
+	"must_be_done", "milestones", "valid_until", "supplier_signature", "investor_signature", "template_id", "template_version", "template_params", "revision", "archived_at", "version"}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanContract scans a row selected with contractColumns
+func scanContract(row rowScanner, contract *Contract) error {
+	var policy, weights, milestones, validUntil, templateParams sql.NullString
+	var templateID, templateVersion sql.NullInt64
+	err := row.Scan(&contract.ID, &contract.Supplier.ID, &contract.Investor.ID, &contract.Stage, &contract.Created,
+		&contract.BiddingDeadline, &contract.Sealed, &contract.RevealDeadline, &policy, &weights, &contract.Award.Awarded, &contract.Award.OfferID,
+		&contract.ContractBody.Title, &contract.ContractBody.Description, &contract.ContractBody.Amount, &contract.ContractBody.Currency,
+		&contract.ContractBody.MustBeDone, &milestones, &validUntil, &contract.SupplierSignature, &contract.InvestorSignature,
+		&templateID, &templateVersion, &templateParams, &contract.Revision, &contract.Archived, &contract.Version)
+	if err != nil {
+		return err
+	}
+	contract.Template = nil
+	if templateID.Valid {
+		contract.Template = &TemplateRef{TemplateID: templateID.Int64, Version: templateVersion.Int64}
+		if err := json.Unmarshal([]byte(templateParams.String), &contract.Template.Params); err != nil {
+			return err
+		}
+	}
+	if err := contract.Award.scan(policy, weights); err != nil {
+		return err
+	}
+	contract.ContractBody.ValidUntil = validUntil.String
+	return contract.ContractBody.scanMilestones(milestones)
+}
+
+// scanMilestones decodes milestones column, NULL means no milestones
+func (b *ContractBody) scanMilestones(s sql.NullString) error {
+	b.Milestones = nil
+	if !s.Valid || s.String == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(s.String), &b.Milestones)
+}
+
+// milestonesValue encodes milestones for the milestones column
+func (b *ContractBody) milestonesValue() interface{} {
+	if len(b.Milestones) == 0 {
+		return nil
+	}
+	r, _ := json.Marshal(b.Milestones)
+	return string(r)
+}
+
+// validateMilestones checks that every milestone is paid in the currency of the contract and dated
+// and that the milestones sum up to the amount, due dates are normalized to RFC3339 in UTC and
+// the currency is set when omitted
+func (b *ContractBody) validateMilestones() error {
+	if len(b.Milestones) == 0 {
+		b.Milestones = nil
+		return nil
+	}
+	var sum int64
+	for i, m := range b.Milestones {
+		if m.Title == "" || m.Amount <= 0 {
+			return errors.New("milestone must have title and positive amount")
+		}
+		if m.Currency != "" && m.Currency != b.Currency {
+			return errors.New("milestone must be paid in the currency of the contract")
+		}
+		b.Milestones[i].Currency = b.Currency
+		due, err := time.Parse(time.RFC3339, m.DueDate)
+		if err != nil {
+			return err
+		}
+		b.Milestones[i].DueDate = due.UTC().Format(time.RFC3339)
+		sum += m.Amount
+	}
+	if sum != b.Amount {
+		return errors.New("milestones do not sum up to the amount")
+	}
+	return nil
+}
+
 type Timestamp time.Time
 
 func (t *Timestamp) UnmarshalParam(src string) error {
@@ -156,17 +295,46 @@ func (t *Timestamp) UnmarshalParam(src string) error {
 	return err
 }
 
+func (t *Timestamp) UnmarshalJSON(src []byte) error {
+	var s string
+	if err := json.Unmarshal(src, &s); err != nil {
+		return err
+	}
+	return t.UnmarshalParam(s)
+}
+
 type ContractQuery struct {
 	Title       string
 	Description string
-	Amount      int64
-	MustBeDone  *Timestamp
+	// Money is the amount in minor units of the currency, the currency is required
+	Money
+	MustBeDone      *Timestamp
+	Milestones      []Milestone
+	BiddingDeadline *Timestamp
+	// Sealed requires BiddingDeadline and RevealDeadline after it
+	Sealed         bool
+	RevealDeadline *Timestamp
+	// CoInvestors fund the contract together with its creator, who funds the rest of Amount
+	CoInvestors []InvestorShare
+	// TemplateID renders Title and Description from TemplateVersion of the template, the latest
+	// one when 0, with TemplateParams
+	TemplateID      int64
+	TemplateVersion int64
+	TemplateParams  map[string]interface{}
+	AwardQuery
 }
 
 type Signature []byte
 
+// CreatedResponse is returned by the api controllers which insert new rows
+type CreatedResponse struct {
+	ID int64 `json:"id"`
+}
+
 type OfferAcceptionQuery struct {
-	OfferID           int64
+	OfferID int64
+	// Revision of the offer which is accepted, the latest one when 0
+	Revision          int64
 	InvestorSignature string
 }
 
@@ -177,14 +345,45 @@ type Offer struct {
 	Supplier          *Supplier
 	SupplierSignature sql.NullString
 	Comment           sql.NullString
+	// ValidUntil of the latest revision
+	ValidUntil sql.NullString
+	// Expired is the time the offer was found expired
+	Expired sql.NullString
+	// Stale is the time the contract was edited after the latest revision of the offer, the offer
+	// may not be accepted until the supplier signs a new revision
+	Stale sql.NullString
+}
+
+// offerColumns are the columns of offers in the order scanOffer expects them
+var offerColumns = []string{"id", "contract_id", "supplier_id", "supplier_signature", "comment", "created", "valid_until", "expired", "stale"}
+
+// scanOffer scans a row selected with offerColumns
+func scanOffer(row rowScanner, offer *Offer) error {
+	return row.Scan(&offer.ID, &offer.ContractID, &offer.Supplier.ID, &offer.SupplierSignature, &offer.Comment,
+		&offer.Created, &offer.ValidUntil, &offer.Expired, &offer.Stale)
 }
 
+// OfferQuery - SupplierSignature is made over the encoded ContractBody of the contract
+// with ValidUntil set
 type OfferQuery struct {
 	ContractID        int64
 	Comment           string
+	ValidUntil        *Timestamp
 	SupplierSignature string
 }
 
+// validUntilValue normalizes the optional validity time, it must be in the future
+func validUntilValue(t *Timestamp) (string, error) {
+	if t == nil {
+		return "", nil
+	}
+	validUntil := time.Time(*t).UTC()
+	if !validUntil.After(time.Now()) {
+		return "", errors.New("ValidUntil is in the past")
+	}
+	return validUntil.Format(time.RFC3339), nil
+}
+
 type ECDSASignature struct {
 	R *big.Int
 	S *big.Int
@@ -200,18 +399,58 @@ type SupplierContext struct {
 	SupplierID sql.NullInt64
 }
 
-const dbDriver = "sqlite3"
-const dbName = "./contracts.sqlite3"
+// dbDriver is the sqlite3 driver instrumented with metrics
+const dbDriver = "sirius_sqlite3"
+
+// dbName is the path of the database, it is set from the configuration at startup
+var dbName = "./contracts.sqlite3"
+
+// paginate applies Limit and Offset query params to the select builder, rows are ordered by id
+// so pages are stable
+func paginate(c echo.Context, sb *sqlbuilder.SelectBuilder) error {
+	sb.OrderBy("id")
+	limit, offset := c.QueryParam("Limit"), c.QueryParam("Offset")
+	if limit == "" && offset == "" {
+		return nil
+	}
+	l, o := math.MaxInt32, 0
+	var err error
+	if limit != "" {
+		if l, err = strconv.Atoi(limit); err != nil || l < 0 {
+			return errors.New("invalid Limit")
+		}
+	}
+	if offset != "" {
+		if o, err = strconv.Atoi(offset); err != nil || o < 0 {
+			return errors.New("invalid Offset")
+		}
+	}
+	sb.Limit(l)
+	sb.Offset(o)
+	return nil
+}
 
 // ListContracts - api controller for getting list of available contracts
 func ListContracts(c echo.Context) error {
 	supplierID := c.QueryParam("SupplierID")
 	investorID := c.QueryParam("InvestorID")
 	title := c.QueryParam("Title")
+	currency := c.QueryParam("Currency")
+	convertTo := c.QueryParam("ConvertTo")
+	if _, ok := currencyExponents[convertTo]; convertTo != "" && !ok {
+		return c.String(http.StatusBadRequest, "Unknown currency")
+	}
 
 	sb := sqlbuilder.NewSelectBuilder()
-	sb.Select("*")
+	sb.Select(contractColumns...)
 	sb.From("contracts")
+	// deleted contracts are not listed, archived ones only with Archived=true
+	sb.Where(sb.IsNull("deleted_at"))
+	if c.QueryParam("Archived") == "true" {
+		sb.Where(sb.IsNotNull("archived_at"))
+	} else {
+		sb.Where(sb.IsNull("archived_at"))
+	}
 	if supplierID != "" {
 		a, err := strconv.Atoi(supplierID)
 		if err != nil {
@@ -224,14 +463,20 @@ func ListContracts(c echo.Context) error {
 		if err != nil {
 			return c.String(http.StatusBadRequest, "Bad Request")
 		}
-		sb.Where(sb.Equal("investor_id", a))
+		sb.Where(ownedByInvestor(&sb.Cond, "", a))
 	}
 	if title != "" {
 		sb.Where(sb.Like("title", title))
 	}
+	if currency != "" {
+		sb.Where(sb.Equal("currency", currency))
+	}
+	if err := paginate(c, sb); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
 	q, args := sb.Build()
 
-	db, err := sql.Open(dbDriver, dbName)
+	db, err := openDB(c.Request().Context())
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -252,16 +497,32 @@ func ListContracts(c echo.Context) error {
 		investor := Investor{}
 		contract := Contract{Investor: &investor, Supplier: &supplier}
 
-		err = rows.Scan(&contract.ID, &contract.Supplier.ID, &contract.Investor.ID, &contract.Stage,
-			&contract.Created, &contract.ContractBody.Title, &contract.ContractBody.Description, &contract.ContractBody.Amount, &contract.ContractBody.MustBeDone,
-			&contract.SupplierSignature, &contract.InvestorSignature)
+		err = scanContract(rows, &contract)
 		if err != nil {
 			log.Fatal(err)
 		}
-		contract.Investor.Load(investorsCache)
-		contract.Supplier.Load(suppliersCache)
 		contracts = append(contracts, contract)
 	}
+	rows.Close()
+
+	var rates map[string]ExchangeRate
+	if convertTo != "" {
+		if rates, err = exchangeRates(db); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for i := range contracts {
+		contract := &contracts[i]
+		if contract.Investors, err = contractInvestors(db, contract.ID); err != nil {
+			log.Fatal(err)
+		}
+		// contracts without a rate of their currency are left unconverted
+		if converted, ok := convert(rates, contract.ContractBody.Money, convertTo); convertTo != "" && ok {
+			contract.Converted = &converted
+		}
+		contract.Investor.Load(c.Request().Context(), investorsCache)
+		contract.Supplier.Load(c.Request().Context(), suppliersCache)
+	}
 
 	return c.JSON(http.StatusOK, contracts)
 }
@@ -271,8 +532,10 @@ func GetContract(c echo.Context) error {
 	ID := c.Param("id")
 
 	sb := sqlbuilder.NewSelectBuilder()
-	sb.Select("*")
+	sb.Select(contractColumns...)
 	sb.From("contracts")
+	// archived contracts remain readable with their signatures
+	sb.Where(sb.IsNull("deleted_at"))
 	if ID != "" {
 		a, err := strconv.Atoi(ID)
 		if err != nil {
@@ -282,7 +545,7 @@ func GetContract(c echo.Context) error {
 	}
 	q, args := sb.Build()
 
-	db, err := sql.Open(dbDriver, dbName)
+	db, err := openDB(c.Request().Context())
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -293,24 +556,63 @@ func GetContract(c echo.Context) error {
 	investor := Investor{}
 	contract := Contract{Investor: &investor, Supplier: &supplier}
 
-	err = db.QueryRow(q, args...).Scan(&contract.ID, &contract.Supplier.ID, &contract.Investor.ID,
-		&contract.Stage, &contract.Created, &contract.ContractBody.Title, &contract.ContractBody.Description,
-		&contract.ContractBody.Amount, &contract.ContractBody.MustBeDone, &contract.SupplierSignature, &contract.InvestorSignature)
+	err = scanContract(db.QueryRow(q, args...), &contract)
 
 	if err == sql.ErrNoRows {
 		return c.String(http.StatusNotFound, "Contract not found")
 	} else if err != nil {
 		log.Fatal(err)
 	}
+	if contract.Investors, err = contractInvestors(db, contract.ID); err != nil {
+		log.Fatal(err)
+	}
 	investorsCache := make(map[int64]UserAbstract)
 	suppliersCache := make(map[int64]UserAbstract)
 
-	contract.Investor.Load(investorsCache)
-	contract.Supplier.Load(suppliersCache)
+	contract.Investor.Load(c.Request().Context(), investorsCache)
+	contract.Supplier.Load(c.Request().Context(), suppliersCache)
 
+	c.Response().Header().Set(HeaderETag, contractETag(contract.Revision))
 	return c.JSON(http.StatusOK, contract)
 }
 
+// GetContractEncoded - api controller for retrieving the exact bytes of ContractBody which are signed
+// by the supplier and the investor
+func GetContractEncoded(c echo.Context) error {
+	ID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("title", "description", "amount", "currency", "must_be_done", "milestones", "valid_until")
+	sb.From("contracts")
+	sb.Where(sb.Equal("id", ID), sb.IsNull("deleted_at"))
+	q, args := sb.Build()
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	contract := Contract{}
+	var milestones, validUntil sql.NullString
+	err = db.QueryRow(q, args...).Scan(&contract.ContractBody.Title, &contract.ContractBody.Description,
+		&contract.ContractBody.Amount, &contract.ContractBody.Currency, &contract.ContractBody.MustBeDone, &milestones, &validUntil)
+	if err == sql.ErrNoRows {
+		return c.String(http.StatusNotFound, "Contract not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	contract.ContractBody.ValidUntil = validUntil.String
+	if err := contract.ContractBody.scanMilestones(milestones); err != nil {
+		log.Fatal(err)
+	}
+
+	return c.Blob(http.StatusOK, echo.MIMEApplicationJSON, contract.GetEncoded())
+}
+
 // CreateContract - api controller for creating new contract
 func CreateContract(c echo.Context) error {
 	ic := c.(InvestorContext)
@@ -321,20 +623,98 @@ func CreateContract(c echo.Context) error {
 		log.Print(err)
 		return c.String(http.StatusBadRequest, "Bad Request")
 	}
+	if contractQuery.MustBeDone == nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	if quota := currentConfig().DailyContracts; quota > 0 {
+		created, retryAfter, err := contractsCreatedToday(db, ic.InvestorID.Int64, time.Now())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if created >= int64(quota) {
+			return quotaExceeded(c, fmt.Sprintf("Quota of %d contracts a day is exceeded", quota), retryAfter)
+		}
+	}
+
+	contractBody := ContractBody{
+		Title:       contractQuery.Title,
+		Description: contractQuery.Description,
+	}
+	var templateRef *TemplateRef
+	if contractQuery.TemplateID != 0 {
+		if contractQuery.Title != "" || contractQuery.Description != "" {
+			return c.String(http.StatusBadRequest, "Title and Description are rendered from the template")
+		}
+		v, err := loadTemplateVersion(db, contractQuery.TemplateID, contractQuery.TemplateVersion)
+		if err == errTemplateNotFound {
+			return c.String(http.StatusBadRequest, err.Error())
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		rendered, params, err := v.render(contractQuery.TemplateParams)
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		contractBody.Title, contractBody.Description = rendered.Title, rendered.Description
+		templateRef = &TemplateRef{TemplateID: v.TemplateID, Version: v.Version, Params: params}
+	}
+	contractBody.Money = contractQuery.Money
+	if err := contractBody.Money.validate(); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	contractBody.MustBeDone = time.Time(*contractQuery.MustBeDone).Format(time.RFC3339)
+	contractBody.Milestones = contractQuery.Milestones
+	if err := contractBody.validateMilestones(); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Milestones")
+	}
+	var biddingDeadline sql.NullString
+	if contractQuery.BiddingDeadline != nil {
+		biddingDeadline.String, biddingDeadline.Valid = time.Time(*contractQuery.BiddingDeadline).UTC().Format(time.RFC3339), true
+	}
+	if err := contractQuery.AwardQuery.validate(biddingDeadline.Valid); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	var revealDeadline sql.NullString
+	if contractQuery.Sealed {
+		if contractQuery.BiddingDeadline == nil || contractQuery.RevealDeadline == nil ||
+			!time.Time(*contractQuery.RevealDeadline).After(time.Time(*contractQuery.BiddingDeadline)) {
+			return c.String(http.StatusBadRequest, "Sealed requires RevealDeadline after BiddingDeadline")
+		}
+		revealDeadline.String, revealDeadline.Valid = time.Time(*contractQuery.RevealDeadline).UTC().Format(time.RFC3339), true
+	} else if contractQuery.RevealDeadline != nil {
+		return c.String(http.StatusBadRequest, "RevealDeadline requires Sealed")
+	}
+	shares, err := fundingShares(ic.InvestorID.Int64, contractBody.Amount, contractQuery.CoInvestors)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
 	ib := sqlbuilder.NewInsertBuilder()
 	ib.InsertInto("contracts")
-	ib.Cols("investor_id", "title", "created", "description", "amount", "must_be_done")
-	ib.Values(ic.InvestorID, contractQuery.Title, time.Now().Format(time.RFC3339), contractQuery.Description,
-		contractQuery.Amount, time.Time(*contractQuery.MustBeDone).Format(time.RFC3339))
+	ib.Cols("investor_id", "title", "created", "description", "amount", "currency", "must_be_done", "milestones", "bidding_deadline",
+		"sealed", "reveal_deadline", "award_policy", "award_weights", "award_signature", "template_id", "template_version", "template_params")
+	templateID, templateVersion, templateParams := templateRef.values()
+	ib.Values(ic.InvestorID, contractBody.Title, time.Now().Format(time.RFC3339), contractBody.Description,
+		contractBody.Amount, contractBody.Currency, contractBody.MustBeDone, contractBody.milestonesValue(), biddingDeadline,
+		contractQuery.Sealed, revealDeadline, contractQuery.AwardQuery.policyValue(), contractQuery.AwardQuery.weightsValue(), contractQuery.AwardQuery.signatureValue(),
+		templateID, templateVersion, templateParams)
 	q, args := ib.Build()
 
-	db, err := sql.Open(dbDriver, dbName)
-	if err != nil {
+	tx, err := db.Begin()
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
+	defer tx.Rollback()
 
-	res, err := db.Exec(q, args...)
+	res, err := tx.Exec(q, args...)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -342,36 +722,279 @@ func CreateContract(c echo.Context) error {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := insertContractInvestors(tx, id, shares); err != nil {
+		log.Fatal(err)
+	}
+	err = insertContractVersion(tx, &ContractVersion{
+		ContractID: id,
+		Version:    1,
+		Body:       contractBody,
+		AuthorKind: UserInvestor,
+		AuthorID:   ic.InvestorID.Int64,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = EmitEvent(tx, Event{
+		Type:       EventContractCreated,
+		ContractID: id,
+		InvestorID: ic.InvestorID.Int64,
+		Data: struct {
+			ContractBody
+			BiddingDeadline string          `json:",omitempty"`
+			Sealed          bool            `json:",omitempty"`
+			RevealDeadline  string          `json:",omitempty"`
+			AwardPolicy     string          `json:",omitempty"`
+			Investors       []InvestorShare `json:",omitempty"`
+			Template        *TemplateRef    `json:",omitempty"`
+		}{contractBody, biddingDeadline.String, contractQuery.Sealed, revealDeadline.String, contractQuery.AwardPolicy, shares, templateRef},
+		Public: true,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := tx.Commit(); isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	eventsCommitted()
 
-	return c.JSON(http.StatusCreated, struct{ id int64 }{id: id})
+	return c.JSON(http.StatusCreated, CreatedResponse{ID: id})
 }
 
 // VerifySignature verifies ecdsa signature, algorithm - ECDSA with curve P-384 and hash - SHA-512-384
 func VerifySignature(b64signature, pemcert string, data []byte) bool {
+	result := verifySignature(b64signature, pemcert, data)
+	signatureVerifications.WithLabelValues(result).Inc()
+	return result == SignatureValid
+}
+
+// verifySignature returns SignatureValid, SignatureInvalid or SignatureMalformed when the signature
+// or the certificate can not be decoded
+func verifySignature(b64signature, pemcert string, data []byte) string {
 	derSignature, err := base64.StdEncoding.DecodeString(b64signature)
 	if err != nil {
-		return false
+		return SignatureMalformed
 	}
 	sig := ECDSASignature{}
 	_, err = asn1.Unmarshal(derSignature, &sig)
 	if err != nil {
 		fmt.Print(err)
-		return false
+		return SignatureMalformed
 	}
 	hash := sha512.Sum384(data)
 	certBlock, rest := pem.Decode([]byte(pemcert))
-	if len(rest) > 0 {
-		return false
+	if certBlock == nil || len(rest) > 0 {
+		return SignatureMalformed
 	}
 	certObj, err := x509.ParseCertificate(certBlock.Bytes)
 	if err != nil {
-		return false
+		return SignatureMalformed
+	}
+	pubKey, ok := certObj.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return SignatureMalformed
+	}
+	if !ecdsa.Verify(pubKey, hash[:], sig.R, sig.S) {
+		return SignatureInvalid
+	}
+	return SignatureValid
+}
+
+// Errors of acceptOffer
+var (
+	errOfferNotFound        = errors.New("Offer not found")
+	errCounterNotAgreed     = errors.New("Counter-offer is not agreed by the supplier")
+	errOfferExpired         = errors.New("Offer expired")
+	errOfferStale           = errors.New("Offer was made on an earlier version of the contract, the supplier must sign its new revision")
+	errContractConcluded    = errors.New("Contract is already concluded")
+	errRevealNotOver        = errors.New("Sealed bids are being revealed")
+	errSignatureNotVerified = errors.New("Signature not verified")
+)
+
+// offerRevisedError is returned by acceptOffer when the accepted revision is not the latest one
+type offerRevisedError struct {
+	Latest int64
+}
+
+func (e offerRevisedError) Error() string {
+	return "Offer was revised, the latest revision is " + strconv.FormatInt(e.Latest, 10)
+}
+
+// acceptOffer concludes the contract with the latest revision of the offer, investorSignature must
+// be made over its encoded terms with the certificate of the investor. revision is the number of
+// the accepted revision, 0 means the latest one. It is the common path of UpdateContract and awards.
+// A co-funded contract is concluded by the last of its investors to sign the same revision, until then
+// the signature is recorded and errSignaturesPending is returned. The amount is moved to the escrow
+// of the contract on conclusion, errInsufficientFunds is returned when the investors lack it.
+// The changes are made in tx, the caller commits it on success and on errSignaturesPending.
+func acceptOffer(tx *sql.Tx, contract *Contract, investor *Investor, offerID, revision int64, investorSignature string) (*OfferRevision, error) {
+	if contract.Stage != 0 {
+		return nil, errContractConcluded
+	}
+	// every sealed bid must have a chance to be revealed before one is accepted
+	if contract.Sealed {
+		if deadline, err := time.Parse(time.RFC3339, contract.RevealDeadline.String); err == nil && time.Now().Before(deadline) {
+			return nil, errRevealNotOver
+		}
+	}
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("supplier_id", "stale")
+	sb.From("offers")
+	sb.Where(sb.Equal("id", offerID), sb.Equal("contract_id", contract.ID), sb.IsNull("deleted_at"))
+	q, args := sb.Build()
+	var supplierID int64
+	var stale sql.NullString
+	err := tx.QueryRow(q, args...).Scan(&supplierID, &stale)
+	if err == sql.ErrNoRows {
+		return nil, errOfferNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if stale.Valid {
+		return nil, errOfferStale
+	}
+
+	// the latest revision of the offer is accepted, it must be signed by the supplier,
+	// a counter-offer of the investor is agreed by the supplier with a revision of the same terms
+	latest, err := latestOfferRevision(tx, offerID)
+	if err == sql.ErrNoRows {
+		return nil, errOfferNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if revision != 0 && revision != latest.Revision {
+		return nil, offerRevisedError{latest.Revision}
+	}
+	if latest.AuthorKind != UserSupplier {
+		return nil, errCounterNotAgreed
+	}
+	if latest.expired(time.Now()) {
+		return nil, errOfferExpired
+	}
+	if !VerifySignature(investorSignature, investor.Cert.String, latest.encoded) {
+		return nil, errSignatureNotVerified
+	}
+	investors, err := contractInvestors(tx, contract.ID)
+	if err != nil {
+		return nil, err
+	}
+	// the co-investors must fund the accepted amount
+	if len(investors) > 0 {
+		var funded int64
+		for _, ci := range investors {
+			funded += ci.Share
+		}
+		if funded < latest.Terms.Amount {
+			return nil, errUnderfunded
+		}
+	}
+
+	investorID := contract.Investor.ID.Int64
+	if len(investors) > 0 {
+		signed, err := signContract(tx, contract.ID, investor.ID.Int64, latest, investorSignature)
+		if err != nil {
+			return nil, err
+		}
+		if signed < len(investors) {
+			err = EmitEvent(tx, Event{
+				Type:       EventContractSigned,
+				ContractID: contract.ID,
+				OfferID:    offerID,
+				InvestorID: investor.ID.Int64,
+				SupplierID: supplierID,
+				Data: struct {
+					Revision int64
+					Signed   int
+					Required int
+				}{latest.Revision, signed, len(investors)},
+				Recipients: investorAndSupplier(investor.ID.Int64, supplierID),
+			})
+			if err != nil {
+				return nil, err
+			}
+			return latest, errSignaturesPending
+		}
+		// the contract keeps the signature of its creator, the others are kept by contract_investors
+		sb := sqlbuilder.NewSelectBuilder()
+		sb.Select("signature")
+		sb.From("contract_investors")
+		sb.Where(sb.Equal("contract_id", contract.ID), sb.Equal("investor_id", investorID))
+		q, args := sb.Build()
+		if err := tx.QueryRow(q, args...).Scan(&investorSignature); err != nil {
+			return nil, err
+		}
+	}
+
+	// the accepted terms are the next version of the body when they differ from it
+	terms := latest.Terms
+	changes := diffBodies(contract.ContractBody, terms)
+	version := contract.Version
+	if len(changes) > 0 {
+		version++
+	}
+	ub := sqlbuilder.NewUpdateBuilder()
+	ub.Update("contracts")
+	ub.Where(ub.Equal("id", contract.ID), ub.Equal("stage", 0))
+	ub.Set(ub.Assign("supplier_id", supplierID), ub.Assign("supplier_signature", latest.Signature), ub.Assign("investor_signature", investorSignature), ub.Assign("stage", 1),
+		ub.Assign("amount", terms.Amount), ub.Assign("must_be_done", terms.MustBeDone), ub.Assign("milestones", terms.milestonesValue()),
+		ub.Assign("valid_until", sql.NullString{String: terms.ValidUntil, Valid: terms.ValidUntil != ""}), ub.Assign("version", version))
+	q, args = ub.Build()
+
+	res, err := tx.Exec(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected != 1 {
+		// concluded concurrently
+		return nil, errContractConcluded
+	}
+	if len(changes) > 0 {
+		err := insertContractVersion(tx, &ContractVersion{
+			ContractID:      contract.ID,
+			Version:         version,
+			Body:            terms,
+			Changes:         changes,
+			AuthorKind:      latest.AuthorKind,
+			AuthorID:        latest.AuthorID,
+			OfferRevisionID: sql.NullInt64{Int64: latest.ID, Valid: true},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	var funders []InvestorShare
+	for _, ci := range investors {
+		funders = append(funders, InvestorShare{ci.InvestorID, ci.Share})
+	}
+	if len(funders) == 0 {
+		funders = []InvestorShare{{investorID, terms.Amount}}
 	}
-	pubKey := certObj.PublicKey.(*ecdsa.PublicKey)
-	return ecdsa.Verify(pubKey, hash[:], sig.R, sig.S)
+	if err := fundEscrow(tx, contract.ID, terms.Money, funders); err != nil {
+		return nil, err
+	}
+
+	err = EmitEvent(tx, Event{
+		Type:       EventContractAccepted,
+		ContractID: contract.ID,
+		OfferID:    offerID,
+		InvestorID: investorID,
+		SupplierID: supplierID,
+		Data:       struct{ Revision int64 }{latest.Revision},
+		Recipients: investorAndSupplier(investorID, supplierID),
+	})
+	return latest, err
 }
 
-// UpdateContract - api controller for accepting an offer and finalizing contract creation
+// UpdateContract - api controller for accepting an offer and finalizing contract creation, the contract
+// is selected, checked against If-Match and concluded in one transaction
 func UpdateContract(c echo.Context) error {
 	ic := c.(InvestorContext)
 	contractID, _ := strconv.Atoi(c.Param("id"))
@@ -382,113 +1005,270 @@ func UpdateContract(c echo.Context) error {
 		return c.String(http.StatusBadRequest, "Bad Request")
 	}
 
-	db, err := sql.Open(dbDriver, dbName)
+	// the signature is made by the current investor, the creator or a co-investor, the certificate
+	// is loaded before the transaction is begun
+	signer := Investor{UserAbstract: UserAbstract{ID: ic.InvestorID}}
+	if err := signer.Load(c.Request().Context(), make(map[int64]UserAbstract)); err != nil {
+		log.Print(err)
+		return c.String(http.StatusBadGateway, "Investor's certificate could not be loaded")
+	}
+
+	db, err := openDB(c.Request().Context())
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
 
+	tx, err := db.Begin()
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
 	sb := sqlbuilder.NewSelectBuilder()
-	sb.Select("*")
+	sb.Select(contractColumns...)
 	sb.From("contracts")
-	sb.Where(sb.Equal("id", contractID), sb.Equal("investor_id", ic.InvestorID))
+	sb.Where(sb.Equal("id", contractID), ownedByInvestor(&sb.Cond, "", ic.InvestorID), sb.IsNull("deleted_at"), sb.IsNull("archived_at"))
 	q, args := sb.Build()
 
 	supplier := Supplier{}
 	investor := Investor{}
 	contract := Contract{Investor: &investor, Supplier: &supplier}
 
-	err = db.QueryRow(q, args...).Scan(&contract.ID, &contract.Supplier.ID, &contract.Investor.ID,
-		&contract.Stage, &contract.Created, &contract.ContractBody.Title, &contract.ContractBody.Description,
-		&contract.ContractBody.Amount, &contract.ContractBody.MustBeDone, &contract.SupplierSignature, &contract.InvestorSignature)
+	err = scanContract(tx.QueryRow(q, args...), &contract)
 
 	if err == sql.ErrNoRows {
 		return c.String(http.StatusNotFound, "Contract not found")
 	} else if err != nil {
 		log.Fatal(err)
 	}
-
-	investorsCache := make(map[int64]UserAbstract)
-	contract.Investor.Load(investorsCache)
-
-	sb = sqlbuilder.NewSelectBuilder()
-
-	sb.Select("supplier_id", "supplier_signature")
-	sb.From("offers")
-	sb.Where(sb.Equal("id", offerAcceptionQuery.OfferID), sb.Equal("contract_id", contract.ID))
-	q, args = sb.Build()
-	var supplierSignature string
-	var supplierID int64
-	err = db.QueryRow(q, args...).Scan(&supplierID, &supplierSignature)
-
-	if err == sql.ErrNoRows {
-		return c.String(http.StatusNotFound, "Offer not found")
-	} else if err != nil {
-		log.Fatal(err)
+	if etag := contractETag(contract.Revision); !ifMatch(c, etag) {
+		return preconditionFailed(c, etag)
 	}
 
-	contractToBeSigned := contract.GetEncoded()
-	investorVerified := VerifySignature(offerAcceptionQuery.InvestorSignature, contract.Investor.Cert.String, contractToBeSigned)
-
-	if investorVerified {
-		ub := sqlbuilder.NewUpdateBuilder()
-		ub.Update("contracts")
-		ub.Where(ub.Equal("id", contract.ID))
-		ub.Set(ub.Assign("supplier_id", supplierID), ub.Assign("supplier_signature", supplierSignature), ub.Assign("investor_signature", offerAcceptionQuery.InvestorSignature), ub.Assign("stage", 1))
-		q, args := ub.Build()
-		res, err := db.Exec(q, args...)
-		if err != nil {
+	_, err = acceptOffer(tx, &contract, &signer, offerAcceptionQuery.OfferID, offerAcceptionQuery.Revision, offerAcceptionQuery.InvestorSignature)
+	if revised, ok := err.(offerRevisedError); ok {
+		return c.String(http.StatusConflict, revised.Error())
+	}
+	switch err {
+	case nil, errSignaturesPending:
+		revision, rerr := currentContractRevision(tx, contract.ID)
+		if rerr != nil {
+			log.Fatal(rerr)
+		}
+		if err := tx.Commit(); isBusy(err) {
+			return busyConflict(c)
+		} else if err != nil {
 			log.Fatal(err)
 		}
-		affected, err := res.RowsAffected()
-		if err != nil || affected != 1 {
-			log.Fatal(err, affected)
+		eventsCommitted()
+		c.Response().Header().Set(HeaderETag, contractETag(revision))
+		if err == errSignaturesPending {
+			return c.String(http.StatusAccepted, err.Error())
 		}
 		return c.String(http.StatusOK, "")
-
-	} else {
-		return c.String(http.StatusBadRequest, "Signature not verified")
+	case errOfferNotFound:
+		return c.String(http.StatusNotFound, err.Error())
+	case errSignatureNotVerified:
+		return c.String(http.StatusBadRequest, err.Error())
+	case errCounterNotAgreed, errOfferExpired, errOfferStale, errContractConcluded, errRevealNotOver, errUnderfunded, errInsufficientFunds:
+		return c.String(http.StatusConflict, err.Error())
+	}
+	if isBusy(err) {
+		return busyConflict(c)
 	}
+	log.Fatal(err)
+	return nil
 }
 
 // DeleteContract - api controller for removing contract
 func DeleteContract(c echo.Context) error {
 	ic := c.(InvestorContext)
 
-	dB, err := sql.Open(dbDriver, dbName)
+	dB, err := openDB(c.Request().Context())
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer dB.Close()
 
-	id := c.Param("id")
-	db := sqlbuilder.NewDeleteBuilder()
-	db.DeleteFrom("contracts")
-	db.Where(db.Equal("id", id), db.Equal("investor_id", ic.InvestorID))
-	q, args := db.Build()
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
 
-	res, err := dB.Exec(q, args...)
+	roles, err := requestRoles(c, dB, UserInvestor, ic.InvestorID.Int64)
 	if err != nil {
 		log.Fatal(err)
 	}
-	affected, err := res.RowsAffected()
+	ownerID := ic.InvestorID.Int64
+	if allowed(roles, ActionContractDelete, ScopeAny) {
+		ownerID = 0
+	} else if !allowed(roles, ActionContractDelete, ScopeOwn) {
+		return c.String(http.StatusForbidden, "Forbidden")
+	}
+	return removeContract(c, dB, id, ownerID, auditActor{UserInvestor, ic.InvestorID.Int64}, "")
+}
+
+// removeContract deletes the contract of the investor ownerID or of any investor when ownerID is 0,
+// the removal is recorded in the audit log when the contract is not removed by its investor. An open
+// contract is soft deleted, it may be restored for restore_grace and is purged after deleted_retention.
+// A concluded contract is cancelled and archived by the staff only, its signatures are kept for good.
+// Its investor cancels it by agreement with the supplier, see CancelContract.
+func removeContract(c echo.Context, dB *sql.DB, id, ownerID int64, by auditActor, reason string) error {
+	tx, err := dB.Begin()
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("investor_id", "stage", "revision")
+	sb.From("contracts")
+	sb.Where(sb.Equal("id", id), sb.IsNull("deleted_at"), sb.IsNull("archived_at"))
+	if ownerID != 0 {
+		sb.Where(sb.Equal("investor_id", ownerID))
+	}
+	q, args := sb.Build()
+	var investorID, stage, revision int64
+	err = tx.QueryRow(q, args...).Scan(&investorID, &stage, &revision)
+	if err == sql.ErrNoRows {
+		return c.String(http.StatusNotFound, "Contract not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if etag := contractETag(revision); !ifMatch(c, etag) {
+		return preconditionFailed(c, etag)
+	}
+	// the escrow of a signed contract is refunded only by agreement of its parties or by the staff
+	archived := stage != 0
+	if archived && by == (auditActor{UserInvestor, investorID}) {
+		return c.String(http.StatusConflict, "Contract is signed, it is cancelled by agreement with the supplier")
+	}
+
+	recipients, err := contractRecipients(tx, id, investorID)
 	if err != nil {
 		log.Fatal(err)
 	}
-	if affected != 1 {
+
+	now := time.Now().UTC()
+	var removed bool
+	if archived {
+		removed, err = archiveContract(tx, id, by, now)
+	} else {
+		ub := sqlbuilder.NewUpdateBuilder()
+		ub.Update("contracts")
+		ub.Set(ub.Assign("deleted_at", now.Format(time.RFC3339)), ub.Assign("deleted_by", by.String()))
+		ub.Where(ub.Equal("id", id), ub.Equal("stage", 0), ub.IsNull("deleted_at"), ub.IsNull("archived_at"))
+		q, args = ub.Build()
+		var res sql.Result
+		if res, err = tx.Exec(q, args...); err == nil {
+			var affected int64
+			affected, err = res.RowsAffected()
+			removed = affected == 1
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !removed {
 		return c.String(http.StatusNotFound, "Contract not found")
 	}
 
+	// the parties learn until when the deletion may be undone
+	data := struct {
+		Archived     bool   `json:",omitempty"`
+		RestoreUntil string `json:",omitempty"`
+	}{Archived: archived}
+	if !archived {
+		data.RestoreUntil = now.Add(currentConfig().RestoreGrace).Format(time.RFC3339)
+	}
+	err = EmitEvent(tx, Event{
+		Type:       EventContractDeleted,
+		ContractID: id,
+		InvestorID: investorID,
+		Data:       data,
+		Recipients: recipients,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if by != (auditActor{UserInvestor, investorID}) {
+		if err := recordAudit(tx, by, ActionContractDelete, auditTarget("contract", id), reason); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	eventsCommitted()
+
 	return c.String(http.StatusOK, "")
 }
 
+// archiveContract cancels the signed contract and refunds the money left in its escrow to its investors,
+// it reports false when the contract is not signed or already archived
+func archiveContract(tx dbExecutor, id int64, by auditActor, now time.Time) (bool, error) {
+	ub := sqlbuilder.NewUpdateBuilder()
+	ub.Update("contracts")
+	ub.Set(ub.Assign("archived_at", now.Format(time.RFC3339)), ub.Assign("deleted_by", by.String()))
+	ub.Where(ub.Equal("id", id), ub.GreaterEqualThan("stage", 1), ub.IsNull("deleted_at"), ub.IsNull("archived_at"))
+	q, args := ub.Build()
+
+	res, err := tx.Exec(q, args...)
+	if err != nil {
+		return false, err
+	}
+	if affected, err := res.RowsAffected(); err != nil || affected != 1 {
+		return false, err
+	}
+	return true, refundEscrow(tx, id)
+}
+
+// contractRecipients returns the investor of the contract with the suppliers who made offers or sealed bids
+// on it, they are notified about its removal and restoring
+func contractRecipients(tx dbExecutor, id, investorID int64) ([]EventRecipient, error) {
+	recipients := investorAndSupplier(investorID, 0)
+	notified := make(map[int64]bool)
+	for _, table := range []string{"offers", "sealed_bids"} {
+		sb := sqlbuilder.NewSelectBuilder()
+		sb.Select("DISTINCT supplier_id")
+		sb.From(table)
+		sb.Where(sb.Equal("contract_id", id))
+		q, args := sb.Build()
+		rows, err := tx.Query(q, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var supplierID int64
+			if err := rows.Scan(&supplierID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if !notified[supplierID] {
+				notified[supplierID] = true
+				recipients = append(recipients, EventRecipient{UserSupplier, supplierID})
+			}
+		}
+		rows.Close()
+	}
+	return recipients, nil
+}
+
 // GetOffer - api controller for retrieving an offer by ID
 func GetOffer(c echo.Context) error {
 	ID := c.Param("id")
 
 	sb := sqlbuilder.NewSelectBuilder()
-	sb.Select("*")
+	sb.Select(offerColumns...)
 	sb.From("offers")
+	sb.Where(sb.IsNull("deleted_at"), "contract_id IN (SELECT id FROM contracts WHERE deleted_at IS NULL)")
 	if ID != "" {
 		a, err := strconv.Atoi(ID)
 		if err != nil {
@@ -498,7 +1278,7 @@ func GetOffer(c echo.Context) error {
 	}
 	q, args := sb.Build()
 
-	db, err := sql.Open(dbDriver, dbName)
+	db, err := openDB(c.Request().Context())
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -508,15 +1288,21 @@ func GetOffer(c echo.Context) error {
 
 	offer := Offer{Supplier: &supplier}
 
-	err = db.QueryRow(q, args...).Scan(offer.ID, offer.ContractID, offer.Supplier.ID, offer.SupplierSignature, offer.Comment, offer.Created)
+	err = scanOffer(db.QueryRow(q, args...), &offer)
 	if err == sql.ErrNoRows {
-		return c.String(http.StatusNotFound, "Contract not found")
+		return c.String(http.StatusNotFound, "Offer not found")
 	} else if err != nil {
 		log.Fatal(err)
 	}
 
+	etag, err := currentOfferETag(db, offer.ID, offer.Expired.Valid, offer.Stale.Valid)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	suppliersCache := make(map[int64]UserAbstract)
-	offer.Supplier.Load(suppliersCache)
+	offer.Supplier.Load(c.Request().Context(), suppliersCache)
+	c.Response().Header().Set(HeaderETag, etag)
 	return c.JSON(http.StatusOK, offer)
 }
 
@@ -524,10 +1310,13 @@ func GetOffer(c echo.Context) error {
 func ListOffers(c echo.Context) error {
 	supplierID := c.QueryParam("SupplierID")
 	contractID := c.QueryParam("ContractID")
+	currency := c.QueryParam("Currency")
 
 	sb := sqlbuilder.NewSelectBuilder()
-	sb.Select("*")
+	sb.Select(offerColumns...)
 	sb.From("offers")
+	// the deleted offers and the offers of the deleted contracts are not listed
+	sb.Where(sb.IsNull("deleted_at"), "contract_id IN (SELECT id FROM contracts WHERE deleted_at IS NULL)")
 	if supplierID != "" {
 		a, err := strconv.Atoi(supplierID)
 		if err != nil {
@@ -542,10 +1331,16 @@ func ListOffers(c echo.Context) error {
 		}
 		sb.Where(sb.Equal("contract_id", a))
 	}
+	if currency != "" {
+		sb.Where("contract_id IN (SELECT id FROM contracts WHERE currency = " + sb.Var(currency) + ")")
+	}
+	if err := paginate(c, sb); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
 
 	q, args := sb.Build()
 
-	db, err := sql.Open(dbDriver, dbName)
+	db, err := openDB(c.Request().Context())
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -564,11 +1359,11 @@ func ListOffers(c echo.Context) error {
 		supplier := Supplier{}
 		offer := Offer{Supplier: &supplier}
 
-		err = rows.Scan(&offer.ID, &offer.ContractID, &offer.Supplier.ID, &offer.SupplierSignature, &offer.Comment, &offer.Created)
+		err = scanOffer(rows, &offer)
 		if err != nil {
 			log.Fatal(err)
 		}
-		offer.Supplier.Load(suppliersCache)
+		offer.Supplier.Load(c.Request().Context(), suppliersCache)
 		offers = append(offers, offer)
 	}
 
@@ -584,7 +1379,7 @@ func CreateOffer(c echo.Context) error {
 	supplier := Supplier{UserAbstract: UserAbstract{ID: sc.SupplierID}}
 
 	m := make(map[int64]UserAbstract)
-	err := supplier.Load(m)
+	err := supplier.Load(c.Request().Context(), m)
 
 	print(supplier.Cert.String)
 	if err != nil {
@@ -597,27 +1392,56 @@ func CreateOffer(c echo.Context) error {
 		return c.String(http.StatusBadRequest, "Bad Request")
 	}
 
-	db, err := sql.Open(dbDriver, dbName)
+	db, err := openDB(c.Request().Context())
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
 
+	if quota := currentConfig().MaxOpenOffers; quota > 0 {
+		open, err := openOffers(db, sc.SupplierID.Int64)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if open >= int64(quota) {
+			return quotaExceeded(c, fmt.Sprintf("Quota of %d open offers is exceeded", quota), 0)
+		}
+	}
+
 	sb := sqlbuilder.NewSelectBuilder()
-	sb.Select("title", "description", "amount", "must_be_done")
+	sb.Select("investor_id", "stage", "bidding_deadline", "sealed", "title", "description", "amount", "currency", "must_be_done", "milestones")
 	sb.From("contracts")
-	sb.Where(sb.Equal("id", offerQuery.ContractID))
+	sb.Where(sb.Equal("id", offerQuery.ContractID), sb.IsNull("deleted_at"), sb.IsNull("archived_at"))
 	q, args := sb.Build()
 
 	contractBody := ContractBody{}
+	var investorID, stage int64
+	var sealed bool
+	var biddingDeadline, milestones sql.NullString
 
-	err = db.QueryRow(q, args...).Scan(&contractBody.Title, &contractBody.Description, &contractBody.Amount, &contractBody.MustBeDone)
+	err = db.QueryRow(q, args...).Scan(&investorID, &stage, &biddingDeadline, &sealed, &contractBody.Title, &contractBody.Description, &contractBody.Amount, &contractBody.Currency, &contractBody.MustBeDone, &milestones)
 
 	if err == sql.ErrNoRows {
 		return c.String(http.StatusNotFound, "Contract not found")
 	} else if err != nil {
 		log.Fatal(err)
 	}
+	if err := contractBody.scanMilestones(milestones); err != nil {
+		log.Fatal(err)
+	}
+	if stage != 0 {
+		return c.String(http.StatusConflict, "Contract is already concluded")
+	}
+	if sealed {
+		return c.String(http.StatusConflict, "Contract accepts sealed bids only")
+	}
+	if deadline, err := time.Parse(time.RFC3339, biddingDeadline.String); err == nil && time.Now().After(deadline) {
+		return c.String(http.StatusConflict, "Bidding is closed")
+	}
+	contractBody.ValidUntil, err = validUntilValue(offerQuery.ValidUntil)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
 
 	contractEncoded, err := json.Marshal(contractBody)
 	fmt.Printf("%v", contractEncoded)
@@ -630,11 +1454,20 @@ func CreateOffer(c echo.Context) error {
 
 	ib := sqlbuilder.NewInsertBuilder()
 	ib.InsertInto("offers")
-	ib.Cols("contract_id", "supplier_id", "supplier_signature", "comment", "created")
-	ib.Values(offerQuery.ContractID, sc.SupplierID, offerQuery.SupplierSignature, offerQuery.Comment, time.Now().Format(time.RFC3339))
+	ib.Cols("contract_id", "supplier_id", "supplier_signature", "comment", "created", "valid_until")
+	ib.Values(offerQuery.ContractID, sc.SupplierID, offerQuery.SupplierSignature, offerQuery.Comment, time.Now().Format(time.RFC3339),
+		sql.NullString{String: contractBody.ValidUntil, Valid: contractBody.ValidUntil != ""})
 	q, args = ib.Build()
 
-	res, err := db.Exec(q, args...)
+	tx, err := db.Begin()
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(q, args...)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -643,26 +1476,118 @@ func CreateOffer(c echo.Context) error {
 		log.Fatal(err)
 	}
 
-	return c.JSON(http.StatusCreated, struct{ id int64 }{id: id})
+	// the offer opens negotiation with the terms of the contract
+	err = insertOfferRevision(tx, &OfferRevision{
+		OfferID:    id,
+		Revision:   1,
+		AuthorKind: UserSupplier,
+		AuthorID:   sc.SupplierID.Int64,
+		Terms:      contractBody,
+		Signature:  offerQuery.SupplierSignature,
+		Comment:    sql.NullString{String: offerQuery.Comment, Valid: true},
+	}, contractEncoded)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = EmitEvent(tx, Event{
+		Type:       EventOfferCreated,
+		ContractID: offerQuery.ContractID,
+		OfferID:    id,
+		InvestorID: investorID,
+		SupplierID: sc.SupplierID.Int64,
+		Data: struct {
+			Comment    string
+			ValidUntil string `json:",omitempty"`
+		}{offerQuery.Comment, contractBody.ValidUntil},
+		Recipients: investorAndSupplier(investorID, sc.SupplierID.Int64),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := tx.Commit(); isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	eventsCommitted()
+
+	return c.JSON(http.StatusCreated, CreatedResponse{ID: id})
 }
 
 // DeleteOffer - api controller for removing offers by ID
 func DeleteOffer(c echo.Context) error {
 	sc := c.(SupplierContext)
 
-	dB, err := sql.Open(dbDriver, dbName)
+	dB, err := openDB(c.Request().Context())
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer dB.Close()
 
-	id := c.Param("id")
-	db := sqlbuilder.NewDeleteBuilder()
-	db.DeleteFrom("offers")
-	db.Where(db.Equal("id", id), db.Equal("supplier_id", sc.SupplierID))
-	q, args := db.Build()
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	roles, err := requestRoles(c, dB, UserSupplier, sc.SupplierID.Int64)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ownerID := sc.SupplierID.Int64
+	if allowed(roles, ActionOfferDelete, ScopeAny) {
+		ownerID = 0
+	} else if !allowed(roles, ActionOfferDelete, ScopeOwn) {
+		return c.String(http.StatusForbidden, "Forbidden")
+	}
+	return removeOffer(c, dB, id, ownerID, auditActor{UserSupplier, sc.SupplierID.Int64}, "")
+}
+
+// removeOffer deletes the offer of the supplier ownerID or of any supplier when ownerID is 0,
+// the removal is recorded in the audit log when the offer is not removed by its supplier. The offer
+// is soft deleted with its revisions, it may be restored for restore_grace.
+func removeOffer(c echo.Context, dB *sql.DB, id, ownerID int64, by auditActor, reason string) error {
+	tx, err := dB.Begin()
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("offers.contract_id", "contracts.investor_id", "offers.supplier_id", "offers.expired", "offers.stale")
+	sb.From("offers")
+	sb.Join("contracts", "contracts.id = offers.contract_id")
+	sb.Where(sb.Equal("offers.id", id), sb.IsNull("offers.deleted_at"), sb.IsNull("contracts.deleted_at"))
+	if ownerID != 0 {
+		sb.Where(sb.Equal("offers.supplier_id", ownerID))
+	}
+	q, args := sb.Build()
+	var contractID, investorID, supplierID int64
+	var expired, stale sql.NullString
+	err = tx.QueryRow(q, args...).Scan(&contractID, &investorID, &supplierID, &expired, &stale)
+	if err == sql.ErrNoRows {
+		return c.String(http.StatusNotFound, "Offer not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	etag, err := currentOfferETag(tx, id, expired.Valid, stale.Valid)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !ifMatch(c, etag) {
+		return preconditionFailed(c, etag)
+	}
+
+	now := time.Now().UTC()
+	ub := sqlbuilder.NewUpdateBuilder()
+	ub.Update("offers")
+	ub.Set(ub.Assign("deleted_at", now.Format(time.RFC3339)), ub.Assign("deleted_by", by.String()))
+	ub.Where(ub.Equal("id", id), ub.Equal("supplier_id", supplierID), ub.IsNull("deleted_at"))
+	q, args = ub.Build()
 
-	res, err := dB.Exec(q, args...)
+	res, err := tx.Exec(q, args...)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -674,6 +1599,30 @@ func DeleteOffer(c echo.Context) error {
 		return c.String(http.StatusNotFound, "Offer not found")
 	}
 
+	err = EmitEvent(tx, Event{
+		Type:       EventOfferDeleted,
+		ContractID: contractID,
+		OfferID:    id,
+		InvestorID: investorID,
+		SupplierID: supplierID,
+		Data:       struct{ RestoreUntil string }{now.Add(currentConfig().RestoreGrace).Format(time.RFC3339)},
+		Recipients: investorAndSupplier(investorID, supplierID),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if by != (auditActor{UserSupplier, supplierID}) {
+		if err := recordAudit(tx, by, ActionOfferDelete, auditTarget("offer", id), reason); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	eventsCommitted()
+
 	return c.String(http.StatusOK, "")
 }
 
@@ -682,16 +1631,21 @@ type (
 	InvestorAuthorizationToken string
 )
 
-func (t SupplierAuthorizationToken) Authorize() (int64, error) {
+func (t SupplierAuthorizationToken) Authorize(ctx context.Context) (int64, error) {
 	//tokenSuppliersApi := GatewayURL + "/clients/auth/"
-	tokenSuppliersApi := "http://192.168.43.219:8191/api/clients/auth/"
-	res, err := http.Get(tokenSuppliersApi + string(t))
+	tokenSuppliersApi := currentConfig().SuppliersURL + "/auth/"
+	start := time.Now()
+	res, span, err := upstreamGet(ctx, UpstreamSuppliers, UpstreamAuthorize, tokenSuppliersApi+string(t))
 	//fmt.Print("111")
 	if err != nil {
+		observeUpstream(UpstreamSuppliers, UpstreamAuthorize, start, true)
+		endSpan(span, err)
 		log.Print(err)
 		return 0, err
 	}
 	defer res.Body.Close()
+	defer span.End()
+	observeUpstream(UpstreamSuppliers, UpstreamAuthorize, start, res.StatusCode >= http.StatusInternalServerError)
 
 	if res.StatusCode == http.StatusOK {
 		idstr, err := ioutil.ReadAll(res.Body)
@@ -702,16 +1656,21 @@ func (t SupplierAuthorizationToken) Authorize() (int64, error) {
 	}
 }
 
-func (t InvestorAuthorizationToken) Authorize() (int64, error) {
+func (t InvestorAuthorizationToken) Authorize(ctx context.Context) (int64, error) {
 	//tokenInvestorsApi := GatewayURL + "/investors/auth/"
-	tokenInvestorsApi := "http://192.168.43.219:8193/api/investors/auth/"
-	res, err := http.Get(tokenInvestorsApi + string(t))
+	tokenInvestorsApi := currentConfig().InvestorsURL + "/auth/"
+	start := time.Now()
+	res, span, err := upstreamGet(ctx, UpstreamInvestors, UpstreamAuthorize, tokenInvestorsApi+string(t))
 
 	if err != nil {
+		observeUpstream(UpstreamInvestors, UpstreamAuthorize, start, true)
+		endSpan(span, err)
 		log.Print(err)
 		return 0, err
 	}
 	defer res.Body.Close()
+	defer span.End()
+	observeUpstream(UpstreamInvestors, UpstreamAuthorize, start, res.StatusCode >= http.StatusInternalServerError)
 	if res.StatusCode == http.StatusOK {
 		idstr, err := ioutil.ReadAll(res.Body)
 		a, err := strconv.Atoi(string(idstr))
@@ -730,63 +1689,222 @@ func ResponseHeaderMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 
 func SupplierAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		// serve passes the request of the user to the handler unless the user exceeds the rate limit
+		serve := func(id int64) error {
+			if ok, err := limitUser(c, UserSupplier, id); !ok {
+				return err
+			}
+			return next(SupplierContext{c, sql.NullInt64{Int64: id, Valid: true}})
+		}
+		if user, ok := c.Get(sessionUserKey).(*sessionUser); ok {
+			if user.kind != UserSupplier {
+				return echo.ErrUnauthorized
+			}
+			return serve(user.id)
+		}
+		if id, ok := certificateUser(c, UserSupplier); ok {
+			return serve(id)
+		}
 		var token SupplierAuthorizationToken
 		var s string
 		fmt.Sscanf(c.Request().Header.Get("Authorization"), "Token %s", &s)
 		//log.Print("111")
 		token = SupplierAuthorizationToken(s)
-		id, err := token.Authorize()
+		id, err := token.Authorize(c.Request().Context())
 		if err != nil {
 			return echo.ErrUnauthorized
 		}
-		ID := sql.NullInt64{Int64: id, Valid: true}
-		sc := SupplierContext{c, ID}
-		return next(sc)
+		return serve(id)
 	}
 }
 
 func InvestorAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		// serve passes the request of the user to the handler unless the user exceeds the rate limit
+		serve := func(id int64) error {
+			if ok, err := limitUser(c, UserInvestor, id); !ok {
+				return err
+			}
+			return next(InvestorContext{c, sql.NullInt64{Int64: id, Valid: true}})
+		}
+		if user, ok := c.Get(sessionUserKey).(*sessionUser); ok {
+			if user.kind != UserInvestor {
+				return echo.ErrUnauthorized
+			}
+			return serve(user.id)
+		}
+		if id, ok := certificateUser(c, UserInvestor); ok {
+			return serve(id)
+		}
 		var token InvestorAuthorizationToken
 		var s string
 		fmt.Sscanf(c.Request().Header.Get("Authorization"), "Token %s", &s)
 		//log.Print("856765765")
 		token = InvestorAuthorizationToken(s)
-		id, err := token.Authorize()
+		id, err := token.Authorize(c.Request().Context())
 		if err != nil {
 			return echo.ErrUnauthorized
 		}
-		ID := sql.NullInt64{Int64: id, Valid: true}
-		sc := InvestorContext{c, ID}
-		return next(sc)
+		return serve(id)
 	}
 }
 
-/*
-	GET contracts/ - list of contracts, filterable params - supplierID, investorID, title
-	GET contracts/{id} - retrieve contract with specific id
-	POST contracts/ - create contract
-	PATCH contracts/{id} - update contract(accept offer)
-	DELETE contracts/{id} - delete contract with specific id
-
-	GET offers/ - list of offers, fliterable params - supplierID, contractID
-	GET offers/{id} - retrieve offer with specific id
-	POST offers/ - create offer
-	DELETE offers/{id} - delete offer with specific id
-*/
+// API description is generated from the registered routes and apiDocs (see openapi.go),
+// it is served at /openapi.json and rendered at /docs
 func main() {
+	configPath := flag.String("config", os.Getenv("SIRIUS_CONFIG"), "YAML or TOML configuration file")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration and exit")
+	configFlags := RegisterConfigFlags(flag.CommandLine)
+	flag.Parse()
+
+	cfg, err := LoadConfig(*configPath, configFlags)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *printConfig {
+		fmt.Print(cfg.Redacted())
+		return
+	}
+	config.Store(cfg)
+	dbName = cfg.Database
+	log.Print("effective configuration:\n", cfg.Redacted())
+
+	if paymentProvider, err = NewPaymentProvider(cfg.PaymentProvider); err != nil {
+		log.Fatal(err)
+	}
+	if err := Migrate(); err != nil {
+		log.Fatal(err)
+	}
+	go RunWebhookDeliveries()
+	go eventHub.Run()
+	go RunOfferExpiry()
+	go RunDepositSettlement()
+	go RunAwards()
+	go RunRetention()
+
+	shutdownTracing, err := InitTracing(cfg.TracesExporter, cfg.TracesFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	if cfg.RatesFile != "" {
+		if err := LoadExchangeRates(cfg.RatesFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if cfg.AMQPURL != "" {
+		broker := NewAMQPBroker(cfg.AMQPURL, cfg.AMQPExchange)
+		defer broker.Close()
+		go RunOutboxRelay(broker)
+	} else {
+		log.Print("amqp_url is not set, events are kept in the outbox unpublished")
+	}
+
+	if cfg.MetricsListen != "" {
+		go ServeMetrics(cfg.MetricsListen)
+	}
+
 	e := echo.New()
+	e.Logger.SetLevel(logLevels[cfg.LogLevel])
+	go WatchConfigReload(*configPath, configFlags, func(cfg *Config) {
+		e.Logger.SetLevel(logLevels[cfg.LogLevel])
+	})
+	registerRoutes(e)
+	CheckAPIDocs(e.Routes())
+
+	tlsConfig, err := serverTLSConfig(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	e.Logger.Fatal(e.StartServer(&http.Server{Addr: cfg.Listen, TLSConfig: tlsConfig}))
+}
+
+// registerRoutes adds the middlewares and the routes of the api to e
+func registerRoutes(e *echo.Echo) {
 	e.Use(ResponseHeaderMiddleware)
+	e.Use(TracingMiddleware)
+	e.Use(MetricsMiddleware)
+	e.Use(RateLimitMiddleware)
+	e.Use(SessionAuthMiddleware)
 	e.GET("/contracts", ListContracts)
 	e.GET("/contracts/:id", GetContract)
-	e.POST("/contracts", CreateContract, InvestorAuthMiddleware)
+	e.GET("/contracts/:id/encoded", GetContractEncoded)
+	e.POST("/contracts", CreateContract, InvestorAuthMiddleware, IdempotencyMiddleware)
 	e.PATCH("/contracts/:id", UpdateContract, InvestorAuthMiddleware)
+	e.PUT("/contracts/:id", EditContract, InvestorAuthMiddleware)
 	e.DELETE("/contracts/:id", DeleteContract, InvestorAuthMiddleware)
+	e.POST("/contracts/:id/restore", RestoreContract, InvestorAuthMiddleware)
+	e.PUT("/contracts/:id/award", SetAwardPolicy, InvestorAuthMiddleware)
+	e.PUT("/contracts/:id/investors", SetContractInvestors, InvestorAuthMiddleware)
+	e.GET("/contracts/:id/versions", ListContractVersions)
+	e.GET("/contracts/:id/amendments", ListAmendments)
 
 	e.GET("/offers", ListOffers)
 	e.GET("/offers/:id", GetOffer)
-	e.POST("/offers", CreateOffer, SupplierAuthMiddleware)
+	e.POST("/offers", CreateOffer, SupplierAuthMiddleware, IdempotencyMiddleware)
 	e.DELETE("/offers/:id", DeleteOffer, SupplierAuthMiddleware)
+	e.POST("/offers/:id/restore", RestoreOffer, SupplierAuthMiddleware)
+
+	e.POST("/bids", SubmitSealedBid, SupplierAuthMiddleware)
+
+	e.GET("/rates", ListExchangeRates)
+
+	e.POST("/investors/deposits", Deposit, InvestorAuthMiddleware)
+	e.POST("/contracts/:id/milestones/:n/accept", AcceptMilestone, InvestorAuthMiddleware)
+	e.GET("/ledger/check", CheckLedger, StaffAuthMiddleware(ActionLedgerCheck))
+
+	e.GET("/templates", ListTemplates)
+	e.GET("/templates/:id", GetTemplate)
+	e.GET("/templates/:id/versions", ListTemplateVersions)
+	e.POST("/templates/:id/render", RenderTemplate)
+	e.POST("/templates", CreateTemplate, InvestorAuthMiddleware)
+	e.PUT("/templates/:id", UpdateTemplate, InvestorAuthMiddleware)
+	e.DELETE("/templates/:id", DeleteTemplate, InvestorAuthMiddleware)
+
+	for _, g := range []struct {
+		prefix string
+		auth   echo.MiddlewareFunc
+	}{{"/investors", InvestorAuthMiddleware}, {"/suppliers", SupplierAuthMiddleware}} {
+		e.POST(g.prefix+"/webhooks", CreateWebhook, g.auth)
+		e.GET(g.prefix+"/webhooks", ListWebhooks, g.auth)
+		e.DELETE(g.prefix+"/webhooks/:id", DeleteWebhook, g.auth)
+		e.GET(g.prefix+"/webhooks/:id/deliveries", ListWebhookDeliveries, g.auth)
+		e.POST(g.prefix+"/webhooks/:id/deliveries/:delivery/replay", ReplayWebhookDelivery, g.auth)
+		e.GET(g.prefix+"/events", StreamEventsSSE, TokenQueryMiddleware, g.auth)
+		e.GET(g.prefix+"/events/ws", StreamEventsWebSocket, TokenQueryMiddleware, g.auth)
+		e.GET(g.prefix+"/offers/:id/revisions", ListOfferRevisions, g.auth)
+		e.GET(g.prefix+"/offers/:id/encoded", GetOfferEncoded, g.auth)
+		e.POST(g.prefix+"/offers/:id/revisions", ProposeOfferRevision, g.auth)
+		e.POST(g.prefix+"/contracts/:id/amendments", ProposeAmendment, g.auth)
+		e.POST(g.prefix+"/contracts/:id/cancellation", CancelContract, g.auth)
+		e.POST(g.prefix+"/contracts/:id/amendments/:amendment/accept", AcceptAmendment, g.auth)
+		e.POST(g.prefix+"/contracts/:id/amendments/:amendment/reject", RejectAmendment, g.auth)
+		e.GET(g.prefix+"/contracts/:id/bids", ListSealedBids, g.auth)
+		e.POST(g.prefix+"/bids/:id/reveal", RevealSealedBid, g.auth)
+		e.GET(g.prefix+"/ledger/accounts", ListLedgerAccounts, g.auth)
+		e.GET(g.prefix+"/ledger/accounts/:id/statement", GetLedgerStatement, g.auth)
+		e.POST(g.prefix+"/certificates", RegisterClientCertificate, g.auth)
+		e.GET(g.prefix+"/certificates", ListClientCertificates, g.auth)
+		e.DELETE(g.prefix+"/certificates/:id", DeleteClientCertificate, g.auth)
+	}
 
-	e.Logger.Fatal(e.Start(":1323"))
+	e.DELETE("/admin/contracts/:id", AdminDeleteContract, StaffAuthMiddleware(ActionContractDelete))
+	e.DELETE("/admin/offers/:id", AdminDeleteOffer, StaffAuthMiddleware(ActionOfferDelete))
+	e.POST("/admin/contracts/:id/restore", AdminRestoreContract, StaffAuthMiddleware(ActionContractRestore))
+	e.POST("/admin/offers/:id/restore", AdminRestoreOffer, StaffAuthMiddleware(ActionOfferRestore))
+	e.GET("/admin/contracts/:id/signatures", GetContractSignatures, StaffAuthMiddleware(ActionSignaturesRead))
+	e.GET("/admin/audit", ListAuditLog, StaffAuthMiddleware(ActionAuditRead))
+	e.GET("/admin/roles", ListRoles, StaffAuthMiddleware(ActionRolesManage))
+	e.POST("/admin/roles", GrantRole, StaffAuthMiddleware(ActionRolesManage))
+	e.DELETE("/admin/roles/:id", RevokeRole, StaffAuthMiddleware(ActionRolesManage))
+
+	e.POST("/auth/challenges", CreateLoginChallenge)
+	e.POST("/auth/sessions", CreateSession)
+	e.DELETE("/auth/sessions", DeleteSession)
+
+	e.GET("/metrics", MetricsHandler, StaffAuthMiddleware(ActionMetricsRead))
+	e.GET("/openapi.json", OpenAPIHandler(e))
+	e.GET("/docs", DocsHandler)
 }
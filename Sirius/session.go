@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/labstack/echo"
+)
+
+// challengeTTL is how long a login challenge may be answered
+const challengeTTL = 2 * time.Minute
+
+// sessionUserKey is the key of the *sessionUser in the echo context
+const sessionUserKey = "sessionUser"
+
+// ChallengeQuery asks for a login challenge of the user
+type ChallengeQuery struct {
+	Kind string
+	ID   int64
+}
+
+// LoginChallenge is answered by signing "Sirius login " followed by the nonce with the user's
+// signing key, the prefix keeps the signature from being valid for a contract or an offer
+type LoginChallenge struct {
+	Nonce   string
+	Expires string
+}
+
+// SessionQuery answers the login challenge
+type SessionQuery struct {
+	Nonce     string
+	Signature string
+}
+
+// Session is issued for the answered challenge, the token is sent as "Session <token>"
+// in the Authorization header until it expires
+type Session struct {
+	Token   string
+	Kind    string
+	ID      int64
+	Expires string
+}
+
+// sessionUser is the user authenticated by the session token of the request
+type sessionUser struct {
+	kind      string
+	id        int64
+	tokenHash string
+}
+
+// loginMessage returns the data signed to answer the challenge
+func loginMessage(nonce string) []byte {
+	return []byte("Sirius login " + nonce)
+}
+
+// hashSessionToken returns the hash the session token is stored by, the tokens themselves are not stored
+func hashSessionToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// randomToken returns n random bytes encoded with the encoding
+func randomToken(n int, encode func([]byte) string) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	return encode(b)
+}
+
+// CreateLoginChallenge - api controller for issuing the nonce the user signs to log in
+func CreateLoginChallenge(c echo.Context) error {
+	challengeQuery := new(ChallengeQuery)
+	if err := c.Bind(challengeQuery); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	if (challengeQuery.Kind != UserInvestor && challengeQuery.Kind != UserSupplier) || challengeQuery.ID <= 0 {
+		return c.String(http.StatusBadRequest, "Kind must be investor or supplier and ID positive")
+	}
+
+	now := time.Now().UTC()
+	challenge := LoginChallenge{
+		Nonce:   randomToken(32, base64.RawURLEncoding.EncodeToString),
+		Expires: now.Add(challengeTTL).Format(time.RFC3339),
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	// expired challenges and sessions are removed as new ones are made
+	for _, table := range []string{"login_challenges", "sessions"} {
+		dlb := sqlbuilder.NewDeleteBuilder()
+		dlb.DeleteFrom(table)
+		dlb.Where(dlb.LessEqualThan("expires", now.Format(time.RFC3339)))
+		q, args := dlb.Build()
+		if _, err := db.Exec(q, args...); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto("login_challenges")
+	ib.Cols("nonce", "user_kind", "user_id", "expires")
+	ib.Values(challenge.Nonce, challengeQuery.Kind, challengeQuery.ID, challenge.Expires)
+	q, args := ib.Build()
+	if _, err := db.Exec(q, args...); err != nil {
+		log.Fatal(err)
+	}
+	return c.JSON(http.StatusCreated, challenge)
+}
+
+// CreateSession - api controller for logging in with the signed challenge, the challenge is used up
+// whether the signature is valid or not
+func CreateSession(c echo.Context) error {
+	sessionQuery := new(SessionQuery)
+	if err := c.Bind(sessionQuery); err != nil || sessionQuery.Nonce == "" {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("user_kind", "user_id")
+	sb.From("login_challenges")
+	sb.Where(sb.Equal("nonce", sessionQuery.Nonce), sb.GreaterThan("expires", now.Format(time.RFC3339)))
+	q, args := sb.Build()
+	session := Session{}
+	err = tx.QueryRow(q, args...).Scan(&session.Kind, &session.ID)
+	if err == sql.ErrNoRows {
+		return c.String(http.StatusUnauthorized, "Challenge not found or expired")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	dlb := sqlbuilder.NewDeleteBuilder()
+	dlb.DeleteFrom("login_challenges")
+	dlb.Where(dlb.Equal("nonce", sessionQuery.Nonce))
+	q, args = dlb.Build()
+	if _, err := tx.Exec(q, args...); err != nil {
+		log.Fatal(err)
+	}
+	if err := tx.Commit(); isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	cert, err := userCert(c.Request().Context(), session.Kind, session.ID)
+	if err != nil {
+		return c.String(http.StatusUnauthorized, "User not found")
+	}
+	if !VerifySignature(sessionQuery.Signature, cert, loginMessage(sessionQuery.Nonce)) {
+		return c.String(http.StatusUnauthorized, "Invalid signature")
+	}
+
+	session.Token = randomToken(32, hex.EncodeToString)
+	session.Expires = now.Add(currentConfig().SessionTTL).Format(time.RFC3339)
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto("sessions")
+	ib.Cols("token_hash", "user_kind", "user_id", "created", "expires")
+	ib.Values(hashSessionToken(session.Token), session.Kind, session.ID, now.Format(time.RFC3339), session.Expires)
+	q, args = ib.Build()
+	if _, err := db.Exec(q, args...); err != nil {
+		log.Fatal(err)
+	}
+	return c.JSON(http.StatusCreated, session)
+}
+
+// DeleteSession - api controller for logging out, the session token of the request is revoked
+func DeleteSession(c echo.Context) error {
+	user, ok := c.Get(sessionUserKey).(*sessionUser)
+	if !ok {
+		return echo.ErrUnauthorized
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	dlb := sqlbuilder.NewDeleteBuilder()
+	dlb.DeleteFrom("sessions")
+	dlb.Where(dlb.Equal("token_hash", user.tokenHash))
+	q, args := dlb.Build()
+	if _, err := db.Exec(q, args...); err != nil {
+		log.Fatal(err)
+	}
+	return c.String(http.StatusOK, "")
+}
+
+// SessionAuthMiddleware authenticates the requests with "Session <token>" in the Authorization header,
+// the user is put in the context for SupplierAuthMiddleware and InvestorAuthMiddleware. Unknown and expired
+// sessions are rejected, requests with other credentials are passed on.
+func SessionAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var token string
+		if n, _ := fmt.Sscanf(c.Request().Header.Get(echo.HeaderAuthorization), "Session %s", &token); n != 1 {
+			return next(c)
+		}
+
+		user, err := findSession(c, token)
+		if err == sql.ErrNoRows {
+			return echo.ErrUnauthorized
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		c.Set(sessionUserKey, user)
+		return next(c)
+	}
+}
+
+// findSession returns the user of the session token, sql.ErrNoRows when the session is unknown or expired
+func findSession(c echo.Context, token string) (*sessionUser, error) {
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	user := &sessionUser{tokenHash: hashSessionToken(token)}
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("user_kind", "user_id")
+	sb.From("sessions")
+	sb.Where(sb.Equal("token_hash", user.tokenHash), sb.GreaterThan("expires", time.Now().UTC().Format(time.RFC3339)))
+	q, args := sb.Build()
+	if err := db.QueryRow(q, args...).Scan(&user.kind, &user.id); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLogin(t *testing.T) {
+	api := newTestAPI(t)
+	supplier, outsider := api.user(UserSupplier, 7), api.user(UserSupplier, 8)
+
+	challenge := func() string {
+		t.Helper()
+		rec := api.do(nil, http.MethodPost, "/auth/challenges", ChallengeQuery{Kind: UserSupplier, ID: 7})
+		var c LoginChallenge
+		if rec.Code != http.StatusCreated || json.Unmarshal(rec.Body.Bytes(), &c) != nil {
+			t.Fatalf("challenge: %d %s", rec.Code, rec.Body.String())
+		}
+		return c.Nonce
+	}
+	expectStatus(t, "challenge of an unknown kind", api.do(nil, http.MethodPost, "/auth/challenges", ChallengeQuery{Kind: "staff", ID: 7}), http.StatusBadRequest)
+
+	// a challenge is used up by a wrong answer
+	nonce := challenge()
+	expectStatus(t, "challenge answered by another supplier", api.do(nil, http.MethodPost, "/auth/sessions",
+		SessionQuery{Nonce: nonce, Signature: outsider.sign(loginMessage(nonce))}), http.StatusUnauthorized)
+	expectStatus(t, "challenge answered twice", api.do(nil, http.MethodPost, "/auth/sessions",
+		SessionQuery{Nonce: nonce, Signature: supplier.sign(loginMessage(nonce))}), http.StatusUnauthorized)
+	nonce = challenge()
+	expectStatus(t, "signature of the bare nonce", api.do(nil, http.MethodPost, "/auth/sessions",
+		SessionQuery{Nonce: nonce, Signature: supplier.sign([]byte(nonce))}), http.StatusUnauthorized)
+
+	nonce = challenge()
+	rec := api.do(nil, http.MethodPost, "/auth/sessions", SessionQuery{Nonce: nonce, Signature: supplier.sign(loginMessage(nonce))})
+	var session Session
+	if rec.Code != http.StatusCreated || json.Unmarshal(rec.Body.Bytes(), &session) != nil {
+		t.Fatalf("session: %d %s", rec.Code, rec.Body.String())
+	}
+	if session.Kind != UserSupplier || session.ID != 7 || session.Token == "" {
+		t.Errorf("session %+v, want one of supplier 7", session)
+	}
+
+	// the session authenticates the supplier, not another one
+	loggedIn := &testUser{api: api, kind: UserSupplier, id: 7, key: supplier.key, session: session.Token}
+	offer := "/offers/" + strconv.FormatInt(loggedIn.createOffer(api.user(UserInvestor, 1).createContract(nil)), 10)
+	expectStatus(t, "offer deleted by another supplier", outsider.do(http.MethodDelete, offer, nil), http.StatusNotFound)
+	expectStatus(t, "offer deleted with the session", loggedIn.do(http.MethodDelete, offer, nil), http.StatusOK)
+
+	expectStatus(t, "logout", loggedIn.do(http.MethodDelete, "/auth/sessions", nil), http.StatusOK)
+	expectStatus(t, "request after the logout", loggedIn.do(http.MethodPost, offer+"/restore", nil), http.StatusUnauthorized)
+	expectStatus(t, "logout without a session", api.do(nil, http.MethodDelete, "/auth/sessions", nil), http.StatusUnauthorized)
+
+	execTestDB(t, "UPDATE sessions SET expires = ? WHERE user_id = 8", time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+	expectStatus(t, "request with an expired session", outsider.do(http.MethodPost, offer+"/restore", nil), http.StatusUnauthorized)
+}
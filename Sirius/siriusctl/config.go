@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Profile holds connection settings for one Sirius environment,
+// Key is the path to PEM encoded EC private key issued by the ca tool,
+// Cert is the path to its client certificate and CA to the certificate verifying the server,
+// Session is the token of auth login which is sent instead of Token
+type Profile struct {
+	URL     string `yaml:"url"`
+	Token   string `yaml:"token,omitempty"`
+	Key     string `yaml:"key,omitempty"`
+	Cert    string `yaml:"cert,omitempty"`
+	CA      string `yaml:"ca,omitempty"`
+	Session string `yaml:"session,omitempty"`
+}
+
+// Config is stored in ~/.siriusctl.yaml
+type Config struct {
+	Current  string              `yaml:"current"`
+	Profiles map[string]*Profile `yaml:"profiles"`
+}
+
+func defaultConfigPath() string {
+	if p := os.Getenv("SIRIUSCTL_CONFIG"); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".siriusctl.yaml"
+	}
+	return filepath.Join(home, ".siriusctl.yaml")
+}
+
+// LoadConfig reads the config file, a missing file yields an empty config
+func LoadConfig(path string) (*Config, error) {
+	cfg := &Config{Profiles: make(map[string]*Profile)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = make(map[string]*Profile)
+	}
+	return cfg, nil
+}
+
+// Save writes the config file readable only by the owner, since it contains tokens
+func (cfg *Config) Save(path string) error {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// Profile returns the named profile or the current one if name is empty,
+// SIRIUS_URL, SIRIUS_TOKEN, SIRIUS_KEY, SIRIUS_CERT and SIRIUS_CA environment variables override its fields
+func (cfg *Config) Profile(name string) (*Profile, error) {
+	if name == "" {
+		name = cfg.Current
+	}
+	p := &Profile{URL: "http://localhost:1323"}
+	if name != "" {
+		stored, ok := cfg.Profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("profile %q not found", name)
+		}
+		*p = *stored
+	}
+	if v := os.Getenv("SIRIUS_URL"); v != "" {
+		p.URL = v
+	}
+	if v := os.Getenv("SIRIUS_TOKEN"); v != "" {
+		p.Token = v
+	}
+	if v := os.Getenv("SIRIUS_KEY"); v != "" {
+		p.Key = v
+	}
+	if v := os.Getenv("SIRIUS_CERT"); v != "" {
+		p.Cert = v
+	}
+	if v := os.Getenv("SIRIUS_CA"); v != "" {
+		p.CA = v
+	}
+	if p.URL == "" {
+		return nil, errors.New("api url is not configured")
+	}
+	return p, nil
+}
+
+// Names returns sorted profile names
+func (cfg *Config) Names() []string {
+	var names []string
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,1429 @@
+// siriusctl is a command line client of the Sirius contracts service
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/msoloviom/bright-sky-project/Sirius/client"
+)
+
+const usage = `Usage: siriusctl [-profile name] [-o table|json|yaml] <command> [args]
+
+Contracts:
+  contracts list [-supplier ID] [-investor ID] [-title pattern] [-currency C] [-convert-to C] [-archived]
+                                     -archived lists the cancelled concluded contracts
+  contracts show ID
+  contracts create -title T -description D -amount N -currency C -must-be-done RFC3339 [-bidding-deadline RFC3339]
+                   [-sealed -reveal-deadline RFC3339] [-co-investor ID,SHARE]...
+                   [-template ID [-template-version N] [-param NAME=VALUE]...] [-idempotency-key K]
+                                     -template renders title and description, VALUE is JSON or a string
+                                     amounts are in minor units of the ISO 4217 currency
+                                     repeating with the same -idempotency-key does not create another contract
+  contracts delete ID [-if-match ETAG] fails if the contract changed since the ETag of contracts show
+  contracts cancel ID [-supplier]    cancel the signed contract, it is archived and its escrow refunded
+                                     when both the investor and the supplier have cancelled it
+  contracts restore ID               undo the deletion within the restore grace period of the server
+  contracts sign ID                  print signature of the contract with the profile key
+  contracts award ID -policy manual|lowest_amount|earliest_delivery|weighted_score
+                  [-weight-amount W] [-weight-delivery W] [-preauthorize]
+                                     -preauthorize signs the contract terms with the profile key
+  contracts investors ID [-co-investor ID,SHARE]...
+                                     replace co-investors, the creator funds the rest of the amount
+  contracts milestone ID N           accept milestone N, numbered from 1, and release its payment
+  contracts edit ID [-title T] [-description D] [-amount N] [-currency C] [-must-be-done RFC3339]
+                 [-milestone T,N,RFC3339]... [-comment C] [-if-match ETAG]
+                                     new version of the open contract, unset fields are kept,
+                                     the offers made so far must be amended by their suppliers
+  contracts versions ID              version history of the contract with the changed fields
+
+Offers:
+  offers list [-contract ID] [-supplier ID] [-currency C]
+  offers create -contract ID [-valid-until RFC3339] [-comment C]
+                                               signed with the profile key
+  offers accept -contract ID -offer ID [-if-match ETAG]
+                                               signed with the profile key
+  offers withdraw ID
+  offers restore ID                            undo the withdrawal within the restore grace period
+  offers revisions ID [-supplier]              negotiation history of the offer, for its supplier
+                                               and the investors of its contract
+  offers amend -offer ID -amount N -must-be-done RFC3339 [-milestone T,N,RFC3339]...
+               [-valid-until RFC3339] [-comment C]
+  offers counter -offer ID -amount N -must-be-done RFC3339 [-milestone T,N,RFC3339]...
+               [-valid-until RFC3339] [-comment C]
+                                               amend as the supplier or counter as the investor,
+                                               signed with the profile key
+
+Amendments of signed contracts (-supplier for a supplier token):
+  amendments list CONTRACT [-status pending|accepted|rejected|withdrawn]
+  amendments propose CONTRACT [-title T] [-description D] [-must-be-done RFC3339]
+                     [-milestone T,N,RFC3339]... [-comment C] [-supplier]
+                                               unset fields are kept, signed with the profile key
+  amendments accept -contract ID -amendment ID [-supplier]
+                                               signed with the profile key, makes a new version
+  amendments reject -contract ID -amendment ID [-supplier]
+                                               reject, or withdraw the user's own proposal
+
+Sealed bids:
+  bids list CONTRACT [-supplier]               suppliers and ciphertexts are shown after the bidding deadline,
+                                               the investors see all the bids, a supplier its own
+  bids submit -contract ID -amount N -must-be-done RFC3339 [-milestone T,N,RFC3339]...
+              [-valid-until RFC3339] [-comment C] [-encrypt] -reveal-file FILE
+                                               signed with the profile key, the reveal is written to FILE,
+                                               -encrypt lets the investor reveal the bid
+  bids reveal ID -reveal-file FILE             reveal the supplier's bid after the bidding deadline
+  bids open -contract ID -bid ID               decrypt the bid with the investor's profile key and reveal it
+
+Templates:
+  templates list [-investor ID] [-name pattern]
+  templates show ID [-version N]
+  templates create -file FILE                 FILE is JSON with Name, Title, Description and Parameters
+  templates update ID -file FILE              makes a new version of the template
+  templates delete ID
+  templates render ID [-version N] [-param NAME=VALUE]...
+
+Exchange rates:
+  rates list                                   rates used by contracts list -convert-to
+
+Ledger:
+  ledger deposit -amount N -currency C         charge the payment provider and credit the investor account
+  ledger accounts [-supplier]                  accounts of the profile user, -supplier for a supplier token
+  ledger statement ID [-supplier]              postings to the account with running balance
+  ledger check                                 verify that the ledger is balanced, for auditors and admins
+
+Client certificates (the profile cert and key authenticate instead of the token):
+  certificates register [-supplier]            register the profile cert, authenticated by the token
+  certificates list [-supplier]
+  certificates delete ID [-supplier]
+
+Login (the session is stored in the profile and sent instead of the token):
+  auth login -id ID [-supplier]                sign the challenge with the profile key
+  auth logout
+
+Staff (after auth login or with the profile cert):
+  admin remove-contract ID -reason R           remove an abusive contract, for moderators and admins
+  admin remove-offer ID -reason R              remove an abusive offer, for moderators and admins
+  admin restore-contract ID [-reason R]        restore a deleted contract, for moderators and admins
+  admin restore-offer ID [-reason R]           restore a deleted offer, for moderators and admins
+  admin signatures ID                          signatures of the contract, its offers and revisions
+  admin audit [-action A] [-target T]          audit log of the removals and role changes
+  admin roles                                  staff roles granted to the users, for admins
+  admin grant -role moderator|auditor|admin -id ID [-supplier]
+  admin revoke ID                              revoke the role grant
+
+Profiles (stored in ~/.siriusctl.yaml, path is overridden by SIRIUSCTL_CONFIG):
+  profile list
+  profile set NAME [-url URL] [-token TOKEN] [-key FILE] [-cert FILE] [-ca FILE]
+  profile use NAME
+`
+
+// env is the state shared by the commands
+type env struct {
+	ctx         context.Context
+	cfg         *Config
+	cfgPath     string
+	profileName string
+	profile     *Profile
+	client      *client.Client
+	printer     *Printer
+	pageSize    int
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("siriusctl: ")
+
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	profileName := flag.String("profile", os.Getenv("SIRIUSCTL_PROFILE"), "profile name")
+	format := flag.String("o", "table", "output format: table, json or yaml")
+	timeout := flag.Duration("timeout", 30*time.Second, "request timeout")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfgPath := defaultConfigPath()
+	cfg, err := LoadConfig(cfgPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	e := &env{ctx: ctx, cfg: cfg, cfgPath: cfgPath, profileName: *profileName, printer: &Printer{Format: *format, Out: os.Stdout}, pageSize: 100}
+
+	if args[0] != "profile" {
+		e.profile, err = cfg.Profile(*profileName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts := []client.Option{client.WithToken(e.profile.Token), client.WithSession(e.profile.Session),
+			client.WithUserAgent("siriusctl/1.0")}
+		if e.profile.Cert != "" || e.profile.CA != "" {
+			opt, err := e.tlsOption()
+			if err != nil {
+				log.Fatal(err)
+			}
+			opts = append(opts, opt)
+		}
+		e.client = client.New(e.profile.URL, opts...)
+	}
+
+	switch args[0] + " " + args[1] {
+	case "contracts list":
+		err = e.listContracts(args[2:])
+	case "contracts show":
+		err = e.showContract(args[2:])
+	case "contracts create":
+		err = e.createContract(args[2:])
+	case "contracts delete":
+		err = e.deleteContract(args[2:])
+	case "contracts cancel":
+		err = e.cancelContract(args[2:])
+	case "contracts restore":
+		err = e.restoreContract(args[2:])
+	case "contracts sign":
+		err = e.signContract(args[2:])
+	case "contracts award":
+		err = e.awardContract(args[2:])
+	case "contracts investors":
+		err = e.setCoInvestors(args[2:])
+	case "contracts milestone":
+		err = e.acceptMilestone(args[2:])
+	case "contracts edit":
+		err = e.editContract(args[2:])
+	case "contracts versions":
+		err = e.listContractVersions(args[2:])
+	case "amendments list":
+		err = e.listAmendments(args[2:])
+	case "amendments propose":
+		err = e.proposeAmendment(args[2:])
+	case "amendments accept", "amendments reject":
+		err = e.resolveAmendment(args[1], args[2:])
+	case "offers list":
+		err = e.listOffers(args[2:])
+	case "offers create":
+		err = e.createOffer(args[2:])
+	case "offers accept":
+		err = e.acceptOffer(args[2:])
+	case "offers withdraw":
+		err = e.withdrawOffer(args[2:])
+	case "offers restore":
+		err = e.restoreOffer(args[2:])
+	case "offers revisions":
+		err = e.listRevisions(args[2:])
+	case "offers amend", "offers counter":
+		err = e.reviseOffer(args[1], args[2:])
+	case "bids list":
+		err = e.listBids(args[2:])
+	case "bids submit":
+		err = e.submitBid(args[2:])
+	case "bids reveal":
+		err = e.revealBid(args[2:])
+	case "bids open":
+		err = e.openBid(args[2:])
+	case "templates list":
+		err = e.listTemplates(args[2:])
+	case "templates show":
+		err = e.showTemplate(args[2:])
+	case "templates create", "templates update":
+		err = e.saveTemplate(args[1], args[2:])
+	case "templates delete":
+		err = e.deleteTemplate(args[2:])
+	case "templates render":
+		err = e.renderTemplate(args[2:])
+	case "rates list":
+		err = e.listRates()
+	case "ledger deposit":
+		err = e.deposit(args[2:])
+	case "ledger accounts":
+		err = e.listLedgerAccounts(args[2:])
+	case "ledger statement":
+		err = e.ledgerStatement(args[2:])
+	case "ledger check":
+		err = e.checkLedger()
+	case "certificates register":
+		err = e.registerCertificate(args[2:])
+	case "certificates list":
+		err = e.listCertificates(args[2:])
+	case "certificates delete":
+		err = e.deleteCertificate(args[2:])
+	case "auth login":
+		err = e.login(args[2:])
+	case "auth logout":
+		err = e.logout()
+	case "admin remove-contract", "admin remove-offer":
+		err = e.remove(args[1], args[2:])
+	case "admin restore-contract", "admin restore-offer":
+		err = e.restoreRemoved(args[1], args[2:])
+	case "admin signatures":
+		err = e.contractSignatures(args[2:])
+	case "admin audit":
+		err = e.auditLog(args[2:])
+	case "admin roles":
+		err = e.listRoles()
+	case "admin grant":
+		err = e.grantRole(args[2:])
+	case "admin revoke":
+		err = e.revokeRole(args[2:])
+	case "profile list":
+		err = e.listProfiles()
+	case "profile set":
+		err = e.setProfile(args[2:])
+	case "profile use":
+		err = e.useProfile(args[2:])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func idArg(args []string) (int64, error) {
+	if len(args) != 1 {
+		return 0, errors.New("exactly one ID expected")
+	}
+	return strconv.ParseInt(args[0], 10, 64)
+}
+
+func (e *env) signer() (crypto.Signer, error) {
+	if e.profile.Key == "" {
+		return nil, errors.New("signing key is not configured, use profile set -key or SIRIUS_KEY")
+	}
+	keyPem, err := ioutil.ReadFile(e.profile.Key)
+	if err != nil {
+		return nil, err
+	}
+	return client.ParsePrivateKey(keyPem)
+}
+
+// tlsOption configures the client with the profile client certificate and the CA of the server
+func (e *env) tlsOption() (client.Option, error) {
+	var cert tls.Certificate
+	if e.profile.Cert != "" {
+		if e.profile.Key == "" {
+			return nil, errors.New("key of the client certificate is not configured, use profile set -key or SIRIUS_KEY")
+		}
+		var err error
+		cert, err = tls.LoadX509KeyPair(e.profile.Cert, e.profile.Key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var roots *x509.CertPool
+	if e.profile.CA != "" {
+		caPem, err := ioutil.ReadFile(e.profile.CA)
+		if err != nil {
+			return nil, err
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(caPem) {
+			return nil, fmt.Errorf("%s: no certificates", e.profile.CA)
+		}
+	}
+	return client.WithClientCertificate(cert, roots), nil
+}
+
+func (e *env) listContracts(args []string) error {
+	fs := flag.NewFlagSet("contracts list", flag.ExitOnError)
+	var f client.ContractFilter
+	fs.Int64Var(&f.SupplierID, "supplier", 0, "supplier ID")
+	fs.Int64Var(&f.InvestorID, "investor", 0, "investor ID")
+	fs.StringVar(&f.Title, "title", "", "title pattern")
+	fs.StringVar(&f.Currency, "currency", "", "ISO 4217 currency")
+	fs.StringVar(&f.ConvertTo, "convert-to", "", "convert amounts to the currency")
+	fs.BoolVar(&f.Archived, "archived", false, "list the archived contracts")
+	fs.Parse(args)
+
+	var contracts []client.Contract
+	it := e.client.Contracts(f, e.pageSize)
+	for it.Next(e.ctx) {
+		contracts = append(contracts, it.Contract())
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	return e.printer.Contracts(contracts)
+}
+
+func (e *env) showContract(args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	contract, err := e.client.GetContract(e.ctx, id)
+	if err != nil {
+		return err
+	}
+	return e.printer.Contract(contract)
+}
+
+func (e *env) createContract(args []string) error {
+	fs := flag.NewFlagSet("contracts create", flag.ExitOnError)
+	var in client.ContractInput
+	fs.StringVar(&in.Title, "title", "", "title")
+	fs.StringVar(&in.Description, "description", "", "description")
+	fs.Int64Var(&in.Amount, "amount", 0, "amount in minor units")
+	fs.StringVar(&in.Currency, "currency", "", "ISO 4217 currency")
+	mustBeDone := fs.String("must-be-done", "", "deadline, RFC3339")
+	fs.Var(timeFlag{&in.BiddingDeadline}, "bidding-deadline", "offers are refused after it, RFC3339")
+	fs.BoolVar(&in.Sealed, "sealed", false, "accept only sealed bids")
+	fs.Var(timeFlag{&in.RevealDeadline}, "reveal-deadline", "sealed bids are revealed until it, RFC3339")
+	var coInvestors sharesFlag
+	fs.Var(&coInvestors, "co-investor", "co-investor ID,share, may be repeated")
+	fs.Int64Var(&in.TemplateID, "template", 0, "template rendering title and description")
+	fs.Int64Var(&in.TemplateVersion, "template-version", 0, "template version, the latest one by default")
+	params := paramsFlag{}
+	fs.Var(params, "param", "template parameter NAME=VALUE, may be repeated")
+	fs.StringVar(&in.IdempotencyKey, "idempotency-key", "", "key of the request, a random one by default")
+	fs.Parse(args)
+	in.CoInvestors = coInvestors
+	in.TemplateParams = params
+
+	if (in.Title == "" && in.TemplateID == 0) || in.Currency == "" || *mustBeDone == "" {
+		return errors.New("-title or -template, -currency and -must-be-done are required")
+	}
+	var err error
+	in.MustBeDone, err = time.Parse(time.RFC3339, *mustBeDone)
+	if err != nil {
+		return err
+	}
+	id, err := e.client.CreateContract(e.ctx, in)
+	if err != nil {
+		return err
+	}
+	return e.printer.Created(id)
+}
+
+func (e *env) deleteContract(args []string) error {
+	fs := flag.NewFlagSet("contracts delete", flag.ExitOnError)
+	etag := fs.String("if-match", "", "ETag of the contract")
+	id, err := idArg(parseInterspersed(fs, args))
+	if err != nil {
+		return err
+	}
+	return e.client.DeleteContract(e.conditional(*etag), id)
+}
+
+func (e *env) cancelContract(args []string) error {
+	fs := flag.NewFlagSet("contracts cancel", flag.ExitOnError)
+	supplier := fs.Bool("supplier", false, "the profile token is a supplier's")
+	id, err := idArg(parseInterspersed(fs, args))
+	if err != nil {
+		return err
+	}
+	cancelled, err := e.client.CancelContract(e.ctx, userKind(*supplier), id)
+	if err != nil {
+		return err
+	}
+	if !cancelled {
+		fmt.Println("waiting for the other party to cancel the contract")
+	}
+	return nil
+}
+
+func (e *env) restoreContract(args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	return e.client.RestoreContract(e.ctx, id)
+}
+
+// conditional returns the context of the requests made only if the resource still has the ETag
+func (e *env) conditional(etag string) context.Context {
+	if etag == "" {
+		return e.ctx
+	}
+	return client.IfMatch(e.ctx, etag)
+}
+
+func (e *env) signContract(args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	signer, err := e.signer()
+	if err != nil {
+		return err
+	}
+	sig, err := e.client.SignContract(e.ctx, id, signer)
+	if err != nil {
+		return err
+	}
+	fmt.Println(sig)
+	return nil
+}
+
+func (e *env) awardContract(args []string) error {
+	fs := flag.NewFlagSet("contracts award", flag.ExitOnError)
+	var in client.AwardInput
+	var weights client.AwardWeights
+	fs.StringVar(&in.AwardPolicy, "policy", "", "award policy")
+	fs.Float64Var(&weights.Amount, "weight-amount", 0, "weight of the amount, weighted_score policy")
+	fs.Float64Var(&weights.Delivery, "weight-delivery", 0, "weight of the delivery time, weighted_score policy")
+	preauthorize := fs.Bool("preauthorize", false, "sign the contract terms with the profile key")
+	id, err := idArg(parseInterspersed(fs, args))
+	if err != nil {
+		return err
+	}
+	if in.AwardPolicy == client.AwardWeightedScore {
+		in.AwardWeights = &weights
+	}
+	if !*preauthorize {
+		return e.client.SetAwardPolicy(e.ctx, id, in)
+	}
+	signer, err := e.signer()
+	if err != nil {
+		return err
+	}
+	return e.client.SetAwardPolicySigned(e.ctx, id, in, signer)
+}
+
+func (e *env) setCoInvestors(args []string) error {
+	fs := flag.NewFlagSet("contracts investors", flag.ExitOnError)
+	var coInvestors sharesFlag
+	fs.Var(&coInvestors, "co-investor", "co-investor ID,share, may be repeated")
+	id, err := idArg(parseInterspersed(fs, args))
+	if err != nil {
+		return err
+	}
+	return e.client.SetCoInvestors(e.ctx, id, coInvestors)
+}
+
+// sharesFlag collects repeated -co-investor ID,share flags
+type sharesFlag []client.InvestorShare
+
+func (f *sharesFlag) String() string {
+	return fmt.Sprint(len(*f), " co-investors")
+}
+
+func (f *sharesFlag) Set(s string) error {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return errors.New("co-investor must be ID,share")
+	}
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	share, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, client.InvestorShare{InvestorID: id, Share: share})
+	return nil
+}
+
+// parseInterspersed parses flags placed before and after the positional arguments
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// paramsFlag collects repeated -param NAME=VALUE flags, VALUE is decoded as JSON when it is valid
+// JSON and taken as a string otherwise
+type paramsFlag map[string]interface{}
+
+func (f paramsFlag) String() string {
+	return fmt.Sprint(len(f), " parameters")
+}
+
+func (f paramsFlag) Set(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return errors.New("parameter must be NAME=VALUE")
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(parts[1]), &v); err != nil {
+		v = parts[1]
+	}
+	f[parts[0]] = v
+	return nil
+}
+
+func (e *env) listOffers(args []string) error {
+	fs := flag.NewFlagSet("offers list", flag.ExitOnError)
+	var f client.OfferFilter
+	fs.Int64Var(&f.ContractID, "contract", 0, "contract ID")
+	fs.Int64Var(&f.SupplierID, "supplier", 0, "supplier ID")
+	fs.StringVar(&f.Currency, "currency", "", "ISO 4217 currency of the contracts")
+	fs.Parse(args)
+
+	var offers []client.Offer
+	it := e.client.Offers(f, e.pageSize)
+	for it.Next(e.ctx) {
+		offers = append(offers, it.Offer())
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	return e.printer.Offers(offers)
+}
+
+func (e *env) createOffer(args []string) error {
+	fs := flag.NewFlagSet("offers create", flag.ExitOnError)
+	var in client.OfferInput
+	fs.Int64Var(&in.ContractID, "contract", 0, "contract ID")
+	fs.StringVar(&in.Comment, "comment", "", "comment")
+	fs.Var(timeFlag{&in.ValidUntil}, "valid-until", "the offer may not be accepted after it, RFC3339")
+	fs.Parse(args)
+
+	if in.ContractID == 0 {
+		return errors.New("-contract is required")
+	}
+	signer, err := e.signer()
+	if err != nil {
+		return err
+	}
+	id, err := e.client.CreateSignedOffer(e.ctx, in, signer)
+	if err != nil {
+		return err
+	}
+	return e.printer.Created(id)
+}
+
+func (e *env) acceptOffer(args []string) error {
+	fs := flag.NewFlagSet("offers accept", flag.ExitOnError)
+	contractID := fs.Int64("contract", 0, "contract ID")
+	offerID := fs.Int64("offer", 0, "offer ID")
+	etag := fs.String("if-match", "", "ETag of the contract")
+	fs.Parse(args)
+
+	if *contractID == 0 || *offerID == 0 {
+		return errors.New("-contract and -offer are required")
+	}
+	signer, err := e.signer()
+	if err != nil {
+		return err
+	}
+	return e.client.AcceptOfferSigned(e.conditional(*etag), *contractID, *offerID, signer)
+}
+
+func (e *env) withdrawOffer(args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	return e.client.DeleteOffer(e.ctx, id)
+}
+
+func (e *env) restoreOffer(args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	return e.client.RestoreOffer(e.ctx, id)
+}
+
+func (e *env) listRevisions(args []string) error {
+	fs := flag.NewFlagSet("offers revisions", flag.ExitOnError)
+	supplier := fs.Bool("supplier", false, "the profile token is a supplier's")
+	id, err := idArg(parseInterspersed(fs, args))
+	if err != nil {
+		return err
+	}
+	revisions, err := e.client.ListOfferRevisions(e.ctx, userKind(*supplier), id, client.Page{})
+	if err != nil {
+		return err
+	}
+	return e.printer.Revisions(revisions)
+}
+
+// timeFlag is an optional RFC3339 time flag
+type timeFlag struct {
+	t *time.Time
+}
+
+func (f timeFlag) String() string {
+	if f.t == nil || f.t.IsZero() {
+		return ""
+	}
+	return f.t.Format(time.RFC3339)
+}
+
+func (f timeFlag) Set(s string) error {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return err
+	}
+	*f.t = t
+	return nil
+}
+
+// milestonesFlag collects repeated -milestone title,amount,due flags
+type milestonesFlag []client.Milestone
+
+func (f *milestonesFlag) String() string {
+	return fmt.Sprint(len(*f), " milestones")
+}
+
+func (f *milestonesFlag) Set(s string) error {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return errors.New("milestone must be title,amount,due")
+	}
+	amount, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, client.Milestone{Title: parts[0], Money: client.Money{Amount: amount}, DueDate: parts[2]})
+	return nil
+}
+
+func (e *env) reviseOffer(action string, args []string) error {
+	fs := flag.NewFlagSet("offers "+action, flag.ExitOnError)
+	offerID := fs.Int64("offer", 0, "offer ID")
+	var in client.RevisionInput
+	var milestones milestonesFlag
+	fs.Int64Var(&in.Amount, "amount", 0, "amount")
+	mustBeDone := fs.String("must-be-done", "", "deadline, RFC3339")
+	fs.Var(&milestones, "milestone", "milestone title,amount,due (RFC3339), may be repeated")
+	fs.Var(timeFlag{&in.ValidUntil}, "valid-until", "the terms may not be accepted after it, RFC3339")
+	fs.StringVar(&in.Comment, "comment", "", "comment")
+	fs.Parse(args)
+
+	if *offerID == 0 || *mustBeDone == "" {
+		return errors.New("-offer and -must-be-done are required")
+	}
+	var err error
+	in.MustBeDone, err = time.Parse(time.RFC3339, *mustBeDone)
+	if err != nil {
+		return err
+	}
+	in.Milestones = milestones
+	signer, err := e.signer()
+	if err != nil {
+		return err
+	}
+	var id int64
+	if action == "amend" {
+		id, err = e.client.AmendOfferSigned(e.ctx, *offerID, in, signer)
+	} else {
+		id, err = e.client.CounterOfferSigned(e.ctx, *offerID, in, signer)
+	}
+	if err != nil {
+		return err
+	}
+	return e.printer.Created(id)
+}
+
+// amendmentFlags are the fields of the contract body changed by contracts edit and amendments propose
+type amendmentFlags struct {
+	fs         *flag.FlagSet
+	in         client.AmendmentInput
+	mustBeDone string
+	milestones milestonesFlag
+}
+
+func newAmendmentFlags(name string, money bool) *amendmentFlags {
+	f := &amendmentFlags{fs: flag.NewFlagSet(name, flag.ExitOnError)}
+	f.fs.StringVar(&f.in.Title, "title", "", "title")
+	f.fs.StringVar(&f.in.Description, "description", "", "description")
+	if money {
+		f.fs.Int64Var(&f.in.Amount, "amount", 0, "amount in minor units")
+		f.fs.StringVar(&f.in.Currency, "currency", "", "ISO 4217 currency")
+	}
+	f.fs.StringVar(&f.mustBeDone, "must-be-done", "", "deadline, RFC3339")
+	f.fs.Var(&f.milestones, "milestone", "milestone title,amount,due (RFC3339), may be repeated")
+	f.fs.StringVar(&f.in.Comment, "comment", "", "comment")
+	return f
+}
+
+// input returns the body of the contract with the fields set by the flags replaced
+func (f *amendmentFlags) input(c *client.Contract) (client.AmendmentInput, error) {
+	in := client.AmendmentInput{
+		Title:       c.ContractBody.Title,
+		Description: c.ContractBody.Description,
+		Amount:      c.ContractBody.Amount,
+		Currency:    c.ContractBody.Currency,
+		Milestones:  c.ContractBody.Milestones,
+		Comment:     f.in.Comment,
+	}
+	var err error
+	in.MustBeDone, err = time.Parse(time.RFC3339, c.ContractBody.MustBeDone)
+	if err != nil {
+		return in, err
+	}
+	f.fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "title":
+			in.Title = f.in.Title
+		case "description":
+			in.Description = f.in.Description
+		case "amount":
+			in.Amount = f.in.Amount
+		case "currency":
+			in.Currency = f.in.Currency
+		case "milestone":
+			in.Milestones = f.milestones
+		case "must-be-done":
+			in.MustBeDone, err = time.Parse(time.RFC3339, f.mustBeDone)
+		}
+	})
+	return in, err
+}
+
+func (e *env) editContract(args []string) error {
+	f := newAmendmentFlags("contracts edit", true)
+	etag := f.fs.String("if-match", "", "ETag of the contract")
+	id, err := idArg(parseInterspersed(f.fs, args))
+	if err != nil {
+		return err
+	}
+	contract, err := e.client.GetContract(e.ctx, id)
+	if err != nil {
+		return err
+	}
+	in, err := f.input(contract)
+	if err != nil {
+		return err
+	}
+	version, err := e.client.EditContract(e.conditional(*etag), id, in)
+	if err != nil {
+		return err
+	}
+	return e.printer.ContractVersions([]client.ContractVersion{*version})
+}
+
+func (e *env) listContractVersions(args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	var versions []client.ContractVersion
+	for page := (client.Page{Limit: e.pageSize}); ; page.Offset += e.pageSize {
+		batch, err := e.client.ListContractVersions(e.ctx, id, page)
+		if err != nil {
+			return err
+		}
+		versions = append(versions, batch...)
+		if len(batch) < e.pageSize {
+			break
+		}
+	}
+	return e.printer.ContractVersions(versions)
+}
+
+func (e *env) listAmendments(args []string) error {
+	fs := flag.NewFlagSet("amendments list", flag.ExitOnError)
+	status := fs.String("status", "", "pending, accepted, rejected or withdrawn")
+	id, err := idArg(parseInterspersed(fs, args))
+	if err != nil {
+		return err
+	}
+	amendments, err := e.client.ListAmendments(e.ctx, id, *status, client.Page{})
+	if err != nil {
+		return err
+	}
+	return e.printer.Amendments(amendments)
+}
+
+func (e *env) proposeAmendment(args []string) error {
+	f := newAmendmentFlags("amendments propose", false)
+	supplier := f.fs.Bool("supplier", false, "the profile token is a supplier's")
+	id, err := idArg(parseInterspersed(f.fs, args))
+	if err != nil {
+		return err
+	}
+	contract, err := e.client.GetContract(e.ctx, id)
+	if err != nil {
+		return err
+	}
+	in, err := f.input(contract)
+	if err != nil {
+		return err
+	}
+	signer, err := e.signer()
+	if err != nil {
+		return err
+	}
+	amendmentID, err := e.client.ProposeAmendmentSigned(e.ctx, userKind(*supplier), id, in, signer)
+	if err != nil {
+		return err
+	}
+	return e.printer.Created(amendmentID)
+}
+
+func (e *env) resolveAmendment(action string, args []string) error {
+	fs := flag.NewFlagSet("amendments "+action, flag.ExitOnError)
+	contractID := fs.Int64("contract", 0, "contract ID")
+	amendmentID := fs.Int64("amendment", 0, "amendment ID")
+	supplier := fs.Bool("supplier", false, "the profile token is a supplier's")
+	fs.Parse(args)
+
+	if *contractID == 0 || *amendmentID == 0 {
+		return errors.New("-contract and -amendment are required")
+	}
+	if action == "reject" {
+		return e.client.RejectAmendment(e.ctx, userKind(*supplier), *contractID, *amendmentID)
+	}
+	signer, err := e.signer()
+	if err != nil {
+		return err
+	}
+	version, err := e.client.AcceptAmendmentSigned(e.ctx, userKind(*supplier), *contractID, *amendmentID, signer)
+	if err != nil {
+		return err
+	}
+	return e.printer.ContractVersions([]client.ContractVersion{*version})
+}
+
+func (e *env) listBids(args []string) error {
+	fs := flag.NewFlagSet("bids list", flag.ExitOnError)
+	supplier := fs.Bool("supplier", false, "the profile token is a supplier's")
+	id, err := idArg(parseInterspersed(fs, args))
+	if err != nil {
+		return err
+	}
+	bids, err := e.client.ListSealedBids(e.ctx, userKind(*supplier), id, client.Page{})
+	if err != nil {
+		return err
+	}
+	return e.printer.SealedBids(bids)
+}
+
+func (e *env) submitBid(args []string) error {
+	fs := flag.NewFlagSet("bids submit", flag.ExitOnError)
+	var in client.BidInput
+	var milestones milestonesFlag
+	fs.Int64Var(&in.ContractID, "contract", 0, "contract ID")
+	fs.Int64Var(&in.Amount, "amount", 0, "amount")
+	mustBeDone := fs.String("must-be-done", "", "deadline, RFC3339")
+	fs.Var(&milestones, "milestone", "milestone title,amount,due (RFC3339), may be repeated")
+	fs.Var(timeFlag{&in.ValidUntil}, "valid-until", "the revealed offer may not be accepted after it, RFC3339")
+	fs.StringVar(&in.Comment, "comment", "", "comment")
+	encrypt := fs.Bool("encrypt", false, "encrypt the reveal to the investor")
+	revealFile := fs.String("reveal-file", "", "file the reveal is written to")
+	fs.Parse(args)
+
+	if in.ContractID == 0 || *mustBeDone == "" || *revealFile == "" {
+		return errors.New("-contract, -must-be-done and -reveal-file are required")
+	}
+	var err error
+	in.MustBeDone, err = time.Parse(time.RFC3339, *mustBeDone)
+	if err != nil {
+		return err
+	}
+	in.Milestones = milestones
+	signer, err := e.signer()
+	if err != nil {
+		return err
+	}
+	bid, reveal, err := e.client.SealBid(e.ctx, in, signer, *encrypt)
+	if err != nil {
+		return err
+	}
+	// the reveal is kept before the bid is submitted, a bid which cannot be revealed is lost
+	data, err := json.MarshalIndent(reveal, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(*revealFile, data, 0600); err != nil {
+		return err
+	}
+	id, err := e.client.SubmitSealedBid(e.ctx, bid)
+	if err != nil {
+		return err
+	}
+	return e.printer.Created(id)
+}
+
+func (e *env) revealBid(args []string) error {
+	fs := flag.NewFlagSet("bids reveal", flag.ExitOnError)
+	revealFile := fs.String("reveal-file", "", "file written by bids submit")
+	id, err := idArg(parseInterspersed(fs, args))
+	if err != nil {
+		return err
+	}
+	if *revealFile == "" {
+		return errors.New("-reveal-file is required")
+	}
+	data, err := ioutil.ReadFile(*revealFile)
+	if err != nil {
+		return err
+	}
+	var reveal client.Reveal
+	if err := json.Unmarshal(data, &reveal); err != nil {
+		return err
+	}
+	offerID, err := e.client.RevealBid(e.ctx, id, reveal)
+	if err != nil {
+		return err
+	}
+	return e.printer.Created(offerID)
+}
+
+func (e *env) openBid(args []string) error {
+	fs := flag.NewFlagSet("bids open", flag.ExitOnError)
+	contractID := fs.Int64("contract", 0, "contract ID")
+	bidID := fs.Int64("bid", 0, "bid ID")
+	fs.Parse(args)
+
+	if *contractID == 0 || *bidID == 0 {
+		return errors.New("-contract and -bid are required")
+	}
+	signer, err := e.signer()
+	if err != nil {
+		return err
+	}
+	bids, err := e.client.ListSealedBids(e.ctx, client.UserInvestor, *contractID, client.Page{})
+	if err != nil {
+		return err
+	}
+	for _, bid := range bids {
+		if bid.ID != *bidID {
+			continue
+		}
+		reveal, err := client.OpenSealedBid(bid, signer)
+		if err != nil {
+			return err
+		}
+		offerID, err := e.client.RevealBidDecrypted(e.ctx, bid.ID, reveal)
+		if err != nil {
+			return err
+		}
+		return e.printer.Created(offerID)
+	}
+	return fmt.Errorf("bid %d not found", *bidID)
+}
+
+func (e *env) listTemplates(args []string) error {
+	fs := flag.NewFlagSet("templates list", flag.ExitOnError)
+	var f client.TemplateFilter
+	fs.Int64Var(&f.InvestorID, "investor", 0, "investor ID")
+	fs.StringVar(&f.Name, "name", "", "SQL LIKE pattern of the name")
+	fs.Parse(args)
+
+	templates, err := e.client.ListTemplates(e.ctx, f, client.Page{})
+	if err != nil {
+		return err
+	}
+	return e.printer.Templates(templates)
+}
+
+func (e *env) showTemplate(args []string) error {
+	fs := flag.NewFlagSet("templates show", flag.ExitOnError)
+	version := fs.Int64("version", 0, "version, the latest one by default")
+	id, err := idArg(parseInterspersed(fs, args))
+	if err != nil {
+		return err
+	}
+	if *version != 0 {
+		v, err := e.client.GetTemplateVersion(e.ctx, id, *version)
+		if err != nil {
+			return err
+		}
+		return e.printer.TemplateVersion(v)
+	}
+	t, err := e.client.GetTemplate(e.ctx, id)
+	if err != nil {
+		return err
+	}
+	return e.printer.Template(t)
+}
+
+// saveTemplate creates a template from -file or makes a new version of the template
+func (e *env) saveTemplate(action string, args []string) error {
+	fs := flag.NewFlagSet("templates "+action, flag.ExitOnError)
+	file := fs.String("file", "", "JSON file with Name, Title, Description and Parameters")
+	positional := parseInterspersed(fs, args)
+	var id int64
+	if action == "update" {
+		var err error
+		if id, err = idArg(positional); err != nil {
+			return err
+		}
+	}
+	if *file == "" {
+		return errors.New("-file is required")
+	}
+	data, err := ioutil.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	var in client.TemplateInput
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	if id == 0 {
+		id, err = e.client.CreateTemplate(e.ctx, in)
+		if err != nil {
+			return err
+		}
+		return e.printer.Created(id)
+	}
+	version, err := e.client.UpdateTemplate(e.ctx, id, in)
+	if err != nil {
+		return err
+	}
+	fmt.Println(version)
+	return nil
+}
+
+func (e *env) deleteTemplate(args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	return e.client.DeleteTemplate(e.ctx, id)
+}
+
+func (e *env) renderTemplate(args []string) error {
+	fs := flag.NewFlagSet("templates render", flag.ExitOnError)
+	version := fs.Int64("version", 0, "version, the latest one by default")
+	params := paramsFlag{}
+	fs.Var(params, "param", "template parameter NAME=VALUE, may be repeated")
+	id, err := idArg(parseInterspersed(fs, args))
+	if err != nil {
+		return err
+	}
+	body, err := e.client.RenderTemplate(e.ctx, id, *version, params)
+	if err != nil {
+		return err
+	}
+	if ok, err := e.printer.structured(body); ok {
+		return err
+	}
+	fmt.Printf("%s\n\n%s\n", body.Title, body.Description)
+	return nil
+}
+
+func (e *env) listRates() error {
+	rates, err := e.client.ListExchangeRates(e.ctx)
+	if err != nil {
+		return err
+	}
+	return e.printer.Rates(rates)
+}
+
+func (e *env) acceptMilestone(args []string) error {
+	if len(args) != 2 {
+		return errors.New("contract ID and milestone number expected")
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil {
+		return err
+	}
+	txID, err := e.client.AcceptMilestone(e.ctx, id, n)
+	if err != nil {
+		return err
+	}
+	return e.printer.Created(txID)
+}
+
+func (e *env) deposit(args []string) error {
+	fs := flag.NewFlagSet("ledger deposit", flag.ExitOnError)
+	var m client.Money
+	fs.Int64Var(&m.Amount, "amount", 0, "amount in minor units")
+	fs.StringVar(&m.Currency, "currency", "", "ISO 4217 currency")
+	fs.Parse(args)
+	if m.Amount == 0 || m.Currency == "" {
+		return errors.New("-amount and -currency are required")
+	}
+	id, err := e.client.Deposit(e.ctx, m)
+	if err != nil {
+		return err
+	}
+	return e.printer.Created(id)
+}
+
+// userKind returns the kind of the profile user from the -supplier flag
+func userKind(supplier bool) string {
+	if supplier {
+		return client.UserSupplier
+	}
+	return client.UserInvestor
+}
+
+func (e *env) listLedgerAccounts(args []string) error {
+	fs := flag.NewFlagSet("ledger accounts", flag.ExitOnError)
+	supplier := fs.Bool("supplier", false, "the profile token is a supplier's")
+	fs.Parse(args)
+
+	accounts, err := e.client.ListLedgerAccounts(e.ctx, userKind(*supplier))
+	if err != nil {
+		return err
+	}
+	return e.printer.LedgerAccounts(accounts)
+}
+
+func (e *env) ledgerStatement(args []string) error {
+	fs := flag.NewFlagSet("ledger statement", flag.ExitOnError)
+	supplier := fs.Bool("supplier", false, "the profile token is a supplier's")
+	id, err := idArg(parseInterspersed(fs, args))
+	if err != nil {
+		return err
+	}
+
+	var entries []client.LedgerEntry
+	for page := (client.Page{Limit: e.pageSize}); ; page.Offset += e.pageSize {
+		batch, err := e.client.LedgerStatement(e.ctx, userKind(*supplier), id, page)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, batch...)
+		if len(batch) < e.pageSize {
+			break
+		}
+	}
+	return e.printer.LedgerEntries(entries)
+}
+
+func (e *env) checkLedger() error {
+	check, err := e.client.CheckLedger(e.ctx)
+	if err != nil {
+		return err
+	}
+	return e.printer.LedgerCheck(check)
+}
+
+func (e *env) registerCertificate(args []string) error {
+	fs := flag.NewFlagSet("certificates register", flag.ExitOnError)
+	supplier := fs.Bool("supplier", false, "the profile token is a supplier's")
+	fs.Parse(args)
+
+	cert, err := e.client.RegisterCertificate(e.ctx, userKind(*supplier))
+	if err != nil {
+		return err
+	}
+	return e.printer.Certificates([]client.ClientCertificate{*cert})
+}
+
+func (e *env) listCertificates(args []string) error {
+	fs := flag.NewFlagSet("certificates list", flag.ExitOnError)
+	supplier := fs.Bool("supplier", false, "the profile token is a supplier's")
+	fs.Parse(args)
+
+	certs, err := e.client.ListCertificates(e.ctx, userKind(*supplier))
+	if err != nil {
+		return err
+	}
+	return e.printer.Certificates(certs)
+}
+
+func (e *env) deleteCertificate(args []string) error {
+	fs := flag.NewFlagSet("certificates delete", flag.ExitOnError)
+	supplier := fs.Bool("supplier", false, "the profile token is a supplier's")
+	id, err := idArg(parseInterspersed(fs, args))
+	if err != nil {
+		return err
+	}
+	return e.client.DeleteCertificate(e.ctx, userKind(*supplier), id)
+}
+
+func (e *env) login(args []string) error {
+	fs := flag.NewFlagSet("auth login", flag.ExitOnError)
+	id := fs.Int64("id", 0, "ID of the profile user")
+	supplier := fs.Bool("supplier", false, "the profile key is a supplier's")
+	fs.Parse(args)
+	if *id == 0 {
+		return errors.New("-id is required")
+	}
+	signer, err := e.signer()
+	if err != nil {
+		return err
+	}
+
+	session, err := e.client.Login(e.ctx, userKind(*supplier), *id, signer)
+	if err != nil {
+		return err
+	}
+	return e.saveSession(session.Token)
+}
+
+func (e *env) logout() error {
+	if err := e.client.Logout(e.ctx); err != nil {
+		return err
+	}
+	return e.saveSession("")
+}
+
+// saveSession stores the session token in the profile, profiles overridden by the environment are
+// not saved
+func (e *env) saveSession(token string) error {
+	name := e.profileName
+	if name == "" {
+		name = e.cfg.Current
+	}
+	p, ok := e.cfg.Profiles[name]
+	if !ok {
+		return errors.New("no stored profile to save the session in, use profile set")
+	}
+	p.Session = token
+	return e.cfg.Save(e.cfgPath)
+}
+
+func (e *env) remove(command string, args []string) error {
+	fs := flag.NewFlagSet("admin "+command, flag.ExitOnError)
+	reason := fs.String("reason", "", "reason kept in the audit log")
+	id, err := idArg(parseInterspersed(fs, args))
+	if err != nil {
+		return err
+	}
+	if *reason == "" {
+		return errors.New("-reason is required")
+	}
+	if command == "remove-offer" {
+		return e.client.RemoveOffer(e.ctx, id, *reason)
+	}
+	return e.client.RemoveContract(e.ctx, id, *reason)
+}
+
+func (e *env) restoreRemoved(command string, args []string) error {
+	fs := flag.NewFlagSet("admin "+command, flag.ExitOnError)
+	reason := fs.String("reason", "", "reason kept in the audit log")
+	id, err := idArg(parseInterspersed(fs, args))
+	if err != nil {
+		return err
+	}
+	if command == "restore-offer" {
+		return e.client.RestoreRemovedOffer(e.ctx, id, *reason)
+	}
+	return e.client.RestoreRemovedContract(e.ctx, id, *reason)
+}
+
+func (e *env) contractSignatures(args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	signatures, err := e.client.ContractSignatures(e.ctx, id)
+	if err != nil {
+		return err
+	}
+	return e.printer.Signatures(signatures)
+}
+
+func (e *env) auditLog(args []string) error {
+	fs := flag.NewFlagSet("admin audit", flag.ExitOnError)
+	action := fs.String("action", "", "contract.delete, offer.delete or roles.manage")
+	target := fs.String("target", "", "resource like contract:6 or investor:2")
+	fs.Parse(args)
+
+	var entries []client.AuditEntry
+	for page := (client.Page{Limit: e.pageSize}); ; page.Offset += e.pageSize {
+		batch, err := e.client.AuditLog(e.ctx, *action, *target, page)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, batch...)
+		if len(batch) < e.pageSize {
+			break
+		}
+	}
+	return e.printer.AuditEntries(entries)
+}
+
+func (e *env) listRoles() error {
+	grants, err := e.client.ListRoles(e.ctx)
+	if err != nil {
+		return err
+	}
+	return e.printer.RoleGrants(grants)
+}
+
+func (e *env) grantRole(args []string) error {
+	fs := flag.NewFlagSet("admin grant", flag.ExitOnError)
+	role := fs.String("role", "", "moderator, auditor or admin")
+	id := fs.Int64("id", 0, "ID of the user")
+	supplier := fs.Bool("supplier", false, "the user is a supplier")
+	fs.Parse(args)
+	if *role == "" || *id == 0 {
+		return errors.New("-role and -id are required")
+	}
+	grant, err := e.client.GrantRole(e.ctx, userKind(*supplier), *id, *role)
+	if err != nil {
+		return err
+	}
+	return e.printer.RoleGrants([]client.RoleGrant{*grant})
+}
+
+func (e *env) revokeRole(args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	return e.client.RevokeRole(e.ctx, id)
+}
+
+func (e *env) listProfiles() error {
+	var rows [][]string
+	for _, name := range e.cfg.Names() {
+		p := e.cfg.Profiles[name]
+		current := ""
+		if name == e.cfg.Current {
+			current = "*"
+		}
+		token := ""
+		if p.Token != "" {
+			token = "set"
+		}
+		rows = append(rows, []string{current, name, p.URL, token, p.Key, p.Cert})
+	}
+	return e.printer.table([]string{"", "NAME", "URL", "TOKEN", "KEY", "CERT"}, rows)
+}
+
+func (e *env) setProfile(args []string) error {
+	if len(args) < 1 {
+		return errors.New("profile name expected")
+	}
+	name := args[0]
+	p, ok := e.cfg.Profiles[name]
+	if !ok {
+		p = &Profile{URL: "http://localhost:1323"}
+	}
+	fs := flag.NewFlagSet("profile set", flag.ExitOnError)
+	fs.StringVar(&p.URL, "url", p.URL, "api url")
+	fs.StringVar(&p.Token, "token", p.Token, "authorization token")
+	fs.StringVar(&p.Key, "key", p.Key, "path to the signing key")
+	fs.StringVar(&p.Cert, "cert", p.Cert, "path to the client certificate of the signing key")
+	fs.StringVar(&p.CA, "ca", p.CA, "path to the CA certificate verifying the server")
+	fs.Parse(args[1:])
+
+	e.cfg.Profiles[name] = p
+	if e.cfg.Current == "" {
+		e.cfg.Current = name
+	}
+	return e.cfg.Save(e.cfgPath)
+}
+
+func (e *env) useProfile(args []string) error {
+	if len(args) != 1 {
+		return errors.New("profile name expected")
+	}
+	if _, ok := e.cfg.Profiles[args[0]]; !ok {
+		return fmt.Errorf("profile %q not found", args[0])
+	}
+	e.cfg.Current = args[0]
+	return e.cfg.Save(e.cfgPath)
+}
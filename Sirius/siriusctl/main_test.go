@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/msoloviom/bright-sky-project/Sirius/client"
+)
+
+// testEnv runs the commands with the stored profile "test" of a supplier against the handler
+type testEnv struct {
+	*env
+	key      *ecdsa.PrivateKey
+	out      *bytes.Buffer
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func newTestEnv(t *testing.T, handler http.HandlerFunc) *testEnv {
+	te := &testEnv{out: &bytes.Buffer{}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		te.mu.Lock()
+		te.requests = append(te.requests, r)
+		te.mu.Unlock()
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	var err error
+	te.key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(te.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	profile := &Profile{URL: server.URL, Token: "secret", Key: keyPath}
+	cfg := &Config{Current: "test", Profiles: map[string]*Profile{"test": profile}}
+	cfgPath := filepath.Join(dir, "siriusctl.yaml")
+	if err := cfg.Save(cfgPath); err != nil {
+		t.Fatal(err)
+	}
+	te.env = &env{ctx: context.Background(), cfg: cfg, cfgPath: cfgPath, profile: profile,
+		client: client.New(server.URL, client.WithToken(profile.Token)), printer: &Printer{Format: "table", Out: te.out}, pageSize: 2}
+	return te
+}
+
+// verify checks the signature of the data with the profile key
+func (te *testEnv) verify(signature string, data []byte) bool {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	hash := sha512.Sum384(data)
+	return err == nil && ecdsa.VerifyASN1(&te.key.PublicKey, hash[:], sig)
+}
+
+func TestProfiles(t *testing.T) {
+	te := newTestEnv(t, http.NotFound)
+	if err := te.setProfile([]string{"prod", "-url", "https://sirius.example.com", "-token", "prod-token"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := te.useProfile([]string{"prod"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := te.useProfile([]string{"staging"}); err == nil {
+		t.Error("unknown profile is used")
+	}
+
+	cfg, err := LoadConfig(te.cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Current != "prod" || len(cfg.Profiles) != 2 {
+		t.Errorf("config %+v, want the current prod profile and the test one", cfg)
+	}
+	// the tokens are readable only by the owner
+	if info, err := os.Stat(te.cfgPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("config mode %v %v, want 0600", info.Mode(), err)
+	}
+
+	t.Setenv("SIRIUS_TOKEN", "env-token")
+	p, err := cfg.Profile("")
+	if err != nil || p.URL != "https://sirius.example.com" || p.Token != "env-token" {
+		t.Errorf("profile %+v %v, want prod with the token of the environment", p, err)
+	}
+	if _, err := cfg.Profile("staging"); err == nil {
+		t.Error("unknown profile is loaded")
+	}
+	if cfg, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml")); err != nil || len(cfg.Profiles) != 0 {
+		t.Errorf("missing config %+v %v, want an empty one", cfg, err)
+	}
+}
+
+func TestLoginAndLogout(t *testing.T) {
+	var te *testEnv
+	te = newTestEnv(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /auth/challenges":
+			var in struct {
+				Kind string
+				ID   int64
+			}
+			if json.NewDecoder(r.Body).Decode(&in) != nil || in.Kind != "supplier" {
+				http.Error(w, "Bad Request", http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"Nonce":"n%d"}`, in.ID)
+		case "POST /auth/sessions":
+			var in struct{ Nonce, Signature string }
+			// the profile key is supplier 7's, the login of another user is not verified by its certificate
+			if json.NewDecoder(r.Body).Decode(&in) != nil || in.Nonce != "n7" || !te.verify(in.Signature, []byte("Sirius login "+in.Nonce)) {
+				http.Error(w, "Invalid signature", http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"Token":"s1","Kind":"supplier","ID":7}`))
+		case "DELETE /auth/sessions":
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	if err := te.login([]string{"-id", "7", "-supplier"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(te.cfgPath)
+	if err != nil || cfg.Profiles["test"].Session != "s1" {
+		t.Fatalf("stored session %+v %v, want s1", cfg.Profiles["test"], err)
+	}
+	if err := te.logout(); err != nil {
+		t.Fatal(err)
+	}
+	if auth := te.requests[len(te.requests)-1].Header.Get("Authorization"); auth != "Session s1" {
+		t.Errorf("logout authorized by %q, want the session", auth)
+	}
+	if cfg, err := LoadConfig(te.cfgPath); err != nil || cfg.Profiles["test"].Session != "" {
+		t.Errorf("session is kept after the logout: %+v %v", cfg.Profiles["test"], err)
+	}
+
+	if err := te.login([]string{"-id", "8", "-supplier"}); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("login as another user: %v, want ErrUnauthorized", err)
+	}
+	if cfg, err := LoadConfig(te.cfgPath); err != nil || cfg.Profiles["test"].Session != "" {
+		t.Errorf("session is stored after a failed login: %+v %v", cfg.Profiles["test"], err)
+	}
+}
+
+func TestAcceptOffer(t *testing.T) {
+	terms := []byte(`{"Title":"Bolts","Amount":90000,"Currency":"EUR"}`)
+	var te *testEnv
+	te = newTestEnv(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "GET /contracts/3":
+			w.Write([]byte(`{"ID":3,"Revision":5}`))
+		case "GET /investors/offers/9/encoded":
+			w.Header().Set("X-Sirius-Revision", "2")
+			w.Write(terms)
+		case "PATCH /contracts/3":
+			var in struct {
+				OfferID           int64
+				InvestorSignature string
+			}
+			if json.NewDecoder(r.Body).Decode(&in) != nil || in.OfferID != 9 || !te.verify(in.InvestorSignature, terms) {
+				http.Error(w, "Bad Signature", http.StatusBadRequest)
+			}
+		default:
+			http.Error(w, "Offer not found", http.StatusNotFound)
+		}
+	})
+
+	if err := te.acceptOffer([]string{"-contract", "3"}); err == nil {
+		t.Error("offer is accepted without -offer")
+	}
+	if err := te.acceptOffer([]string{"-contract", "3", "-offer", "9", "-if-match", `"4"`}); err != nil {
+		t.Fatal(err)
+	}
+	accept := te.requests[len(te.requests)-1]
+	if accept.Method != http.MethodPatch || accept.Header.Get("If-Match") != `"4"` {
+		t.Errorf("%s with If-Match %q, want PATCH with the given ETag", accept.Method, accept.Header.Get("If-Match"))
+	}
+
+	// the offer of another contract is not found and nothing is accepted
+	sent := len(te.requests)
+	if err := te.acceptOffer([]string{"-contract", "3", "-offer", "10"}); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("acceptance of an unknown offer: %v, want ErrNotFound", err)
+	}
+	for _, r := range te.requests[sent:] {
+		if r.Method == http.MethodPatch {
+			t.Error("unknown offer is accepted")
+		}
+	}
+}
+
+func TestAuditLogPages(t *testing.T) {
+	te := newTestEnv(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin/audit" || r.URL.Query().Get("Action") != "offer.delete" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Query().Get("Offset") {
+		case "":
+			w.Write([]byte(`[{"ID":1,"Action":"offer.delete"},{"ID":2,"Action":"offer.delete"}]`))
+		case "2":
+			w.Write([]byte(`[{"ID":3,"Action":"offer.delete"}]`))
+		default:
+			w.Write([]byte(`[]`))
+		}
+	})
+
+	te.printer.Format = "json"
+	if err := te.auditLog([]string{"-action", "offer.delete"}); err != nil {
+		t.Fatal(err)
+	}
+	var entries []client.AuditEntry
+	if err := json.Unmarshal(te.out.Bytes(), &entries); err != nil || len(entries) != 3 {
+		t.Errorf("entries %s, want the 3 of both pages", te.out.String())
+	}
+	if len(te.requests) != 2 {
+		t.Errorf("%d pages requested, want 2", len(te.requests))
+	}
+
+	te.out.Reset()
+	te.printer.Format = "table"
+	if err := te.auditLog([]string{"-action", "contract.delete"}); err == nil || !strings.Contains(err.Error(), "Forbidden") {
+		t.Errorf("audit log for a user who is not staff: %v", err)
+	}
+	if te.out.Len() != 0 {
+		t.Errorf("printed %q on an error", te.out.String())
+	}
+}
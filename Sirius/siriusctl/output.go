@@ -0,0 +1,446 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/msoloviom/bright-sky-project/Sirius/client"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Printer writes command results in the selected format: table, json or yaml
+type Printer struct {
+	Format string
+	Out    io.Writer
+}
+
+func (p *Printer) structured(v interface{}) (bool, error) {
+	switch p.Format {
+	case "json":
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return true, err
+		}
+		_, err = fmt.Fprintln(p.Out, string(data))
+		return true, err
+	case "yaml":
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return true, err
+		}
+		_, err = p.Out.Write(data)
+		return true, err
+	case "table", "":
+		return false, nil
+	}
+	return true, fmt.Errorf("unknown output format %q", p.Format)
+}
+
+func (p *Printer) table(header []string, rows [][]string) error {
+	w := tabwriter.NewWriter(p.Out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+func userName(u *client.User) string {
+	if u == nil || !u.ID.Valid {
+		return "-"
+	}
+	if u.Name.Valid {
+		return u.Name.String
+	}
+	return fmt.Sprintf("#%d", u.ID.Int64)
+}
+
+func nullID(id client.NullInt64) string {
+	if !id.Valid {
+		return "-"
+	}
+	return fmt.Sprint(id.Int64)
+}
+
+// money prints the amount in minor units with its currency
+func money(m client.Money) string {
+	return strings.TrimSpace(fmt.Sprint(m.Amount, " ", m.Currency))
+}
+
+func signed(s client.NullString) string {
+	if s.Valid && s.String != "" {
+		return "yes"
+	}
+	return "no"
+}
+
+// Contracts prints the list of contracts
+func (p *Printer) Contracts(contracts []client.Contract) error {
+	if contracts == nil {
+		contracts = []client.Contract{}
+	}
+	if ok, err := p.structured(contracts); ok {
+		return err
+	}
+	var rows [][]string
+	for _, c := range contracts {
+		amount := money(c.ContractBody.Money)
+		if c.Converted != nil {
+			amount += " (" + money(*c.Converted) + ")"
+		}
+		rows = append(rows, []string{
+			fmt.Sprint(c.ID), c.ContractBody.Title, amount, c.ContractBody.MustBeDone,
+			fmt.Sprint(c.Stage), userName(c.Investor), userName(c.Supplier),
+		})
+	}
+	return p.table([]string{"ID", "TITLE", "AMOUNT", "MUST BE DONE", "STAGE", "INVESTOR", "SUPPLIER"}, rows)
+}
+
+// Contract prints details of the contract
+func (p *Printer) Contract(c *client.Contract) error {
+	if ok, err := p.structured(c); ok {
+		return err
+	}
+	rows := [][]string{
+		{"ID", fmt.Sprint(c.ID)},
+		{"Title", c.ContractBody.Title},
+		{"Description", c.ContractBody.Description},
+		{"Amount", money(c.ContractBody.Money)},
+		{"Must be done", c.ContractBody.MustBeDone},
+		{"Stage", fmt.Sprint(c.Stage)},
+		{"Bidding deadline", c.BiddingDeadline.String},
+		{"Sealed", fmt.Sprint(c.Sealed)},
+		{"Reveal deadline", c.RevealDeadline.String},
+		{"Award policy", c.Award.Policy},
+		{"Awarded offer", nullID(c.Award.OfferID)},
+		{"Created", c.Created},
+		{"Revision", fmt.Sprintf("%d (ETag %s)", c.Revision, c.ETag())},
+		{"Version", fmt.Sprint(c.Version)},
+		{"Archived", c.Archived.String},
+		{"Investor", userName(c.Investor)},
+		{"Supplier", userName(c.Supplier)},
+		{"Investor signed", signed(c.InvestorSignature)},
+		{"Supplier signed", signed(c.SupplierSignature)},
+	}
+	if c.Template != nil {
+		rows = append(rows, []string{"Template", fmt.Sprintf("#%d version %d", c.Template.TemplateID, c.Template.Version)})
+	}
+	for _, ci := range c.Investors {
+		rows = append(rows, []string{fmt.Sprintf("Co-investor #%d", ci.InvestorID),
+			fmt.Sprintf("share %d, signed revision %s", ci.Share, nullID(ci.Revision))})
+	}
+	return p.table([]string{"FIELD", "VALUE"}, rows)
+}
+
+// Offers prints the list of offers
+func (p *Printer) Offers(offers []client.Offer) error {
+	if offers == nil {
+		offers = []client.Offer{}
+	}
+	if ok, err := p.structured(offers); ok {
+		return err
+	}
+	var rows [][]string
+	for _, o := range offers {
+		validUntil := o.ValidUntil.String
+		if o.Expired.Valid {
+			validUntil = "expired"
+		} else if o.Stale.Valid {
+			validUntil = "stale since " + o.Stale.String
+		}
+		rows = append(rows, []string{
+			fmt.Sprint(o.ID), fmt.Sprint(o.ContractID), userName(o.Supplier), o.Comment.String, o.Created, validUntil,
+		})
+	}
+	return p.table([]string{"ID", "CONTRACT", "SUPPLIER", "COMMENT", "CREATED", "VALID UNTIL"}, rows)
+}
+
+// Revisions prints the negotiation history of an offer
+func (p *Printer) Revisions(revisions []client.OfferRevision) error {
+	if revisions == nil {
+		revisions = []client.OfferRevision{}
+	}
+	if ok, err := p.structured(revisions); ok {
+		return err
+	}
+	var rows [][]string
+	for _, r := range revisions {
+		rows = append(rows, []string{
+			fmt.Sprint(r.Revision), r.AuthorKind, money(r.Terms.Money), r.Terms.MustBeDone,
+			fmt.Sprint(len(r.Terms.Milestones)), r.Comment.String, r.Created,
+		})
+	}
+	return p.table([]string{"REVISION", "AUTHOR", "AMOUNT", "MUST BE DONE", "MILESTONES", "COMMENT", "CREATED"}, rows)
+}
+
+// ContractVersions prints the version history of a contract, CHANGES are the changed fields
+func (p *Printer) ContractVersions(versions []client.ContractVersion) error {
+	if versions == nil {
+		versions = []client.ContractVersion{}
+	}
+	if ok, err := p.structured(versions); ok {
+		return err
+	}
+	var rows [][]string
+	for _, v := range versions {
+		rows = append(rows, []string{
+			fmt.Sprint(v.Version), fmt.Sprintf("%s:%d", v.AuthorKind, v.AuthorID), changedFields(v.Changes),
+			money(v.Body.Money), v.Body.MustBeDone, v.Comment.String, v.Created,
+		})
+	}
+	return p.table([]string{"VERSION", "AUTHOR", "CHANGES", "AMOUNT", "MUST BE DONE", "COMMENT", "CREATED"}, rows)
+}
+
+// Amendments prints the amendments proposed for a contract
+func (p *Printer) Amendments(amendments []client.ContractAmendment) error {
+	if amendments == nil {
+		amendments = []client.ContractAmendment{}
+	}
+	if ok, err := p.structured(amendments); ok {
+		return err
+	}
+	var rows [][]string
+	for _, a := range amendments {
+		rows = append(rows, []string{
+			fmt.Sprint(a.ID), fmt.Sprint(a.BaseVersion), fmt.Sprintf("%s:%d", a.ProposerKind, a.ProposerID),
+			changedFields(a.Changes), a.Status, a.Comment.String, a.Created,
+		})
+	}
+	return p.table([]string{"ID", "BASE VERSION", "PROPOSER", "CHANGES", "STATUS", "COMMENT", "CREATED"}, rows)
+}
+
+func changedFields(changes []client.BodyChange) string {
+	fields := make([]string, 0, len(changes))
+	for _, c := range changes {
+		fields = append(fields, c.Field)
+	}
+	return strings.Join(fields, ", ")
+}
+
+// SealedBids prints the sealed bids of a contract
+func (p *Printer) SealedBids(bids []client.SealedBid) error {
+	if bids == nil {
+		bids = []client.SealedBid{}
+	}
+	if ok, err := p.structured(bids); ok {
+		return err
+	}
+	var rows [][]string
+	for _, b := range bids {
+		rows = append(rows, []string{
+			fmt.Sprint(b.ID), nullID(b.SupplierID), signed(b.Ciphertext), b.Created, nullID(b.OfferID),
+		})
+	}
+	return p.table([]string{"ID", "SUPPLIER", "ENCRYPTED", "CREATED", "OFFER"}, rows)
+}
+
+// Templates prints the list of templates
+func (p *Printer) Templates(templates []client.Template) error {
+	if templates == nil {
+		templates = []client.Template{}
+	}
+	if ok, err := p.structured(templates); ok {
+		return err
+	}
+	var rows [][]string
+	for _, t := range templates {
+		rows = append(rows, []string{
+			fmt.Sprint(t.ID), t.Name, fmt.Sprint(t.InvestorID), fmt.Sprint(t.Latest.Version), t.Created,
+		})
+	}
+	return p.table([]string{"ID", "NAME", "INVESTOR", "VERSION", "CREATED"}, rows)
+}
+
+// Template prints the template with its latest version
+func (p *Printer) Template(t *client.Template) error {
+	if ok, err := p.structured(t); ok {
+		return err
+	}
+	return p.templateVersion([][]string{
+		{"ID", fmt.Sprint(t.ID)},
+		{"Name", t.Name},
+		{"Investor", fmt.Sprint(t.InvestorID)},
+	}, &t.Latest)
+}
+
+// TemplateVersion prints a version of a template
+func (p *Printer) TemplateVersion(v *client.TemplateVersion) error {
+	if ok, err := p.structured(v); ok {
+		return err
+	}
+	return p.templateVersion([][]string{{"Template", fmt.Sprint(v.TemplateID)}}, v)
+}
+
+func (p *Printer) templateVersion(rows [][]string, v *client.TemplateVersion) error {
+	rows = append(rows,
+		[]string{"Version", fmt.Sprint(v.Version)},
+		[]string{"Title", v.Title},
+		[]string{"Description", v.Description},
+		[]string{"Created", v.Created},
+	)
+	for _, param := range v.Parameters {
+		value := param.Type
+		if param.Required {
+			value += ", required"
+		}
+		if param.Default != nil {
+			value += fmt.Sprintf(", default %v", param.Default)
+		}
+		if param.Description != "" {
+			value += ", " + param.Description
+		}
+		rows = append(rows, []string{"Parameter " + param.Name, value})
+	}
+	return p.table([]string{"FIELD", "VALUE"}, rows)
+}
+
+// Rates prints the exchange rates
+func (p *Printer) Rates(rates []client.ExchangeRate) error {
+	if rates == nil {
+		rates = []client.ExchangeRate{}
+	}
+	if ok, err := p.structured(rates); ok {
+		return err
+	}
+	var rows [][]string
+	for _, r := range rates {
+		rows = append(rows, []string{r.Currency, fmt.Sprint(r.Rate), fmt.Sprint(r.Exponent), r.Updated})
+	}
+	return p.table([]string{"CURRENCY", "RATE", "MINOR DIGITS", "UPDATED"}, rows)
+}
+
+// LedgerAccounts prints the ledger accounts of a user
+func (p *Printer) LedgerAccounts(accounts []client.LedgerAccount) error {
+	if accounts == nil {
+		accounts = []client.LedgerAccount{}
+	}
+	if ok, err := p.structured(accounts); ok {
+		return err
+	}
+	var rows [][]string
+	for _, a := range accounts {
+		rows = append(rows, []string{
+			fmt.Sprint(a.ID), a.Kind, fmt.Sprint(a.OwnerID),
+			money(client.Money{Amount: a.Balance, Currency: a.Currency}), a.Created,
+		})
+	}
+	return p.table([]string{"ID", "KIND", "OWNER", "BALANCE", "CREATED"}, rows)
+}
+
+// Certificates prints the client certificates registered by a user
+func (p *Printer) Certificates(certs []client.ClientCertificate) error {
+	if certs == nil {
+		certs = []client.ClientCertificate{}
+	}
+	if ok, err := p.structured(certs); ok {
+		return err
+	}
+	var rows [][]string
+	for _, c := range certs {
+		rows = append(rows, []string{fmt.Sprint(c.ID), c.Serial, c.Subject, c.Expires, c.Created})
+	}
+	return p.table([]string{"ID", "SERIAL", "SUBJECT", "EXPIRES", "CREATED"}, rows)
+}
+
+// Signatures prints the signatures made on a contract
+func (p *Printer) Signatures(signatures []client.SignatureRecord) error {
+	if signatures == nil {
+		signatures = []client.SignatureRecord{}
+	}
+	if ok, err := p.structured(signatures); ok {
+		return err
+	}
+	var rows [][]string
+	for _, s := range signatures {
+		offer := ""
+		if s.OfferID != 0 {
+			offer = fmt.Sprintf("%d/%d", s.OfferID, s.Revision)
+		} else if s.AmendmentID != 0 {
+			offer = fmt.Sprintf("amendment %d", s.AmendmentID)
+		}
+		signed := ""
+		if s.Signed.Valid {
+			signed = s.Signed.String
+		}
+		rows = append(rows, []string{s.Source, offer, fmt.Sprintf("%s:%d", s.SignerKind, s.SignerID), signed, s.Signature})
+	}
+	return p.table([]string{"SOURCE", "OFFER/REV", "SIGNER", "SIGNED", "SIGNATURE"}, rows)
+}
+
+// AuditEntries prints the audit log
+func (p *Printer) AuditEntries(entries []client.AuditEntry) error {
+	if entries == nil {
+		entries = []client.AuditEntry{}
+	}
+	if ok, err := p.structured(entries); ok {
+		return err
+	}
+	var rows [][]string
+	for _, a := range entries {
+		detail := ""
+		if a.Detail.Valid {
+			detail = a.Detail.String
+		}
+		rows = append(rows, []string{fmt.Sprint(a.ID), fmt.Sprintf("%s:%d", a.ActorKind, a.ActorID), a.Action, a.Target, detail, a.Created})
+	}
+	return p.table([]string{"ID", "ACTOR", "ACTION", "TARGET", "DETAIL", "CREATED"}, rows)
+}
+
+// RoleGrants prints the staff roles granted to the users
+func (p *Printer) RoleGrants(grants []client.RoleGrant) error {
+	if grants == nil {
+		grants = []client.RoleGrant{}
+	}
+	if ok, err := p.structured(grants); ok {
+		return err
+	}
+	var rows [][]string
+	for _, g := range grants {
+		rows = append(rows, []string{fmt.Sprint(g.ID), fmt.Sprintf("%s:%d", g.UserKind, g.UserID), g.Role, g.Created})
+	}
+	return p.table([]string{"ID", "USER", "ROLE", "CREATED"}, rows)
+}
+
+// LedgerEntries prints the statement of a ledger account
+func (p *Printer) LedgerEntries(entries []client.LedgerEntry) error {
+	if entries == nil {
+		entries = []client.LedgerEntry{}
+	}
+	if ok, err := p.structured(entries); ok {
+		return err
+	}
+	var rows [][]string
+	for _, e := range entries {
+		rows = append(rows, []string{
+			fmt.Sprint(e.TransactionID), e.Type, nullID(e.ContractID), nullID(e.Milestone), e.Reference.String,
+			fmt.Sprint(e.Debit), fmt.Sprint(e.Credit), fmt.Sprint(e.Balance), e.Created,
+		})
+	}
+	return p.table([]string{"TRANSACTION", "TYPE", "CONTRACT", "MILESTONE", "REFERENCE", "DEBIT", "CREDIT", "BALANCE", "CREATED"}, rows)
+}
+
+// LedgerCheck prints the result of the ledger checks
+func (p *Printer) LedgerCheck(check *client.LedgerCheck) error {
+	if ok, err := p.structured(check); ok {
+		return err
+	}
+	rows := [][]string{
+		{"Debits", fmt.Sprint(check.Debits)},
+		{"Credits", fmt.Sprint(check.Credits)},
+		{"Unbalanced transactions", fmt.Sprint(check.Unbalanced)},
+		{"Overdrawn accounts", fmt.Sprint(check.Overdrawn)},
+	}
+	return p.table([]string{"FIELD", "VALUE"}, rows)
+}
+
+// Created prints ID of the created resource
+func (p *Printer) Created(id int64) error {
+	if ok, err := p.structured(map[string]int64{"id": id}); ok {
+		return err
+	}
+	_, err := fmt.Fprintln(p.Out, id)
+	return err
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/labstack/echo"
+)
+
+const (
+	streamHeartbeat      = 15 * time.Second
+	streamBuffer         = 64
+	streamReplayLimit    = 1000
+	streamWriteTimeout   = 10 * time.Second
+	hubPollPeriod        = time.Second
+	sseRetryMilliseconds = 5000
+)
+
+// Subscription receives events published to the hub which its user may see,
+// C is closed when the subscriber is too slow to keep up
+type Subscription struct {
+	Kind   string
+	UserID int64
+	Types  map[string]bool
+	C      chan *Event
+}
+
+func (s *Subscription) wants(ev *Event) bool {
+	if len(s.Types) > 0 && !s.Types[ev.Type] {
+		return false
+	}
+	return ev.VisibleTo(s.Kind, s.UserID)
+}
+
+// EventHub fans committed events out to the stream subscribers. Events are taken from
+// the events table after the transaction which emitted them is committed, EmitEvent
+// wakes the hub up
+type EventHub struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]bool
+	lastID      int64
+	wake        chan struct{}
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{subscribers: make(map[*Subscription]bool), wake: make(chan struct{}, 1)}
+}
+
+var eventHub = NewEventHub()
+
+// Wake makes the hub look for new events immediately
+func (h *EventHub) Wake() {
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Subscribe registers subscriber of the user, types limits event types if not empty
+func (h *EventHub) Subscribe(kind string, userID int64, types []string) *Subscription {
+	s := &Subscription{Kind: kind, UserID: userID, Types: make(map[string]bool), C: make(chan *Event, streamBuffer)}
+	for _, t := range types {
+		s.Types[t] = true
+	}
+	h.mu.Lock()
+	h.subscribers[s] = true
+	h.mu.Unlock()
+	return s
+}
+
+// Unsubscribe removes the subscriber
+func (h *EventHub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscribers[s] {
+		delete(h.subscribers, s)
+		close(s.C)
+	}
+}
+
+// Publish sends the event to the interested subscribers without blocking,
+// a subscriber with full buffer is dropped and resumes by Last-Event-ID
+func (h *EventHub) Publish(ev *Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subscribers {
+		if !s.wants(ev) {
+			continue
+		}
+		select {
+		case s.C <- ev:
+		default:
+			delete(h.subscribers, s)
+			close(s.C)
+		}
+	}
+}
+
+// Run publishes events stored after the hub was started
+func (h *EventHub) Run() {
+	db, err := sql.Open(dbDriver, dbName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.QueryRow("SELECT IFNULL(MAX(id), 0) FROM events").Scan(&h.lastID); err != nil {
+		log.Fatal(err)
+	}
+
+	ticker := time.NewTicker(hubPollPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-h.wake:
+		}
+		events, err := eventsAfter(db, h.lastID, streamReplayLimit)
+		if err != nil {
+			log.Print("hub: ", err)
+			continue
+		}
+		for _, ev := range events {
+			h.Publish(ev)
+			h.lastID = ev.ID
+		}
+	}
+}
+
+// eventsAfter loads up to limit stored events with IDs greater than afterID
+func eventsAfter(db *sql.DB, afterID int64, limit int) ([]*Event, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("id")
+	sb.From("events")
+	sb.Where(sb.GreaterThan("id", afterID))
+	sb.OrderBy("id")
+	sb.Limit(limit)
+	q, args := sb.Build()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var events []*Event
+	for _, id := range ids {
+		ev, err := loadEvent(db, id)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+// TokenQueryMiddleware lets clients which cannot set headers (EventSource, browser WebSocket)
+// pass the authorization token in the token query param, a session token or a token of the
+// upstream apis. It follows SessionAuthMiddleware, so a session token found in the param is
+// authenticated here. The param is removed from the URL of the request, so the token is not
+// written to the request logs.
+func TokenQueryMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		query := req.URL.Query()
+		token := query.Get("token")
+		if token == "" {
+			return next(c)
+		}
+		query.Del("token")
+		req.URL.RawQuery = query.Encode()
+		req.RequestURI = req.URL.RequestURI()
+		if req.Header.Get(echo.HeaderAuthorization) != "" {
+			return next(c)
+		}
+
+		user, err := findSession(c, token)
+		if err == nil {
+			req.Header.Set(echo.HeaderAuthorization, "Session "+token)
+			c.Set(sessionUserKey, user)
+			return next(c)
+		} else if err != sql.ErrNoRows {
+			return err
+		}
+		req.Header.Set(echo.HeaderAuthorization, "Token "+token)
+		return next(c)
+	}
+}
+
+// eventStream merges the replay of stored events with the live subscription,
+// events are yielded in order of IDs without duplicates
+type eventStream struct {
+	sub    *Subscription
+	ctx    context.Context
+	replay []*Event
+	// replayedID is the last stored event looked at by the replay, which is loaded by pages of
+	// streamReplayLimit events until it reaches the events published to the subscription
+	replayedID int64
+	replaying  bool
+	lastID     int64
+}
+
+// errBadLastEventID is returned by openEventStream for the last event ID which is not a number
+var errBadLastEventID = errors.New("Bad last event ID")
+
+// openEventStream subscribes the current user and replays the events stored after lastEventID
+func openEventStream(c echo.Context, lastEventID string) (*eventStream, error) {
+	kind, userID := currentUser(c)
+	var types []string
+	if t := c.QueryParam("types"); t != "" {
+		types = strings.Split(t, ",")
+	}
+	stream := &eventStream{sub: eventHub.Subscribe(kind, userID, types), ctx: c.Request().Context()}
+	if lastEventID == "" {
+		return stream, nil
+	}
+
+	afterID, err := strconv.ParseInt(lastEventID, 10, 64)
+	if err != nil {
+		eventHub.Unsubscribe(stream.sub)
+		return nil, errBadLastEventID
+	}
+	// subscription is made before the replay is loaded, so no event falls in between
+	stream.replayedID, stream.replaying, stream.lastID = afterID, true, afterID
+	if err := stream.loadReplay(); err != nil {
+		eventHub.Unsubscribe(stream.sub)
+		return nil, err
+	}
+	return stream, nil
+}
+
+// loadReplay loads the next page of the replay, the replay is over when the page is not full
+// as the later events are published to the subscription
+func (s *eventStream) loadReplay() error {
+	db, err := openDB(s.ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	events, err := eventsAfter(db, s.replayedID, streamReplayLimit)
+	if err != nil {
+		return err
+	}
+	for _, ev := range events {
+		if s.sub.wants(ev) {
+			s.replay = append(s.replay, ev)
+		}
+		s.replayedID = ev.ID
+	}
+	s.replaying = len(events) == streamReplayLimit
+	return nil
+}
+
+// next returns the next event to send, ok is false when the subscription was dropped
+func (s *eventStream) next(done <-chan struct{}, heartbeat <-chan time.Time) (ev *Event, beat bool, ok bool) {
+	for {
+		if len(s.replay) == 0 && s.replaying {
+			if err := s.loadReplay(); err != nil {
+				log.Print("stream: ", err)
+				return nil, false, false
+			}
+		}
+		if len(s.replay) > 0 {
+			ev, s.replay = s.replay[0], s.replay[1:]
+			s.lastID = ev.ID
+			return ev, false, true
+		}
+		select {
+		case ev, ok = <-s.sub.C:
+			if !ok {
+				return nil, false, false
+			}
+			if ev.ID <= s.lastID {
+				continue
+			}
+			s.lastID = ev.ID
+			return ev, false, true
+		case <-heartbeat:
+			return nil, true, true
+		case <-done:
+			return nil, false, false
+		}
+	}
+}
+
+// StreamEventsSSE - api controller streaming events as Server-Sent Events,
+// the stream is resumed after the event given in Last-Event-ID header
+func StreamEventsSSE(c echo.Context) error {
+	lastEventID := c.Request().Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.QueryParam("last_event_id")
+	}
+	stream, err := openEventStream(c, lastEventID)
+	if err == errBadLastEventID {
+		return c.String(http.StatusBadRequest, "Bad Last-Event-ID")
+	} else if err != nil {
+		return err
+	}
+	defer eventHub.Unsubscribe(stream.sub)
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	fmt.Fprintf(res, "retry: %d\n\n", sseRetryMilliseconds)
+	res.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		ev, beat, ok := stream.next(c.Request().Context().Done(), heartbeat.C)
+		if !ok {
+			return nil
+		}
+		if beat {
+			_, err = fmt.Fprint(res, ": heartbeat\n\n")
+		} else {
+			var data []byte
+			data, err = json.Marshal(ev)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+		}
+		if err != nil {
+			return nil
+		}
+		res.Flush()
+	}
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     allowedOrigin,
+}
+
+// allowedOrigin reports whether the page the WebSocket is opened from may use the stream, the socket
+// is authenticated by the token in the URL, so pages of other sites could open it for the user. Pages of
+// the api itself and of allowed_origins are allowed, clients which are not browsers send no Origin.
+func allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range strings.Split(currentConfig().AllowedOrigins, ",") {
+		if allowed = strings.TrimSpace(allowed); allowed != "" && strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// StreamEventsWebSocket - api controller streaming events over WebSocket as json text messages,
+// the stream is resumed after the event given in last_event_id query param or Last-Event-ID header
+func StreamEventsWebSocket(c echo.Context) error {
+	lastEventID := c.QueryParam("last_event_id")
+	if lastEventID == "" {
+		lastEventID = c.Request().Header.Get("Last-Event-ID")
+	}
+	stream, err := openEventStream(c, lastEventID)
+	if err == errBadLastEventID {
+		return c.String(http.StatusBadRequest, "Bad last_event_id")
+	} else if err != nil {
+		return err
+	}
+	defer eventHub.Unsubscribe(stream.sub)
+
+	ws, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		return nil
+	}
+	defer ws.Close()
+
+	// the client is not expected to send anything, reading handles pongs and detects close
+	done := make(chan struct{})
+	ws.SetReadDeadline(time.Now().Add(2 * streamHeartbeat))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(2 * streamHeartbeat))
+	})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		ev, beat, ok := stream.next(done, heartbeat.C)
+		if !ok {
+			return nil
+		}
+		deadline := time.Now().Add(streamWriteTimeout)
+		if beat {
+			err = ws.WriteControl(websocket.PingMessage, nil, deadline)
+		} else {
+			ws.SetWriteDeadline(deadline)
+			err = ws.WriteJSON(ev)
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// emitTestEvents stores the events in one transaction
+func emitTestEvents(t *testing.T, events ...Event) {
+	t.Helper()
+	db, err := sql.Open(dbDriver, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range events {
+		if err := EmitEvent(tx, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEventStreamReplaysPastLimit(t *testing.T) {
+	useTestDB(t)
+	var events []Event
+	for i := 0; i < streamReplayLimit; i++ {
+		events = append(events, Event{Type: EventContractCreated, Recipients: []EventRecipient{{Kind: UserInvestor, ID: 2}}})
+	}
+	for i := 0; i < 3; i++ {
+		events = append(events, Event{Type: EventContractCreated, Public: true})
+	}
+	emitTestEvents(t, events...)
+
+	c, _ := investorContext(httptest.NewRequest(http.MethodGet, "/investors/events", nil), 1)
+	stream, err := openEventStream(c, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer eventHub.Unsubscribe(stream.sub)
+	last := int64(streamReplayLimit + 3)
+	eventHub.Publish(&Event{ID: last, Type: EventContractCreated, Public: true})
+	eventHub.Publish(&Event{ID: last + 1, Type: EventContractCreated, Public: true})
+
+	done := make(chan struct{})
+	for _, want := range []int64{last - 2, last - 1, last, last + 1} {
+		ev, _, ok := stream.next(done, nil)
+		if !ok {
+			t.Fatalf("stream ended, want event %d", want)
+		}
+		if ev.ID != want {
+			t.Fatalf("event %d, want %d", ev.ID, want)
+		}
+	}
+}
+
+func TestTokenQueryMiddleware(t *testing.T) {
+	useTestDB(t)
+	req := httptest.NewRequest(http.MethodGet, "/investors/events?token=secret&types=contract.created", nil)
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+	err := TokenQueryMiddleware(func(c echo.Context) error {
+		if c.QueryParam("types") != "contract.created" {
+			t.Errorf("types %q", c.QueryParam("types"))
+		}
+		return nil
+	})(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth := req.Header.Get(echo.HeaderAuthorization); auth != "Token secret" {
+		t.Errorf("Authorization %q", auth)
+	}
+	if strings.Contains(req.URL.String(), "secret") || strings.Contains(req.RequestURI, "secret") {
+		t.Errorf("token is left in the URL %s, %s", req.URL, req.RequestURI)
+	}
+}
+
+// createTestSession stores a session of the user and returns its token
+func createTestSession(t *testing.T, kind string, id int64) string {
+	t.Helper()
+	db, err := sql.Open(dbDriver, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	token := randomToken(32, hex.EncodeToString)
+	now := time.Now().UTC()
+	_, err = db.Exec("INSERT INTO sessions (token_hash, user_kind, user_id, created, expires) VALUES (?, ?, ?, ?, ?)",
+		hashSessionToken(token), kind, id, now.Format(time.RFC3339), now.Add(time.Hour).Format(time.RFC3339))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestStreamEventsWithSessionToken(t *testing.T) {
+	useTestDB(t)
+	emitTestEvents(t, Event{Type: EventContractCreated, Recipients: []EventRecipient{{Kind: UserInvestor, ID: 1}}})
+	server := httptest.NewServer(newTestServer())
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	get := func(token string) *http.Response {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/investors/events?last_event_id=0&token="+token, nil)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	res := get(createTestSession(t, UserSupplier, 1))
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("supplier session on the investor stream: %d, want 401", res.StatusCode)
+	}
+
+	res = get(createTestSession(t, UserInvestor, 1))
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("investor session: %d", res.StatusCode)
+	}
+	lines := bufio.NewScanner(res.Body)
+	for lines.Scan() {
+		if strings.HasPrefix(lines.Text(), "event: ") {
+			if lines.Text() != "event: "+EventContractCreated {
+				t.Errorf("streamed %s", lines.Text())
+			}
+			return
+		}
+	}
+	t.Fatalf("stream ended without the event: %v", lines.Err())
+}
+
+func TestAllowedOrigin(t *testing.T) {
+	useTestConfig(t, func(cfg *Config) { cfg.AllowedOrigins = "https://app.example.com, http://localhost:3000/" })
+	for _, test := range []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"https://sirius.example.com", true},
+		{"https://app.example.com", true},
+		{"http://localhost:3000", true},
+		{"https://evil.example.com", false},
+		{"http://app.example.com", false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "https://sirius.example.com/investors/events/ws", nil)
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+		if got := allowedOrigin(req); got != test.want {
+			t.Errorf("origin %q allowed = %v, want %v", test.origin, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,633 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/labstack/echo"
+)
+
+// Types of template parameters
+const (
+	ParamString  = "string"
+	ParamInteger = "integer"
+	ParamNumber  = "number"
+	ParamBoolean = "boolean"
+	ParamDate    = "date"
+)
+
+// maxRenderedSize bounds the rendered title and description, templates are written by the users
+const maxRenderedSize = 64 << 10
+
+var paramNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// TemplateParameter is a typed value substituted into a template, Default is used when the value
+// is not given. Dates are RFC3339 strings rendered as time.Time, so {{.Due.Format "2006-01-02"}} works.
+type TemplateParameter struct {
+	Name        string
+	Type        string
+	Description string      `json:",omitempty"`
+	Required    bool        `json:",omitempty"`
+	Default     interface{} `json:",omitempty"`
+}
+
+// TemplateVersion is an immutable revision of a template, Title and Description are text/template
+// sources rendered into ContractBody
+type TemplateVersion struct {
+	TemplateID  int64
+	Version     int64
+	Title       string
+	Description string
+	Parameters  []TemplateParameter
+	Created     string
+}
+
+// Template is owned by the investor who created it, it is shown with its latest version
+type Template struct {
+	ID         int64
+	InvestorID int64
+	Name       string
+	Created    string
+	Latest     TemplateVersion
+}
+
+// TemplateQuery - a template or its new version
+type TemplateQuery struct {
+	Name        string
+	Title       string
+	Description string
+	Parameters  []TemplateParameter
+}
+
+// TemplateRef is the exact template version and the parameters a contract was rendered with,
+// defaults are included in Params
+type TemplateRef struct {
+	TemplateID int64
+	Version    int64
+	Params     map[string]interface{}
+}
+
+// values returns the template columns of contracts, NULL for a contract made without template
+func (r *TemplateRef) values() (id, version, params interface{}) {
+	if r == nil {
+		return nil, nil, nil
+	}
+	encoded, _ := json.Marshal(r.Params)
+	return r.TemplateID, r.Version, string(encoded)
+}
+
+// RenderQuery - parameters of a template, Version 0 means the latest one
+type RenderQuery struct {
+	Version int64
+	Params  map[string]interface{}
+}
+
+// errTemplateNotFound is returned by loadTemplateVersion
+var errTemplateNotFound = errors.New("Template not found")
+
+// paramValue converts a json value to the type of the parameter
+func paramValue(typ string, v interface{}) (interface{}, error) {
+	switch typ {
+	case ParamString:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case ParamInteger:
+		if f, ok := v.(float64); ok && f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			return int64(f), nil
+		}
+	case ParamNumber:
+		if f, ok := v.(float64); ok {
+			return f, nil
+		}
+	case ParamBoolean:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case ParamDate:
+		if s, ok := v.(string); ok {
+			return time.Parse(time.RFC3339, s)
+		}
+	default:
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+	return nil, fmt.Errorf("%v is not %s", v, typ)
+}
+
+// zeroParam is the value of an optional parameter without default
+func zeroParam(typ string) interface{} {
+	switch typ {
+	case ParamInteger:
+		return int64(0)
+	case ParamNumber:
+		return float64(0)
+	case ParamBoolean:
+		return false
+	case ParamDate:
+		return time.Time{}
+	}
+	return ""
+}
+
+// parseTemplate parses the source and rejects the actions which could run for long, the size of the output
+// is bounded by limitedBuffer but not the time spent by loops which print nothing
+func parseTemplate(name, src string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl.Tree != nil {
+		if err := checkTemplateNode(tmpl.Tree.Root); err != nil {
+			return nil, fmt.Errorf("template: %s: %v", name, err)
+		}
+	}
+	return tmpl, nil
+}
+
+// checkTemplateNode rejects range, as the parameters are no collections and it could only loop over
+// an integer like {{range 1000000000000}}, and template calls, which could nest into exponentially many
+func checkTemplateNode(node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkTemplateNode(child); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		return checkTemplateBranch(&n.BranchNode)
+	case *parse.WithNode:
+		return checkTemplateBranch(&n.BranchNode)
+	case *parse.RangeNode:
+		return fmt.Errorf("range is not allowed: %s", n)
+	case *parse.TemplateNode:
+		return fmt.Errorf("template calls are not allowed: %s", n)
+	}
+	return nil
+}
+
+func checkTemplateBranch(n *parse.BranchNode) error {
+	if err := checkTemplateNode(n.List); err != nil {
+		return err
+	}
+	return checkTemplateNode(n.ElseList)
+}
+
+// validate checks the parameters and parses the sources of the template
+func (q *TemplateQuery) validate() error {
+	if q.Name == "" || q.Title == "" {
+		return errors.New("Name and Title are required")
+	}
+	seen := make(map[string]bool)
+	for _, p := range q.Parameters {
+		if !paramNameRe.MatchString(p.Name) || seen[p.Name] {
+			return fmt.Errorf("bad or duplicate parameter name %q", p.Name)
+		}
+		seen[p.Name] = true
+		switch p.Type {
+		case ParamString, ParamInteger, ParamNumber, ParamBoolean, ParamDate:
+		default:
+			return fmt.Errorf("parameter %s: unknown type %q", p.Name, p.Type)
+		}
+		if p.Default != nil {
+			if _, err := paramValue(p.Type, p.Default); err != nil {
+				return fmt.Errorf("default of %s: %v", p.Name, err)
+			}
+		}
+	}
+	if _, err := parseTemplate("Title", q.Title); err != nil {
+		return err
+	}
+	_, err := parseTemplate("Description", q.Description)
+	return err
+}
+
+// limitedBuffer fails writes beyond maxRenderedSize, so a template cannot render unbounded output
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > maxRenderedSize {
+		return 0, errors.New("rendered template is too large")
+	}
+	return b.Buffer.Write(p)
+}
+
+// render substitutes the parameters into the title and description. It returns the parameters
+// with the defaults applied, they render the same body again.
+func (v *TemplateVersion) render(params map[string]interface{}) (ContractBody, map[string]interface{}, error) {
+	body := ContractBody{}
+	data := make(map[string]interface{})
+	resolved := make(map[string]interface{})
+	for _, p := range v.Parameters {
+		raw, ok := params[p.Name]
+		if !ok {
+			raw = p.Default
+		}
+		if raw == nil {
+			if p.Required {
+				return body, nil, fmt.Errorf("parameter %s is required", p.Name)
+			}
+			data[p.Name] = zeroParam(p.Type)
+			continue
+		}
+		value, err := paramValue(p.Type, raw)
+		if err != nil {
+			return body, nil, fmt.Errorf("parameter %s: %v", p.Name, err)
+		}
+		data[p.Name], resolved[p.Name] = value, raw
+	}
+	for name := range params {
+		if _, ok := data[name]; !ok {
+			return body, nil, fmt.Errorf("unknown parameter %s", name)
+		}
+	}
+
+	for _, t := range []struct {
+		name, src string
+		out       *string
+	}{{"Title", v.Title, &body.Title}, {"Description", v.Description, &body.Description}} {
+		tmpl, err := parseTemplate(t.name, t.src)
+		if err != nil {
+			return body, nil, err
+		}
+		buf := &limitedBuffer{}
+		if err := tmpl.Execute(buf, data); err != nil {
+			return body, nil, err
+		}
+		*t.out = buf.String()
+	}
+	return body, resolved, nil
+}
+
+var templateVersionColumns = []string{"template_id", "version", "title", "description", "parameters", "created"}
+
+func scanTemplateVersion(row rowScanner, v *TemplateVersion) error {
+	var parameters string
+	err := row.Scan(&v.TemplateID, &v.Version, &v.Title, &v.Description, &parameters, &v.Created)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(parameters), &v.Parameters)
+}
+
+// loadTemplateVersion returns the version of the template, the latest one when version is 0.
+// Deleted templates are not found unless the version is given, contracts keep referring to them.
+func loadTemplateVersion(db dbExecutor, templateID, version int64) (*TemplateVersion, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select(templateVersionColumns...)
+	sb.From("template_versions")
+	sb.Where(sb.Equal("template_id", templateID))
+	if version != 0 {
+		sb.Where(sb.Equal("version", version))
+	} else {
+		sb.Where("template_id IN (SELECT id FROM templates WHERE deleted IS NULL)")
+		sb.OrderBy("version").Desc()
+		sb.Limit(1)
+	}
+	q, args := sb.Build()
+
+	v := &TemplateVersion{}
+	err := scanTemplateVersion(db.QueryRow(q, args...), v)
+	if err == sql.ErrNoRows {
+		return nil, errTemplateNotFound
+	}
+	return v, err
+}
+
+// insertTemplateVersion stores the next version of the template
+func insertTemplateVersion(tx dbExecutor, templateID, version int64, q *TemplateQuery) error {
+	parameters, _ := json.Marshal(q.Parameters)
+	if q.Parameters == nil {
+		parameters = []byte("[]")
+	}
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto("template_versions")
+	ib.Cols("template_id", "version", "title", "description", "parameters", "created")
+	ib.Values(templateID, version, q.Title, q.Description, string(parameters), time.Now().Format(time.RFC3339))
+	query, args := ib.Build()
+	_, err := tx.Exec(query, args...)
+	return err
+}
+
+// ListTemplates - api controller for getting list of templates
+func ListTemplates(c echo.Context) error {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("id", "investor_id", "name", "created")
+	sb.From("templates")
+	sb.Where(sb.IsNull("deleted"))
+	if investorID := c.QueryParam("InvestorID"); investorID != "" {
+		a, err := strconv.ParseInt(investorID, 10, 64)
+		if err != nil {
+			return c.String(http.StatusBadRequest, "Bad Request")
+		}
+		sb.Where(sb.Equal("investor_id", a))
+	}
+	if name := c.QueryParam("Name"); name != "" {
+		sb.Where(sb.Like("name", name))
+	}
+	if err := paginate(c, sb); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	q, args := sb.Build()
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	templates := []Template{}
+	for rows.Next() {
+		t := Template{}
+		if err := rows.Scan(&t.ID, &t.InvestorID, &t.Name, &t.Created); err != nil {
+			log.Fatal(err)
+		}
+		templates = append(templates, t)
+	}
+	rows.Close()
+
+	for i := range templates {
+		latest, err := loadTemplateVersion(db, templates[i].ID, 0)
+		if err != nil {
+			log.Fatal(err)
+		}
+		templates[i].Latest = *latest
+	}
+	return c.JSON(http.StatusOK, templates)
+}
+
+// GetTemplate - api controller for retrieving a template with its latest version
+func GetTemplate(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("id", "investor_id", "name", "created")
+	sb.From("templates")
+	sb.Where(sb.Equal("id", id), sb.IsNull("deleted"))
+	q, args := sb.Build()
+
+	t := Template{}
+	err = db.QueryRow(q, args...).Scan(&t.ID, &t.InvestorID, &t.Name, &t.Created)
+	if err == sql.ErrNoRows {
+		return c.String(http.StatusNotFound, "Template not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	latest, err := loadTemplateVersion(db, id, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	t.Latest = *latest
+	return c.JSON(http.StatusOK, t)
+}
+
+// ListTemplateVersions - api controller for obtaining the history of a template, the versions of
+// deleted templates remain available for the contracts rendered from them
+func ListTemplateVersions(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select(templateVersionColumns...)
+	sb.From("template_versions")
+	sb.Where(sb.Equal("template_id", id))
+	if version := c.QueryParam("Version"); version != "" {
+		a, err := strconv.ParseInt(version, 10, 64)
+		if err != nil {
+			return c.String(http.StatusBadRequest, "Bad Request")
+		}
+		sb.Where(sb.Equal("version", a))
+	}
+	if err := paginate(c, sb); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	q, args := sb.Build()
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	versions := []TemplateVersion{}
+	for rows.Next() {
+		v := TemplateVersion{}
+		if err := scanTemplateVersion(rows, &v); err != nil {
+			log.Fatal(err)
+		}
+		versions = append(versions, v)
+	}
+	if len(versions) == 0 {
+		return c.String(http.StatusNotFound, "Template not found")
+	}
+	return c.JSON(http.StatusOK, versions)
+}
+
+// CreateTemplate - api controller for creating a template, its first version is made with it
+func CreateTemplate(c echo.Context) error {
+	ic := c.(InvestorContext)
+
+	templateQuery := new(TemplateQuery)
+	if err := c.Bind(templateQuery); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	if err := templateQuery.validate(); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto("templates")
+	ib.Cols("investor_id", "name", "created")
+	ib.Values(ic.InvestorID.Int64, templateQuery.Name, time.Now().Format(time.RFC3339))
+	q, args := ib.Build()
+	res, err := tx.Exec(q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := insertTemplateVersion(tx, id, 1, templateQuery); err != nil {
+		log.Fatal(err)
+	}
+	if err := tx.Commit(); isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	return c.JSON(http.StatusCreated, CreatedResponse{ID: id})
+}
+
+// UpdateTemplate - api controller for making a new version of the investor's template,
+// the contracts rendered from the previous versions keep referring to them
+func UpdateTemplate(c echo.Context) error {
+	ic := c.(InvestorContext)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	templateQuery := new(TemplateQuery)
+	if err := c.Bind(templateQuery); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	if err := templateQuery.validate(); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	ub := sqlbuilder.NewUpdateBuilder()
+	ub.Update("templates")
+	ub.Set(ub.Assign("name", templateQuery.Name))
+	ub.Where(ub.Equal("id", id), ub.Equal("investor_id", ic.InvestorID.Int64), ub.IsNull("deleted"))
+	q, args := ub.Build()
+	res, err := tx.Exec(q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if affected, err := res.RowsAffected(); err != nil || affected != 1 {
+		return c.String(http.StatusNotFound, "Template not found")
+	}
+
+	latest, err := loadTemplateVersion(tx, id, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	version := latest.Version + 1
+	if err := insertTemplateVersion(tx, id, version, templateQuery); err != nil {
+		log.Fatal(err)
+	}
+	if err := tx.Commit(); isBusy(err) {
+		return busyConflict(c)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	return c.JSON(http.StatusOK, struct{ Version int64 }{version})
+}
+
+// DeleteTemplate - api controller for removing the investor's template, its versions are kept
+// for the contracts rendered from them
+func DeleteTemplate(c echo.Context) error {
+	ic := c.(InvestorContext)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	ub := sqlbuilder.NewUpdateBuilder()
+	ub.Update("templates")
+	ub.Set(ub.Assign("deleted", time.Now().Format(time.RFC3339)))
+	ub.Where(ub.Equal("id", id), ub.Equal("investor_id", ic.InvestorID.Int64), ub.IsNull("deleted"))
+	q, args := ub.Build()
+	res, err := db.Exec(q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if affected, err := res.RowsAffected(); err != nil || affected != 1 {
+		return c.String(http.StatusNotFound, "Template not found")
+	}
+	return c.String(http.StatusOK, "")
+}
+
+// RenderTemplate - api controller for previewing the title and description a contract would get
+func RenderTemplate(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	renderQuery := new(RenderQuery)
+	if err := c.Bind(renderQuery); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	v, err := loadTemplateVersion(db, id, renderQuery.Version)
+	if err == errTemplateNotFound {
+		return c.String(http.StatusNotFound, err.Error())
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	body, _, err := v.render(renderQuery.Params)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, body)
+}
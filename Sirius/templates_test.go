@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTemplateRejectsLoops(t *testing.T) {
+	for _, src := range []string{
+		`{{range 1000000000000}}{{end}}`,
+		`{{range .Count}}x{{end}}`,
+		`{{if .Flag}}{{else}}{{range 10}}{{end}}{{end}}`,
+		`{{with .Count}}{{range .}}{{end}}{{end}}`,
+		`{{define "a"}}{{template "b"}}{{template "b"}}{{end}}{{define "b"}}{{end}}{{template "a"}}`,
+		`{{block "a" .}}{{end}}`,
+	} {
+		q := &TemplateQuery{Name: "loop", Title: "Contract", Description: src, Parameters: []TemplateParameter{
+			{Name: "Count", Type: ParamInteger}, {Name: "Flag", Type: ParamBoolean}}}
+		if err := q.validate(); err == nil {
+			t.Errorf("%s is accepted", src)
+		}
+	}
+}
+
+func TestTemplateRender(t *testing.T) {
+	q := &TemplateQuery{
+		Name:        "supply",
+		Title:       `Supply of {{.Count}} {{.Item}}`,
+		Description: `{{if .Urgent}}Urgent, due {{else}}Due {{end}}{{.Due.Format "2006-01-02"}}{{with .Note}}. {{.}}{{end}}`,
+		Parameters: []TemplateParameter{
+			{Name: "Count", Type: ParamInteger, Required: true},
+			{Name: "Item", Type: ParamString, Default: "bolts"},
+			{Name: "Urgent", Type: ParamBoolean},
+			{Name: "Due", Type: ParamDate, Required: true},
+			{Name: "Note", Type: ParamString},
+		},
+	}
+	if err := q.validate(); err != nil {
+		t.Fatal(err)
+	}
+	v := &TemplateVersion{Title: q.Title, Description: q.Description, Parameters: q.Parameters}
+	body, params, err := v.render(map[string]interface{}{
+		"Count": float64(500), "Urgent": true, "Due": time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body.Title != "Supply of 500 bolts" || body.Description != "Urgent, due 2026-11-01" {
+		t.Errorf("rendered %q, %q", body.Title, body.Description)
+	}
+	if params["Item"] != "bolts" {
+		t.Errorf("parameters with defaults %v", params)
+	}
+	if _, _, err := v.render(map[string]interface{}{"Count": float64(1)}); err == nil {
+		t.Error("rendered without the required Due")
+	}
+}
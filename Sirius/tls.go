@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/labstack/echo"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Modes of the TLS client authentication
+const (
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+// ClientCertificate is a TLS client certificate registered by its user, the certificates issued
+// with the kind and the ID of the user in the subject are not registered
+type ClientCertificate struct {
+	ID      int64
+	Serial  string
+	Subject string
+	Expires string
+	Created string
+}
+
+// serverTLSConfig returns the TLS configuration of the api, it is nil when the api is served over
+// plain HTTP. The client certificates are verified against ClientCA when it is set.
+func serverTLSConfig(cfg *Config) (*tls.Config, error) {
+	if cfg.TLSCert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if cfg.ClientCA == "" {
+		return tlsConfig, nil
+	}
+	caPem, err := ioutil.ReadFile(cfg.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.ClientCAs = x509.NewCertPool()
+	if !tlsConfig.ClientCAs.AppendCertsFromPEM(caPem) {
+		return nil, errors.New(cfg.ClientCA + ": no certificates")
+	}
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	if cfg.ClientAuth == ClientAuthRequire {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// clientCertificate returns the verified TLS client certificate of the request or nil
+func clientCertificate(c echo.Context) *x509.Certificate {
+	state := c.Request().TLS
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
+
+// subjectUser returns the user identified by the subject of the certificate, the organizational
+// unit is the kind of the user and the serial number attribute is the ID
+func subjectUser(cert *x509.Certificate) (string, int64, bool) {
+	if len(cert.Subject.OrganizationalUnit) != 1 {
+		return "", 0, false
+	}
+	kind := cert.Subject.OrganizationalUnit[0]
+	if kind != UserSupplier && kind != UserInvestor {
+		return "", 0, false
+	}
+	id, err := strconv.ParseInt(cert.Subject.SerialNumber, 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return kind, id, true
+}
+
+// certificateSerial formats the serial number of the certificate the way the registry stores it
+func certificateSerial(cert *x509.Certificate) string {
+	return strings.ToUpper(cert.SerialNumber.Text(16))
+}
+
+// certificateUser returns the ID of the user of the kind identified by the client certificate
+// of the request
+func certificateUser(c echo.Context, kind string) (int64, bool) {
+	certKind, id, ok := certificateOwner(c)
+	return id, ok && certKind == kind
+}
+
+// certificateOwner returns the user identified by the client certificate of the request,
+// by the subject of the certificate or by the registry of the serials
+func certificateOwner(c echo.Context) (string, int64, bool) {
+	cert := clientCertificate(c)
+	if cert == nil {
+		return "", 0, false
+	}
+	if kind, id, ok := subjectUser(cert); ok {
+		return kind, id, true
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("user_kind", "user_id")
+	sb.From("client_certificates")
+	sb.Where(sb.Equal("serial", certificateSerial(cert)))
+	q, args := sb.Build()
+	var kind string
+	var id int64
+	if err := db.QueryRow(q, args...).Scan(&kind, &id); err == nil {
+		return kind, id, true
+	} else if err != sql.ErrNoRows {
+		log.Fatal(err)
+	}
+	return "", 0, false
+}
+
+// RegisterClientCertificate - api controller for registering the TLS client certificate of the request,
+// the user authenticated with the token is identified by the certificate afterwards
+func RegisterClientCertificate(c echo.Context) error {
+	kind, userID := currentUser(c)
+	cert := clientCertificate(c)
+	if cert == nil {
+		return c.String(http.StatusBadRequest, "No verified client certificate")
+	}
+	if _, _, ok := subjectUser(cert); ok {
+		return c.String(http.StatusConflict, "Certificate identifies its user by the subject")
+	}
+
+	certificate := ClientCertificate{
+		Serial:  certificateSerial(cert),
+		Subject: cert.Subject.String(),
+		Expires: cert.NotAfter.UTC().Format(time.RFC3339),
+		Created: time.Now().Format(time.RFC3339),
+	}
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto("client_certificates")
+	ib.Cols("serial", "user_kind", "user_id", "subject", "expires", "created")
+	ib.Values(certificate.Serial, kind, userID, certificate.Subject, certificate.Expires, certificate.Created)
+	q, args := ib.Build()
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	res, err := db.Exec(q, args...)
+	if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+		return c.String(http.StatusConflict, "Certificate is already registered")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	certificate.ID, err = res.LastInsertId()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return c.JSON(http.StatusCreated, certificate)
+}
+
+// ListClientCertificates - api controller for obtaining the client certificates registered by the user
+func ListClientCertificates(c echo.Context) error {
+	kind, userID := currentUser(c)
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("id", "serial", "subject", "expires", "created")
+	sb.From("client_certificates")
+	sb.Where(sb.Equal("user_kind", kind), sb.Equal("user_id", userID))
+	sb.OrderBy("id")
+	q, args := sb.Build()
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	certificates := []ClientCertificate{}
+	for rows.Next() {
+		cc := ClientCertificate{}
+		if err := rows.Scan(&cc.ID, &cc.Serial, &cc.Subject, &cc.Expires, &cc.Created); err != nil {
+			log.Fatal(err)
+		}
+		certificates = append(certificates, cc)
+	}
+	return c.JSON(http.StatusOK, certificates)
+}
+
+// DeleteClientCertificate - api controller for unregistering a client certificate of the user
+func DeleteClientCertificate(c echo.Context) error {
+	kind, userID := currentUser(c)
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	dlb := sqlbuilder.NewDeleteBuilder()
+	dlb.DeleteFrom("client_certificates")
+	dlb.Where(dlb.Equal("id", c.Param("id")), dlb.Equal("user_kind", kind), dlb.Equal("user_id", userID))
+	q, args := dlb.Build()
+
+	res, err := db.Exec(q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if affected != 1 {
+		return c.String(http.StatusNotFound, "Certificate not found")
+	}
+	return c.String(http.StatusOK, "")
+}
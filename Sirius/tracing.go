@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/labstack/echo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span exporters selected by SIRIUS_TRACES_EXPORTER
+const (
+	TracesOTLP   = "otlp"
+	TracesStdout = "stdout"
+	TracesFile   = "file"
+)
+
+// tracer starts the spans of the handlers, the database and the upstream calls,
+// its spans are not recorded until InitTracing configures an exporter
+var tracer = otel.Tracer("sirius")
+
+// InitTracing sets up the W3C trace context propagation and the span exporter: otlp sends
+// the spans to the collector configured by the OTEL_EXPORTER_OTLP_* variables, stdout and file
+// write them as JSON to the standard output or the file. Spans are not exported when exporter
+// is empty. The returned func flushes the exporter.
+func InitTracing(exporter, file string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "":
+		return func(context.Context) error { return nil }, nil
+	case TracesOTLP:
+		spanExporter, err = otlptracehttp.New(context.Background())
+	case TracesStdout:
+		spanExporter, err = stdouttrace.New()
+	case TracesFile:
+		if file == "" {
+			return nil, errors.New("file of the traces is not set")
+		}
+		var f *os.File
+		f, err = os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err == nil {
+			spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+		}
+	default:
+		return nil, errors.New("unknown traces exporter " + exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(semconv.ServiceName("sirius")), resource.WithFromEnv(), resource.WithTelemetrySDK())
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(spanExporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// TracingMiddleware starts the span of the request as a child of the span propagated by the caller,
+// the handler gets it in the context of the request
+func TracingMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		ctx, span := tracer.Start(ctx, req.Method, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPRequestMethodKey.String(req.Method), semconv.URLPath(req.URL.Path)))
+		defer span.End()
+		c.SetRequest(req.WithContext(ctx))
+
+		err := next(c)
+
+		route, code := requestRoute(c, err)
+		span.SetName(req.Method + " " + route)
+		span.SetAttributes(semconv.HTTPRoute(route), semconv.HTTPResponseStatusCode(code))
+		if code >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(code))
+		}
+		return err
+	}
+}
+
+// upstreamGet requests the url of the upstream service in a span of the operation, the trace context
+// is propagated to the service. The url is not recorded as it may contain the token being authorized.
+func upstreamGet(ctx context.Context, target, operation, url string) (*http.Response, trace.Span, error) {
+	ctx, span := tracer.Start(ctx, target+"."+operation, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPRequestMethodKey.String(http.MethodGet), semconv.PeerService(target)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, span, err
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	res, err := http.DefaultClient.Do(req)
+	if err == nil {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(res.StatusCode))
+	}
+	return res, span, err
+}
+
+// endSpan ends the span recording the error
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// userCacheLimit is the number of users kept by the cache
+const userCacheLimit = 10000
+
+// userCache keeps the suppliers and investors loaded from the upstream apis across the requests
+// for UserCacheTTL of the configuration, at most limit of them
+type userCache struct {
+	mu      sync.Mutex
+	entries map[userCacheKey]userCacheEntry
+	limit   int
+}
+
+type userCacheKey struct {
+	kind string
+	id   int64
+}
+
+type userCacheEntry struct {
+	user    UserAbstract
+	expires time.Time
+}
+
+// upstreamUsers caches the users loaded by Supplier.Load and Investor.Load
+var upstreamUsers = &userCache{entries: make(map[userCacheKey]userCacheEntry), limit: userCacheLimit}
+
+// get returns the cached user of kind UserSupplier or UserInvestor unless it has expired
+func (c *userCache) get(kind string, id int64) (UserAbstract, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := userCacheKey{kind, id}
+	entry, ok := c.entries[key]
+	if !ok {
+		return UserAbstract{}, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return UserAbstract{}, false
+	}
+	return entry.user, true
+}
+
+// put caches the user for the configured TTL, nothing is cached when the TTL is 0. When the cache
+// is full the expired users are removed, then the user expiring first.
+func (c *userCache) put(kind string, id int64, user UserAbstract) {
+	ttl := currentConfig().UserCacheTTL
+	if ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := userCacheKey{kind, id}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.limit {
+		c.evict(time.Now())
+	}
+	c.entries[key] = userCacheEntry{user, time.Now().Add(ttl)}
+}
+
+// evict removes the expired entries, or the entry expiring first when none has expired
+func (c *userCache) evict(now time.Time) {
+	var first userCacheKey
+	var firstExpires time.Time
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		} else if firstExpires.IsZero() || entry.expires.Before(firstExpires) {
+			first, firstExpires = key, entry.expires
+		}
+	}
+	if len(c.entries) >= c.limit {
+		delete(c.entries, first)
+	}
+}
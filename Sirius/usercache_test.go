@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUserCacheLimit(t *testing.T) {
+	useTestConfig(t, func(cfg *Config) { cfg.UserCacheTTL = time.Hour })
+	cache := &userCache{entries: make(map[userCacheKey]userCacheEntry), limit: 3}
+
+	for id := int64(1); id <= 3; id++ {
+		cache.put(UserSupplier, id, UserAbstract{})
+	}
+	cache.put(UserSupplier, 3, UserAbstract{})
+	if len(cache.entries) != 3 {
+		t.Fatalf("%d users cached after a refresh, want 3", len(cache.entries))
+	}
+
+	// the expired user is removed first, then the one expiring first
+	cache.entries[userCacheKey{UserSupplier, 2}] = userCacheEntry{UserAbstract{}, time.Now().Add(-time.Second)}
+	cache.put(UserInvestor, 4, UserAbstract{})
+	if _, ok := cache.entries[userCacheKey{UserSupplier, 2}]; ok || len(cache.entries) != 3 {
+		t.Errorf("expired user is kept: %v", cache.entries)
+	}
+	cache.put(UserInvestor, 5, UserAbstract{})
+	if _, ok := cache.entries[userCacheKey{UserSupplier, 1}]; ok || len(cache.entries) != 3 {
+		t.Errorf("user expiring first is kept: %v", cache.entries)
+	}
+	if _, ok := cache.get(UserInvestor, 5); !ok {
+		t.Error("user put last is not cached")
+	}
+}
@@ -0,0 +1,583 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/labstack/echo"
+)
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+const (
+	webhookMaxAttempts = 8
+	webhookBackoff     = 10 * time.Second
+	webhookTimeout     = 10 * time.Second
+	webhookPollPeriod  = time.Second
+)
+
+// Webhook is a subscription of a user to the events of given types,
+// Secret is returned only when the webhook is created
+type Webhook struct {
+	ID         int64
+	URL        string
+	EventTypes []string
+	Secret     string `json:",omitempty"`
+	Created    string
+}
+
+type WebhookQuery struct {
+	URL        string
+	EventTypes []string
+}
+
+// WebhookDelivery is a record of the delivery log
+type WebhookDelivery struct {
+	ID           int64
+	WebhookID    int64
+	EventID      int64
+	ReplayOf     sql.NullInt64
+	Status       string
+	Attempts     int64
+	NextAttempt  string
+	ResponseCode sql.NullInt64
+	Error        sql.NullString
+	Created      string
+	Delivered    sql.NullString
+}
+
+// webhookWake wakes up the delivery worker when new deliveries are scheduled
+var webhookWake = make(chan struct{}, 1)
+
+func wakeWebhookWorker() {
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// webhookAddressAllowed tells whether webhooks may be sent to the address
+var webhookAddressAllowed = publicAddress
+
+// publicAddress reports whether the address is not a loopback, link-local, private or unspecified one,
+// so a subscription can't make the server call the hosts of its own network
+func publicAddress(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsPrivate() ||
+		ip.IsUnspecified() || ip.IsInterfaceLocalMulticast())
+}
+
+// errWebhookAddress is returned when the webhook host resolves to an address which is not allowed
+var errWebhookAddress = errors.New("webhook address is not public")
+
+// checkWebhookHost resolves the host of the webhook url and checks all its addresses
+func checkWebhookHost(host string) error {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		if !webhookAddressAllowed(ip) {
+			return errWebhookAddress
+		}
+	}
+	return nil
+}
+
+// newWebhookClient returns the client of the delivery worker. The addresses are checked once more
+// when connecting, as the host may resolve to another address by then, and redirects are checked too.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !webhookAddressAllowed(ip) {
+				return errWebhookAddress
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+	}
+}
+
+// currentUser returns kind and ID of the user authorized by InvestorAuthMiddleware or SupplierAuthMiddleware
+func currentUser(c echo.Context) (string, int64) {
+	switch uc := c.(type) {
+	case InvestorContext:
+		return UserInvestor, uc.InvestorID.Int64
+	case SupplierContext:
+		return UserSupplier, uc.SupplierID.Int64
+	}
+	return "", 0
+}
+
+func validEventType(t string) bool {
+	for _, et := range EventTypes {
+		if et == t {
+			return true
+		}
+	}
+	return false
+}
+
+// SignWebhookPayload computes value of X-Sirius-Signature header: HMAC-SHA256 of "timestamp.payload"
+// keyed with the webhook secret
+func SignWebhookPayload(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// CreateWebhook - api controller for subscribing to events
+func CreateWebhook(c echo.Context) error {
+	kind, userID := currentUser(c)
+
+	webhookQuery := new(WebhookQuery)
+	if err := c.Bind(webhookQuery); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	u, err := url.Parse(webhookQuery.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return c.String(http.StatusBadRequest, "Invalid URL")
+	}
+	if err := checkWebhookHost(u.Hostname()); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid URL: "+err.Error())
+	}
+	if len(webhookQuery.EventTypes) == 0 {
+		return c.String(http.StatusBadRequest, "No event types")
+	}
+	for _, t := range webhookQuery.EventTypes {
+		if !validEventType(t) {
+			return c.String(http.StatusBadRequest, "Unknown event type "+t)
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatal(err)
+	}
+	webhook := Webhook{
+		URL:        webhookQuery.URL,
+		EventTypes: webhookQuery.EventTypes,
+		Secret:     hex.EncodeToString(secret),
+		Created:    time.Now().Format(time.RFC3339),
+	}
+
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto("webhooks")
+	ib.Cols("user_kind", "user_id", "url", "event_types", "secret", "created")
+	ib.Values(kind, userID, webhook.URL, strings.Join(webhook.EventTypes, ","), webhook.Secret, webhook.Created)
+	q, args := ib.Build()
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	res, err := db.Exec(q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	webhook.ID, err = res.LastInsertId()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return c.JSON(http.StatusCreated, webhook)
+}
+
+// ListWebhooks - api controller for obtaining subscriptions of the user
+func ListWebhooks(c echo.Context) error {
+	kind, userID := currentUser(c)
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("id", "url", "event_types", "created")
+	sb.From("webhooks")
+	sb.Where(sb.Equal("user_kind", kind), sb.Equal("user_id", userID))
+	sb.OrderBy("id")
+	q, args := sb.Build()
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	var webhooks []Webhook
+	for rows.Next() {
+		webhook := Webhook{}
+		var eventTypes string
+		err = rows.Scan(&webhook.ID, &webhook.URL, &eventTypes, &webhook.Created)
+		if err != nil {
+			log.Fatal(err)
+		}
+		webhook.EventTypes = strings.Split(eventTypes, ",")
+		webhooks = append(webhooks, webhook)
+	}
+
+	return c.JSON(http.StatusOK, webhooks)
+}
+
+// DeleteWebhook - api controller for unsubscribing
+func DeleteWebhook(c echo.Context) error {
+	kind, userID := currentUser(c)
+
+	dB, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer dB.Close()
+
+	db := sqlbuilder.NewDeleteBuilder()
+	db.DeleteFrom("webhooks")
+	db.Where(db.Equal("id", c.Param("id")), db.Equal("user_kind", kind), db.Equal("user_id", userID))
+	q, args := db.Build()
+
+	res, err := dB.Exec(q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if affected != 1 {
+		return c.String(http.StatusNotFound, "Webhook not found")
+	}
+
+	return c.String(http.StatusOK, "")
+}
+
+// ownWebhook checks that the webhook belongs to the current user
+func ownWebhook(c echo.Context, db *sql.DB, webhookID int64) (bool, error) {
+	kind, userID := currentUser(c)
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("id")
+	sb.From("webhooks")
+	sb.Where(sb.Equal("id", webhookID), sb.Equal("user_kind", kind), sb.Equal("user_id", userID))
+	q, args := sb.Build()
+
+	var id int64
+	err := db.QueryRow(q, args...).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ListWebhookDeliveries - api controller for obtaining the delivery log of the webhook
+func ListWebhookDeliveries(c echo.Context) error {
+	webhookID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	own, err := ownWebhook(c, db, webhookID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !own {
+		return c.String(http.StatusNotFound, "Webhook not found")
+	}
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("id", "webhook_id", "event_id", "replay_of", "status", "attempts", "next_attempt",
+		"response_code", "error", "created", "delivered")
+	sb.From("webhook_deliveries")
+	sb.Where(sb.Equal("webhook_id", webhookID))
+	if status := c.QueryParam("Status"); status != "" {
+		sb.Where(sb.Equal("status", status))
+	}
+	if err := paginate(c, sb); err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	q, args := sb.Build()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		d := WebhookDelivery{}
+		err = rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.ReplayOf, &d.Status, &d.Attempts, &d.NextAttempt,
+			&d.ResponseCode, &d.Error, &d.Created, &d.Delivered)
+		if err != nil {
+			log.Fatal(err)
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return c.JSON(http.StatusOK, deliveries)
+}
+
+// ReplayWebhookDelivery - api controller for sending the event of a logged delivery once more,
+// a new delivery is created for that
+func ReplayWebhookDelivery(c echo.Context) error {
+	webhookID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+	deliveryID, err := strconv.ParseInt(c.Param("delivery"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Bad Request")
+	}
+
+	db, err := openDB(c.Request().Context())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	own, err := ownWebhook(c, db, webhookID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !own {
+		return c.String(http.StatusNotFound, "Webhook not found")
+	}
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("event_id")
+	sb.From("webhook_deliveries")
+	sb.Where(sb.Equal("id", deliveryID), sb.Equal("webhook_id", webhookID))
+	q, args := sb.Build()
+
+	var eventID int64
+	err = db.QueryRow(q, args...).Scan(&eventID)
+	if err == sql.ErrNoRows {
+		return c.String(http.StatusNotFound, "Delivery not found")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	id, err := insertWebhookDelivery(db, webhookID, eventID, sql.NullInt64{Int64: deliveryID, Valid: true})
+	if err != nil {
+		log.Fatal(err)
+	}
+	wakeWebhookWorker()
+
+	return c.JSON(http.StatusCreated, CreatedResponse{ID: id})
+}
+
+func insertWebhookDelivery(db dbExecutor, webhookID, eventID int64, replayOf sql.NullInt64) (int64, error) {
+	now := time.Now().Format(time.RFC3339)
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto("webhook_deliveries")
+	ib.Cols("webhook_id", "event_id", "replay_of", "status", "next_attempt", "created")
+	ib.Values(webhookID, eventID, replayOf, DeliveryPending, now, now)
+	q, args := ib.Build()
+
+	res, err := db.Exec(q, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// ScheduleWebhookDeliveries creates pending deliveries of the event for every subscribed webhook
+// which owner may see the event
+func ScheduleWebhookDeliveries(db dbExecutor, ev *Event) error {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("id", "user_kind", "user_id", "event_types")
+	sb.From("webhooks")
+	sb.Where(sb.Like("event_types", "%"+ev.Type+"%"))
+	q, args := sb.Build()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return err
+	}
+	var webhookIDs []int64
+	for rows.Next() {
+		var id, userID int64
+		var kind, eventTypes string
+		if err := rows.Scan(&id, &kind, &userID, &eventTypes); err != nil {
+			rows.Close()
+			return err
+		}
+		subscribed := false
+		for _, t := range strings.Split(eventTypes, ",") {
+			subscribed = subscribed || t == ev.Type
+		}
+		if subscribed && ev.VisibleTo(kind, userID) {
+			webhookIDs = append(webhookIDs, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range webhookIDs {
+		if _, err := insertWebhookDelivery(db, id, ev.ID, sql.NullInt64{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunWebhookDeliveries sends pending deliveries, a failed delivery is retried with exponential
+// backoff and marked failed after webhookMaxAttempts attempts
+func RunWebhookDeliveries() {
+	httpClient := newWebhookClient()
+	ticker := time.NewTicker(webhookPollPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-webhookWake:
+		}
+		if err := deliverPendingWebhooks(httpClient); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+type pendingDelivery struct {
+	ID        int64
+	EventID   int64
+	Attempts  int64
+	URL       string
+	Secret    string
+	EventType string
+}
+
+func deliverPendingWebhooks(httpClient *http.Client) error {
+	db, err := sql.Open(dbDriver, dbName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("webhook_deliveries.id", "webhook_deliveries.event_id", "webhook_deliveries.attempts",
+		"webhooks.url", "webhooks.secret")
+	sb.From("webhook_deliveries")
+	sb.Join("webhooks", "webhooks.id = webhook_deliveries.webhook_id")
+	sb.Where(sb.Equal("webhook_deliveries.status", DeliveryPending),
+		sb.LessEqualThan("webhook_deliveries.next_attempt", time.Now().Format(time.RFC3339)))
+	sb.OrderBy("webhook_deliveries.id")
+	sb.Limit(100)
+	q, args := sb.Build()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return err
+	}
+	var pending []pendingDelivery
+	for rows.Next() {
+		d := pendingDelivery{}
+		if err := rows.Scan(&d.ID, &d.EventID, &d.Attempts, &d.URL, &d.Secret); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, d)
+	}
+	rows.Close()
+
+	for _, d := range pending {
+		ev, err := loadEvent(db, d.EventID)
+		if err != nil {
+			return err
+		}
+		code, deliveryErr := sendWebhook(httpClient, d, ev)
+
+		d.Attempts++
+		ub := sqlbuilder.NewUpdateBuilder()
+		ub.Update("webhook_deliveries")
+		ub.Where(ub.Equal("id", d.ID))
+		assignments := []string{ub.Assign("attempts", d.Attempts), ub.Assign("response_code", sql.NullInt64{Int64: int64(code), Valid: code != 0})}
+		now := time.Now()
+		if deliveryErr == nil {
+			assignments = append(assignments, ub.Assign("status", DeliveryDelivered), ub.Assign("delivered", now.Format(time.RFC3339)),
+				ub.Assign("error", nil))
+		} else if d.Attempts >= webhookMaxAttempts {
+			assignments = append(assignments, ub.Assign("status", DeliveryFailed), ub.Assign("error", deliveryErr.Error()))
+		} else {
+			next := now.Add(webhookBackoff << uint(d.Attempts-1))
+			assignments = append(assignments, ub.Assign("next_attempt", next.Format(time.RFC3339)), ub.Assign("error", deliveryErr.Error()))
+		}
+		ub.Set(assignments...)
+		q, args := ub.Build()
+		if _, err := db.Exec(q, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendWebhook posts the event to the webhook url, any response other than 2xx is a failure
+func sendWebhook(httpClient *http.Client, d pendingDelivery, ev *Event) (int, error) {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("User-Agent", "Sirius-Webhooks/1.0")
+	req.Header.Set("X-Sirius-Event", ev.Type)
+	req.Header.Set("X-Sirius-Delivery", strconv.FormatInt(d.ID, 10))
+	req.Header.Set("X-Sirius-Timestamp", timestamp)
+	req.Header.Set("X-Sirius-Signature", SignWebhookPayload(d.Secret, timestamp, payload))
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("unexpected response status %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
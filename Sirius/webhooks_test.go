@@ -0,0 +1,429 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// useTestDB points dbName to a new database for the test. Migrate does not create contracts and offers,
+// their tables are copied from the database of the repository.
+func useTestDB(t *testing.T) {
+	t.Helper()
+	committed, err := sql.Open(dbDriver, "./contracts.sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer committed.Close()
+	rows, err := committed.Query("SELECT sql FROM sqlite_master WHERE type = 'table' AND name IN ('contracts', 'offers')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+
+	old := dbName
+	dbName = filepath.Join(t.TempDir(), "contracts.sqlite3")
+	t.Cleanup(func() { dbName = old })
+	db, err := sql.Open(dbDriver, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, table := range tables {
+		if _, err := db.Exec(table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Migrate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// allowLoopbackWebhooks lets the webhooks be sent to the httptest receivers
+func allowLoopbackWebhooks(t *testing.T) {
+	webhookAddressAllowed = func(ip net.IP) bool { return ip.IsLoopback() || publicAddress(ip) }
+	t.Cleanup(func() { webhookAddressAllowed = publicAddress })
+}
+
+// investorContext makes the context of a request authorized by InvestorAuthMiddleware
+func investorContext(req *http.Request, investorID int64) (InvestorContext, *httptest.ResponseRecorder) {
+	rec := httptest.NewRecorder()
+	return InvestorContext{echo.New().NewContext(req, rec), sql.NullInt64{Int64: investorID, Valid: true}}, rec
+}
+
+func createTestWebhook(t *testing.T, investorID int64, url string) (Webhook, int) {
+	t.Helper()
+	body, _ := json.Marshal(WebhookQuery{URL: url, EventTypes: []string{EventContractCreated}})
+	req := httptest.NewRequest(http.MethodPost, "/investors/webhooks", strings.NewReader(string(body)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	c, rec := investorContext(req, investorID)
+	if err := CreateWebhook(c); err != nil {
+		t.Fatal(err)
+	}
+	webhook := Webhook{}
+	if rec.Code == http.StatusCreated {
+		if err := json.Unmarshal(rec.Body.Bytes(), &webhook); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return webhook, rec.Code
+}
+
+func emitTestEvent(t *testing.T) {
+	t.Helper()
+	db, err := sql.Open(dbDriver, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := EmitEvent(tx, Event{Type: EventContractCreated, Public: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	eventsCommitted()
+}
+
+func listTestDeliveries(t *testing.T, investorID, webhookID int64) []WebhookDelivery {
+	t.Helper()
+	c, rec := investorContext(httptest.NewRequest(http.MethodGet, "/", nil), investorID)
+	c.SetParamNames("id")
+	c.SetParamValues(strconv.FormatInt(webhookID, 10))
+	if err := ListWebhookDeliveries(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list deliveries: %d %s", rec.Code, rec.Body.String())
+	}
+	var deliveries []WebhookDelivery
+	if err := json.Unmarshal(rec.Body.Bytes(), &deliveries); err != nil {
+		t.Fatal(err)
+	}
+	return deliveries
+}
+
+func deliverTestWebhooks(t *testing.T) {
+	t.Helper()
+	if err := deliverPendingWebhooks(newWebhookClient()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// makeDeliveriesDue moves the next attempt of the pending deliveries to now
+func makeDeliveriesDue(t *testing.T) {
+	t.Helper()
+	db, err := sql.Open(dbDriver, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("UPDATE webhook_deliveries SET next_attempt = ?", time.Now().Format(time.RFC3339)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// webhookReceiver records the deliveries and answers with the statuses given in turn,
+// the last one is repeated
+type webhookReceiver struct {
+	*httptest.Server
+	secret   string
+	statuses []int
+
+	mu       sync.Mutex
+	requests []receivedWebhook
+}
+
+type receivedWebhook struct {
+	Header http.Header
+	Event  Event
+	Err    error
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	r := &webhookReceiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *webhookReceiver) serve(w http.ResponseWriter, req *http.Request) {
+	payload, err := io.ReadAll(req.Body)
+	received := receivedWebhook{Header: req.Header, Err: err}
+	if err == nil {
+		received.Err = verifyWebhookSignature(r.secret, req.Header, payload)
+	}
+	if received.Err == nil {
+		received.Err = json.Unmarshal(payload, &received.Event)
+	}
+
+	r.mu.Lock()
+	status := r.statuses[len(r.statuses)-1]
+	if len(r.requests) < len(r.statuses) {
+		status = r.statuses[len(r.requests)]
+	}
+	r.requests = append(r.requests, received)
+	r.mu.Unlock()
+	w.WriteHeader(status)
+}
+
+// verifyWebhookSignature checks X-Sirius-Signature the way a receiver does
+func verifyWebhookSignature(secret string, header http.Header, payload []byte) error {
+	timestamp := header.Get("X-Sirius-Timestamp")
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return err
+	}
+	if d := time.Since(time.Unix(sent, 0)); d < -time.Minute || d > time.Minute {
+		return errors.New("stale timestamp " + timestamp)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + string(payload)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(header.Get("X-Sirius-Signature")), []byte(want)) {
+		return errors.New("bad signature " + header.Get("X-Sirius-Signature"))
+	}
+	return nil
+}
+
+func (r *webhookReceiver) received() []receivedWebhook {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedWebhook(nil), r.requests...)
+}
+
+func setupWebhook(t *testing.T, statuses ...int) (*webhookReceiver, Webhook) {
+	useTestDB(t)
+	allowLoopbackWebhooks(t)
+	receiver := newWebhookReceiver(t, statuses...)
+	webhook, code := createTestWebhook(t, 7, receiver.URL+"/hook")
+	if code != http.StatusCreated {
+		t.Fatalf("create webhook: %d", code)
+	}
+	receiver.secret = webhook.Secret
+	return receiver, webhook
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Sirius-Timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+	payload := []byte(`{"id":1}`)
+	header.Set("X-Sirius-Signature", SignWebhookPayload("secret", header.Get("X-Sirius-Timestamp"), payload))
+	if err := verifyWebhookSignature("secret", header, payload); err != nil {
+		t.Error(err)
+	}
+	if err := verifyWebhookSignature("other", header, payload); err == nil {
+		t.Error("signature is verified with another secret")
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	receiver, webhook := setupWebhook(t, http.StatusOK)
+	emitTestEvent(t)
+	deliverTestWebhooks(t)
+
+	received := receiver.received()
+	if len(received) != 1 {
+		t.Fatalf("received %d requests, want 1", len(received))
+	}
+	if received[0].Err != nil {
+		t.Fatal(received[0].Err)
+	}
+	if received[0].Header.Get("X-Sirius-Event") != EventContractCreated || received[0].Event.Type != EventContractCreated {
+		t.Errorf("received event %q, header %q", received[0].Event.Type, received[0].Header.Get("X-Sirius-Event"))
+	}
+
+	deliveries := listTestDeliveries(t, 7, webhook.ID)
+	if len(deliveries) != 1 {
+		t.Fatalf("%d deliveries logged, want 1", len(deliveries))
+	}
+	d := deliveries[0]
+	if d.Status != DeliveryDelivered || d.Attempts != 1 || d.ResponseCode.Int64 != http.StatusOK || !d.Delivered.Valid ||
+		d.EventID != received[0].Event.ID || received[0].Header.Get("X-Sirius-Delivery") != strconv.FormatInt(d.ID, 10) {
+		t.Errorf("delivery %+v", d)
+	}
+
+	deliverTestWebhooks(t)
+	if n := len(receiver.received()); n != 1 {
+		t.Errorf("delivered event is sent again, %d requests", n)
+	}
+
+	c, rec := investorContext(httptest.NewRequest(http.MethodGet, "/", nil), 8)
+	c.SetParamNames("id")
+	c.SetParamValues(strconv.FormatInt(webhook.ID, 10))
+	if err := ListWebhookDeliveries(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("deliveries of another user's webhook: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	receiver, webhook := setupWebhook(t, http.StatusInternalServerError)
+	emitTestEvent(t)
+
+	for attempt := int64(1); attempt <= webhookMaxAttempts; attempt++ {
+		before := time.Now().Truncate(time.Second)
+		deliverTestWebhooks(t)
+		if n := int64(len(receiver.received())); n != attempt {
+			t.Fatalf("attempt %d: %d requests", attempt, n)
+		}
+
+		d := listTestDeliveries(t, 7, webhook.ID)[0]
+		if d.Attempts != attempt || d.ResponseCode.Int64 != http.StatusInternalServerError || !d.Error.Valid {
+			t.Fatalf("attempt %d: delivery %+v", attempt, d)
+		}
+		if attempt == webhookMaxAttempts {
+			if d.Status != DeliveryFailed {
+				t.Errorf("delivery is %s after %d attempts, want failed", d.Status, attempt)
+			}
+			break
+		}
+		if d.Status != DeliveryPending {
+			t.Fatalf("attempt %d: delivery is %s, want pending", attempt, d.Status)
+		}
+		next, err := time.Parse(time.RFC3339, d.NextAttempt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backoff := webhookBackoff << uint(attempt-1)
+		if next.Before(before.Add(backoff)) || next.After(time.Now().Add(backoff)) {
+			t.Errorf("attempt %d: next attempt at %s, want after %s", attempt, next, backoff)
+		}
+
+		// not due yet
+		deliverTestWebhooks(t)
+		if n := int64(len(receiver.received())); n != attempt {
+			t.Fatalf("attempt %d: delivery is retried before the backoff", attempt)
+		}
+		makeDeliveriesDue(t)
+	}
+
+	makeDeliveriesDue(t)
+	deliverTestWebhooks(t)
+	if n := len(receiver.received()); n != webhookMaxAttempts {
+		t.Errorf("failed delivery is retried, %d requests", n)
+	}
+}
+
+func TestWebhookRecovers(t *testing.T) {
+	receiver, webhook := setupWebhook(t, http.StatusServiceUnavailable, http.StatusNoContent)
+	emitTestEvent(t)
+	deliverTestWebhooks(t)
+	makeDeliveriesDue(t)
+	deliverTestWebhooks(t)
+
+	received := receiver.received()
+	if len(received) != 2 || received[0].Event.ID != received[1].Event.ID {
+		t.Fatalf("received %+v, want the event twice", received)
+	}
+	d := listTestDeliveries(t, 7, webhook.ID)[0]
+	if d.Status != DeliveryDelivered || d.Attempts != 2 || d.ResponseCode.Int64 != http.StatusNoContent || d.Error.Valid {
+		t.Errorf("delivery %+v", d)
+	}
+}
+
+func replayTestDelivery(t *testing.T, investorID, webhookID, deliveryID int64) int {
+	t.Helper()
+	c, rec := investorContext(httptest.NewRequest(http.MethodPost, "/", nil), investorID)
+	c.SetParamNames("id", "delivery")
+	c.SetParamValues(strconv.FormatInt(webhookID, 10), strconv.FormatInt(deliveryID, 10))
+	if err := ReplayWebhookDelivery(c); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code
+}
+
+func TestReplayWebhookDelivery(t *testing.T) {
+	receiver, webhook := setupWebhook(t, http.StatusOK)
+	emitTestEvent(t)
+	deliverTestWebhooks(t)
+	first := listTestDeliveries(t, 7, webhook.ID)[0]
+
+	if code := replayTestDelivery(t, 8, webhook.ID, first.ID); code != http.StatusNotFound {
+		t.Errorf("replay of another user's delivery: %d", code)
+	}
+	if code := replayTestDelivery(t, 7, webhook.ID, first.ID+100); code != http.StatusNotFound {
+		t.Errorf("replay of unknown delivery: %d", code)
+	}
+	if code := replayTestDelivery(t, 7, webhook.ID, first.ID); code != http.StatusCreated {
+		t.Fatalf("replay: %d", code)
+	}
+	deliverTestWebhooks(t)
+
+	received := receiver.received()
+	if len(received) != 2 {
+		t.Fatalf("received %d requests, want 2", len(received))
+	}
+	if received[1].Err != nil || received[1].Event.ID != first.EventID {
+		t.Errorf("replayed %+v, want event %d", received[1], first.EventID)
+	}
+	deliveries := listTestDeliveries(t, 7, webhook.ID)
+	if len(deliveries) != 2 {
+		t.Fatalf("%d deliveries logged, want 2", len(deliveries))
+	}
+	replay := deliveries[1]
+	if replay.ReplayOf.Int64 != first.ID || replay.EventID != first.EventID || replay.Status != DeliveryDelivered ||
+		received[1].Header.Get("X-Sirius-Delivery") != strconv.FormatInt(replay.ID, 10) {
+		t.Errorf("replay delivery %+v", replay)
+	}
+}
+
+func TestCreateWebhookRejectsPrivateAddresses(t *testing.T) {
+	useTestDB(t)
+	for _, url := range []string{
+		"http://127.0.0.1/hook",
+		"http://localhost:8080/hook",
+		"http://[::1]/hook",
+		"http://0.0.0.0/hook",
+		"https://10.1.2.3/hook",
+		"https://172.16.0.1/hook",
+		"https://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[fe80::1]/hook",
+		"http://[fd00::1]/hook",
+	} {
+		if _, code := createTestWebhook(t, 7, url); code != http.StatusBadRequest {
+			t.Errorf("%s: %d, want 400", url, code)
+		}
+	}
+}
+
+func TestWebhookClientRefusesPrivateAddresses(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusOK)
+	_, err := newWebhookClient().Post(receiver.URL, echo.MIMEApplicationJSON, nil)
+	if !errors.Is(err, errWebhookAddress) {
+		t.Errorf("post to %s: %v, want %v", receiver.URL, err, errWebhookAddress)
+	}
+	if n := len(receiver.received()); n != 0 {
+		t.Errorf("receiver got %d requests", n)
+	}
+}